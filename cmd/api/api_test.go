@@ -3,18 +3,23 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"p2pFileTransfer/pkg/config"
+	"p2pFileTransfer/pkg/p2p"
 )
 
 // 测试配置
@@ -74,6 +79,7 @@ func TestMain(m *testing.M) {
 	// 清理测试文件
 	os.RemoveAll("test_metadata")
 	os.RemoveAll("files")
+	os.RemoveAll("downloads")
 
 	// 退出
 	os.Exit(code)
@@ -206,6 +212,14 @@ func TestNodeInfo(t *testing.T) {
 		t.Error("Expected non-empty peer ID")
 	}
 
+	storage, ok := data["storage"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected storage usage in node info, got %v", data)
+	}
+	if storage["maxBytes"].(float64) != 0 || storage["usedBytes"].(float64) < 0 {
+		t.Errorf("Unexpected storage usage: %v", storage)
+	}
+
 	t.Logf("✓ Node info: peerID=%s", peerID)
 }
 
@@ -573,6 +587,58 @@ func TestDHTFindProviders(t *testing.T) {
 	t.Logf("✓ DHT find providers: found %d providers", count)
 }
 
+func TestDHTReprovide(t *testing.T) {
+	t.Log("Testing POST/GET /api/v1/dht/reprovide")
+
+	resp, err := sendRequest("POST", testServerAddr+"/api/v1/dht/reprovide", nil, "")
+	if err != nil {
+		t.Fatalf("Failed to trigger reprovide: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	result, err := parseJSONResponse(resp)
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	data := result["data"].(map[string]interface{})
+	if _, ok := data["triggered"].(bool); !ok {
+		t.Error("Expected triggered field in response")
+	}
+
+	// 等待后台完成本轮公告
+	var status map[string]interface{}
+	for i := 0; i < 20; i++ {
+		time.Sleep(250 * time.Millisecond)
+
+		statusResp, err := sendRequest("GET", testServerAddr+"/api/v1/dht/reprovide", nil, "")
+		if err != nil {
+			t.Fatalf("Failed to get reprovide status: %v", err)
+		}
+		statusResult, err := parseJSONResponse(statusResp)
+		statusResp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+
+		status = statusResult["data"].(map[string]interface{})
+		if !status["running"].(bool) && status["rounds"].(float64) >= 1 {
+			break
+		}
+	}
+
+	if status["rounds"].(float64) < 1 {
+		t.Errorf("Expected at least one reprovide round, got status %v", status)
+	}
+
+	t.Logf("✓ Reprovide status: %d/%d chunks announced",
+		int(status["announced"].(float64)), int(status["total"].(float64)))
+}
+
 // ========== 连接对等节点测试 ==========
 
 func TestPeerConnect(t *testing.T) {
@@ -1002,6 +1068,52 @@ func TestMultipleChunkFile(t *testing.T) {
 	}
 }
 
+// TestOddChunkCountUpload 奇数个分块时 Merkle 树会重复最后一个叶子补齐，
+// 上传时不能用补齐叶子读到的空数据覆盖最后一个分块
+func TestOddChunkCountUpload(t *testing.T) {
+	t.Log("Testing upload and download of a file with an odd number of chunks")
+
+	// 2.5 个分块 -> 3 个叶子
+	content := strings.Repeat("odd chunk count ", DefaultBlockSize*5/2/16)
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	for _, treeType := range []string{"chameleon", "regular"} {
+		req, err := createMultipartUploadRequest(
+			testServerAddr+"/api/v1/files/upload",
+			"file",
+			"odd_chunks_"+treeType+".txt",
+			content,
+			map[string]string{"tree_type": treeType},
+		)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to upload: %v", err)
+		}
+		result, err := parseJSONResponse(resp)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Upload failed with status %d: %v %v", resp.StatusCode, result, err)
+		}
+		cid := result["data"].(map[string]interface{})["cid"].(string)
+
+		resp, err = sendRequest("GET", testServerAddr+"/api/v1/files/"+cid+"/download", nil, "")
+		if err != nil {
+			t.Fatalf("Failed to download: %v", err)
+		}
+		downloaded, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to read downloaded content: %v", err)
+		}
+		if string(downloaded) != content {
+			t.Errorf("%s: downloaded %d bytes, want %d bytes of the original content", treeType, len(downloaded), len(content))
+		}
+	}
+}
+
 // ========== 并发上传测试 ==========
 
 func TestConcurrentUploads(t *testing.T) {
@@ -1377,3 +1489,744 @@ func TestDefaultTreeType(t *testing.T) {
 
 	t.Log("✓ Default tree_type is correctly set to 'chameleon'")
 }
+
+// ========== Range / 条件请求测试 ==========
+
+func TestFileDownloadRange(t *testing.T) {
+	t.Log("Testing Range, ETag and conditional GET on file download")
+
+	// 跨越多个 chunk 的内容，每个字节位置可区分
+	var sb strings.Builder
+	for sb.Len() < 600*1024 {
+		fmt.Fprintf(&sb, "%08d\n", sb.Len())
+	}
+	content := sb.String()
+
+	req, err := createMultipartUploadRequest(
+		testServerAddr+"/api/v1/files/upload",
+		"file",
+		"range_test.txt",
+		content,
+		map[string]string{"tree_type": "regular"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create upload request: %v", err)
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	uploadResult, err := parseJSONResponse(resp)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse upload response: %v", err)
+	}
+	cid := uploadResult["data"].(map[string]interface{})["cid"].(string)
+	url := testServerAddr + "/api/v1/files/" + cid + "/download"
+
+	get := func(headers map[string]string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", url, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to download: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read body: %v", err)
+		}
+		return resp, body
+	}
+
+	// 完整下载返回 ETag 和 Content-Length
+	resp, body := get(nil)
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || string(body) != content || etag == "" {
+		t.Fatalf("Full download failed: status=%d len=%d etag=%q", resp.StatusCode, len(body), etag)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Expected Accept-Ranges: bytes")
+	}
+
+	// 跨 chunk 边界的单个范围
+	start, end := DefaultBlockSize-10, DefaultBlockSize+9
+	resp, body = get(map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", start, end)})
+	if resp.StatusCode != http.StatusPartialContent || string(body) != content[start:end+1] {
+		t.Fatalf("Range request failed: status=%d body=%q", resp.StatusCode, body)
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)) {
+		t.Errorf("Unexpected Content-Range: %s", cr)
+	}
+
+	// 后缀范围
+	resp, body = get(map[string]string{"Range": "bytes=-5"})
+	if resp.StatusCode != http.StatusPartialContent || string(body) != content[len(content)-5:] {
+		t.Errorf("Suffix range failed: status=%d body=%q", resp.StatusCode, body)
+	}
+
+	// 多个范围
+	resp, body = get(map[string]string{"Range": "bytes=0-3,300000-300003"})
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Multi-range failed: status=%d", resp.StatusCode)
+	}
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var parts []string
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		data, _ := io.ReadAll(part)
+		parts = append(parts, string(data))
+	}
+	if len(parts) != 2 || parts[0] != content[0:4] || parts[1] != content[300000:300004] {
+		t.Errorf("Unexpected multi-range parts: %q", parts)
+	}
+
+	// If-None-Match 命中返回 304
+	resp, _ = get(map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", resp.StatusCode)
+	}
+
+	// If-Range 不匹配时返回完整内容
+	resp, body = get(map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`})
+	if resp.StatusCode != http.StatusOK || len(body) != len(content) {
+		t.Errorf("If-Range mismatch should return full content: status=%d len=%d", resp.StatusCode, len(body))
+	}
+
+	// 超出文件大小的范围返回 416
+	resp, _ = get(map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(content))})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected 416, got %d", resp.StatusCode)
+	}
+
+	t.Log("✓ Range and conditional requests work")
+}
+
+// ========== 带宽限制测试 ==========
+
+func TestBandwidthLimits(t *testing.T) {
+	t.Log("Testing GET/PUT /api/v1/node/bandwidth")
+
+	url := testServerAddr + "/api/v1/node/bandwidth"
+	put := func(body string) (int, map[string]interface{}) {
+		resp, err := sendRequest("PUT", url, strings.NewReader(body), "application/json")
+		if err != nil {
+			t.Fatalf("Failed to update bandwidth: %v", err)
+		}
+		defer resp.Body.Close()
+		result, err := parseJSONResponse(resp)
+		if err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return resp.StatusCode, result
+	}
+	defer put(`{"limits": {}, "schedules": []}`)
+
+	status, result := put(`{
+		"limits": {"uploadRate": 1048576, "peerDownloadRate": 262144},
+		"schedules": [{"start": "00:00", "end": "00:00", "downloadRate": 524288}]
+	}`)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", status, result)
+	}
+
+	resp, err := sendRequest("GET", url, nil, "")
+	if err != nil {
+		t.Fatalf("Failed to get bandwidth: %v", err)
+	}
+	result, err = parseJSONResponse(resp)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	data := result["data"].(map[string]interface{})
+	limits := data["limits"].(map[string]interface{})
+	if limits["uploadRate"].(float64) != 1048576 || limits["peerDownloadRate"].(float64) != 262144 {
+		t.Errorf("Unexpected limits: %v", limits)
+	}
+	// 全天的时段计划覆盖默认限速
+	active := data["active"].(map[string]interface{})
+	if active["downloadRate"].(float64) != 524288 || active["uploadRate"].(float64) != 0 {
+		t.Errorf("Expected schedule to be active, got %v", active)
+	}
+
+	// 非法的时间格式和负数速率返回 400
+	if status, _ := put(`{"schedules": [{"start": "25:00", "end": "06:00"}]}`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid schedule, got %d", status)
+	}
+	if status, _ := put(`{"limits": {"uploadRate": -1}}`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for negative rate, got %d", status)
+	}
+
+	t.Log("✓ Bandwidth limits can be updated at runtime")
+}
+
+// TestDownloadJobs 测试后台下载任务的创建、暂停、继续和取消
+func TestDownloadJobs(t *testing.T) {
+	t.Log("Testing /api/v1/downloads")
+
+	do := func(method, url, body string) (int, map[string]interface{}) {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		resp, err := sendRequest(method, url, reader, "application/json")
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, url, err)
+		}
+		defer resp.Body.Close()
+		result, err := parseJSONResponse(resp)
+		if err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return resp.StatusCode, result
+	}
+
+	base := testServerAddr + "/api/v1/downloads"
+
+	// 非法 CID 返回 400
+	if status, _ := do("POST", base, `{"cid": "not-a-cid"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid CID, got %d", status)
+	}
+
+	// 测试节点没有其他对等节点，不存在的 CID 会很快下载失败
+	cid := strings.Repeat("ab", 32)
+	status, result := do("POST", base, fmt.Sprintf(`{"cid": "%s", "fileName": "job.bin", "priority": 5}`, cid))
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %v", status, result)
+	}
+	job := result["data"].(map[string]interface{})
+	id := job["id"].(string)
+	if job["cid"] != cid || job["priority"].(float64) != 5 || job["fileName"] != "job.bin" {
+		t.Errorf("Unexpected job: %v", job)
+	}
+
+	status, result = do("GET", base, "")
+	if status != http.StatusOK || result["data"].(map[string]interface{})["count"].(float64) < 1 {
+		t.Errorf("Expected job in list, got %d: %v", status, result)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		status, result = do("GET", base+"/"+id, "")
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %v", status, result)
+		}
+		job = result["data"].(map[string]interface{})
+		if job["state"] == "failed" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job did not fail in time: %v", job)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if job["error"] == nil || job["error"] == "" {
+		t.Errorf("Expected failed job to carry an error, got %v", job)
+	}
+
+	// 失败的任务不能暂停，但可以重试
+	if status, _ := do("POST", base+"/"+id+"/pause", ""); status != http.StatusConflict {
+		t.Errorf("Expected 409 for pausing a failed job, got %d", status)
+	}
+	status, result = do("POST", base+"/"+id+"/resume", "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", status, result)
+	}
+	if state := result["data"].(map[string]interface{})["state"]; state != "queued" && state != "running" {
+		t.Errorf("Expected resumed job to be queued or running, got %v", state)
+	}
+
+	if status, result := do("DELETE", base+"/"+id, ""); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", status, result)
+	}
+	if status, _ := do("GET", base+"/"+id, ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 after cancel, got %d", status)
+	}
+	if status, _ := do("POST", base+"/"+id+"/resume", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job, got %d", status)
+	}
+
+	t.Log("✓ Download jobs can be retried and canceled")
+}
+
+// ========== 固定和垃圾回收测试 ==========
+
+func TestPinsAndGC(t *testing.T) {
+	t.Log("Testing /api/v1/storage/pins and /api/v1/storage/gc")
+
+	do := func(method, url, body string) (int, map[string]interface{}) {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		resp, err := sendRequest(method, url, reader, "application/json")
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, url, err)
+		}
+		defer resp.Body.Close()
+		result, err := parseJSONResponse(resp)
+		if err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return resp.StatusCode, result
+	}
+
+	req, err := createMultipartUploadRequest(
+		testServerAddr+"/api/v1/files/upload",
+		"file",
+		"test_pin.txt",
+		"content that should be pinned after upload",
+		map[string]string{"tree_type": "regular"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	resp, err := (&http.Client{Timeout: 60 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	result, err := parseJSONResponse(resp)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload failed: %d %v %v", resp.StatusCode, result, err)
+	}
+	cid := result["data"].(map[string]interface{})["cid"].(string)
+
+	base := testServerAddr + "/api/v1/storage"
+	isPinned := func() bool {
+		status, result := do("GET", base+"/pins", "")
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %v", status, result)
+		}
+		for _, pin := range result["data"].(map[string]interface{})["pins"].([]interface{}) {
+			if pin.(map[string]interface{})["cid"] == cid {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 上传的文件自动固定
+	if !isPinned() {
+		t.Fatalf("Expected uploaded file %s to be pinned", cid)
+	}
+
+	// 回收在后台进行，POST 立即返回，结果从状态的 lastResult 获取
+	status, result := do("GET", base+"/gc", "")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", status, result)
+	}
+	runs := result["data"].(map[string]interface{})["runs"].(float64)
+
+	// 试运行不删除任何分片，被引用的分片不计入回收
+	status, result = do("POST", base+"/gc", `{"dryRun": true}`)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", status, result)
+	}
+	if started := result["data"].(map[string]interface{})["started"]; started != true {
+		t.Fatalf("Expected GC to start in the background, got %v", result)
+	}
+	var gcStatus map[string]interface{}
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		status, result = do("GET", base+"/gc", "")
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %v", status, result)
+		}
+		gcStatus = result["data"].(map[string]interface{})
+		if gcStatus["running"] == false && gcStatus["runs"].(float64) > runs {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GC did not finish: %v", gcStatus)
+		}
+	}
+	if gcStatus["lastError"] != nil {
+		t.Fatalf("GC failed: %v", gcStatus)
+	}
+	gc := gcStatus["lastResult"].(map[string]interface{})
+	if gc["dryRun"] != true || gc["files"].(float64) < 1 || gc["referencedChunks"].(float64) < 1 {
+		t.Errorf("Unexpected dry-run result: %v", gc)
+	}
+	if gc["totalChunks"].(float64)-gc["removedChunks"].(float64) < gc["referencedChunks"].(float64) {
+		t.Errorf("Dry run would remove referenced chunks: %v", gc)
+	}
+
+	if status, result := do("DELETE", base+"/pins/"+cid, ""); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", status, result)
+	}
+	if isPinned() {
+		t.Error("Expected file to be unpinned")
+	}
+	if status, _ := do("DELETE", base+"/pins/"+cid, ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for unpinning twice, got %d", status)
+	}
+
+	if status, result := do("POST", base+"/pins", fmt.Sprintf(`{"cid": "%s"}`, cid)); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %v", status, result)
+	}
+	if !isPinned() {
+		t.Error("Expected file to be pinned again")
+	}
+	if status, _ := do("POST", base+"/pins", fmt.Sprintf(`{"cid": "%s"}`, strings.Repeat("cd", 32))); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for pinning an unknown file, got %d", status)
+	}
+	if status, _ := do("POST", base+"/pins", `{"cid": "xyz"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid CID, got %d", status)
+	}
+
+	t.Log("✓ Uploads are pinned and GC dry run keeps referenced chunks")
+}
+
+func TestContentDefinedChunking(t *testing.T) {
+	t.Log("Testing FastCDC chunking on upload and download")
+
+	// 伪随机内容，FastCDC 切分出大小不同的分块
+	var sb strings.Builder
+	seed := uint32(1)
+	for sb.Len() < 3*1024*1024 {
+		seed = seed*1664525 + 1013904223
+		sb.WriteByte(byte(seed >> 24))
+	}
+	content := sb.String()
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	for _, treeType := range []string{"chameleon", "regular"} {
+		req, err := createMultipartUploadRequest(
+			testServerAddr+"/api/v1/files/upload",
+			"file",
+			"cdc_"+treeType+".bin",
+			content,
+			map[string]string{"tree_type": treeType, "chunking": "fastcdc"},
+		)
+		if err != nil {
+			t.Fatalf("Failed to create upload request: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to upload: %v", err)
+		}
+		uploadResult, err := parseJSONResponse(resp)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Upload failed with status %d: %v %v", resp.StatusCode, uploadResult, err)
+		}
+		uploadData := uploadResult["data"].(map[string]interface{})
+		if uploadData["chunking"] != "fastcdc" {
+			t.Errorf("Expected chunking fastcdc, got %v", uploadData["chunking"])
+		}
+		cid := uploadData["cid"].(string)
+
+		// 元数据记录分块参数和每个分块的实际大小
+		resp, err = sendRequest("GET", testServerAddr+"/api/v1/files/"+cid, nil, "")
+		if err != nil {
+			t.Fatalf("Failed to get metadata: %v", err)
+		}
+		infoResult, err := parseJSONResponse(resp)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to parse metadata response: %v", err)
+		}
+		infoData := infoResult["data"].(map[string]interface{})
+		chunking, _ := infoData["chunking"].(map[string]interface{})
+		if chunking == nil || chunking["mode"] != "fastcdc" {
+			t.Fatalf("Expected fastcdc chunking in metadata, got %v", infoData["chunking"])
+		}
+		leaves := infoData["leaves"].([]interface{})
+		total, sizes := 0, map[int]bool{}
+		for _, leaf := range leaves {
+			size := int(leaf.(map[string]interface{})["chunkSize"].(float64))
+			total += size
+			sizes[size] = true
+		}
+		if total != len(content) {
+			t.Errorf("Leaf sizes sum to %d, want %d", total, len(content))
+		}
+		if len(leaves) < 2 || len(sizes) < 2 {
+			t.Errorf("Expected several variable-size chunks, got %d leaves with %d distinct sizes", len(leaves), len(sizes))
+		}
+
+		resp, err = sendRequest("GET", testServerAddr+"/api/v1/files/"+cid+"/download", nil, "")
+		if err != nil {
+			t.Fatalf("Failed to download: %v", err)
+		}
+		downloaded, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to read downloaded content: %v", err)
+		}
+		if string(downloaded) != content {
+			t.Errorf("%s: downloaded %d bytes do not match the uploaded %d bytes", treeType, len(downloaded), len(content))
+		}
+	}
+
+	req, err := createMultipartUploadRequest(
+		testServerAddr+"/api/v1/files/upload",
+		"file",
+		"cdc_invalid.txt",
+		testFileContent,
+		map[string]string{"chunking": "rabin"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create upload request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid chunking, got %d", resp.StatusCode)
+	}
+
+	t.Log("✓ FastCDC uploads record variable-size chunks and download intact")
+}
+
+// ========== 完整性巡检测试 ==========
+
+func TestScrub(t *testing.T) {
+	t.Log("Testing POST/GET /api/v1/storage/scrub")
+
+	// 直接在分片存储中写入内容与哈希不符的分片
+	sum := sha256.Sum256([]byte("original chunk content"))
+	hash := hex.EncodeToString(sum[:])
+	chunkPath := filepath.Join(hash[:2], hash[2:])
+	if err := os.MkdirAll(hash[:2], 0755); err != nil {
+		t.Fatalf("Failed to create shard directory: %v", err)
+	}
+	if err := os.WriteFile(chunkPath, []byte("bit-rotted chunk content"), 0644); err != nil {
+		t.Fatalf("Failed to write corrupt chunk: %v", err)
+	}
+	defer os.Remove(chunkPath)
+	defer os.RemoveAll(".quarantine")
+
+	resp, err := sendRequest("POST", testServerAddr+"/api/v1/storage/scrub", nil, "")
+	if err != nil {
+		t.Fatalf("Failed to trigger scrub: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// 等待后台完成本轮巡检
+	var status map[string]interface{}
+	for i := 0; i < 40; i++ {
+		time.Sleep(250 * time.Millisecond)
+
+		statusResp, err := sendRequest("GET", testServerAddr+"/api/v1/storage/scrub", nil, "")
+		if err != nil {
+			t.Fatalf("Failed to get scrub status: %v", err)
+		}
+		statusResult, err := parseJSONResponse(statusResp)
+		statusResp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+
+		status = statusResult["data"].(map[string]interface{})
+		if !status["running"].(bool) && status["passes"].(float64) >= 1 {
+			break
+		}
+	}
+
+	lastResult, _ := status["lastResult"].(map[string]interface{})
+	if lastResult == nil || lastResult["corrupt"].(float64) < 1 {
+		t.Fatalf("Expected the corrupt chunk to be detected, got status %v", status)
+	}
+
+	var record map[string]interface{}
+	quarantined, _ := status["quarantined"].([]interface{})
+	for _, item := range quarantined {
+		if item.(map[string]interface{})["hash"] == hash {
+			record = item.(map[string]interface{})
+			break
+		}
+	}
+	if record == nil {
+		t.Fatalf("Expected %s in quarantine records, got %v", hash, quarantined)
+	}
+
+	// 损坏的分片移入隔离目录，不再对外提供
+	if _, err := os.Stat(chunkPath); !os.IsNotExist(err) {
+		t.Errorf("Expected corrupt chunk to be removed from the store, stat err: %v", err)
+	}
+	quarantinedData, err := os.ReadFile(record["path"].(string))
+	if err != nil {
+		t.Fatalf("Failed to read quarantined chunk: %v", err)
+	}
+	if string(quarantinedData) != "bit-rotted chunk content" {
+		t.Errorf("Quarantined chunk content mismatch: %q", quarantinedData)
+	}
+
+	// 测试节点没有其他提供者，无法恢复
+	if record["repaired"].(bool) {
+		t.Errorf("Expected repair to fail without providers, got %v", record)
+	}
+
+	t.Logf("✓ Scrub detected %d corrupt chunk(s) in %d checked", int(lastResult["corrupt"].(float64)), int(lastResult["chunks"].(float64)))
+}
+
+// ========== 文件列表测试 ==========
+
+func TestFileListAndDelete(t *testing.T) {
+	t.Log("Testing GET /api/v1/files and DELETE /api/v1/files/{cid}")
+
+	prefix := fmt.Sprintf("list_%d_", time.Now().UnixNano())
+	uploads := []struct{ name, treeType string }{
+		{prefix + "c.txt", "regular"},
+		{prefix + "a.txt", "chameleon"},
+		{prefix + "b.txt", "regular"},
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	cids := make(map[string]string)
+	for _, u := range uploads {
+		req, err := createMultipartUploadRequest(
+			testServerAddr+"/api/v1/files/upload",
+			"file",
+			u.name,
+			"content of "+u.name,
+			map[string]string{"tree_type": u.treeType},
+		)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to upload: %v", err)
+		}
+		result, err := parseJSONResponse(resp)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Upload failed with status %d: %v %v", resp.StatusCode, result, err)
+		}
+		cids[u.name] = result["data"].(map[string]interface{})["cid"].(string)
+	}
+
+	list := func(query string) (int, map[string]interface{}) {
+		resp, err := sendRequest("GET", testServerAddr+"/api/v1/files?"+query, nil, "")
+		if err != nil {
+			t.Fatalf("Failed to list files: %v", err)
+		}
+		defer resp.Body.Close()
+		result, err := parseJSONResponse(resp)
+		if err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		data, _ := result["data"].(map[string]interface{})
+		return resp.StatusCode, data
+	}
+	names := func(data map[string]interface{}) []string {
+		var out []string
+		for _, f := range data["files"].([]interface{}) {
+			out = append(out, f.(map[string]interface{})["fileName"].(string))
+		}
+		return out
+	}
+
+	// 按名称升序分页
+	status, page := list("name=" + prefix + "&sort=name&order=asc&limit=2")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if int(page["total"].(float64)) != 3 {
+		t.Fatalf("Expected 3 matching files, got %v", page["total"])
+	}
+	got := names(page)
+	cursor, _ := page["nextCursor"].(string)
+	if len(got) != 2 || got[0] != prefix+"a.txt" || got[1] != prefix+"b.txt" || cursor == "" {
+		t.Fatalf("Unexpected first page %v (cursor %q)", got, cursor)
+	}
+	status, page = list("name=" + prefix + "&sort=name&order=asc&limit=2&cursor=" + cursor)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if got := names(page); len(got) != 1 || got[0] != prefix+"c.txt" || page["nextCursor"] != nil {
+		t.Fatalf("Unexpected second page %v (cursor %v)", got, page["nextCursor"])
+	}
+
+	// 过滤树类型和日期
+	if _, page := list("name=" + prefix + "&tree_type=chameleon"); len(names(page)) != 1 {
+		t.Errorf("Expected 1 chameleon file, got %v", names(page))
+	}
+	if _, page := list("name=" + prefix + "&since=" + time.Now().Add(time.Hour).Format(time.RFC3339)); len(names(page)) != 0 {
+		t.Errorf("Expected no files updated in the future, got %v", names(page))
+	}
+
+	// 游标必须与排序方式一致
+	if status, _ := list("name=" + prefix + "&sort=size&cursor=" + cursor); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a cursor from another sort, got %d", status)
+	}
+	if status, _ := list("sort=color"); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown sort field, got %d", status)
+	}
+
+	// 删除后不再出现在列表中
+	deleteURL := testServerAddr + "/api/v1/files/" + cids[prefix+"b.txt"]
+	resp, err := sendRequest("DELETE", deleteURL, nil, "")
+	if err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if _, page := list("name=" + prefix + "&sort=name&order=asc"); strings.Join(names(page), ",") != prefix+"a.txt,"+prefix+"c.txt" {
+		t.Errorf("Unexpected files after delete: %v", names(page))
+	}
+	resp, err = sendRequest("DELETE", deleteURL, nil, "")
+	if err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting a missing file, got %d", resp.StatusCode)
+	}
+
+	t.Logf("✓ Listed and paginated %d files", len(uploads))
+}
+
+func TestFileListStatusCodes(t *testing.T) {
+	t.Log("Testing GET /api/v1/files error status codes")
+
+	dir := filepath.Join(t.TempDir(), "metadata")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	index, err := p2p.LoadMetaDataIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadMetaDataIndex: %v", err)
+	}
+	s := &Server{p2pService: &p2p.P2PService{MetaIndex: index}}
+	list := func(query string) int {
+		rec := httptest.NewRecorder()
+		s.handleFileList(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files?"+query, nil))
+		return rec.Code
+	}
+
+	if status := list("sort=color"); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown sort field, got %d", status)
+	}
+	if status := list("cursor=invalid!"); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid cursor, got %d", status)
+	}
+
+	// 读取元数据目录失败是服务端错误
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status := list(""); status != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the metadata directory cannot be read, got %d", status)
+	}
+}
@@ -3,18 +3,26 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"p2pFileTransfer/pkg/chameleonMerkleTree"
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/chunker"
 	"p2pFileTransfer/pkg/file"
+	"p2pFileTransfer/pkg/p2p"
 )
 
 const (
@@ -28,39 +36,6 @@ const (
 	DefaultBufferNumber = 16
 )
 
-// getChunkPath 返回 chunk 的存储路径，使用子目录分片避免路径过长
-// 格式: <chunkPath>/<前2字符>/<剩余62字符>
-// 例如: chunks/ab/cdef...1234
-// 同时自动创建所需的子目录
-func getChunkPath(chunkPath string, chunkHash []byte) (string, error) {
-	hashHex := hex.EncodeToString(chunkHash)
-	if len(hashHex) < 4 {
-		// 如果 hash 太短，直接使用原路径
-		return filepath.Join(chunkPath, hashHex), nil
-	}
-	// 使用前2字符作为子目录
-	subDir := hashHex[:2]
-	fileName := hashHex[2:]
-	chunkDir := filepath.Join(chunkPath, subDir)
-
-	// 确保子目录存在
-	if err := os.MkdirAll(chunkDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create chunk directory %s: %w", chunkDir, err)
-	}
-
-	return filepath.Join(chunkDir, fileName), nil
-}
-
-// getChunkPathFromHash 从 hex 字符串获取 chunk 路径（用于读取）
-func getChunkPathFromHash(chunkPath string, hashHex string) string {
-	if len(hashHex) < 4 {
-		return filepath.Join(chunkPath, hashHex)
-	}
-	subDir := hashHex[:2]
-	fileName := hashHex[2:]
-	return filepath.Join(chunkPath, subDir, fileName)
-}
-
 // APIResponse 统一的API响应格式
 type APIResponse struct {
 	Success bool        `json:"success"`
@@ -135,16 +110,23 @@ func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 分块方式（未指定时使用配置的默认分块方式）
+	chunking, err := s.uploadChunking(r.FormValue("chunking"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chunking: %v", err))
+		return
+	}
+
 	// 根据树类型上传文件
 	var result map[string]interface{}
 	if treeType == "chameleon" {
-		result, err = s.uploadFileChameleon(r.Context(), file, header.Filename, description)
+		result, err = s.uploadFileChameleon(r.Context(), file, header.Filename, description, chunking)
 	} else {
-		result, err = s.uploadFileRegular(r.Context(), file, header.Filename, description)
+		result, err = s.uploadFileRegular(r.Context(), file, header.Filename, description, chunking)
 	}
 
 	if err != nil {
-		s.respondError(w, http.StatusInternalServerError, fmt.Sprintf("Upload failed: %v", err))
+		s.respondError(w, uploadErrorStatus(err), fmt.Sprintf("Upload failed: %v", err))
 		return
 	}
 
@@ -152,9 +134,9 @@ func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
 }
 
 // uploadFileChameleon 使用Chameleon Merkle Tree上传文件
-func (s *Server) uploadFileChameleon(ctx context.Context, fileReader io.Reader, fileName, description string) (map[string]interface{}, error) {
-	// 生成密钥对
-	_, pubKey := chameleonMerkleTree.NewChameleonKeyPair()
+func (s *Server) uploadFileChameleon(ctx context.Context, fileReader io.Reader, fileName, description string, chunking chunker.Config) (map[string]interface{}, error) {
+	// 生成密钥对（私钥仅用于签名 DHT 元数据记录）
+	privKey, pubKey := chameleonMerkleTree.NewChameleonKeyPair()
 
 	// 创建临时文件（避免将整个文件加载到内存）
 	tmpFile, err := os.CreateTemp("", "upload-*.tmp")
@@ -180,6 +162,9 @@ func (s *Server) uploadFileChameleon(ctx context.Context, fileReader io.Reader,
 		BlockSize:    DefaultBlockSize,
 		BufferNumber: DefaultBufferNumber,
 	}
+	if chunking.IsContentDefined() {
+		config.Chunking = &chunking
+	}
 
 	cmt, err := chameleonMerkleTree.NewChameleonMerkleTree(tmpFile, config, pubKey)
 	if err != nil {
@@ -196,38 +181,21 @@ func (s *Server) uploadFileChameleon(ctx context.Context, fileReader io.Reader,
 	// 获取所有分块哈希
 	chunkHashes := cmt.GetAllLeavesHashes()
 
-	// 保存所有分块到本地存储
-	chunkPath := s.config.Storage.ChunkPath
-	buffer := make([]byte, config.BlockSize) // 重用缓冲区，避免频繁分配
-
-	for i, chunkHash := range chunkHashes {
-		// 重置文件指针到chunk起始位置
-		offset := int64(i) * int64(config.BlockSize)
-		if _, err := tmpFile.Seek(offset, 0); err != nil {
-			return nil, fmt.Errorf("failed to seek to chunk %d: %w", i, err)
-		}
-
-		// 读取chunk数据
-		n, err := tmpFile.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
-		}
-		chunkData := buffer[:n]
-
-		// 保存chunk到文件（使用子目录分片）
-		chunkFile, err := getChunkPath(chunkPath, chunkHash)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get chunk path %d: %w", i, err)
-		}
-		if err := os.WriteFile(chunkFile, chunkData, 0644); err != nil {
-			return nil, fmt.Errorf("failed to save chunk %d: %w", i, err)
-		}
+	// 保存所有分块到本地存储（保存元数据前阻止垃圾回收删除新写入的 chunk）
+	release := s.p2pService.GC.Hold(chunkHashes)
+	defer release()
+	if err := s.p2pService.Quota.CanStore(size); err != nil {
+		return nil, err
+	}
+	leaves, announceHashes, err := s.storeChunks(tmpFile, chunkHashes, chunking)
+	if err != nil {
+		return nil, err
+	}
 
-		// Announce到DHT
-		chunkHashStr := hex.EncodeToString(chunkHash)
-		if err := s.p2pService.Announce(ctx, chunkHashStr); err != nil {
-			return nil, fmt.Errorf("failed to announce chunk %d: %w", i, err)
-		}
+	// 批量Announce到DHT（同时公告文件 CID，表示本节点持有完整文件）
+	announceHashes = append(announceHashes, cidHex)
+	if err := s.p2pService.AnnounceBatch(ctx, announceHashes); err != nil {
+		return nil, fmt.Errorf("failed to announce chunks: %w", err)
 	}
 
 	// 生成元数据
@@ -241,14 +209,23 @@ func (s *Server) uploadFileChameleon(ctx context.Context, fileReader io.Reader,
 		FileSize:        uint64(size),
 		Encryption:      "none",
 		TreeType:        "chameleon",
-		Leaves:          convertToChunkData(chunkHashes, int(config.BlockSize)),
+		Leaves:          leaves,
+		Chunking:        chunking.Info(),
 	}
 
 	// 保存元数据
-	if err := s.saveMetadata(cidHex, metadata); err != nil {
+	if err := s.p2pService.GC.Commit(func() error { return s.saveMetadata(cidHex, metadata) }); err != nil {
 		return nil, err
 	}
 
+	// 上传的文件自动固定
+	if err := s.p2pService.Pins.Pin(cidHex); err != nil {
+		logrus.Warnf("Failed to pin uploaded file %s: %v", cidHex, err)
+	}
+
+	// 发布元数据到DHT，使其他节点可以通过CID下载
+	s.publishMetadata(ctx, metadata, privKey)
+
 	return map[string]interface{}{
 		"cid":             cidHex,
 		"fileName":        fileName,
@@ -256,14 +233,15 @@ func (s *Server) uploadFileChameleon(ctx context.Context, fileReader io.Reader,
 		"regularRootHash": hex.EncodeToString(regularRootHash),
 		"randomNum":       hex.EncodeToString(cmt.GetRandomNumber().Serialize()),
 		"publicKey":       hex.EncodeToString(cmt.GetPublicKey().Serialize()),
-		"chunkCount":      len(chunkHashes),
+		"chunkCount":      len(leaves),
+		"chunking":        chunking.Mode,
 		"fileSize":        size,
 		"message":         "File uploaded successfully with Chameleon Merkle Tree",
 	}, nil
 }
 
 // uploadFileRegular 使用Regular Merkle Tree上传文件
-func (s *Server) uploadFileRegular(ctx context.Context, fileReader io.Reader, fileName, description string) (map[string]interface{}, error) {
+func (s *Server) uploadFileRegular(ctx context.Context, fileReader io.Reader, fileName, description string, chunking chunker.Config) (map[string]interface{}, error) {
 	// 创建临时文件（避免将整个文件加载到内存）
 	tmpFile, err := os.CreateTemp("", "upload-*.tmp")
 	if err != nil {
@@ -288,6 +266,9 @@ func (s *Server) uploadFileRegular(ctx context.Context, fileReader io.Reader, fi
 		BlockSize:    DefaultBlockSize,
 		BufferNumber: DefaultBufferNumber,
 	}
+	if chunking.IsContentDefined() {
+		config.Chunking = &chunking
+	}
 
 	// 使用标准Merkle Tree（不使用Chameleon哈希）
 	rootNode, err := chameleonMerkleTree.BuildMerkleTreeFromFileRW(tmpFile, config)
@@ -302,38 +283,21 @@ func (s *Server) uploadFileRegular(ctx context.Context, fileReader io.Reader, fi
 	// 获取所有叶子节点哈希
 	chunkHashes := getAllLeafHashes(rootNode)
 
-	// 保存所有分块到本地存储
-	chunkPath := s.config.Storage.ChunkPath
-	buffer := make([]byte, config.BlockSize) // 重用缓冲区，避免频繁分配
-
-	for i, chunkHash := range chunkHashes {
-		// 重置文件指针到chunk起始位置
-		offset := int64(i) * int64(config.BlockSize)
-		if _, err := tmpFile.Seek(offset, 0); err != nil {
-			return nil, fmt.Errorf("failed to seek to chunk %d: %w", i, err)
-		}
-
-		// 读取chunk数据
-		n, err := tmpFile.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
-		}
-		chunkData := buffer[:n]
-
-		// 保存chunk到文件（使用子目录分片）
-		chunkFile, err := getChunkPath(chunkPath, chunkHash)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get chunk path %d: %w", i, err)
-		}
-		if err := os.WriteFile(chunkFile, chunkData, 0644); err != nil {
-			return nil, fmt.Errorf("failed to save chunk %d: %w", i, err)
-		}
+	// 保存所有分块到本地存储（保存元数据前阻止垃圾回收删除新写入的 chunk）
+	release := s.p2pService.GC.Hold(chunkHashes)
+	defer release()
+	if err := s.p2pService.Quota.CanStore(size); err != nil {
+		return nil, err
+	}
+	leaves, announceHashes, err := s.storeChunks(tmpFile, chunkHashes, chunking)
+	if err != nil {
+		return nil, err
+	}
 
-		// Announce到DHT
-		chunkHashStr := hex.EncodeToString(chunkHash)
-		if err := s.p2pService.Announce(ctx, chunkHashStr); err != nil {
-			return nil, fmt.Errorf("failed to announce chunk %d: %w", i, err)
-		}
+	// 批量Announce到DHT（同时公告文件 CID，表示本节点持有完整文件）
+	announceHashes = append(announceHashes, cidHex)
+	if err := s.p2pService.AnnounceBatch(ctx, announceHashes); err != nil {
+		return nil, fmt.Errorf("failed to announce chunks: %w", err)
 	}
 
 	// 生成元数据
@@ -344,19 +308,29 @@ func (s *Server) uploadFileRegular(ctx context.Context, fileReader io.Reader, fi
 		FileSize:     uint64(size),
 		Encryption:   "none",
 		TreeType:     "regular",
-		Leaves:       convertToChunkData(chunkHashes, int(config.BlockSize)),
+		Leaves:       leaves,
+		Chunking:     chunking.Info(),
 	}
 
 	// 保存元数据
-	if err := s.saveMetadata(cidHex, metadata); err != nil {
+	if err := s.p2pService.GC.Commit(func() error { return s.saveMetadata(cidHex, metadata) }); err != nil {
 		return nil, err
 	}
 
+	// 上传的文件自动固定
+	if err := s.p2pService.Pins.Pin(cidHex); err != nil {
+		logrus.Warnf("Failed to pin uploaded file %s: %v", cidHex, err)
+	}
+
+	// 发布元数据到DHT，使其他节点可以通过CID下载
+	s.publishMetadata(ctx, metadata, nil)
+
 	return map[string]interface{}{
 		"cid":        cidHex,
 		"fileName":   fileName,
 		"treeType":   "regular",
-		"chunkCount": len(chunkHashes),
+		"chunkCount": len(leaves),
+		"chunking":   chunking.Mode,
 		"fileSize":   size,
 		"message":    "File uploaded successfully with Regular Merkle Tree",
 	}, nil
@@ -415,6 +389,124 @@ func (s *Server) handleFileInfo(w http.ResponseWriter, r *http.Request) {
 	s.respondSuccess(w, metadata)
 }
 
+// handleFileList 列出本节点保存的文件（来自元数据索引）
+// 查询参数:
+//   - name: 文件名包含的字符串（不区分大小写）
+//   - tree_type: chameleon | regular
+//   - since / until: 更新时间范围 [since, until)，RFC3339 或 YYYY-MM-DD
+//   - sort: name | size | created | updated（默认 updated）
+//   - order: asc | desc（时间字段默认 desc，其他默认 asc）
+//   - limit: 每页数量（默认 50，最大 1000）
+//   - cursor: 上一页返回的 nextCursor
+func (s *Server) handleFileList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	query := p2p.MetaDataQuery{
+		Name:     params.Get("name"),
+		TreeType: params.Get("tree_type"),
+		Sort:     params.Get("sort"),
+		Cursor:   params.Get("cursor"),
+	}
+	if query.TreeType != "" && query.TreeType != "chameleon" && query.TreeType != "regular" {
+		s.respondError(w, http.StatusBadRequest, "tree_type must be 'chameleon' or 'regular'")
+		return
+	}
+	if query.Sort == "" {
+		query.Sort = p2p.MetaDataSortUpdated
+	}
+
+	switch params.Get("order") {
+	case "":
+		query.Desc = query.Sort == p2p.MetaDataSortCreated || query.Sort == p2p.MetaDataSortUpdated
+	case "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		s.respondError(w, http.StatusBadRequest, "order must be 'asc' or 'desc'")
+		return
+	}
+
+	var err error
+	if query.Since, err = parseDateParam(params.Get("since")); err != nil {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid since: %v", err))
+		return
+	}
+	if query.Until, err = parseDateParam(params.Get("until")); err != nil {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid until: %v", err))
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			s.respondError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	page, err := s.p2pService.MetaIndex.Query(query)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, p2p.ErrInvalidCursor) || errors.Is(err, p2p.ErrUnknownSortField) {
+			status = http.StatusBadRequest
+		}
+		s.respondError(w, status, err.Error())
+		return
+	}
+	s.respondSuccess(w, page)
+}
+
+// parseDateParam 解析 RFC3339 时间或 YYYY-MM-DD 日期（UTC 零点），空字符串返回零值
+func parseDateParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// handleFileDelete 删除本地元数据并取消固定
+// 分片不会立即删除，不再被其他文件引用的分片由垃圾回收清理
+func (s *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// 只接受 hex CID，避免删除元数据目录中的其他文件（如 pins.json）
+	cid := strings.ToLower(r.PathValue("cid"))
+	if _, err := hex.DecodeString(cid); err != nil || cid == "" {
+		s.respondError(w, http.StatusBadRequest, "Invalid CID format")
+		return
+	}
+
+	metadataPath := filepath.Join(s.config.HTTP.MetadataStoragePath, cid+".json")
+	if err := os.Remove(metadataPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.respondError(w, http.StatusNotFound, "File not found")
+		} else {
+			s.respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete metadata: %v", err))
+		}
+		return
+	}
+	if err := s.p2pService.MetaIndex.Remove(cid); err != nil {
+		logrus.Warnf("Failed to update metadata index: %v", err)
+	}
+	if err := s.p2pService.Pins.Unpin(cid); err != nil && !errors.Is(err, p2p.ErrNotPinned) {
+		logrus.Warnf("Failed to unpin deleted file %s: %v", cid, err)
+	}
+
+	logrus.Infof("[FileDelete] Deleted metadata of %s", cid)
+	s.respondSuccess(w, map[string]interface{}{
+		"cid":     cid,
+		"message": "File deleted",
+	})
+}
+
 // handleFileDownload 文件下载
 func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -439,57 +531,129 @@ func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 设置响应头
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", metadata.FileName))
+	// 所有chunk都在本地时从本地重组；否则完整下载从P2P网络按顺序流式下载，
+	// 部分范围（如播放器拖动进度）通过可随机访问的读取器按需获取，多个范围共享 chunk 缓存
+	local := s.hasLocalChunks(metadata)
+	var reader io.ReadSeekCloser
+	defer func() {
+		if reader != nil {
+			reader.Close()
+		}
+	}()
+	writeRange := func(w io.Writer, start, length int64) error {
+		if local {
+			return s.writeLocalRange(w, metadata, start, length)
+		}
+		if start == 0 && length == int64(metadata.FileSize) {
+			return s.p2pService.StreamFileRange(r.Context(), cid, w, start, length, nil)
+		}
+		if reader == nil {
+			var err error
+			if reader, err = s.p2pService.OpenFile(r.Context(), cid); err != nil {
+				return err
+			}
+		}
+		if _, err := reader.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.CopyN(w, reader, length)
+		return err
+	}
+
+	s.serveRanges(w, r, cid, int64(metadata.FileSize), fileETag(cid, metadata), func(h http.Header) {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", metadata.FileName))
+	}, writeRange)
+}
 
-	// 首先尝试从本地chunk文件重组
-	if err := s.downloadFromLocalChunks(w, metadata); err == nil {
+// fileETag 返回文件的强 ETag
+// chameleon 文件更新后 CID 不变，因此同时包含常规 Merkle 根哈希
+func fileETag(cid string, metadata *file.MetaData) string {
+	cid = strings.ToLower(cid)
+	if metadata.TreeType == "chameleon" && len(metadata.RegularRootHash) > 0 {
+		return fmt.Sprintf("\"%s-%s\"", cid, hex.EncodeToString(metadata.RegularRootHash))
+	}
+	return fmt.Sprintf("\"%s\"", cid)
+}
+
+// downloadResponseWriter 第一次写入时才设置响应头和状态码
+type downloadResponseWriter struct {
+	w       http.ResponseWriter
+	header  func(http.Header)
+	status  int // 0 表示 200
+	written int64
+}
+
+func (dw *downloadResponseWriter) Write(data []byte) (int, error) {
+	dw.commit()
+	n, err := dw.w.Write(data)
+	dw.written += int64(n)
+	return n, err
+}
+
+// commit 设置响应头（只执行一次），空文件下载完成时也需调用
+func (dw *downloadResponseWriter) commit() {
+	if dw.header == nil {
 		return
 	}
+	dw.header(dw.w.Header())
+	dw.header = nil
+	if dw.status != 0 {
+		dw.w.WriteHeader(dw.status)
+	}
+}
 
-	// 如果本地chunk不可用，尝试从P2P网络下载
-	ctx := r.Context()
-	var buf bytes.Buffer
-	if err := s.p2pService.GetFileOrdered(ctx, cid, &buf); err != nil {
+// abortDownload 处理下载错误: 尚未发送响应头时返回 JSON 错误，否则只能中断响应
+// 已发送 Content-Length，客户端会因响应体不完整而识别失败
+func (s *Server) abortDownload(w http.ResponseWriter, dw *downloadResponseWriter, cid string, err error) {
+	if dw.header != nil {
 		s.respondError(w, http.StatusInternalServerError, fmt.Sprintf("Download failed: %v", err))
 		return
 	}
-
-	// 写入文件数据
-	w.Write(buf.Bytes())
+	logrus.Errorf("Download of %s aborted after %d bytes: %v", cid, dw.written, err)
 }
 
-// downloadFromLocalChunks 从本地chunk文件重组下载
-func (s *Server) downloadFromLocalChunks(w http.ResponseWriter, metadata *file.MetaData) error {
-	chunkPath := s.config.Storage.ChunkPath
-
-	// 创建副本并按索引排序，确保顺序正确
-	leaves := make([]file.ChunkData, len(metadata.Leaves))
-	copy(leaves, metadata.Leaves)
-
-	// 按 Index 排序（冒泡排序或使用 sort 包）
-	for i := 0; i < len(leaves); i++ {
-		for j := i + 1; j < len(leaves); j++ {
-			if leaves[i].Index > leaves[j].Index {
-				leaves[i], leaves[j] = leaves[j], leaves[i]
-			}
+// hasLocalChunks 检查文件的所有chunk是否都在本地
+func (s *Server) hasLocalChunks(metadata *file.MetaData) bool {
+	for _, leaf := range metadata.Leaves {
+		if !s.p2pService.ChunkStore.Has(hex.EncodeToString(leaf.ChunkHash)) {
+			return false
 		}
 	}
+	return true
+}
+
+// writeLocalRange 从本地chunk存储重组文件的字节范围 [start, start+length)
+func (s *Server) writeLocalRange(w io.Writer, metadata *file.MetaData, start, length int64) error {
+	// 创建副本并按索引排序，确保顺序正确
+	sorted := *metadata
+	sorted.Leaves = make([]file.ChunkData, len(metadata.Leaves))
+	copy(sorted.Leaves, metadata.Leaves)
+	sort.SliceStable(sorted.Leaves, func(i, j int) bool {
+		return sorted.Leaves[i].Index < sorted.Leaves[j].Index
+	})
 
-	// 读取所有chunk并组装（按排序后的顺序）
-	for _, leaf := range leaves {
-		chunkFile := getChunkPathFromHash(chunkPath, hex.EncodeToString(leaf.ChunkHash))
-		data, err := os.ReadFile(chunkFile)
+	// 只读取与范围重叠的chunk
+	first, last, skip := p2p.LeafRange(&sorted, start, length)
+	remaining := length
+	for i := first; i <= last && remaining > 0; i++ {
+		leaf := sorted.Leaves[i]
+		data, err := s.p2pService.ChunkStore.Get(hex.EncodeToString(leaf.ChunkHash))
 		if err != nil {
-			return fmt.Errorf("failed to read chunk %d (hash=%s) from %s: %w",
-				leaf.Index, hex.EncodeToString(leaf.ChunkHash)[:16], chunkFile, err)
+			return fmt.Errorf("failed to read chunk %d (hash=%s): %w",
+				leaf.Index, hex.EncodeToString(leaf.ChunkHash)[:16], err)
 		}
 
 		// 写入响应
-		if _, err := w.Write(data); err != nil {
+		n, err := io.Copy(w, io.NewSectionReader(bytes.NewReader(data), skip, remaining))
+		if err != nil {
 			return fmt.Errorf("failed to write chunk %d: %w", leaf.Index, err)
 		}
+		remaining -= n
+		skip = 0
+	}
+	if remaining > 0 {
+		return fmt.Errorf("local chunks ended %d bytes before range end", remaining)
 	}
 
 	return nil
@@ -514,6 +678,7 @@ func (s *Server) handleNodeInfo(w http.ResponseWriter, r *http.Request) {
 		"peerID":    peerID,
 		"addresses": addrs,
 		"protocols": s.p2pService.Host.Mux().Protocols(),
+		"storage":   s.p2pService.Quota.Usage(),
 	})
 }
 
@@ -574,6 +739,57 @@ func (s *Server) handlePeerConnect(w http.ResponseWriter, r *http.Request) {
 	s.respondError(w, http.StatusNotImplemented, "Connection feature not implemented")
 }
 
+// handleBandwidthStatus 查询带宽限制配置、当前生效的限速和流量统计
+func (s *Server) handleBandwidthStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.respondSuccess(w, s.p2pService.Bandwidth.Status())
+}
+
+// handleBandwidthUpdate 运行时修改带宽限制
+// 请求体中省略的字段保持不变；schedules 为空数组时清除所有时段计划
+func (s *Server) handleBandwidthUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Limits    *p2p.BandwidthLimits     `json:"limits"`
+		Schedules *[]p2p.BandwidthSchedule `json:"schedules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	bandwidth := s.p2pService.Bandwidth
+	if req.Limits != nil {
+		if err := req.Limits.Validate(); err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Schedules != nil {
+		if err := bandwidth.SetSchedules(*req.Schedules); err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Limits != nil {
+		if err := bandwidth.SetLimits(*req.Limits); err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	logrus.Infof("Bandwidth limits updated: %+v", bandwidth.Status().Active)
+	s.respondSuccess(w, bandwidth.Status())
+}
+
 // handleDHTFindProviders 查找DHT提供者
 func (s *Server) handleDHTFindProviders(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -705,6 +921,297 @@ func (s *Server) handleDHTPutValue(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleReprovideStatus 查询重新公告状态
+func (s *Server) handleReprovideStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.respondSuccess(w, s.p2pService.Reprovider.Status())
+}
+
+// handleReprovideTrigger 立即触发一轮重新公告
+func (s *Server) handleReprovideTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	triggered := s.p2pService.Reprovider.Trigger()
+	message := "Reprovide triggered"
+	if !triggered {
+		message = "Reprovide already pending"
+	}
+
+	s.respondSuccess(w, map[string]interface{}{
+		"triggered": triggered,
+		"message":   message,
+		"status":    s.p2pService.Reprovider.Status(),
+	})
+}
+
+// handleDownloadCreate 创建后台下载任务
+// 请求体: {"cid": "...", "fileName": "可选", "priority": 0}
+func (s *Server) handleDownloadCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		CID      string `json:"cid"`
+		FileName string `json:"fileName"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.CID == "" {
+		s.respondError(w, http.StatusBadRequest, "CID is required")
+		return
+	}
+
+	job, err := s.downloads.Add(req.CID, req.FileName, req.Priority)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.respondJSON(w, http.StatusCreated, APIResponse{Success: true, Data: job})
+}
+
+// handleDownloadList 列出所有后台下载任务（按优先级和创建时间排序）
+func (s *Server) handleDownloadList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	jobs := s.downloads.List()
+	s.respondSuccess(w, map[string]interface{}{
+		"count": len(jobs),
+		"jobs":  jobs,
+	})
+}
+
+// handleDownloadGet 查询单个后台下载任务
+func (s *Server) handleDownloadGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	job, err := s.downloads.Get(r.PathValue("id"))
+	if err != nil {
+		s.respondDownloadError(w, err)
+		return
+	}
+	s.respondSuccess(w, job)
+}
+
+// handleDownloadPause 暂停后台下载任务
+func (s *Server) handleDownloadPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	job, err := s.downloads.Pause(r.PathValue("id"))
+	if err != nil {
+		s.respondDownloadError(w, err)
+		return
+	}
+	s.respondSuccess(w, job)
+}
+
+// handleDownloadResume 继续暂停的任务或重试失败的任务
+func (s *Server) handleDownloadResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	job, err := s.downloads.Resume(r.PathValue("id"))
+	if err != nil {
+		s.respondDownloadError(w, err)
+		return
+	}
+	s.respondSuccess(w, job)
+}
+
+// handleDownloadCancel 取消并删除后台下载任务
+func (s *Server) handleDownloadCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := s.downloads.Cancel(id); err != nil {
+		s.respondDownloadError(w, err)
+		return
+	}
+	s.respondSuccess(w, map[string]interface{}{
+		"id":      id,
+		"message": "Download job canceled",
+	})
+}
+
+// respondDownloadError 将下载任务错误映射为 HTTP 状态码
+func (s *Server) respondDownloadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, p2p.ErrDownloadJobNotFound):
+		s.respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, p2p.ErrInvalidJobState):
+		s.respondError(w, http.StatusConflict, err.Error())
+	default:
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// handlePinList 列出被固定的文件
+func (s *Server) handlePinList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	pins := s.p2pService.Pins.List()
+	s.respondSuccess(w, map[string]interface{}{
+		"count": len(pins),
+		"pins":  pins,
+	})
+}
+
+// handlePinAdd 固定本地保存了元数据的文件
+// 请求体: {"cid": "..."}
+func (s *Server) handlePinAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		CID string `json:"cid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	cid := strings.ToLower(req.CID)
+	if _, err := hex.DecodeString(cid); err != nil || cid == "" {
+		s.respondError(w, http.StatusBadRequest, "Invalid CID format")
+		return
+	}
+	// 没有本地元数据时无法确定文件的分片，固定没有意义
+	if _, err := s.loadMetadata(cid); err != nil {
+		s.respondError(w, http.StatusNotFound, fmt.Sprintf("File not found locally: %v", err))
+		return
+	}
+	if err := s.p2pService.Pins.Pin(cid); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.respondSuccess(w, map[string]interface{}{
+		"cid":     cid,
+		"message": "File pinned",
+	})
+}
+
+// handlePinRemove 取消固定
+func (s *Server) handlePinRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	cid := strings.ToLower(r.PathValue("cid"))
+	if err := s.p2pService.Pins.Unpin(cid); err != nil {
+		if errors.Is(err, p2p.ErrNotPinned) {
+			s.respondError(w, http.StatusNotFound, err.Error())
+		} else {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	s.respondSuccess(w, map[string]interface{}{
+		"cid":     cid,
+		"message": "File unpinned",
+	})
+}
+
+// handleGCStatus 查询垃圾回收状态和最近一轮结果
+func (s *Server) handleGCStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.respondSuccess(w, s.p2pService.GC.Status())
+}
+
+// handleGCRun 在后台启动一轮垃圾回收，立即返回当前状态
+// 请求体（可选）: {"dryRun": true}，试运行只报告可回收的分片数和字节数
+// 结果通过 GET /api/v1/storage/gc 的 lastResult 查询
+func (s *Server) handleGCRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		DryRun bool `json:"dryRun"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	// 回收可能耗时较长，使用服务的上下文而不是请求的，客户端断开后回收继续
+	// 唯一的错误是已有回收在进行（ErrGCRunning）
+	if err := s.p2pService.GC.Trigger(s.p2pService.Ctx, req.DryRun); err != nil {
+		s.respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	s.respondSuccess(w, map[string]interface{}{
+		"started": true,
+		"dryRun":  req.DryRun,
+		"message": "Garbage collection started",
+		"status":  s.p2pService.GC.Status(),
+	})
+}
+
+// handleScrubStatus 查询完整性巡检状态和最近的隔离记录
+func (s *Server) handleScrubStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.respondSuccess(w, s.p2pService.Scrubber.Status())
+}
+
+// handleScrubTrigger 立即触发一轮完整性巡检（后台执行）
+func (s *Server) handleScrubTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	triggered := s.p2pService.Scrubber.Trigger()
+	message := "Scrub triggered"
+	if !triggered {
+		message = "Scrub already pending"
+	}
+
+	s.respondSuccess(w, map[string]interface{}{
+		"triggered": triggered,
+		"message":   message,
+		"status":    s.p2pService.Scrubber.Status(),
+	})
+}
+
 // handleChunkDownload 根据hash下载单个分片
 //
 // 功能说明:
@@ -712,6 +1219,7 @@ func (s *Server) handleDHTPutValue(w http.ResponseWriter, r *http.Request) {
 //   - 优先从本地存储读取，本地不存在时从P2P网络下载
 //   - P2P下载的分片会自动缓存到本地存储
 //   - 通过响应头 X-Chunk-Source 标识数据来源
+//   - 支持 Range（含多范围）、If-Range 和 If-None-Match，ETag 为分片哈希
 //
 // 路由: GET /api/v1/chunks/{hash}/download
 //
@@ -725,8 +1233,10 @@ func (s *Server) handleDHTPutValue(w http.ResponseWriter, r *http.Request) {
 //     * "local" - 从本地存储读取
 //     * "p2p-downloaded" - 从P2P网络下载并已缓存到本地
 //     * "p2p" - 从P2P网络下载（缓存失败）
+//   - ETag: "{hash}"
+//   - Accept-Ranges: bytes
 //
-// 响应体: 分片二进制数据
+// 响应体: 分片二进制数据（Range 请求时返回 206 和请求的范围）
 //
 // 错误响应:
 //   - 400 Bad Request - 无效的hash格式
@@ -764,17 +1274,23 @@ func (s *Server) handleChunkDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// chunk 内容由哈希决定，哈希即强 ETag；命中缓存时无需读取或下载
+	etag := fmt.Sprintf("\"%s\"", strings.ToLower(chunkHash))
+	w.Header().Set("ETag", etag)
+	if notModified(w, r, etag) {
+		return
+	}
+
 	// 设置响应头
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.bin\"", chunkHash))
 
 	// 优先从本地存储读取
-	chunkPath := getChunkPathFromHash(s.config.Storage.ChunkPath, chunkHash)
-	data, err := os.ReadFile(chunkPath)
-	if err == nil {
-		// 本地存在，直接返回
+	store := s.p2pService.ChunkStore
+	if data, err := store.Get(chunkHash); err == nil {
+		// 本地存在，直接返回（Range、If-Range 由 http.ServeContent 处理）
 		w.Header().Set("X-Chunk-Source", "local")
-		w.Write(data)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
 		return
 	}
 
@@ -782,7 +1298,7 @@ func (s *Server) handleChunkDownload(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// 查找拥有该chunk的peer
-	providers, err := s.p2pService.Lookup(ctx, chunkHash)
+	providers, err := s.p2pService.FindChunkProviders(ctx, chunkHash)
 	if err != nil || len(providers) == 0 {
 		s.respondError(w, http.StatusNotFound, fmt.Sprintf("Chunk not found locally or in P2P network: %v", err))
 		return
@@ -792,15 +1308,17 @@ func (s *Server) handleChunkDownload(w http.ResponseWriter, r *http.Request) {
 	var downloadErr error
 	for _, provider := range providers {
 		// 使用P2P服务下载chunk (provider.ID 是 peer.ID 类型)
+		var data []byte
 		data, downloadErr = s.p2pService.DownloadChunk(ctx, provider.ID, chunkHash)
 		if downloadErr == nil {
 			// 下载成功，保存到本地存储以便下次使用
-			if saveErr := os.WriteFile(chunkPath, data, 0644); saveErr == nil {
+			if saveErr := store.Put(chunkHash, data); saveErr == nil {
 				w.Header().Set("X-Chunk-Source", "p2p-downloaded")
 			} else {
+				logrus.Warnf("Failed to cache chunk %s: %v", chunkHash, saveErr)
 				w.Header().Set("X-Chunk-Source", "p2p")
 			}
-			w.Write(data)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
 			return
 		}
 	}
@@ -872,15 +1390,12 @@ func (s *Server) handleChunkInfo(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// 检查本地是否存在
-	chunkPath := getChunkPathFromHash(s.config.Storage.ChunkPath, chunkHash)
 	info := make(map[string]interface{})
 	info["hash"] = chunkHash
 
-	if _, err := os.Stat(chunkPath); err == nil {
+	if size, err := s.p2pService.ChunkStore.Size(chunkHash); err == nil {
 		info["local"] = true
-		if fileInfo, _ := os.Stat(chunkPath); fileInfo != nil {
-			info["size"] = fileInfo.Size()
-		}
+		info["size"] = size
 	} else {
 		info["local"] = false
 	}
@@ -906,6 +1421,14 @@ func (s *Server) handleChunkInfo(w http.ResponseWriter, r *http.Request) {
 
 // 辅助函数
 
+// uploadErrorStatus 返回上传失败对应的 HTTP 状态码，超过存储配额时为 507
+func uploadErrorStatus(err error) int {
+	if errors.Is(err, chunkStore.ErrQuotaExceeded) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
 // convertToChunkData 转换哈希列表为ChunkData，保存索引确保顺序
 func convertToChunkData(hashes [][]byte, chunkSize int) []file.ChunkData {
 	chunks := make([]file.ChunkData, len(hashes))
@@ -919,6 +1442,79 @@ func convertToChunkData(hashes [][]byte, chunkSize int) []file.ChunkData {
 	return chunks
 }
 
+// uploadChunking 解析上传请求的分块方式
+// mode 为空时使用配置的默认分块方式；固定分块始终使用 DefaultBlockSize
+func (s *Server) uploadChunking(mode string) (chunker.Config, error) {
+	cfg := s.config.Storage.UploadChunking()
+	if mode != "" {
+		cfg.Mode = mode
+	}
+	cfg.BlockSize = DefaultBlockSize
+	if err := cfg.Validate(); err != nil {
+		return chunker.Config{}, err
+	}
+	return cfg, nil
+}
+
+// updateChunking 确定更新文件使用的分块方式
+// mode 为空时沿用原元数据记录的分块参数，旧版元数据（没有记录）视为固定分块
+func (s *Server) updateChunking(mode string, info *file.ChunkingInfo) (chunker.Config, error) {
+	if mode != "" {
+		cfg, err := s.uploadChunking(mode)
+		if err != nil {
+			return chunker.Config{}, fmt.Errorf("invalid chunking: %w", err)
+		}
+		return cfg, nil
+	}
+	cfg := chunker.FromInfo(info, chunker.Config{Mode: chunker.ModeFixed, BlockSize: DefaultBlockSize})
+	if err := cfg.Validate(); err != nil {
+		return chunker.Config{}, fmt.Errorf("invalid chunking in metadata: %w", err)
+	}
+	return cfg, nil
+}
+
+// storeChunks 按分块方式重新切分临时文件，保存分块到本地存储
+// 参数:
+//   - tmpFile: 上传内容
+//   - chunkHashes: Merkle 树的叶子哈希（可能包含补齐的重复叶子）
+//   - chunking: 建树时使用的分块方式
+//
+// 返回值:
+//   - []file.ChunkData: 元数据叶子。固定分块沿用所有叶子（含补齐叶子）和固定块大小；
+//     FastCDC 只记录实际分块及其大小
+//   - []string: 需要 Announce 的分块哈希
+//   - error: 读取失败、分块与叶子哈希不一致或保存失败时返回错误
+func (s *Server) storeChunks(tmpFile *os.File, chunkHashes [][]byte, chunking chunker.Config) ([]file.ChunkData, []string, error) {
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to seek temp file: %w", err)
+	}
+
+	var leaves []file.ChunkData
+	announceHashes := make([]string, 0, len(chunkHashes))
+	err := chunker.Split(tmpFile, chunking, func(chunk []byte) error {
+		i := len(announceHashes)
+		hash := sha256.Sum256(chunk)
+		if i >= len(chunkHashes) || !bytes.Equal(hash[:], chunkHashes[i]) {
+			return fmt.Errorf("chunk %d does not match merkle tree leaf", i)
+		}
+		hashHex := hex.EncodeToString(hash[:])
+		if err := s.p2pService.ChunkStore.Put(hashHex, chunk); err != nil {
+			return fmt.Errorf("failed to save chunk %d: %w", i, err)
+		}
+		announceHashes = append(announceHashes, hashHex)
+		leaves = append(leaves, file.ChunkData{Index: i, ChunkSize: len(chunk), ChunkHash: hash[:]})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !chunking.IsContentDefined() {
+		leaves = convertToChunkData(chunkHashes, chunking.BlockSize)
+	}
+	return leaves, announceHashes, nil
+}
+
 // saveMetadata 保存元数据
 func (s *Server) saveMetadata(cid string, metadata *file.MetaData) error {
 	metadataPath := filepath.Join(s.config.HTTP.MetadataStoragePath, cid+".json")
@@ -936,9 +1532,22 @@ func (s *Server) saveMetadata(cid string, metadata *file.MetaData) error {
 		return fmt.Errorf("failed to write metadata file: %w", err)
 	}
 
+	// 索引失败不影响已保存的元数据，下次启动时会与元数据目录对账
+	if err := s.p2pService.MetaIndex.Put(cid, metadata); err != nil {
+		logrus.Warnf("Failed to update metadata index: %v", err)
+	}
+
 	return nil
 }
 
+// publishMetadata 发布签名的元数据到DHT（chameleon 文件需提供变色龙私钥，regular 文件传 nil）
+// 失败时只记录警告：本地元数据已保存，其他节点仍可通过 QueryMetaData 协议获取
+func (s *Server) publishMetadata(ctx context.Context, metadata *file.MetaData, chameleonPrivKey []byte) {
+	if err := s.p2pService.PublishMetaData(ctx, metadata, chameleonPrivKey); err != nil {
+		logrus.Warnf("Failed to publish metadata to DHT: %v", err)
+	}
+}
+
 // loadMetadata 加载元数据
 func (s *Server) loadMetadata(cid string) (*file.MetaData, error) {
 	metadataPath := filepath.Join(s.config.HTTP.MetadataStoragePath, cid+".json")
@@ -1041,10 +1650,10 @@ func (s *Server) handleFileUpdate(w http.ResponseWriter, r *http.Request) {
 
 	// 调用更新逻辑
 	logrus.Info("[FileUpdate] Calling updateFileChameleon...")
-	result, err := s.updateFileChameleon(r.Context(), file, header.Filename, cid, regularRootHash, randomNumStr, publicKeyStr, privKey)
+	result, err := s.updateFileChameleon(r.Context(), file, header.Filename, cid, regularRootHash, randomNumStr, publicKeyStr, privKey, r.FormValue("chunking"))
 	if err != nil {
 		logrus.Errorf("[FileUpdate] Update failed: %v", err)
-		s.respondError(w, http.StatusInternalServerError, fmt.Sprintf("Update failed: %v", err))
+		s.respondError(w, uploadErrorStatus(err), fmt.Sprintf("Update failed: %v", err))
 		return
 	}
 
@@ -1056,7 +1665,7 @@ func (s *Server) handleFileUpdate(w http.ResponseWriter, r *http.Request) {
 func (s *Server) updateFileChameleon(
 	ctx context.Context,
 	fileReader io.Reader,
-	fileName, cid, regularRootHashStr, randomNumStr, publicKeyStr, privKeyStr string,
+	fileName, cid, regularRootHashStr, randomNumStr, publicKeyStr, privKeyStr, chunkingMode string,
 ) (map[string]interface{}, error) {
 
 	logrus.Info("[FileUpdate] Starting updateFileChameleon")
@@ -1138,12 +1747,26 @@ func (s *Server) updateFileChameleon(
 		return nil, fmt.Errorf("invalid CID format: %w", err)
 	}
 
-	// 7. 调用 UpdateChameleonMerkleTree
+	// 7. 加载原元数据，确定分块方式（未指定时沿用原文件的分块参数，使未改变的分块可以复用）
+	metadata, err := s.loadMetadata(cid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	chunking, err := s.updateChunking(chunkingMode, metadata.Chunking)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("[FileUpdate] Chunking: %s", chunking.Mode)
+
+	// 8. 调用 UpdateChameleonMerkleTree
 	logrus.Info("[FileUpdate] Calling UpdateChameleonMerkleTree")
 	config := &chameleonMerkleTree.MerkleConfig{
 		BlockSize:    DefaultBlockSize,
 		BufferNumber: DefaultBufferNumber,
 	}
+	if chunking.IsContentDefined() {
+		config.Chunking = &chunking
+	}
 
 	newTree, err := chameleonMerkleTree.UpdateChameleonMerkleTree(
 		tmpFile,
@@ -1158,69 +1781,50 @@ func (s *Server) updateFileChameleon(
 		return nil, fmt.Errorf("failed to update merkle tree: %w", err)
 	}
 
-	// 8. 验证 CID 保持不变
+	// 9. 验证 CID 保持不变
 	newCID := newTree.GetChameleonHash()
 	if !bytes.Equal(newCID, cidBytes) {
 		return nil, fmt.Errorf("CID mismatch after update (expected %x, got %x)", cidBytes, newCID)
 	}
 
-	// 9. 计算新文件的 chunk 哈希
+	// 10. 计算新文件的 chunk 哈希
 	tmpFile.Seek(0, 0)
 	chunkHashes := newTree.GetAllLeavesHashes()
 
-	// 10. 保存新的 chunk 文件
-	chunkPath := s.config.Storage.ChunkPath
-	buffer := make([]byte, config.BlockSize)
-
-	for i, chunkHash := range chunkHashes {
-		// 重置文件指针到chunk起始位置
-		offset := int64(i) * int64(config.BlockSize)
-		if _, err := tmpFile.Seek(offset, 0); err != nil {
-			return nil, fmt.Errorf("failed to seek to chunk %d: %w", i, err)
-		}
-
-		// 读取chunk数据
-		n, err := tmpFile.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
-		}
-		chunkData := buffer[:n]
-
-		// 保存chunk到文件（使用子目录分片）
-		chunkFile, err := getChunkPath(chunkPath, chunkHash)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get chunk path %d: %w", i, err)
-		}
-		if err := os.WriteFile(chunkFile, chunkData, 0644); err != nil {
-			return nil, fmt.Errorf("failed to save chunk %d: %w", i, err)
-		}
-
-		// Announce 到 DHT
-		chunkHashStr := hex.EncodeToString(chunkHash)
-		if err := s.p2pService.Announce(ctx, chunkHashStr); err != nil {
-			logrus.Warnf("Failed to announce chunk %d: %v", i, err)
-		}
+	// 11. 保存新的 chunk 文件（保存元数据前阻止垃圾回收删除新写入的 chunk）
+	release := s.p2pService.GC.Hold(chunkHashes)
+	defer release()
+	if err := s.p2pService.Quota.CanStore(size); err != nil {
+		return nil, err
 	}
-
-	// 11. 加载并更新元数据
-	metadata, err := s.loadMetadata(cid)
+	leaves, announceHashes, err := s.storeChunks(tmpFile, chunkHashes, chunking)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load metadata: %w", err)
+		return nil, err
 	}
 
-	// 更新元数据
+	// 批量 Announce 到 DHT（同时公告文件 CID，表示本节点持有完整文件）
+	announceHashes = append(announceHashes, strings.ToLower(cid))
+	if err := s.p2pService.AnnounceBatch(ctx, announceHashes); err != nil {
+		logrus.Warnf("Failed to announce chunks: %v", err)
+	}
+
+	// 12. 更新元数据
 	metadata.RegularRootHash = newTree.GetRootHash()
 	metadata.RandomNum = newTree.GetRandomNumber().Serialize()
 	metadata.FileSize = uint64(size)
 	metadata.FileName = fileName
-	metadata.Leaves = convertToChunkData(chunkHashes, int(config.BlockSize))
+	metadata.Leaves = leaves
+	metadata.Chunking = chunking.Info()
 
 	// 保存元数据
-	if err := s.saveMetadata(cid, metadata); err != nil {
+	if err := s.p2pService.GC.Commit(func() error { return s.saveMetadata(cid, metadata) }); err != nil {
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
 	}
 
-	// 12. 返回结果
+	// 发布更新后的元数据到DHT
+	s.publishMetadata(ctx, metadata, privKey)
+
+	// 13. 返回结果
 	return map[string]interface{}{
 		"cid":             cid,
 		"fileName":        fileName,
@@ -1228,7 +1832,8 @@ func (s *Server) updateFileChameleon(
 		"regularRootHash": hex.EncodeToString(newTree.GetRootHash()),
 		"randomNum":       hex.EncodeToString(newTree.GetRandomNumber().Serialize()),
 		"publicKey":       hex.EncodeToString(pubKey.Serialize()),
-		"chunkCount":      len(chunkHashes),
+		"chunkCount":      len(leaves),
+		"chunking":        chunking.Mode,
 		"fileSize":        size,
 		"message":         "File updated successfully",
 	}, nil
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxRanges 单个请求允许的最大范围数量，超过时忽略 Range 头返回完整内容
+const maxRanges = 32
+
+// errUnsatisfiableRange 请求的范围都不在内容大小内
+var errUnsatisfiableRange = errors.New("requested range not satisfiable")
+
+// httpRange 字节范围 [start, start+length)
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange 解析 Range 请求头（只支持 bytes 单位）
+// 语法错误时返回其他错误（调用方应忽略 Range 头），所有范围都无法满足时返回 errUnsatisfiableRange
+func parseRange(header string, size int64) ([]httpRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, fmt.Errorf("unsupported range unit")
+	}
+
+	var ranges []httpRange
+	for _, spec := range strings.Split(header[len(prefix):], ",") {
+		spec = textproto.TrimString(spec)
+		if spec == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q", spec)
+		}
+		startStr, endStr = textproto.TrimString(startStr), textproto.TrimString(endStr)
+
+		var r httpRange
+		if startStr == "" {
+			// 后缀范围 "-N": 最后 N 个字节
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid range %q", spec)
+			}
+			if n == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = httpRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range %q", spec)
+			}
+			end := size - 1
+			if endStr != "" {
+				end, err = strconv.ParseInt(endStr, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("invalid range %q", spec)
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = httpRange{start: start, length: end - start + 1}
+		}
+		if r.length > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// etagMatches 判断 If-None-Match / If-Range 中的 ETag 列表是否包含 etag
+// weak 为 true 时使用弱比较（忽略 W/ 前缀）
+func etagMatches(list, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = textproto.TrimString(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified 处理 If-None-Match，匹配时返回 304 并返回 true
+// 调用方需先设置 ETag 响应头
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" || !etagMatches(inm, etag, true) {
+		return false
+	}
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Disposition")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// requestedRanges 返回需要返回的范围，nil 表示返回完整内容
+// If-Range 不匹配（内容已变化或是日期）时忽略 Range 头
+func requestedRanges(r *http.Request, size int64, etag string) ([]httpRange, error) {
+	header := r.Header.Get("Range")
+	if header == "" || r.Method != http.MethodGet {
+		return nil, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !etagMatches(ifRange, etag, false) {
+		return nil, nil
+	}
+
+	ranges, err := parseRange(header, size)
+	if err != nil {
+		if errors.Is(err, errUnsatisfiableRange) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	// 范围过多或总长度超过内容大小（大量重叠）时返回完整内容
+	var total int64
+	for _, rg := range ranges {
+		total += rg.length
+	}
+	if len(ranges) > maxRanges || total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// serveRanges 处理条件请求和范围请求，数据由 writeRange 写出
+// 参数:
+//   - w, r: HTTP 响应与请求
+//   - name: 日志中使用的内容标识
+//   - size: 内容总大小
+//   - etag: 强 ETag（含引号）
+//   - header: 设置完整响应的 Content-Type、Content-Disposition 等头
+//   - writeRange: 将 [start, start+length) 写入 w
+func (s *Server) serveRanges(w http.ResponseWriter, r *http.Request, name string, size int64, etag string,
+	header func(http.Header), writeRange func(w io.Writer, start, length int64) error) {
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if notModified(w, r, etag) {
+		return
+	}
+
+	ranges, err := requestedRanges(r, size, etag)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		s.respondError(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+		return
+	}
+
+	switch len(ranges) {
+	case 0:
+		dw := &downloadResponseWriter{w: w, header: func(h http.Header) {
+			header(h)
+			h.Set("Content-Length", strconv.FormatInt(size, 10))
+		}}
+		if err := writeRange(dw, 0, size); err != nil {
+			s.abortDownload(w, dw, name, err)
+			return
+		}
+		dw.commit()
+
+	case 1:
+		rg := ranges[0]
+		dw := &downloadResponseWriter{w: w, status: http.StatusPartialContent, header: func(h http.Header) {
+			header(h)
+			h.Set("Content-Range", rg.contentRange(size))
+			h.Set("Content-Length", strconv.FormatInt(rg.length, 10))
+		}}
+		if err := writeRange(dw, rg.start, rg.length); err != nil {
+			s.abortDownload(w, dw, name, err)
+			return
+		}
+		dw.commit()
+
+	default:
+		// 多个范围: multipart/byteranges
+		partHeader := http.Header{}
+		header(partHeader)
+		contentType := partHeader.Get("Content-Type")
+
+		dw := &downloadResponseWriter{w: w, status: http.StatusPartialContent}
+		mw := multipart.NewWriter(dw)
+		dw.header = func(h http.Header) {
+			header(h)
+			h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+			h.Set("Content-Length", strconv.FormatInt(multipartLength(ranges, size, contentType, mw.Boundary()), 10))
+		}
+		for _, rg := range ranges {
+			part, err := mw.CreatePart(rangePartHeader(rg, size, contentType))
+			if err == nil {
+				err = writeRange(part, rg.start, rg.length)
+			}
+			if err != nil {
+				s.abortDownload(w, dw, name, err)
+				return
+			}
+		}
+		if err := mw.Close(); err != nil {
+			s.abortDownload(w, dw, name, err)
+		}
+	}
+}
+
+func rangePartHeader(rg httpRange, size int64, contentType string) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {rg.contentRange(size)},
+	}
+}
+
+// multipartLength 计算 multipart/byteranges 响应体的长度
+func multipartLength(ranges []httpRange, size int64, contentType, boundary string) int64 {
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	mw.SetBoundary(boundary)
+	for _, rg := range ranges {
+		mw.CreatePart(rangePartHeader(rg, size, contentType))
+		cw.n += rg.length
+	}
+	mw.Close()
+	return cw.n
+}
+
+// countingWriter 只统计写入的字节数
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
@@ -15,6 +15,7 @@ import (
 type Server struct {
 	server      *http.Server
 	p2pService  *p2p.P2PService
+	downloads   *p2p.DownloadManager
 	config      *config.Config
 	router      *http.ServeMux
 	mu          sync.RWMutex
@@ -28,6 +29,43 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	p2pCfg := p2p.NewP2PConfig()
 	// 使用配置文件中的存储路径，而不是硬编码的默认值
 	p2pCfg.ChunkStoragePath = cfg.Storage.ChunkPath
+	// 元数据目录与 HTTP 上传保存的目录一致，供 QueryMetaData 协议对外提供元数据
+	p2pCfg.MetadataStoragePath = cfg.HTTP.MetadataStoragePath
+	// 重新公告配置（未配置时使用默认值）
+	if cfg.Reprovider.Concurrency > 0 {
+		p2pCfg.ReprovideInterval = cfg.Reprovider.Interval
+		p2pCfg.ReprovideJitter = cfg.Reprovider.Jitter
+		p2pCfg.ReprovideConcurrency = cfg.Reprovider.Concurrency
+		p2pCfg.ReprovideOnStart = cfg.Reprovider.OnStart
+	}
+	// 下载调度策略（未配置时使用默认的顺序调度）
+	if cfg.Performance.Scheduler != "" {
+		p2pCfg.Scheduler = cfg.Performance.Scheduler
+	}
+	// 范围下载的预取和缓存（read_ahead 为 0 表示不预取，reader_cache 未配置时使用默认值）
+	if cfg.Performance.ReadAhead >= 0 {
+		p2pCfg.ReadAheadChunks = cfg.Performance.ReadAhead
+	}
+	if cfg.Performance.ReaderCache > 0 {
+		p2pCfg.ReaderCacheChunks = cfg.Performance.ReaderCache
+	}
+	// 下载时要求对端附带 Merkle 证明（轻量元数据的文件总是使用证明）
+	p2pCfg.VerifyChunkProofs = cfg.Performance.VerifyProofs
+	// 传输压缩（未配置时使用默认的 zstd、gzip 和 64MB 缓存）
+	if len(cfg.Compression.Codecs) > 0 {
+		p2pCfg.CompressionCodecs = cfg.Compression.Codecs
+		p2pCfg.CompressionCacheSize = int64(cfg.Compression.CacheSize) * 1024 * 1024
+	}
+	// 定期垃圾回收（0 表示只通过 /api/v1/storage/gc 手动触发）
+	p2pCfg.GCInterval = cfg.Storage.GCInterval
+	// 存储配额（0 表示不限制，超过时淘汰最久未读取的未固定分块）
+	p2pCfg.MaxStorageBytes = cfg.Storage.MaxBytes
+	// 完整性巡检（0 表示只通过 /api/v1/storage/scrub 手动触发）
+	p2pCfg.ScrubInterval = cfg.Storage.ScrubInterval
+	p2pCfg.ScrubRate = cfg.Storage.ScrubRate
+	p2pCfg.QuarantinePath = cfg.Storage.QuarantinePath
+	// 带宽限制（未配置时不限速，运行时可通过 /api/v1/node/bandwidth 调整）
+	p2pCfg.BandwidthLimits, p2pCfg.BandwidthSchedules = cfg.Bandwidth.ToP2P()
 	// 可选：也可以使用配置文件中的其他值
 	// p2pCfg.MaxRetries = cfg.Performance.MaxRetries
 	// p2pCfg.MaxConcurrency = cfg.Performance.MaxConcurrency
@@ -40,12 +78,24 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		return nil, fmt.Errorf("failed to create P2P service: %w", err)
 	}
 
+	// 后台下载任务（未配置时使用 ./downloads）
+	downloadDir := cfg.Downloads.Dir
+	if downloadDir == "" {
+		downloadDir = "downloads"
+	}
+	downloads, err := p2p.NewDownloadManager(p2pSvc, downloadDir, cfg.Downloads.MaxActive)
+	if err != nil {
+		p2pSvc.Shutdown()
+		return nil, fmt.Errorf("failed to create download manager: %w", err)
+	}
+
 	// 创建路由器
 	router := http.NewServeMux()
 
 	// 创建HTTP服务器
 	srv := &Server{
 		p2pService: p2pSvc,
+		downloads:  downloads,
 		config:     cfg,
 		router:     router,
 		started:    false,
@@ -74,7 +124,9 @@ func (s *Server) registerRoutes() {
 	// 文件操作
 	s.router.HandleFunc("POST /api/v1/files/upload", s.handleFileUpload)
 	s.router.HandleFunc("POST /api/v1/files/update", s.handleFileUpdate)
+	s.router.HandleFunc("GET /api/v1/files", s.handleFileList)
 	s.router.HandleFunc("GET /api/v1/files/{cid}", s.handleFileInfo)
+	s.router.HandleFunc("DELETE /api/v1/files/{cid}", s.handleFileDelete)
 	s.router.HandleFunc("GET /api/v1/files/{cid}/download", s.handleFileDownload)
 
 	// 分片操作
@@ -85,12 +137,33 @@ func (s *Server) registerRoutes() {
 	s.router.HandleFunc("GET /api/v1/node/info", s.handleNodeInfo)
 	s.router.HandleFunc("GET /api/v1/node/peers", s.handlePeerList)
 	s.router.HandleFunc("POST /api/v1/node/connect", s.handlePeerConnect)
+	s.router.HandleFunc("GET /api/v1/node/bandwidth", s.handleBandwidthStatus)
+	s.router.HandleFunc("PUT /api/v1/node/bandwidth", s.handleBandwidthUpdate)
+
+	// 后台下载任务
+	s.router.HandleFunc("POST /api/v1/downloads", s.handleDownloadCreate)
+	s.router.HandleFunc("GET /api/v1/downloads", s.handleDownloadList)
+	s.router.HandleFunc("GET /api/v1/downloads/{id}", s.handleDownloadGet)
+	s.router.HandleFunc("POST /api/v1/downloads/{id}/pause", s.handleDownloadPause)
+	s.router.HandleFunc("POST /api/v1/downloads/{id}/resume", s.handleDownloadResume)
+	s.router.HandleFunc("DELETE /api/v1/downloads/{id}", s.handleDownloadCancel)
+
+	// 存储管理
+	s.router.HandleFunc("GET /api/v1/storage/pins", s.handlePinList)
+	s.router.HandleFunc("POST /api/v1/storage/pins", s.handlePinAdd)
+	s.router.HandleFunc("DELETE /api/v1/storage/pins/{cid}", s.handlePinRemove)
+	s.router.HandleFunc("GET /api/v1/storage/gc", s.handleGCStatus)
+	s.router.HandleFunc("POST /api/v1/storage/gc", s.handleGCRun)
+	s.router.HandleFunc("GET /api/v1/storage/scrub", s.handleScrubStatus)
+	s.router.HandleFunc("POST /api/v1/storage/scrub", s.handleScrubTrigger)
 
 	// DHT操作
 	s.router.HandleFunc("GET /api/v1/dht/providers/{key}", s.handleDHTFindProviders)
 	s.router.HandleFunc("POST /api/v1/dht/announce", s.handleDHTAnnounce)
 	s.router.HandleFunc("GET /api/v1/dht/value/{key}", s.handleDHTGetValue)
 	s.router.HandleFunc("POST /api/v1/dht/value", s.handleDHTPutValue)
+	s.router.HandleFunc("GET /api/v1/dht/reprovide", s.handleReprovideStatus)
+	s.router.HandleFunc("POST /api/v1/dht/reprovide", s.handleReprovideTrigger)
 }
 
 // Start 启动HTTP服务器
@@ -108,17 +181,36 @@ func (s *Server) Start() error {
 	fmt.Println("  GET    /api/health")
 	fmt.Println("  POST   /api/v1/files/upload")
 	fmt.Println("  POST   /api/v1/files/update")
+	fmt.Println("  GET    /api/v1/files")
 	fmt.Println("  GET    /api/v1/files/{cid}")
+	fmt.Println("  DELETE /api/v1/files/{cid}")
 	fmt.Println("  GET    /api/v1/files/{cid}/download")
 	fmt.Println("  GET    /api/v1/chunks/{hash}")
 	fmt.Println("  GET    /api/v1/chunks/{hash}/download")
 	fmt.Println("  GET    /api/v1/node/info")
 	fmt.Println("  GET    /api/v1/node/peers")
 	fmt.Println("  POST   /api/v1/node/connect")
+	fmt.Println("  GET    /api/v1/node/bandwidth")
+	fmt.Println("  PUT    /api/v1/node/bandwidth")
+	fmt.Println("  POST   /api/v1/downloads")
+	fmt.Println("  GET    /api/v1/downloads")
+	fmt.Println("  GET    /api/v1/downloads/{id}")
+	fmt.Println("  POST   /api/v1/downloads/{id}/pause")
+	fmt.Println("  POST   /api/v1/downloads/{id}/resume")
+	fmt.Println("  DELETE /api/v1/downloads/{id}")
+	fmt.Println("  GET    /api/v1/storage/pins")
+	fmt.Println("  POST   /api/v1/storage/pins")
+	fmt.Println("  DELETE /api/v1/storage/pins/{cid}")
+	fmt.Println("  GET    /api/v1/storage/gc")
+	fmt.Println("  POST   /api/v1/storage/gc")
+	fmt.Println("  GET    /api/v1/storage/scrub")
+	fmt.Println("  POST   /api/v1/storage/scrub")
 	fmt.Println("  GET    /api/v1/dht/providers/{key}")
 	fmt.Println("  POST   /api/v1/dht/announce")
 	fmt.Println("  GET    /api/v1/dht/value/{key}")
 	fmt.Println("  POST   /api/v1/dht/value")
+	fmt.Println("  GET    /api/v1/dht/reprovide")
+	fmt.Println("  POST   /api/v1/dht/reprovide")
 	fmt.Println()
 
 	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -145,7 +237,8 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		return fmt.Errorf("HTTP server shutdown error: %w", err)
 	}
 
-	// 关闭P2P服务
+	// 停止后台下载（运行中的任务下次启动时继续），再关闭P2P服务
+	s.downloads.Close()
 	s.p2pService.Shutdown()
 
 	fmt.Println("HTTP API server stopped")
@@ -14,5 +14,7 @@ var FileCmd = &cobra.Command{
 This command group provides operations for:
   • Uploading files to the network
   • Downloading files from the network
-  • Viewing file metadata information`,
+  • Viewing file metadata information
+  • Pinning files and garbage-collecting unreferenced chunks
+  • Scrubbing local chunks for corruption`,
 }
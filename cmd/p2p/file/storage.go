@@ -0,0 +1,189 @@
+// Package file provides local storage management commands (pinning, garbage collection and scrubbing)
+package file
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/p2p"
+)
+
+var (
+	storageMetadataDir string
+	storageChunkDir    string
+	gcDryRun           bool
+	scrubRate          int64
+)
+
+// gcCmd runs one garbage collection pass over the local chunk store
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove chunks not referenced by any local metadata",
+	Long: `Run mark-and-sweep garbage collection on the local chunk store.
+
+Chunks referenced by any metadata file in the metadata directory are kept;
+all other chunks are deleted. Use --dry-run to only report what would be
+removed and how many bytes would be reclaimed.
+
+Do not run this while another process is uploading into the same
+directories.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGC(context.Background(), gcDryRun)
+	},
+}
+
+// scrubCmd re-hashes every local chunk once
+var scrubCmd = &cobra.Command{
+	Use:   "scrub",
+	Short: "Verify local chunks and quarantine corrupt ones",
+	Long: `Re-hash every chunk in the local chunk store and compare it with its name.
+
+Corrupt chunks are moved to the quarantine directory (<chunks>/.quarantine)
+and removed from the store, so they are no longer served or announced.
+The command then tries to fetch a good copy from other peers; a node
+without connected peers can only quarantine.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runScrub(context.Background())
+	},
+}
+
+// pinCmd pins a file so its chunks are never evicted
+var pinCmd = &cobra.Command{
+	Use:   "pin <cid>",
+	Short: "Pin a file stored on this node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pins, err := loadStoragePins()
+		if err != nil {
+			return err
+		}
+		if err := pins.Pin(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Pinned %s\n", args[0])
+		return nil
+	},
+}
+
+// unpinCmd removes a pin
+var unpinCmd = &cobra.Command{
+	Use:   "unpin <cid>",
+	Short: "Unpin a file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pins, err := loadStoragePins()
+		if err != nil {
+			return err
+		}
+		if err := pins.Unpin(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Unpinned %s\n", args[0])
+		return nil
+	},
+}
+
+// pinsCmd lists pinned files
+var pinsCmd = &cobra.Command{
+	Use:   "pins",
+	Short: "List pinned files",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pins, err := loadStoragePins()
+		if err != nil {
+			return err
+		}
+		list := pins.List()
+		if len(list) == 0 {
+			fmt.Println("No pinned files")
+			return nil
+		}
+		for _, pin := range list {
+			fmt.Printf("%s  %s\n", pin.PinnedAt.Format("2006-01-02 15:04:05"), pin.CID)
+		}
+		return nil
+	},
+}
+
+func init() {
+	defaults := p2p.NewP2PConfig()
+	for _, cmd := range []*cobra.Command{gcCmd, scrubCmd, pinCmd, unpinCmd, pinsCmd} {
+		FileCmd.AddCommand(cmd)
+		cmd.Flags().StringVarP(&storageMetadataDir, "metadata", "m", defaults.MetadataStoragePath, "Metadata directory")
+	}
+	gcCmd.Flags().StringVar(&storageChunkDir, "chunks", defaults.ChunkStoragePath, "Chunk storage directory")
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "Only report what would be removed")
+	scrubCmd.Flags().StringVar(&storageChunkDir, "chunks", defaults.ChunkStoragePath, "Chunk storage directory")
+	scrubCmd.Flags().Int64Var(&scrubRate, "rate", 0, "Read rate in bytes per second (0 for unlimited)")
+}
+
+func loadStoragePins() (*p2p.PinSet, error) {
+	return p2p.LoadPinSet(filepath.Join(storageMetadataDir, p2p.PinSetFileName))
+}
+
+func runGC(ctx context.Context, dryRun bool) error {
+	store, err := chunkStore.NewFSStore(storageChunkDir)
+	if err != nil {
+		return err
+	}
+	pins, err := loadStoragePins()
+	if err != nil {
+		return err
+	}
+
+	result, err := p2p.NewGarbageCollector(store, storageMetadataDir, pins, 0).Run(ctx, dryRun)
+	if err != nil {
+		return fmt.Errorf("garbage collection failed: %w", err)
+	}
+
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("\n✓ Garbage collection complete!\n")
+	fmt.Printf("  Files: %d (%d pinned)\n", result.Files, result.PinnedFiles)
+	fmt.Printf("  Chunks: %d (%d bytes), %d referenced\n", result.TotalChunks, result.TotalBytes, result.ReferencedChunks)
+	fmt.Printf("  %s: %d chunks, %d bytes\n", verb, result.RemovedChunks, result.ReclaimedBytes)
+	if result.Failed > 0 {
+		fmt.Printf("  Failed: %d chunks\n", result.Failed)
+	}
+	for _, cid := range result.MissingPinned {
+		fmt.Printf("  ⚠️  Pinned file has no local metadata: %s\n", cid)
+	}
+	return nil
+}
+
+func runScrub(ctx context.Context) error {
+	p2pConfig := p2p.NewP2PConfig()
+	p2pConfig.ChunkStoragePath = storageChunkDir
+	p2pConfig.MetadataStoragePath = storageMetadataDir
+	p2pConfig.ScrubInterval = 0
+	p2pConfig.ScrubRate = scrubRate
+	p2pConfig.ReprovideOnStart = false
+	service, err := p2p.NewP2PService(ctx, p2pConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create P2P service: %w", err)
+	}
+	defer service.Shutdown()
+
+	result, err := service.Scrubber.RunOnce(ctx)
+	if err != nil {
+		return fmt.Errorf("scrub failed: %w", err)
+	}
+
+	fmt.Printf("\n✓ Scrub complete!\n")
+	fmt.Printf("  Checked: %d chunks, %d bytes\n", result.Chunks, result.Bytes)
+	fmt.Printf("  Corrupt: %d (%d repaired)\n", result.Corrupt, result.Repaired)
+	if result.Failed > 0 {
+		fmt.Printf("  Failed: %d chunks\n", result.Failed)
+	}
+	for _, record := range service.Scrubber.Status().Quarantined {
+		fmt.Printf("  ⚠️  %s: %s, quarantined to %s\n", record.Hash, record.Reason, record.Path)
+	}
+	return nil
+}
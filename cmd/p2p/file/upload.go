@@ -11,6 +11,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/sirupsen/logrus"
 	"p2pFileTransfer/pkg/chameleonMerkleTree"
+	"p2pFileTransfer/pkg/chunker"
 	"p2pFileTransfer/pkg/file"
 	"p2pFileTransfer/pkg/p2p"
 )
@@ -21,6 +22,10 @@ var (
 	metadataPath string
 	chunkSize    uint
 	showProgress bool
+	chunkingMode string // fixed | fastcdc
+	cdcMinSize   int
+	cdcAvgSize   int
+	cdcMaxSize   int
 )
 
 // uploadCmd represents the file upload command
@@ -35,7 +40,14 @@ Supports two Merkle tree types:
     that may need to be modified later.
 
   - regular: Standard immutable SHA256 Merkle tree.
-    Simpler and faster. Suitable for one-time uploads.`,
+    Simpler and faster. Suitable for one-time uploads.
+
+Supports two chunking modes:
+  - fixed (default): Fixed-size chunks of --chunk-size bytes.
+
+  - fastcdc: Content-defined chunking. Chunk boundaries follow the
+    data, so inserting or deleting bytes only changes nearby chunks
+    and the rest can be deduplicated across versions.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filePath := args[0]
@@ -52,6 +64,11 @@ func init() {
 	uploadCmd.Flags().StringVarP(&metadataPath, "output", "o", "", "Metadata output path")
 	uploadCmd.Flags().UintVar(&chunkSize, "chunk-size", 262144, "Chunk size in bytes")
 	uploadCmd.Flags().BoolVarP(&showProgress, "progress", "p", false, "Show progress bar")
+	uploadCmd.Flags().StringVar(&chunkingMode, "chunking", chunker.ModeFixed,
+		"Chunking mode: fixed (--chunk-size blocks) | fastcdc (content-defined)")
+	uploadCmd.Flags().IntVar(&cdcMinSize, "cdc-min", chunker.DefaultMinSize, "FastCDC minimum chunk size in bytes")
+	uploadCmd.Flags().IntVar(&cdcAvgSize, "cdc-avg", chunker.DefaultAvgSize, "FastCDC average chunk size in bytes")
+	uploadCmd.Flags().IntVar(&cdcMaxSize, "cdc-max", chunker.DefaultMaxSize, "FastCDC maximum chunk size in bytes")
 }
 
 func uploadFile(ctx context.Context, filePath string) error {
@@ -59,6 +76,9 @@ func uploadFile(ctx context.Context, filePath string) error {
 	if treeType != "chameleon" && treeType != "regular" {
 		return fmt.Errorf("invalid tree-type: %s (must be 'chameleon' or 'regular')", treeType)
 	}
+	if err := uploadChunking().Validate(); err != nil {
+		return fmt.Errorf("invalid chunking: %w", err)
+	}
 
 	// Route to appropriate upload function based on tree type
 	if treeType == "chameleon" {
@@ -87,7 +107,8 @@ func uploadFileChameleon(ctx context.Context, filePath string) error {
 	privKey, pubKey := chameleonMerkleTree.NewChameleonKeyPair()
 
 	// 4. Read file and compute SHA256 hashes for each chunk
-	chunks, err := p2p.CalculateChunkHashes(f, chunkSize)
+	chunking := uploadChunking()
+	chunks, err := p2p.CalculateChunks(f, chunking)
 	if err != nil {
 		return fmt.Errorf("failed to calculate chunk hashes: %w", err)
 	}
@@ -112,28 +133,26 @@ func uploadFileChameleon(ctx context.Context, filePath string) error {
 	// 6. Create P2P service
 	logrus.Info("Creating P2P service...")
 	p2pConfig := p2p.NewP2PConfig()
+	p2pConfig.MetadataStoragePath = metadataOutputDir() // pins.json lives next to the metadata
 	service, err := p2p.NewP2PService(ctx, p2pConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create P2P service: %w", err)
 	}
 	defer service.Shutdown()
 
-	// 7. Upload chunks to storage directory and Announce
-	chunkPath := p2pConfig.ChunkStoragePath
-	logrus.Infof("Uploading %d chunks to %s...", len(chunks), chunkPath)
+	// 7. Upload chunks to the chunk store and Announce
+	logrus.Infof("Uploading %d chunks to %s...", len(chunks), p2pConfig.ChunkStoragePath)
 
-	// Ensure chunk storage directory exists
-	if err := os.MkdirAll(chunkPath, 0755); err != nil {
-		return fmt.Errorf("failed to create chunk storage directory: %w", err)
-	}
+	// Keep garbage collection from removing the new chunks before the metadata is saved
+	release := service.GC.Hold(chunkHashes(chunks))
+	defer release()
 
+	announceHashes := make([]string, 0, len(chunks))
 	for i, chunk := range chunks {
 		logrus.Debugf("Chunk %d hash length: %d bytes", i, len(chunk.Hash))
 
 		// Write chunk to storage
-		chunkFile := filepath.Join(chunkPath, fmt.Sprintf("%x", chunk.Hash))
-		logrus.Debugf("Writing chunk %d to %s (path length: %d)", i, chunkFile, len(chunkFile))
-		if err := os.WriteFile(chunkFile, chunk.Data, 0644); err != nil {
+		if err := service.ChunkStore.Put(fmt.Sprintf("%x", chunk.Hash), chunk.Data); err != nil {
 			return fmt.Errorf("failed to write chunk %d: %w", i, err)
 		}
 
@@ -141,11 +160,13 @@ func uploadFileChameleon(ctx context.Context, filePath string) error {
 			fmt.Printf("[%d/%d] Uploaded chunk\n", i+1, len(chunks))
 		}
 
-		// Announce chunk to DHT
-		chunkHashStr := fmt.Sprintf("%x", chunk.Hash)
-		if err := service.Announce(ctx, chunkHashStr); err != nil {
-			logrus.Warnf("Failed to announce chunk %d: %v", i, err)
-		}
+		announceHashes = append(announceHashes, fmt.Sprintf("%x", chunk.Hash))
+	}
+
+	// Announce all chunks and the file CID (this node is a full seeder) to DHT in batches
+	announceHashes = append(announceHashes, fmt.Sprintf("%x", cid))
+	if err := service.AnnounceBatch(ctx, announceHashes); err != nil {
+		logrus.Warnf("Failed to announce chunks: %v", err)
 	}
 
 	// 9. Get random number and regular root hash from Chameleon Merkle Tree
@@ -170,12 +191,21 @@ func uploadFileChameleon(ctx context.Context, filePath string) error {
 		Encryption:      "none",
 		TreeType:        "chameleon",
 		Leaves:          leaves,
+		Chunking:        chunking.Info(),
 	}
 
-	// 11. Save MetaData and private key
-	if err := saveMetadataAndKey(metadata, privKey, cid); err != nil {
+	// 11. Save MetaData and private key (the metadata index picks the file up on its next query)
+	if err := service.GC.Commit(func() error { return saveMetadataAndKey(metadata, privKey, cid) }); err != nil {
 		return err
 	}
+	if err := service.Pins.Pin(fmt.Sprintf("%x", cid)); err != nil {
+		logrus.Warnf("Failed to pin uploaded file: %v", err)
+	}
+
+	// 12. Publish MetaData to DHT so other nodes can download by CID
+	if err := service.PublishMetaData(ctx, metadata, privKey); err != nil {
+		logrus.Warnf("Failed to publish metadata to DHT: %v", err)
+	}
 
 	printUploadSummary(fileName, fileSize, len(chunks), cid, "chameleon", regularRootHash)
 	return nil
@@ -198,7 +228,8 @@ func uploadFileRegular(ctx context.Context, filePath string) error {
 	fileSize := fileInfo.Size()
 
 	// 3. Read file and calculate all chunk hashes
-	chunks, err := p2p.CalculateChunkHashes(f, chunkSize)
+	chunking := uploadChunking()
+	chunks, err := p2p.CalculateChunks(f, chunking)
 	if err != nil {
 		return fmt.Errorf("failed to calculate chunk hashes: %w", err)
 	}
@@ -210,19 +241,23 @@ func uploadFileRegular(ctx context.Context, filePath string) error {
 	// 5. Create P2P service
 	logrus.Info("Creating P2P service...")
 	p2pConfig := p2p.NewP2PConfig()
+	p2pConfig.MetadataStoragePath = metadataOutputDir() // pins.json lives next to the metadata
 	service, err := p2p.NewP2PService(ctx, p2pConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create P2P service: %w", err)
 	}
 	defer service.Shutdown()
 
-	// 6. Upload chunks to storage directory and Announce
-	chunkPath := p2pConfig.ChunkStoragePath
-	logrus.Infof("Uploading %d chunks to %s...", len(chunks), chunkPath)
+	// 6. Upload chunks to the chunk store and Announce
+	logrus.Infof("Uploading %d chunks to %s...", len(chunks), p2pConfig.ChunkStoragePath)
 
+	// Keep garbage collection from removing the new chunks before the metadata is saved
+	release := service.GC.Hold(chunkHashes(chunks))
+	defer release()
+
+	announceHashes := make([]string, 0, len(chunks))
 	for i, chunk := range chunks {
-		chunkFile := filepath.Join(chunkPath, fmt.Sprintf("%x", chunk.Hash))
-		if err := os.WriteFile(chunkFile, chunk.Data, 0644); err != nil {
+		if err := service.ChunkStore.Put(fmt.Sprintf("%x", chunk.Hash), chunk.Data); err != nil {
 			return fmt.Errorf("failed to write chunk %d: %w", i, err)
 		}
 
@@ -230,10 +265,13 @@ func uploadFileRegular(ctx context.Context, filePath string) error {
 			fmt.Printf("[%d/%d] Uploaded chunk\n", i+1, len(chunks))
 		}
 
-		chunkHashStr := fmt.Sprintf("%x", chunk.Hash)
-		if err := service.Announce(ctx, chunkHashStr); err != nil {
-			logrus.Warnf("Failed to announce chunk %d: %v", i, err)
-		}
+		announceHashes = append(announceHashes, fmt.Sprintf("%x", chunk.Hash))
+	}
+
+	// Announce all chunks and the file CID (this node is a full seeder) to DHT in batches
+	announceHashes = append(announceHashes, fmt.Sprintf("%x", cid))
+	if err := service.AnnounceBatch(ctx, announceHashes); err != nil {
+		logrus.Warnf("Failed to announce chunks: %v", err)
 	}
 
 	// 7. Generate MetaData (no key info needed)
@@ -247,12 +285,21 @@ func uploadFileRegular(ctx context.Context, filePath string) error {
 		Encryption:  "none",
 		TreeType:    "regular",
 		Leaves:      convertChunksToChunkData(chunks),
+		Chunking:    chunking.Info(),
 	}
 
-	// 8. Save MetaData (no private key needed)
-	if err := saveMetadata(metadata, cid); err != nil {
+	// 8. Save MetaData (no private key needed; the metadata index picks the file up on its next query)
+	if err := service.GC.Commit(func() error { return saveMetadata(metadata, cid) }); err != nil {
 		return err
 	}
+	if err := service.Pins.Pin(fmt.Sprintf("%x", cid)); err != nil {
+		logrus.Warnf("Failed to pin uploaded file: %v", err)
+	}
+
+	// 9. Publish MetaData to DHT so other nodes can download by CID
+	if err := service.PublishMetaData(ctx, metadata, nil); err != nil {
+		logrus.Warnf("Failed to publish metadata to DHT: %v", err)
+	}
 
 	printUploadSummary(fileName, fileSize, len(chunks), cid, "regular", nil)
 	return nil
@@ -260,6 +307,26 @@ func uploadFileRegular(ctx context.Context, filePath string) error {
 
 // Helper functions
 
+// chunkHashes returns the leaf hashes of the chunks
+func chunkHashes(chunks []p2p.Chunk) [][]byte {
+	hashes := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = chunk.Hash
+	}
+	return hashes
+}
+
+// uploadChunking returns the chunking config selected by the command line flags
+func uploadChunking() chunker.Config {
+	return chunker.Config{
+		Mode:      chunkingMode,
+		BlockSize: int(chunkSize),
+		MinSize:   cdcMinSize,
+		AvgSize:   cdcAvgSize,
+		MaxSize:   cdcMaxSize,
+	}
+}
+
 func convertChunksToChunkData(chunks []p2p.Chunk) []file.ChunkData {
 	result := make([]file.ChunkData, len(chunks))
 	for i, chunk := range chunks {
@@ -271,11 +338,16 @@ func convertChunksToChunkData(chunks []p2p.Chunk) []file.ChunkData {
 	return result
 }
 
-func saveMetadataAndKey(metadata *file.MetaData, privKey []byte, cid []byte) error {
-	outputDir := metadataPath
-	if outputDir == "" {
-		outputDir = "./metadata"
+// metadataOutputDir returns the directory metadata is written to (--output, default ./metadata)
+func metadataOutputDir() string {
+	if metadataPath == "" {
+		return "./metadata"
 	}
+	return metadataPath
+}
+
+func saveMetadataAndKey(metadata *file.MetaData, privKey []byte, cid []byte) error {
+	outputDir := metadataOutputDir()
 
 	// Ensure directory exists
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
@@ -304,10 +376,7 @@ func saveMetadataAndKey(metadata *file.MetaData, privKey []byte, cid []byte) err
 }
 
 func saveMetadata(metadata *file.MetaData, cid []byte) error {
-	outputDir := metadataPath
-	if outputDir == "" {
-		outputDir = "./metadata"
-	}
+	outputDir := metadataOutputDir()
 
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create metadata directory: %w", err)
@@ -3,7 +3,7 @@
 // 核心功能:
 //   - Chameleon 哈希: 基于椭圆曲线的可编辑哈希函数
 //   - Merkle 树: 构建和验证 Merkle 树
-//   - 文件分块: 将大文件分割为固定大小的块
+//   - 文件分块: 将大文件分割为固定大小的块，或按 Chunking 使用 FastCDC 内容定义分块
 //   - 完整性验证: 使用根哈希验证文件完整性
 //
 // 主要组件:
@@ -33,13 +33,16 @@ package chameleonMerkleTree
 
 import (
 	"math/big"
+
+	"p2pFileTransfer/pkg/chunker"
 )
 
 // MerkleConfig contains configuration for Merkle tree construction
 // MerkleConfig holds configuration for building a Merkle tree
 type MerkleConfig struct {
-	BlockSize    uint // 每个文件块的大小
-	BufferNumber uint // channel缓冲区大小
+	BlockSize    uint            // 每个文件块的大小
+	BufferNumber uint            // channel缓冲区大小
+	Chunking     *chunker.Config // 分块方式，nil 时按 BlockSize 固定分块
 }
 
 // NewDefaultMerkleConfig returns a default configuration
@@ -3,6 +3,7 @@ package chameleonMerkleTree
 import (
 	"bytes"
 	"container/list"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -28,6 +29,33 @@ func (cmn *ChameleonMerkleNode) VerifyChameleonHash() bool {
 	return VerifyHash(cmn.node.Hash, randomNum.rX, randomNum.rY, randomNum.s, pubKey.pubX, pubKey.pubY, new(big.Int).SetBytes(cmn.hash))
 }
 
+// VerifySerializedChameleonHash 使用序列化后的随机数和公钥验证变色龙哈希
+// 用于校验从网络获取的元数据（RegularRootHash、RandomNum、PublicKey、RootHash）
+func VerifySerializedChameleonHash(regularRootHash, chameleonHash, randomNumBytes, pubKeyBytes []byte) (bool, error) {
+	if !CheckBytes(regularRootHash) || !CheckBytes(chameleonHash) {
+		return false, fmt.Errorf("regular root hash and chameleon hash are required")
+	}
+	randomNum, err := DeserializeChameleonRandomNum(randomNumBytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to deserialize random number: %w", err)
+	}
+	pubKey, err := DeserializeChameleonPubKey(pubKeyBytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to deserialize public key: %w", err)
+	}
+	return VerifyHash(regularRootHash, randomNum.rX, randomNum.rY, randomNum.s, pubKey.pubX, pubKey.pubY, new(big.Int).SetBytes(chameleonHash)), nil
+}
+
+// BuildMerkleRootFromHashes 从叶子哈希计算常规 Merkle 根哈希
+// 与 NewChameleonMerkleTreeFromHashes 使用相同的构建规则（奇数节点与自身拼接）
+func BuildMerkleRootFromHashes(leaves [][]byte) ([]byte, error) {
+	root, err := buildMerkleTreeFromLeafHashes(leaves)
+	if err != nil {
+		return nil, err
+	}
+	return root.Hash, nil
+}
+
 func (cmn *ChameleonMerkleNode) GetChameleonHash() []byte {
 	return cmn.hash
 }
@@ -269,6 +297,90 @@ func (cmn *ChameleonMerkleNode) VerifyProof(proof [][][]byte, targetHash []byte)
 	return bytes.Equal(currentHash, cmn.node.Hash)
 }
 
+// NewMerkleTreeFromHashes 从叶子哈希构建常规 Merkle 树，不计算变色龙哈希
+// 用于已知叶子哈希时生成证明（GetChameleonHash 返回 nil）
+func NewMerkleTreeFromHashes(hashes [][]byte) (*ChameleonMerkleNode, error) {
+	root, err := buildMerkleTreeFromLeafHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+	return &ChameleonMerkleNode{node: root}, nil
+}
+
+// GenerateProofByIndex 生成第 index 个叶子的默克尔证明路径
+// 格式与 GenerateProof 相同（从叶子到根，每层 [左兄弟, 右兄弟]），
+// 但按叶子位置而不是哈希查找，文件中存在相同内容的 chunk 时也能得到该位置的证明。
+//
+// 参数:
+// - index: 叶子索引（从 0 开始，与 GetAllLeavesHashes 的顺序一致）
+//
+// 返回值:
+// - 证明路径；index 超出范围时返回错误
+func (cmn *ChameleonMerkleNode) GenerateProofByIndex(index int) ([][][]byte, error) {
+	if cmn.node == nil {
+		return nil, fmt.Errorf("tree is empty")
+	}
+
+	// 所有叶子位于同一深度（奇数节点与自身拼接）
+	height := 0
+	for n := cmn.node; n.Left != nil; n = n.Left {
+		height++
+	}
+	if index < 0 || index >= 1<<height {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+
+	proof := make([][][]byte, height)
+	n := cmn.node
+	for level := height - 1; level >= 0; level-- {
+		if (index>>level)&1 == 0 {
+			proof[level] = [][]byte{nil, n.Right.Hash}
+			n = n.Left
+		} else {
+			proof[level] = [][]byte{n.Left.Hash, nil}
+			n = n.Right
+		}
+	}
+	return proof, nil
+}
+
+// VerifyProofByIndex 验证 leafHash 是根为 root 的 Merkle 树中第 index 个叶子
+// 与 VerifyProof 不同，同时校验每层兄弟节点的方向与 index 一致，
+// 因此不能用同一数据在其他位置的证明冒充。
+func VerifyProofByIndex(root, leafHash []byte, index int, proof [][][]byte) bool {
+	if index < 0 || len(proof) >= 63 || index>>len(proof) != 0 {
+		return false
+	}
+
+	currentHash := leafHash
+	for level, layer := range proof {
+		if len(layer) != 2 {
+			return false
+		}
+		left, right := layer[0], layer[1]
+
+		var combined []byte
+		if (index>>level)&1 == 0 {
+			// 当前节点在左边
+			if len(left) != 0 || len(right) == 0 {
+				return false
+			}
+			combined = append(append(combined, currentHash...), right...)
+		} else {
+			// 当前节点在右边
+			if len(left) == 0 || len(right) != 0 {
+				return false
+			}
+			combined = append(append(combined, left...), currentHash...)
+		}
+
+		hash := sha256.Sum256(combined)
+		currentHash = hash[:]
+	}
+
+	return bytes.Equal(currentHash, root)
+}
+
 // 序列化：只保存叶子节点
 func (cmn *ChameleonMerkleNode) Serialize() ([]byte, error) {
 	leaves := cmn.GetAllLeavesHashes()
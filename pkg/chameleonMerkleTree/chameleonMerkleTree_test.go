@@ -110,3 +110,49 @@ func TestRebuildChameleonPubKey(t *testing.T) {
 		t.Errorf("Rebuilt public key does not match original")
 	}
 }
+
+func TestGenerateProofByIndex(t *testing.T) {
+	// 5 个叶子，包含两个内容相同的叶子（索引 1 和 3）
+	var hashes [][]byte
+	for _, s := range []string{"a", "b", "c", "b", "e"} {
+		h, _ := sha256Hash([]byte(s))
+		hashes = append(hashes, h)
+	}
+	tree, err := NewMerkleTreeFromHashes(hashes)
+	if err != nil {
+		t.Fatalf("Error building tree: %v", err)
+	}
+	root, _ := BuildMerkleRootFromHashes(hashes)
+	if !bytes.Equal(tree.GetRootHash(), root) {
+		t.Fatalf("Tree root does not match BuildMerkleRootFromHashes")
+	}
+
+	for i, leaf := range hashes {
+		proof, err := tree.GenerateProofByIndex(i)
+		if err != nil {
+			t.Fatalf("Error generating proof for leaf %d: %v", i, err)
+		}
+		if !VerifyProofByIndex(root, leaf, i, proof) {
+			t.Errorf("Proof for leaf %d does not verify", i)
+		}
+		if !tree.VerifyProof(proof, leaf) {
+			t.Errorf("Proof for leaf %d is not compatible with VerifyProof", i)
+		}
+	}
+
+	// 相同内容在其他位置的证明不能冒充
+	proof, _ := tree.GenerateProofByIndex(1)
+	if VerifyProofByIndex(root, hashes[1], 3, proof) {
+		t.Errorf("Proof for leaf 1 verified at index 3")
+	}
+	// 错误的叶子哈希或根
+	if VerifyProofByIndex(root, hashes[0], 1, proof) {
+		t.Errorf("Proof verified with wrong leaf hash")
+	}
+	if VerifyProofByIndex(hashes[0], hashes[1], 1, proof) {
+		t.Errorf("Proof verified against wrong root")
+	}
+	if _, err := tree.GenerateProofByIndex(8); err == nil {
+		t.Errorf("Expected error for out-of-range index")
+	}
+}
@@ -0,0 +1,48 @@
+package chameleonMerkleTree
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// SignWithChameleonKey 使用变色龙私钥对摘要进行 ECDSA 签名
+// 变色龙密钥对本身就是 P256 曲线上的密钥对，因此可以直接用于 ECDSA，
+// 用于证明元数据记录由文件所有者（持有私钥者）发布
+func SignWithChameleonKey(secKey []byte, digest []byte) ([]byte, error) {
+	pubKey, err := RebuildChameleonPubKey(secKey)
+	if err != nil {
+		return nil, err
+	}
+	priv := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: GetCurve(),
+			X:     pubKey.pubX,
+			Y:     pubKey.pubY,
+		},
+		D: new(big.Int).SetBytes(secKey),
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign digest: %w", err)
+	}
+	return sig, nil
+}
+
+// VerifyChameleonKeySignature 使用序列化的变色龙公钥验证 ECDSA 签名
+func VerifyChameleonKeySignature(pubKeyBytes []byte, digest []byte, sig []byte) (bool, error) {
+	pubKey, err := DeserializeChameleonPubKey(pubKeyBytes)
+	if err != nil {
+		return false, err
+	}
+	if !GetCurve().IsOnCurve(pubKey.pubX, pubKey.pubY) {
+		return false, fmt.Errorf("public key is not on curve")
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: GetCurve(),
+		X:     pubKey.pubX,
+		Y:     pubKey.pubY,
+	}
+	return ecdsa.VerifyASN1(pub, digest, sig), nil
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+
+	"p2pFileTransfer/pkg/chunker"
 )
 
 func CheckBytes(bytes []byte) bool {
@@ -56,6 +58,28 @@ func ReadFileToBuffers(file io.ReadWriter, blockSize uint) ([][]byte, error) {
 	return hashes, nil
 }
 
+// readLeafHashes 按 config 的分块方式读取文件并返回每个分块的 SHA256 哈希
+// Chunking 为 nil 时按 BlockSize 固定分块
+func readLeafHashes(file io.ReadWriter, config *MerkleConfig) ([][]byte, error) {
+	if config.Chunking == nil {
+		return ReadFileToBuffers(file, config.BlockSize)
+	}
+
+	var hashes [][]byte
+	err := chunker.Split(file, *config.Chunking, func(chunk []byte) error {
+		hash := sha256.Sum256(chunk)
+		hashes = append(hashes, hash[:])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+	return hashes, nil
+}
+
 // buildMerkleTreeFromLeafHashes 从叶子哈希构建Merkle树（直接使用已有哈希）
 // leaves 是一组叶子节点的哈希值
 func buildMerkleTreeFromLeafHashes(leaves [][]byte) (*MerkleNode, error) {
@@ -103,11 +127,11 @@ func BuildMerkleTreeFromFileRW(file io.ReadWriter, config *MerkleConfig) (*Merkl
 	if file == nil {
 		return nil, fmt.Errorf("file is nil")
 	}
-	if config.BlockSize <= 0 {
+	if config.Chunking == nil && config.BlockSize <= 0 {
 		return nil, fmt.Errorf("invalid block size")
 	}
 
-	leaves, err := ReadFileToBuffers(file, config.BlockSize)
+	leaves, err := readLeafHashes(file, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create buffer channel: %w", err)
 	}
@@ -0,0 +1,82 @@
+// Package chunkStore 提供 Chunk 持久化的统一接口
+//
+// 功能:
+//   - 统一接口: Put/Get/Has/Delete/Size/Iterate，按 Chunk 哈希（hex 编码）存取
+//   - 分片文件系统: FSStore 将 Chunk 保存为 <root>/<前2字符>/<剩余字符>
+//   - 内存存储: MemoryStore 用于测试和不需要持久化的节点
+//   - 哈希校验: 所有方法只接受 hex 编码的哈希，防止通过哈希构造任意路径
+//
+// 主要组件:
+//   - ChunkStore: Chunk 存储接口
+//   - FSStore: 分片目录的文件系统实现
+//   - MemoryStore: 内存实现
+//
+// 使用示例:
+//
+//	store, err := chunkStore.NewFSStore("files")
+//	if err != nil {
+//	    return err
+//	}
+//	if err := store.Put(hashHex, data); err != nil {
+//	    return err
+//	}
+//	data, err := store.Get(hashHex)
+//
+// 注意事项:
+//   - 存储不校验数据与哈希是否一致，调用方负责在写入前和读取后校验
+//   - 哈希不区分大小写，统一转换为小写
+package chunkStore
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound Chunk 不在存储中
+	ErrNotFound = errors.New("chunk not found")
+
+	// ErrInvalidHash 哈希不是合法的 hex 字符串
+	ErrInvalidHash = errors.New("invalid chunk hash")
+)
+
+// ChunkStore Chunk 存储接口，实现必须可以并发使用
+type ChunkStore interface {
+	// Put 保存 Chunk，已存在时覆盖
+	Put(hash string, data []byte) error
+
+	// Get 读取 Chunk 的完整数据，不存在时返回 ErrNotFound
+	Get(hash string) ([]byte, error)
+
+	// Has 检查 Chunk 是否存在
+	Has(hash string) bool
+
+	// Delete 删除 Chunk，不存在时不返回错误
+	Delete(hash string) error
+
+	// Size 返回 Chunk 的字节数，不存在时返回 ErrNotFound
+	Size(hash string) (int64, error)
+
+	// Iterate 遍历所有 Chunk，fn 返回错误时停止遍历并返回该错误
+	Iterate(fn func(hash string, size int64) error) error
+}
+
+// NormalizeHash 校验哈希并转换为小写
+// 参数:
+//   - hash: hex 编码的 Chunk 哈希
+//
+// 返回值:
+//   - string: 小写的哈希
+//   - error: 哈希为空、长度为奇数、少于 4 个字符或包含非 hex 字符时返回 ErrInvalidHash
+func NormalizeHash(hash string) (string, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if len(hash) < 4 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidHash, hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidHash, hash)
+	}
+	return hash, nil
+}
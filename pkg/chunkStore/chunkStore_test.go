@@ -0,0 +1,216 @@
+package chunkStore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// testChunkStore 对任意 ChunkStore 实现执行相同的检查
+func testChunkStore(t *testing.T, store ChunkStore) {
+	a, b := []byte("chunk a"), []byte("chunk bb")
+	hashA, hashB := testHash(a), testHash(b)
+
+	if store.Has(hashA) {
+		t.Fatalf("empty store has %s", hashA)
+	}
+	if _, err := store.Get(hashA); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get on empty store: got %v, want ErrNotFound", err)
+	}
+	if err := store.Put(hashA, a); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// 哈希不区分大小写
+	if err := store.Put(strings.ToUpper(hashB), b); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := store.Get(hashA)
+	if err != nil || !bytes.Equal(got, a) {
+		t.Fatalf("Get: got %q, %v", got, err)
+	}
+	if size, err := store.Size(hashB); err != nil || size != int64(len(b)) {
+		t.Fatalf("Size: got %d, %v", size, err)
+	}
+
+	seen := make(map[string]int64)
+	if err := store.Iterate(func(hash string, size int64) error {
+		seen[hash] = size
+		return nil
+	}); err != nil {
+		t.Fatalf("Iterate: %v", err)
+	}
+	if len(seen) != 2 || seen[hashA] != int64(len(a)) || seen[hashB] != int64(len(b)) {
+		t.Fatalf("Iterate: got %v", seen)
+	}
+
+	stop := errors.New("stop")
+	if err := store.Iterate(func(string, int64) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("Iterate: got %v, want stop", err)
+	}
+
+	if err := store.Delete(hashA); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(hashA); err != nil {
+		t.Fatalf("Delete of missing chunk: %v", err)
+	}
+	if store.Has(hashA) || !store.Has(hashB) {
+		t.Fatalf("Has after Delete: a=%v b=%v", store.Has(hashA), store.Has(hashB))
+	}
+
+	for _, bad := range []string{"", "../etc/passwd", "zz" + hashA[2:], "abc"} {
+		if err := store.Put(bad, a); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("Put(%q): got %v, want ErrInvalidHash", bad, err)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testChunkStore(t, NewMemoryStore())
+}
+
+func TestFSStore(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewFSStore(root)
+	if err != nil {
+		t.Fatalf("NewFSStore: %v", err)
+	}
+	testChunkStore(t, store)
+}
+
+func TestFSStoreMigratesFlatChunks(t *testing.T) {
+	root := t.TempDir()
+	data := []byte("legacy chunk")
+	hash := testHash(data)
+	if err := os.WriteFile(filepath.Join(root, hash), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFSStore(root)
+	if err != nil {
+		t.Fatalf("NewFSStore: %v", err)
+	}
+	got, err := store.Get(hash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get migrated chunk: got %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, hash[:2], hash[2:])); err != nil {
+		t.Fatalf("chunk not in shard directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, hash)); !os.IsNotExist(err) {
+		t.Fatalf("flat chunk file still exists: %v", err)
+	}
+}
+
+func TestQuotaStore(t *testing.T) {
+	store, err := NewQuotaStore(NewMemoryStore(), 0, nil)
+	if err != nil {
+		t.Fatalf("NewQuotaStore: %v", err)
+	}
+	testChunkStore(t, store)
+	if usage := store.Usage(); usage.Chunks != 1 || usage.UsedBytes != int64(len("chunk bb")) {
+		t.Fatalf("Usage: got %+v", usage)
+	}
+}
+
+func TestQuotaStoreEvictsLeastRecentlyServed(t *testing.T) {
+	a, b, c, d := []byte("aaaa"), []byte("bbbb"), []byte("cccc"), []byte("dddddddddd")
+	hashA, hashB, hashC, hashD := testHash(a), testHash(b), testHash(c), testHash(d)
+
+	inner := NewMemoryStore()
+	if err := inner.Put(hashA, a); err != nil {
+		t.Fatal(err)
+	}
+	var pinned *ProtectedSet
+	store, err := NewQuotaStore(inner, 10, func() *ProtectedSet { return pinned })
+	if err != nil {
+		t.Fatalf("NewQuotaStore: %v", err)
+	}
+	if usage := store.Usage(); usage.UsedBytes != 4 || usage.Chunks != 1 {
+		t.Fatalf("existing chunks not counted: %+v", usage)
+	}
+
+	if err := store.Put(hashB, b); err != nil {
+		t.Fatalf("Put b: %v", err)
+	}
+	// 读取 a 后 b 成为最久未读取的 Chunk
+	if _, err := store.Get(hashA); err != nil {
+		t.Fatalf("Get a: %v", err)
+	}
+	if err := store.Put(hashC, c); err != nil {
+		t.Fatalf("Put c: %v", err)
+	}
+	if store.Has(hashB) || !store.Has(hashA) || !store.Has(hashC) {
+		t.Fatalf("expected b to be evicted: a=%v b=%v c=%v", store.Has(hashA), store.Has(hashB), store.Has(hashC))
+	}
+	if usage := store.Usage(); usage.UsedBytes != 8 || usage.EvictedChunks != 1 || usage.EvictedBytes != 4 {
+		t.Fatalf("Usage after eviction: %+v", usage)
+	}
+
+	// 被保护的 Chunk 不会被淘汰，放不下时拒绝写入且不删除任何 Chunk
+	pinned = &ProtectedSet{Hashes: map[string]bool{hashA: true}}
+	if err := store.Put(hashD, d); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Put over quota: got %v, want ErrQuotaExceeded", err)
+	}
+	if !store.Has(hashA) || !store.Has(hashC) {
+		t.Fatal("rejected Put evicted chunks")
+	}
+	if err := store.CanStore(6); err != nil {
+		t.Fatalf("CanStore(6): %v", err)
+	}
+	if err := store.CanStore(7); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("CanStore(7): got %v, want ErrQuotaExceeded", err)
+	}
+
+	if err := store.Delete(hashC); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if usage := store.Usage(); usage.UsedBytes != 4 || usage.Chunks != 1 {
+		t.Fatalf("Usage after Delete: %+v", usage)
+	}
+}
+
+func TestQuotaStoreProtectAll(t *testing.T) {
+	a, b := []byte("aaaaaa"), []byte("bbbbbb")
+	hashA, hashB := testHash(a), testHash(b)
+
+	protected := &ProtectedSet{All: true}
+	store, err := NewQuotaStore(NewMemoryStore(), 10, func() *ProtectedSet { return protected })
+	if err != nil {
+		t.Fatalf("NewQuotaStore: %v", err)
+	}
+	if err := store.Put(hashA, a); err != nil {
+		t.Fatalf("Put a: %v", err)
+	}
+
+	// 无法确定受保护的集合时不淘汰任何 Chunk
+	if err := store.CanStore(6); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("CanStore with all protected: got %v, want ErrQuotaExceeded", err)
+	}
+	if err := store.Put(hashB, b); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Put with all protected: got %v, want ErrQuotaExceeded", err)
+	}
+
+	// 新的快照生效后重新计算可淘汰的字节数
+	protected = &ProtectedSet{}
+	if err := store.CanStore(6); err != nil {
+		t.Fatalf("CanStore after snapshot change: %v", err)
+	}
+	if err := store.Put(hashB, b); err != nil {
+		t.Fatalf("Put after snapshot change: %v", err)
+	}
+	if store.Has(hashA) || !store.Has(hashB) {
+		t.Fatalf("expected a to be evicted: a=%v b=%v", store.Has(hashA), store.Has(hashB))
+	}
+}
@@ -0,0 +1,232 @@
+// Package chunkStore 提供分片目录的文件系统 Chunk 存储
+//
+// FSStore 功能:
+//   - 分片目录: Chunk 保存为 <root>/<前2字符>/<剩余字符>，避免单个目录文件过多
+//   - 原子写入: 先写入同目录的临时文件再重命名，读取方不会看到写了一半的 Chunk
+//   - 旧格式迁移: 打开时将旧版 CLI 上传写入的平铺文件 <root>/<hash> 移入分片目录
+//
+// 注意事项:
+//   - 只有普通文件被视为 Chunk，目录、符号链接和以 "." 开头的临时文件都被忽略
+package chunkStore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FSStore 分片目录的文件系统实现
+type FSStore struct {
+	root string
+}
+
+// NewFSStore 打开（必要时创建）root 目录下的 Chunk 存储，并迁移旧版平铺存放的 Chunk
+// 参数:
+//   - root: 存储根目录，为空时使用当前目录
+//
+// 返回值:
+//   - *FSStore: 存储实例
+//   - error: 创建目录失败时返回错误
+func NewFSStore(root string) (*FSStore, error) {
+	if root == "" {
+		root = "."
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create chunk storage directory: %w", err)
+	}
+	s := &FSStore{root: root}
+	if err := s.migrateFlat(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Root 返回存储根目录
+func (s *FSStore) Root() string {
+	return s.root
+}
+
+// path 返回 Chunk 的存储路径: <root>/<前2字符>/<剩余字符>
+func (s *FSStore) path(hash string) (string, error) {
+	hash, err := NormalizeHash(hash)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, hash[:2], hash[2:]), nil
+}
+
+// Put 保存 Chunk，写入临时文件后重命名
+func (s *FSStore) Put(hash string, data []byte) error {
+	path, err := s.path(hash)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".chunk-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp chunk file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store chunk: %w", err)
+	}
+	return nil
+}
+
+// Get 读取 Chunk 的完整数据
+func (s *FSStore) Get(hash string) ([]byte, error) {
+	path, err := s.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.stat(path); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read chunk: %w", err)
+	}
+	return data, nil
+}
+
+// Has 检查 Chunk 是否存在
+func (s *FSStore) Has(hash string) bool {
+	_, err := s.Size(hash)
+	return err == nil
+}
+
+// Delete 删除 Chunk
+func (s *FSStore) Delete(hash string) error {
+	path, err := s.path(hash)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete chunk: %w", err)
+	}
+	return nil
+}
+
+// Size 返回 Chunk 的字节数
+func (s *FSStore) Size(hash string) (int64, error) {
+	path, err := s.path(hash)
+	if err != nil {
+		return 0, err
+	}
+	info, err := s.stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// stat 返回 Chunk 文件信息，不是普通文件时视为不存在
+func (s *FSStore) stat(path string) (os.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat chunk: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, ErrNotFound
+	}
+	return info, nil
+}
+
+// Iterate 按分片目录顺序遍历所有 Chunk
+func (s *FSStore) Iterate(fn func(hash string, size int64) error) error {
+	shards, err := os.ReadDir(s.root)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read chunk directory: %w", err)
+	}
+
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.root, shard.Name()))
+		if err != nil {
+			logrus.Warnf("Failed to read chunk shard %s: %v", shard.Name(), err)
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			hash, err := NormalizeHash(shard.Name() + entry.Name())
+			if err != nil {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				// 遍历期间被删除
+				continue
+			}
+			if err := fn(hash, info.Size()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateFlat 将根目录下平铺存放的 Chunk（旧版 CLI 上传的格式）移入分片目录
+func (s *FSStore) migrateFlat() error {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk directory: %w", err)
+	}
+
+	migrated := 0
+	for _, entry := range entries {
+		// 分片目录名只有 2 个字符，平铺的 Chunk 文件名是完整的 SHA256 哈希
+		if !entry.Type().IsRegular() || len(entry.Name()) != 64 {
+			continue
+		}
+		hash, err := NormalizeHash(entry.Name())
+		if err != nil {
+			continue
+		}
+		dst, _ := s.path(hash)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return fmt.Errorf("failed to create chunk directory: %w", err)
+		}
+		if err := os.Rename(filepath.Join(s.root, entry.Name()), dst); err != nil {
+			logrus.Warnf("Failed to migrate chunk %s: %v", entry.Name(), err)
+			continue
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		logrus.Infof("Migrated %d flat chunk files in %s to sharded layout", migrated, s.root)
+	}
+	return nil
+}
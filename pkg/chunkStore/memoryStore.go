@@ -0,0 +1,106 @@
+// Package chunkStore 提供内存 Chunk 存储
+//
+// MemoryStore 功能:
+//   - 进程内存储: 数据只保存在内存中，进程退出后丢失
+//   - 数据隔离: Put 和 Get 都复制数据，调用方修改切片不影响存储内容
+//
+// 使用场景:
+//   - 测试: 不需要临时目录
+//   - 临时节点: 只转发、不需要持久化 Chunk 的节点
+package chunkStore
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore 内存实现
+type MemoryStore struct {
+	mu     sync.RWMutex
+	chunks map[string][]byte
+}
+
+// NewMemoryStore 创建空的内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{chunks: make(map[string][]byte)}
+}
+
+// Put 保存 Chunk 的副本
+func (s *MemoryStore) Put(hash string, data []byte) error {
+	hash, err := NormalizeHash(hash)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks[hash] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get 返回 Chunk 数据的副本
+func (s *MemoryStore) Get(hash string) ([]byte, error) {
+	hash, err := NormalizeHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.chunks[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Has 检查 Chunk 是否存在
+func (s *MemoryStore) Has(hash string) bool {
+	_, err := s.Size(hash)
+	return err == nil
+}
+
+// Delete 删除 Chunk
+func (s *MemoryStore) Delete(hash string) error {
+	hash, err := NormalizeHash(hash)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.chunks, hash)
+	return nil
+}
+
+// Size 返回 Chunk 的字节数
+func (s *MemoryStore) Size(hash string) (int64, error) {
+	hash, err := NormalizeHash(hash)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.chunks[hash]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return int64(len(data)), nil
+}
+
+// Iterate 按哈希顺序遍历所有 Chunk，遍历的是调用时的快照，fn 中可以修改存储
+func (s *MemoryStore) Iterate(fn func(hash string, size int64) error) error {
+	s.mu.RLock()
+	hashes := make([]string, 0, len(s.chunks))
+	sizes := make(map[string]int64, len(s.chunks))
+	for hash, data := range s.chunks {
+		hashes = append(hashes, hash)
+		sizes[hash] = int64(len(data))
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		if err := fn(hash, sizes[hash]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
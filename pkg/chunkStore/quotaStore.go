@@ -0,0 +1,329 @@
+// Package chunkStore 提供带容量配额的 Chunk 存储
+//
+// QuotaStore 功能:
+//   - 用量统计: 打开时遍历底层存储统计总字节数，之后随 Put / Delete 更新
+//   - LRU 淘汰: 写入会超过配额时，按最久未被读取的顺序删除未受保护的 Chunk
+//   - 拒绝写入: 淘汰所有可淘汰的 Chunk 仍放不下时返回 ErrQuotaExceeded，不删除任何 Chunk
+//
+// 注意事项:
+//   - 访问顺序只保存在内存中，重启后按底层存储的遍历顺序初始化
+//   - 受保护的 Chunk（由 protected 回调返回的快照决定，如被固定文件引用的 Chunk）永远不会被淘汰
+//   - 快照在加锁前获取，提供者扫描元数据时不阻塞其他存储操作；快照变化时重新标记所有 Chunk，
+//     否则可淘汰字节数随写入和删除增量维护，CanStore 为 O(1)
+//   - 其他进程直接写入底层存储的 Chunk 在被读取时才计入用量
+package chunkStore
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrQuotaExceeded 存储空间超过配额
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaUsage 存储用量
+type QuotaUsage struct {
+	MaxBytes      int64 `json:"maxBytes"`      // 配额（字节），0 表示不限制
+	UsedBytes     int64 `json:"usedBytes"`     // 已使用的字节数
+	Chunks        int   `json:"chunks"`        // Chunk 数量
+	EvictedChunks int64 `json:"evictedChunks"` // 启动以来淘汰的 Chunk 数
+	EvictedBytes  int64 `json:"evictedBytes"`  // 启动以来淘汰的字节数
+}
+
+// ProtectedSet 受保护 Chunk 的只读快照
+// 提供者在集合变化时返回新的快照，QuotaStore 按指针判断快照是否变化
+type ProtectedSet struct {
+	All    bool            // 所有 Chunk 都受保护（如无法确定受保护的集合时）
+	Hashes map[string]bool // 受保护的 Chunk 哈希（小写 hex）
+}
+
+// Contains 检查 Chunk 是否受保护，nil 快照不保护任何 Chunk
+func (s *ProtectedSet) Contains(hash string) bool {
+	return s != nil && (s.All || s.Hashes[hash])
+}
+
+// QuotaStore 为任意 ChunkStore 增加容量配额和 LRU 淘汰
+type QuotaStore struct {
+	inner     ChunkStore
+	maxBytes  int64
+	protected func() *ProtectedSet
+
+	mu            sync.Mutex
+	used          int64
+	evictable     int64         // 未受保护的 Chunk 总字节数
+	snapshot      *ProtectedSet // 当前 entries 的 protected 标记所依据的快照
+	lru           *list.List    // 元素为 *quotaEntry，队首为最近读取或写入的 Chunk
+	entries       map[string]*list.Element
+	evictedChunks int64
+	evictedBytes  int64
+}
+
+type quotaEntry struct {
+	hash      string
+	size      int64
+	protected bool
+}
+
+// NewQuotaStore 包装底层存储并统计现有用量，现有用量超过配额时立即淘汰
+// 参数:
+//   - inner: 底层存储
+//   - maxBytes: 配额（字节），0 表示只统计用量不限制
+//   - protected: 返回受保护 Chunk 的快照，快照中的 Chunk 不会被淘汰；可以为 nil
+//
+// 返回值:
+//   - *QuotaStore: 存储实例
+//   - error: 遍历底层存储失败时返回错误
+func NewQuotaStore(inner ChunkStore, maxBytes int64, protected func() *ProtectedSet) (*QuotaStore, error) {
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("invalid storage quota: %d", maxBytes)
+	}
+	if protected == nil {
+		protected = func() *ProtectedSet { return nil }
+	}
+	q := &QuotaStore{
+		inner:     inner,
+		maxBytes:  maxBytes,
+		protected: protected,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+	if err := inner.Iterate(func(hash string, size int64) error {
+		q.entries[hash] = q.lru.PushBack(&quotaEntry{hash: hash, size: size})
+		q.used += size
+		q.evictable += size
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if q.maxBytes > 0 && q.used > q.maxBytes {
+		snapshot := q.protected()
+		q.mu.Lock()
+		q.applySnapshotLocked(snapshot)
+		err := q.evictLocked(q.used-q.maxBytes, "")
+		q.mu.Unlock()
+		if err != nil {
+			logrus.Warnf("Chunk storage is over quota: %v", err)
+		}
+	}
+	return q, nil
+}
+
+// Put 保存 Chunk，超过配额时先淘汰最久未读取的 Chunk
+// 返回值:
+//   - error: 淘汰后仍放不下时返回包装了 ErrQuotaExceeded 的错误
+func (q *QuotaStore) Put(hash string, data []byte) error {
+	hash, err := NormalizeHash(hash)
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	var snapshot *ProtectedSet
+	if q.maxBytes > 0 {
+		snapshot = q.protected()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxBytes > 0 {
+		q.applySnapshotLocked(snapshot)
+	}
+	var oldSize int64
+	elem, exists := q.entries[hash]
+	if exists {
+		oldSize = elem.Value.(*quotaEntry).size
+	}
+	if q.maxBytes > 0 {
+		if need := q.used - oldSize + size - q.maxBytes; need > 0 {
+			if err := q.evictLocked(need, hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := q.inner.Put(hash, data); err != nil {
+		return err
+	}
+	if exists {
+		entry := elem.Value.(*quotaEntry)
+		q.used += size - oldSize
+		if !entry.protected {
+			q.evictable += size - oldSize
+		}
+		entry.size = size
+		q.lru.MoveToFront(elem)
+	} else {
+		q.addLocked(hash, size)
+	}
+	return nil
+}
+
+// Get 读取 Chunk，并将其标记为最近使用
+func (q *QuotaStore) Get(hash string) ([]byte, error) {
+	data, err := q.inner.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	hash, _ = NormalizeHash(hash)
+	q.mu.Lock()
+	if elem, ok := q.entries[hash]; ok {
+		q.lru.MoveToFront(elem)
+	} else {
+		// 其他进程直接写入底层存储的 Chunk
+		q.addLocked(hash, int64(len(data)))
+	}
+	q.mu.Unlock()
+	return data, nil
+}
+
+// Peek 读取 Chunk 但不更新访问顺序，用于后台巡检等不代表真实访问的读取
+func (q *QuotaStore) Peek(hash string) ([]byte, error) {
+	return q.inner.Get(hash)
+}
+
+// Has 检查 Chunk 是否存在
+func (q *QuotaStore) Has(hash string) bool {
+	return q.inner.Has(hash)
+}
+
+// Delete 删除 Chunk 并扣除用量
+func (q *QuotaStore) Delete(hash string) error {
+	hash, err := NormalizeHash(hash)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.inner.Delete(hash); err != nil {
+		return err
+	}
+	q.removeLocked(hash)
+	return nil
+}
+
+// Size 返回 Chunk 的字节数
+func (q *QuotaStore) Size(hash string) (int64, error) {
+	return q.inner.Size(hash)
+}
+
+// Iterate 遍历底层存储中的所有 Chunk
+func (q *QuotaStore) Iterate(fn func(hash string, size int64) error) error {
+	return q.inner.Iterate(fn)
+}
+
+// CanStore 检查淘汰可淘汰的 Chunk 后能否再保存 n 字节，用于上传前提前拒绝
+// 返回值:
+//   - error: 放不下时返回包装了 ErrQuotaExceeded 的错误
+func (q *QuotaStore) CanStore(n int64) error {
+	if q.maxBytes == 0 {
+		return nil
+	}
+	snapshot := q.protected()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.applySnapshotLocked(snapshot)
+	free := q.maxBytes - q.used
+	if free >= n {
+		return nil
+	}
+	if free+q.evictable < n {
+		return q.quotaErrorLocked(n-free, q.evictable)
+	}
+	return nil
+}
+
+// Usage 返回当前用量
+func (q *QuotaStore) Usage() QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QuotaUsage{
+		MaxBytes:      q.maxBytes,
+		UsedBytes:     q.used,
+		Chunks:        len(q.entries),
+		EvictedChunks: q.evictedChunks,
+		EvictedBytes:  q.evictedBytes,
+	}
+}
+
+// evictLocked 从最久未使用的一端淘汰至少 need 字节，keep 不会被淘汰
+// 可淘汰的字节数不足时不删除任何 Chunk 并返回 ErrQuotaExceeded
+func (q *QuotaStore) evictLocked(need int64, keep string) error {
+	var victims []*quotaEntry
+	var freed int64
+	for elem := q.lru.Back(); elem != nil && freed < need; elem = elem.Prev() {
+		entry := elem.Value.(*quotaEntry)
+		if entry.hash == keep || entry.protected {
+			continue
+		}
+		victims = append(victims, entry)
+		freed += entry.size
+	}
+	if freed < need {
+		return q.quotaErrorLocked(need, freed)
+	}
+
+	freed = 0
+	for _, entry := range victims {
+		if err := q.inner.Delete(entry.hash); err != nil {
+			logrus.Warnf("Failed to evict chunk %s: %v", entry.hash, err)
+			continue
+		}
+		q.removeLocked(entry.hash)
+		q.evictedChunks++
+		q.evictedBytes += entry.size
+		freed += entry.size
+		logrus.Debugf("Evicted chunk %s (%d bytes) to stay within storage quota", entry.hash, entry.size)
+	}
+	if freed < need {
+		return q.quotaErrorLocked(need, freed)
+	}
+	return nil
+}
+
+// applySnapshotLocked 快照变化时重新标记每个 Chunk 是否受保护，并重新计算可淘汰字节数
+func (q *QuotaStore) applySnapshotLocked(snapshot *ProtectedSet) {
+	if snapshot == q.snapshot {
+		return
+	}
+	q.snapshot = snapshot
+	q.evictable = 0
+	for elem := q.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*quotaEntry)
+		entry.protected = snapshot.Contains(entry.hash)
+		if !entry.protected {
+			q.evictable += entry.size
+		}
+	}
+}
+
+// addLocked 在队首加入新的 Chunk，按当前快照标记是否受保护
+func (q *QuotaStore) addLocked(hash string, size int64) {
+	entry := &quotaEntry{hash: hash, size: size, protected: q.snapshot.Contains(hash)}
+	q.entries[hash] = q.lru.PushFront(entry)
+	q.used += size
+	if !entry.protected {
+		q.evictable += size
+	}
+}
+
+// quotaErrorLocked 构造配额错误，need 为需要腾出的字节数
+func (q *QuotaStore) quotaErrorLocked(need, evictable int64) error {
+	return fmt.Errorf("%w: %d of %d bytes used, need to free %d bytes but only %d bytes of unpinned chunks can be evicted",
+		ErrQuotaExceeded, q.used, q.maxBytes, need, evictable)
+}
+
+func (q *QuotaStore) removeLocked(hash string) {
+	if elem, ok := q.entries[hash]; ok {
+		entry := elem.Value.(*quotaEntry)
+		q.used -= entry.size
+		if !entry.protected {
+			q.evictable -= entry.size
+		}
+		q.lru.Remove(elem)
+		delete(q.entries, hash)
+	}
+}
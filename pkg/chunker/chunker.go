@@ -0,0 +1,169 @@
+// Package chunker 提供文件分块功能
+//
+// 分块方式:
+//   - fixed: 按固定的 BlockSize 切分，与旧版上传和元数据兼容
+//   - fastcdc: 基于内容的 FastCDC 分块，切分点由数据本身决定，
+//     在文件中插入或删除字节只影响附近的分块，其余分块哈希不变
+//
+// 使用场景:
+//   - 上传: HTTP API 和 CLI 按上传参数选择分块方式
+//   - Merkle 树: chameleonMerkleTree.MerkleConfig.Chunking 控制建树时的分块方式
+//
+// 注意事项:
+//   - FastCDC 的 Gear 表由固定种子生成，修改会改变所有文件的分块结果
+//   - 分块方式和参数记录在 file.MetaData.Chunking 中，更新文件时沿用原参数才能复用未改变的分块
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"p2pFileTransfer/pkg/file"
+)
+
+const (
+	// ModeFixed 固定大小分块
+	ModeFixed = "fixed"
+	// ModeFastCDC 基于内容的 FastCDC 分块
+	ModeFastCDC = "fastcdc"
+
+	DefaultBlockSize = 256 * 1024 // 固定分块的默认块大小 256KB
+	DefaultMinSize   = 64 * 1024  // FastCDC 默认最小分块 64KB
+	DefaultAvgSize   = 256 * 1024 // FastCDC 默认平均分块 256KB
+	DefaultMaxSize   = 1024 * 1024
+
+	// MaxChunkSize 分块大小上限，与 p2p.MaxChunkSize 一致（超过的 Chunk 不会对外提供）
+	MaxChunkSize = 4 * 1024 * 1024
+	// minCDCSize FastCDC 最小分块的下限
+	minCDCSize = 64
+)
+
+// Config 分块参数
+type Config struct {
+	Mode      string // fixed | fastcdc，为空时视为 fixed
+	BlockSize int    // 固定分块的块大小
+	MinSize   int    // FastCDC 最小分块大小
+	AvgSize   int    // FastCDC 期望的平均分块大小
+	MaxSize   int    // FastCDC 最大分块大小
+}
+
+// DefaultConfig 返回固定 256KB 分块的默认参数（FastCDC 参数也填充默认值）
+func DefaultConfig() Config {
+	return Config{
+		Mode:      ModeFixed,
+		BlockSize: DefaultBlockSize,
+		MinSize:   DefaultMinSize,
+		AvgSize:   DefaultAvgSize,
+		MaxSize:   DefaultMaxSize,
+	}
+}
+
+// Validate 检查分块参数
+func (c Config) Validate() error {
+	switch c.Mode {
+	case "", ModeFixed:
+		if c.BlockSize <= 0 || c.BlockSize > MaxChunkSize {
+			return fmt.Errorf("invalid block size: %d (must be 1-%d)", c.BlockSize, MaxChunkSize)
+		}
+	case ModeFastCDC:
+		if c.MinSize < minCDCSize || c.MinSize >= c.AvgSize || c.AvgSize >= c.MaxSize || c.MaxSize > MaxChunkSize {
+			return fmt.Errorf("invalid fastcdc sizes: min=%d avg=%d max=%d (need %d <= min < avg < max <= %d)",
+				c.MinSize, c.AvgSize, c.MaxSize, minCDCSize, MaxChunkSize)
+		}
+	default:
+		return fmt.Errorf("unknown chunking mode: %q (must be '%s' or '%s')", c.Mode, ModeFixed, ModeFastCDC)
+	}
+	return nil
+}
+
+// IsContentDefined 是否为基于内容的分块
+func (c Config) IsContentDefined() bool {
+	return c.Mode == ModeFastCDC
+}
+
+// Info 返回记录到元数据中的分块信息
+func (c Config) Info() *file.ChunkingInfo {
+	if c.IsContentDefined() {
+		return &file.ChunkingInfo{Mode: ModeFastCDC, MinSize: c.MinSize, AvgSize: c.AvgSize, MaxSize: c.MaxSize}
+	}
+	return &file.ChunkingInfo{Mode: ModeFixed, BlockSize: c.BlockSize}
+}
+
+// FromInfo 根据元数据中的分块信息恢复分块参数，info 为 nil（旧版元数据）时返回 fallback
+func FromInfo(info *file.ChunkingInfo, fallback Config) Config {
+	if info == nil {
+		return fallback
+	}
+	if info.Mode == ModeFastCDC {
+		return Config{Mode: ModeFastCDC, MinSize: info.MinSize, AvgSize: info.AvgSize, MaxSize: info.MaxSize}
+	}
+	cfg := Config{Mode: ModeFixed, BlockSize: info.BlockSize}
+	if cfg.BlockSize <= 0 {
+		cfg.BlockSize = fallback.BlockSize
+	}
+	return cfg
+}
+
+// Chunker 依次返回输入中的分块
+type Chunker interface {
+	// Next 返回下一个分块，输入结束时返回 io.EOF
+	// 返回的切片只在下一次调用 Next 之前有效
+	Next() ([]byte, error)
+}
+
+// New 按分块参数创建 Chunker
+// 参数:
+//   - r: 输入
+//   - cfg: 分块参数
+//
+// 返回值:
+//   - Chunker: 分块器
+//   - error: 参数无效时返回错误
+func New(r io.Reader, cfg Config) (Chunker, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.IsContentDefined() {
+		return newFastCDC(r, cfg), nil
+	}
+	return &fixedChunker{r: r, buf: make([]byte, cfg.BlockSize)}, nil
+}
+
+// Split 按分块参数切分 r，对每个分块调用 fn
+// fn 收到的切片只在本次调用期间有效；fn 返回错误时停止并返回该错误
+func Split(r io.Reader, cfg Config, fn func(chunk []byte) error) error {
+	c, err := New(r, cfg)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// fixedChunker 固定大小分块
+type fixedChunker struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (c *fixedChunker) Next() ([]byte, error) {
+	n, err := io.ReadFull(c.r, c.buf)
+	if n > 0 {
+		return c.buf[:n], nil
+	}
+	if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return nil, err
+}
@@ -0,0 +1,160 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func testData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// splitAll 返回所有分块的副本
+func splitAll(t *testing.T, data []byte, cfg Config) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	// HalfReader 检查分块结果不依赖底层 Read 的返回长度
+	if err := Split(iotest.HalfReader(bytes.NewReader(data)), cfg, func(chunk []byte) error {
+		chunks = append(chunks, append([]byte(nil), chunk...))
+		return nil
+	}); err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, data) {
+		t.Fatalf("chunks do not reassemble to the input (%d of %d bytes)", len(joined), len(data))
+	}
+	return chunks
+}
+
+func TestFixedChunking(t *testing.T) {
+	data := testData(10*1000+123, 1)
+	chunks := splitAll(t, data, Config{Mode: ModeFixed, BlockSize: 1000})
+	if len(chunks) != 11 {
+		t.Fatalf("got %d chunks, want 11", len(chunks))
+	}
+	for i, chunk := range chunks[:10] {
+		if len(chunk) != 1000 {
+			t.Fatalf("chunk %d: got %d bytes, want 1000", i, len(chunk))
+		}
+	}
+	if len(chunks[10]) != 123 {
+		t.Fatalf("last chunk: got %d bytes, want 123", len(chunks[10]))
+	}
+
+	if chunks := splitAll(t, nil, Config{Mode: ModeFixed, BlockSize: 1000}); len(chunks) != 0 {
+		t.Fatalf("empty input: got %d chunks", len(chunks))
+	}
+}
+
+func TestFastCDCBounds(t *testing.T) {
+	cfg := Config{Mode: ModeFastCDC, MinSize: 2 * 1024, AvgSize: 8 * 1024, MaxSize: 32 * 1024}
+	data := testData(2*1024*1024, 2)
+	chunks := splitAll(t, data, cfg)
+
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) < cfg.MinSize || len(chunk) > cfg.MaxSize {
+			t.Fatalf("chunk %d: %d bytes outside [%d, %d]", i, len(chunk), cfg.MinSize, cfg.MaxSize)
+		}
+	}
+	avg := len(data) / len(chunks)
+	if avg < cfg.AvgSize/2 || avg > cfg.AvgSize*2 {
+		t.Fatalf("average chunk size %d too far from %d", avg, cfg.AvgSize)
+	}
+
+	// 全零数据没有切分点，按最大分块切分
+	zeros := splitAll(t, make([]byte, 100*1024), cfg)
+	if len(zeros[0]) != cfg.MaxSize {
+		t.Fatalf("zero data: first chunk %d bytes, want %d", len(zeros[0]), cfg.MaxSize)
+	}
+}
+
+func TestFastCDCDeterministic(t *testing.T) {
+	cfg := Config{Mode: ModeFastCDC, MinSize: 1024, AvgSize: 4096, MaxSize: 16 * 1024}
+	data := testData(512*1024, 3)
+	a, b := splitAll(t, data, cfg), splitAll(t, data, cfg)
+	if len(a) != len(b) {
+		t.Fatalf("got %d and %d chunks", len(a), len(b))
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			t.Fatalf("chunk %d differs between runs", i)
+		}
+	}
+}
+
+func TestFastCDCInsertionKeepsChunks(t *testing.T) {
+	cfg := Config{Mode: ModeFastCDC, MinSize: 1024, AvgSize: 4096, MaxSize: 16 * 1024}
+	data := testData(1024*1024, 4)
+	edited := append(append(append([]byte(nil), data[:5000]...), 'x'), data[5000:]...)
+
+	hashes := make(map[[32]byte]bool)
+	for _, chunk := range splitAll(t, data, cfg) {
+		hashes[sha256.Sum256(chunk)] = true
+	}
+	editedChunks := splitAll(t, edited, cfg)
+	changed := 0
+	for _, chunk := range editedChunks {
+		if !hashes[sha256.Sum256(chunk)] {
+			changed++
+		}
+	}
+	// 插入一个字节只影响附近的一两个分块
+	if changed > 3 {
+		t.Fatalf("%d of %d chunks changed after inserting one byte", changed, len(editedChunks))
+	}
+
+	// 固定分块下插入点之后的所有分块都会改变
+	fixed := Config{Mode: ModeFixed, BlockSize: 4096}
+	fixedHashes := make(map[[32]byte]bool)
+	for _, chunk := range splitAll(t, data, fixed) {
+		fixedHashes[sha256.Sum256(chunk)] = true
+	}
+	kept := 0
+	for _, chunk := range splitAll(t, edited, fixed) {
+		if fixedHashes[sha256.Sum256(chunk)] {
+			kept++
+		}
+	}
+	if kept > 2 {
+		t.Fatalf("fixed chunking kept %d chunks after insertion", kept)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := []Config{
+		DefaultConfig(),
+		{Mode: "", BlockSize: 1},
+		{Mode: ModeFastCDC, MinSize: 64, AvgSize: 128, MaxSize: 256},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", cfg, err)
+		}
+	}
+	invalid := []Config{
+		{Mode: ModeFixed},
+		{Mode: ModeFixed, BlockSize: MaxChunkSize + 1},
+		{Mode: ModeFastCDC, MinSize: 32, AvgSize: 128, MaxSize: 256},
+		{Mode: ModeFastCDC, MinSize: 256, AvgSize: 128, MaxSize: 512},
+		{Mode: ModeFastCDC, MinSize: 64, AvgSize: 128, MaxSize: MaxChunkSize + 1},
+		{Mode: "rabin", BlockSize: 1024},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%+v): expected error", cfg)
+		}
+	}
+
+	cdc := Config{Mode: ModeFastCDC, MinSize: 64, AvgSize: 128, MaxSize: 256}
+	if got := FromInfo(cdc.Info(), DefaultConfig()); got != cdc {
+		t.Errorf("FromInfo(Info()) = %+v, want %+v", got, cdc)
+	}
+	if got := FromInfo(nil, DefaultConfig()); got != DefaultConfig() {
+		t.Errorf("FromInfo(nil) = %+v, want fallback", got)
+	}
+}
@@ -0,0 +1,136 @@
+// Package chunker 提供 FastCDC 内容定义分块
+//
+// FastCDC 算法（Xia et al., USENIX ATC 2016）:
+//   - Gear 滚动哈希: hash = (hash << 1) + gear[b]，高位只依赖最近 64 个字节
+//   - 跳过最小分块: 前 MinSize 个字节不检查切分点
+//   - 归一化分块: 未达到平均大小时使用更严格的掩码，超过后使用更宽松的掩码，使分块大小集中在平均值附近
+//   - 最大分块: 到达 MaxSize 时强制切分
+//
+// 注意事项:
+//   - 掩码位数由 AvgSize 按 2 的幂向下取整得到
+//   - Gear 表由固定种子的 splitmix64 生成，所有节点的分块结果一致
+package chunker
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// gearSeed Gear 表的固定种子，修改会改变所有 FastCDC 分块结果
+const gearSeed = 0x70326646696c6554 // "p2pFileT"
+
+// gearTable FastCDC 使用的 256 个随机 64 位整数
+var gearTable = newGearTable(gearSeed)
+
+// newGearTable 用 splitmix64 生成确定的 Gear 表
+func newGearTable(seed uint64) [256]uint64 {
+	var table [256]uint64
+	state := seed
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// fastCDC 流式 FastCDC 分块器
+type fastCDC struct {
+	r       io.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64 // 未达到平均大小时使用的掩码（多 1 位，更难命中）
+	maskL   uint64 // 超过平均大小后使用的掩码（少 1 位，更容易命中）
+	buf     []byte
+	start   int // buf 中未返回数据的起始位置
+	end     int // buf 中已读取数据的结束位置
+	eof     bool
+	readErr error
+}
+
+func newFastCDC(r io.Reader, cfg Config) *fastCDC {
+	avgBits := bits.Len(uint(cfg.AvgSize)) - 1
+	return &fastCDC{
+		r:       r,
+		minSize: cfg.MinSize,
+		avgSize: cfg.AvgSize,
+		maxSize: cfg.MaxSize,
+		maskS:   highMask(avgBits + 1),
+		maskL:   highMask(avgBits - 1),
+		buf:     make([]byte, 2*cfg.MaxSize),
+	}
+}
+
+// highMask 返回最高 n 位为 1 的掩码
+func highMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+func (c *fastCDC) Next() ([]byte, error) {
+	if c.end-c.start < c.maxSize && !c.eof {
+		c.fill()
+	}
+	if c.start == c.end {
+		if c.readErr != nil {
+			return nil, c.readErr
+		}
+		return nil, io.EOF
+	}
+
+	n := c.cutPoint(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill 将未返回的数据移到缓冲区开头，并读取直到缓冲区满或输入结束
+func (c *fastCDC) fill() {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err != nil {
+			c.eof = true
+			if !errors.Is(err, io.EOF) {
+				c.readErr = err
+			}
+			return
+		}
+	}
+}
+
+// cutPoint 返回 data 中第一个分块的长度
+func (c *fastCDC) cutPoint(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+	normal := min(c.avgSize, n)
+
+	var hash uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -36,6 +36,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/spf13/viper"
+	"p2pFileTransfer/pkg/chunker"
 	"p2pFileTransfer/pkg/p2p"
 )
 
@@ -48,6 +49,10 @@ type Config struct {
 	AntiLeecher AntiLeecherConfig `mapstructure:"anti_leecher"`
 	HTTP        HTTPConfig        `mapstructure:"http"`
 	Chameleon   ChameleonConfig   `mapstructure:"chameleon"`
+	Reprovider  ReproviderConfig  `mapstructure:"reprovider"`
+	Compression CompressionConfig `mapstructure:"compression"`
+	Bandwidth   BandwidthConfig   `mapstructure:"bandwidth"`
+	Downloads   DownloadsConfig   `mapstructure:"downloads"`
 }
 
 // HTTPConfig HTTP API配置
@@ -72,6 +77,16 @@ type StorageConfig struct {
 	ChunkPath    string `mapstructure:"chunk_path"`
 	BlockSize    uint   `mapstructure:"block_size"`
 	BufferNumber uint   `mapstructure:"buffer_number"`
+	GCInterval   int    `mapstructure:"gc_interval"`
+	MaxBytes     int64  `mapstructure:"max_bytes"`
+	Chunking     string `mapstructure:"chunking"`     // 上传默认分块方式: fixed | fastcdc
+	CDCMinSize   int    `mapstructure:"cdc_min_size"` // FastCDC 最小分块大小
+	CDCAvgSize   int    `mapstructure:"cdc_avg_size"` // FastCDC 平均分块大小
+	CDCMaxSize   int    `mapstructure:"cdc_max_size"` // FastCDC 最大分块大小
+
+	ScrubInterval  int    `mapstructure:"scrub_interval"`  // 完整性巡检间隔（秒），0 表示只手动触发
+	ScrubRate      int64  `mapstructure:"scrub_rate"`      // 巡检读取速率（字节/秒），0 表示不限速
+	QuarantinePath string `mapstructure:"quarantine_path"` // 损坏分块的隔离目录，为空时使用 chunk_path/.quarantine
 }
 
 // PerformanceConfig 性能配置
@@ -81,6 +96,11 @@ type PerformanceConfig struct {
 	RequestTimeout int  `mapstructure:"request_timeout"`
 	DataTimeout    int  `mapstructure:"data_timeout"`
 	DHTTimeout     int  `mapstructure:"dht_timeout"`
+	ProviderCacheTTL int `mapstructure:"provider_cache_ttl"`
+	Scheduler      string `mapstructure:"scheduler"`
+	ReadAhead      int    `mapstructure:"read_ahead"`
+	ReaderCache    int    `mapstructure:"reader_cache"`
+	VerifyProofs   bool   `mapstructure:"verify_proofs"`
 }
 
 // LoggingConfig 日志配置
@@ -103,6 +123,88 @@ type ChameleonConfig struct {
 	PrivateKeyFile string `mapstructure:"private_key_file"` // 私钥文件路径
 }
 
+// ReproviderConfig Chunk 重新公告配置
+type ReproviderConfig struct {
+	Interval    int  `mapstructure:"interval"`    // 重新公告间隔（秒），0 表示禁用
+	Jitter      int  `mapstructure:"jitter"`      // 随机抖动（秒）
+	Concurrency int  `mapstructure:"concurrency"` // 最大并发数
+	OnStart     bool `mapstructure:"on_start"`    // 启动时立即重新公告
+}
+
+// CompressionConfig Chunk 传输压缩配置
+type CompressionConfig struct {
+	Codecs    []string `mapstructure:"codecs"`     // 支持的压缩算法（按优先级），[none] 表示禁用压缩
+	CacheSize int      `mapstructure:"cache_size"` // 压缩结果缓存大小（MB），0 表示不缓存
+}
+
+// BandwidthConfig 带宽限制配置（字节/秒，0 表示不限速）
+type BandwidthConfig struct {
+	UploadRate       int64                     `mapstructure:"upload_rate"`        // 全局上传
+	DownloadRate     int64                     `mapstructure:"download_rate"`      // 全局下载
+	PeerUploadRate   int64                     `mapstructure:"peer_upload_rate"`   // 每个节点的上传
+	PeerDownloadRate int64                     `mapstructure:"peer_download_rate"` // 每个节点的下载
+	Schedules        []BandwidthScheduleConfig `mapstructure:"schedules"`          // 时段计划，按顺序匹配
+}
+
+// BandwidthScheduleConfig 时段限速计划，[start, end) 时段内使用其中的限速
+type BandwidthScheduleConfig struct {
+	Start            string `mapstructure:"start"` // 本地时间 HH:MM
+	End              string `mapstructure:"end"`   // 本地时间 HH:MM，小于 start 表示跨越午夜
+	UploadRate       int64  `mapstructure:"upload_rate"`
+	DownloadRate     int64  `mapstructure:"download_rate"`
+	PeerUploadRate   int64  `mapstructure:"peer_upload_rate"`
+	PeerDownloadRate int64  `mapstructure:"peer_download_rate"`
+}
+
+// DownloadsConfig 后台下载任务配置
+type DownloadsConfig struct {
+	Dir       string `mapstructure:"dir"`        // 下载目录，同时保存任务列表
+	MaxActive int    `mapstructure:"max_active"` // 同时运行的任务数
+}
+
+// ToP2P 转换为 P2PConfig 使用的限速和时段计划
+func (b BandwidthConfig) ToP2P() (p2p.BandwidthLimits, []p2p.BandwidthSchedule) {
+	limits := p2p.BandwidthLimits{
+		UploadRate:       b.UploadRate,
+		DownloadRate:     b.DownloadRate,
+		PeerUploadRate:   b.PeerUploadRate,
+		PeerDownloadRate: b.PeerDownloadRate,
+	}
+	var schedules []p2p.BandwidthSchedule
+	for _, s := range b.Schedules {
+		schedules = append(schedules, p2p.BandwidthSchedule{
+			Start: s.Start,
+			End:   s.End,
+			BandwidthLimits: p2p.BandwidthLimits{
+				UploadRate:       s.UploadRate,
+				DownloadRate:     s.DownloadRate,
+				PeerUploadRate:   s.PeerUploadRate,
+				PeerDownloadRate: s.PeerDownloadRate,
+			},
+		})
+	}
+	return limits, schedules
+}
+
+// UploadChunking 返回上传文件默认使用的分块参数，未设置的字段使用 chunker 的默认值
+// 固定分块的块大小与 HTTP API 一致，使用 chunker.DefaultBlockSize
+func (s StorageConfig) UploadChunking() chunker.Config {
+	cfg := chunker.DefaultConfig()
+	if s.Chunking != "" {
+		cfg.Mode = s.Chunking
+	}
+	if s.CDCMinSize > 0 {
+		cfg.MinSize = s.CDCMinSize
+	}
+	if s.CDCAvgSize > 0 {
+		cfg.AvgSize = s.CDCAvgSize
+	}
+	if s.CDCMaxSize > 0 {
+		cfg.MaxSize = s.CDCMaxSize
+	}
+	return cfg
+}
+
 // Load 从配置文件加载配置
 // 如果配置文件不存在，返回默认配置
 func Load(configPath string) (*Config, error) {
@@ -165,6 +267,15 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("storage.chunk_path", "files")
 	v.SetDefault("storage.block_size", 256*1024) // 256KB
 	v.SetDefault("storage.buffer_number", 16)
+	v.SetDefault("storage.gc_interval", 0) // 默认不定期回收
+	v.SetDefault("storage.max_bytes", 0)   // 默认不限制存储空间
+	v.SetDefault("storage.chunking", chunker.ModeFixed)
+	v.SetDefault("storage.cdc_min_size", chunker.DefaultMinSize)
+	v.SetDefault("storage.cdc_avg_size", chunker.DefaultAvgSize)
+	v.SetDefault("storage.cdc_max_size", chunker.DefaultMaxSize)
+	v.SetDefault("storage.scrub_interval", 24*60*60) // 默认每天巡检一次
+	v.SetDefault("storage.scrub_rate", p2p.DefaultScrubRate)
+	v.SetDefault("storage.quarantine_path", "")
 
 	// 性能配置默认值
 	v.SetDefault("performance.max_retries", 3)
@@ -172,6 +283,11 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("performance.request_timeout", 5)
 	v.SetDefault("performance.data_timeout", 30)
 	v.SetDefault("performance.dht_timeout", 10)
+	v.SetDefault("performance.provider_cache_ttl", 60)
+	v.SetDefault("performance.scheduler", "sequential")
+	v.SetDefault("performance.read_ahead", p2p.DefaultReadAheadChunks)
+	v.SetDefault("performance.reader_cache", p2p.DefaultReaderCacheChunks)
+	v.SetDefault("performance.verify_proofs", false)
 
 	// 日志配置默认值
 	v.SetDefault("logging.level", "info")
@@ -190,6 +306,26 @@ func setDefaults(v *viper.Viper) {
 	// 变色龙哈希配置默认值
 	v.SetDefault("chameleon.private_key", "")
 	v.SetDefault("chameleon.private_key_file", "")
+
+	// 重新公告配置默认值
+	v.SetDefault("reprovider.interval", 12*60*60) // 12 hours
+	v.SetDefault("reprovider.jitter", 10*60)      // 10 minutes
+	v.SetDefault("reprovider.concurrency", 8)
+	v.SetDefault("reprovider.on_start", true)
+
+	// 压缩配置默认值
+	v.SetDefault("compression.codecs", []string{"zstd", "gzip"})
+	v.SetDefault("compression.cache_size", 64) // 64MB
+
+	// 带宽限制默认值（不限速）
+	v.SetDefault("bandwidth.upload_rate", 0)
+	v.SetDefault("bandwidth.download_rate", 0)
+	v.SetDefault("bandwidth.peer_upload_rate", 0)
+	v.SetDefault("bandwidth.peer_download_rate", 0)
+
+	// 后台下载配置默认值
+	v.SetDefault("downloads.dir", "downloads")
+	v.SetDefault("downloads.max_active", 2)
 }
 
 // bindEnvVars 绑定环境变量
@@ -210,11 +346,25 @@ func bindEnvVars(v *viper.Viper) {
 		"storage.chunk_path":        "CHUNK_PATH",
 		"storage.block_size":        "BLOCK_SIZE",
 		"storage.buffer_number":     "BUFFER_NUMBER",
+		"storage.gc_interval":       "GC_INTERVAL",
+		"storage.max_bytes":         "STORAGE_MAX_BYTES",
+		"storage.chunking":          "CHUNKING",
+		"storage.cdc_min_size":      "CDC_MIN_SIZE",
+		"storage.cdc_avg_size":      "CDC_AVG_SIZE",
+		"storage.cdc_max_size":      "CDC_MAX_SIZE",
+		"storage.scrub_interval":    "SCRUB_INTERVAL",
+		"storage.scrub_rate":        "SCRUB_RATE",
+		"storage.quarantine_path":   "QUARANTINE_PATH",
 		"performance.max_retries":   "MAX_RETRIES",
 		"performance.max_concurrency": "MAX_CONCURRENCY",
 		"performance.request_timeout": "REQUEST_TIMEOUT",
 		"performance.data_timeout":  "DATA_TIMEOUT",
 		"performance.dht_timeout":   "DHT_TIMEOUT",
+		"performance.provider_cache_ttl": "PROVIDER_CACHE_TTL",
+		"performance.scheduler":     "SCHEDULER",
+		"performance.read_ahead":    "READ_AHEAD",
+		"performance.reader_cache":  "READER_CACHE",
+		"performance.verify_proofs": "VERIFY_PROOFS",
 		"logging.level":             "LOG_LEVEL",
 		"logging.format":            "LOG_FORMAT",
 		"anti_leecher.enabled":        "ANTI_LEECHER_ENABLED",
@@ -225,6 +375,18 @@ func bindEnvVars(v *viper.Viper) {
 		"http.metadata_path":           "METADATA_PATH",
 		"chameleon.private_key":        "CHAMELEON_PRIVATE_KEY",
 		"chameleon.private_key_file":   "CHAMELEON_PRIVATE_KEY_FILE",
+		"reprovider.interval":          "REPROVIDE_INTERVAL",
+		"reprovider.jitter":            "REPROVIDE_JITTER",
+		"reprovider.concurrency":       "REPROVIDE_CONCURRENCY",
+		"reprovider.on_start":          "REPROVIDE_ON_START",
+		"compression.codecs":           "COMPRESSION_CODECS",
+		"compression.cache_size":       "COMPRESSION_CACHE_SIZE",
+		"bandwidth.upload_rate":        "UPLOAD_RATE",
+		"bandwidth.download_rate":      "DOWNLOAD_RATE",
+		"bandwidth.peer_upload_rate":   "PEER_UPLOAD_RATE",
+		"bandwidth.peer_download_rate": "PEER_DOWNLOAD_RATE",
+		"downloads.dir":                "DOWNLOAD_DIR",
+		"downloads.max_active":         "DOWNLOAD_MAX_ACTIVE",
 	}
 
 	for configKey, envKey := range bindings {
@@ -254,6 +416,26 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid buffer_number: %d (must be 1-256)", c.Storage.BufferNumber)
 	}
 
+	if c.Storage.GCInterval < 0 {
+		return fmt.Errorf("invalid gc_interval: %d (must be >= 0)", c.Storage.GCInterval)
+	}
+
+	if c.Storage.MaxBytes < 0 {
+		return fmt.Errorf("invalid max_bytes: %d (must be >= 0)", c.Storage.MaxBytes)
+	}
+
+	if c.Storage.ScrubInterval < 0 {
+		return fmt.Errorf("invalid scrub_interval: %d (must be >= 0)", c.Storage.ScrubInterval)
+	}
+
+	if c.Storage.ScrubRate < 0 {
+		return fmt.Errorf("invalid scrub_rate: %d (must be >= 0)", c.Storage.ScrubRate)
+	}
+
+	if err := c.Storage.UploadChunking().Validate(); err != nil {
+		return fmt.Errorf("invalid chunking config: %w", err)
+	}
+
 	// 验证性能配置
 	if c.Performance.MaxRetries < 0 || c.Performance.MaxRetries > 100 {
 		return fmt.Errorf("invalid max_retries: %d (must be 0-100)", c.Performance.MaxRetries)
@@ -275,6 +457,22 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid dht_timeout: %d (must be 1-3600)", c.Performance.DHTTimeout)
 	}
 
+	if c.Performance.ProviderCacheTTL < 1 || c.Performance.ProviderCacheTTL > 86400 {
+		return fmt.Errorf("invalid provider_cache_ttl: %d (must be 1-86400)", c.Performance.ProviderCacheTTL)
+	}
+
+	if _, err := p2p.NewScheduler(c.Performance.Scheduler); err != nil {
+		return fmt.Errorf("invalid scheduler: %s (must be sequential, rarest-first, or endgame)", c.Performance.Scheduler)
+	}
+
+	if c.Performance.ReadAhead < 0 || c.Performance.ReadAhead > 256 {
+		return fmt.Errorf("invalid read_ahead: %d (must be 0-256)", c.Performance.ReadAhead)
+	}
+
+	if c.Performance.ReaderCache < 1 || c.Performance.ReaderCache > 4096 {
+		return fmt.Errorf("invalid reader_cache: %d (must be 1-4096)", c.Performance.ReaderCache)
+	}
+
 	// 验证日志配置
 	validLogLevels := map[string]bool{
 		"debug": true,
@@ -303,6 +501,42 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid min_requests: %d (must be 1-10000)", c.AntiLeecher.MinRequests)
 	}
 
+	// 验证重新公告配置
+	if c.Reprovider.Interval < 0 {
+		return fmt.Errorf("invalid reprovider interval: %d (must be >= 0)", c.Reprovider.Interval)
+	}
+
+	if c.Reprovider.Jitter < 0 {
+		return fmt.Errorf("invalid reprovider jitter: %d (must be >= 0)", c.Reprovider.Jitter)
+	}
+
+	if c.Reprovider.Concurrency < 1 || c.Reprovider.Concurrency > 256 {
+		return fmt.Errorf("invalid reprovider concurrency: %d (must be 1-256)", c.Reprovider.Concurrency)
+	}
+
+	// 验证压缩配置
+	if _, err := p2p.ParseCompressionCodecs(c.Compression.Codecs); err != nil {
+		return fmt.Errorf("invalid compression codecs: %v (must be zstd, gzip, or none)", c.Compression.Codecs)
+	}
+
+	if c.Compression.CacheSize < 0 || c.Compression.CacheSize > 4096 {
+		return fmt.Errorf("invalid compression cache_size: %d (must be 0-4096)", c.Compression.CacheSize)
+	}
+
+	// 验证带宽限制配置
+	if _, err := p2p.NewBandwidthLimiter(c.Bandwidth.ToP2P()); err != nil {
+		return fmt.Errorf("invalid bandwidth config: %w", err)
+	}
+
+	// 验证后台下载配置
+	if c.Downloads.Dir == "" {
+		return fmt.Errorf("downloads dir cannot be empty")
+	}
+
+	if c.Downloads.MaxActive < 1 || c.Downloads.MaxActive > 64 {
+		return fmt.Errorf("invalid downloads max_active: %d (must be 1-64)", c.Downloads.MaxActive)
+	}
+
 	return nil
 }
 
@@ -317,11 +551,29 @@ func (c *Config) ToP2PConfig() *p2p.P2PConfig {
 	cfg.EnableAutoRefresh = c.Network.AutoRefresh
 	cfg.NameSpace = c.Network.NameSpace
 	cfg.ChunkStoragePath = c.Storage.ChunkPath
+	cfg.GCInterval = c.Storage.GCInterval
+	cfg.MaxStorageBytes = c.Storage.MaxBytes
+	cfg.ScrubInterval = c.Storage.ScrubInterval
+	cfg.ScrubRate = c.Storage.ScrubRate
+	cfg.QuarantinePath = c.Storage.QuarantinePath
+	cfg.MetadataStoragePath = c.HTTP.MetadataStoragePath
 	cfg.MaxRetries = c.Performance.MaxRetries
 	cfg.MaxConcurrency = c.Performance.MaxConcurrency
 	cfg.RequestTimeout = c.Performance.RequestTimeout
 	cfg.DataTimeout = c.Performance.DataTimeout
 	cfg.DHTTimeout = c.Performance.DHTTimeout
+	cfg.ProviderCacheTTL = c.Performance.ProviderCacheTTL
+	cfg.Scheduler = c.Performance.Scheduler
+	cfg.ReadAheadChunks = c.Performance.ReadAhead
+	cfg.ReaderCacheChunks = c.Performance.ReaderCache
+	cfg.VerifyChunkProofs = c.Performance.VerifyProofs
+	cfg.ReprovideInterval = c.Reprovider.Interval
+	cfg.ReprovideJitter = c.Reprovider.Jitter
+	cfg.ReprovideConcurrency = c.Reprovider.Concurrency
+	cfg.ReprovideOnStart = c.Reprovider.OnStart
+	cfg.CompressionCodecs = c.Compression.Codecs
+	cfg.CompressionCacheSize = int64(c.Compression.CacheSize) * 1024 * 1024
+	cfg.BandwidthLimits, cfg.BandwidthSchedules = c.Bandwidth.ToP2P()
 
 	// 解析 bootstrap peers
 	if len(c.Network.BootstrapPeers) > 0 {
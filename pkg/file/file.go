@@ -3,6 +3,7 @@
 // 主要类型:
 //   - MetaData: 文件元数据，包含根哈希、随机数、公钥等信息
 //   - ChunkData: Chunk 数据，包含大小和哈希
+//   - ChunkingInfo: 文件的分块方式（固定大小或 FastCDC）
 //
 // 使用场景:
 //   - 文件标识: 使用根哈希和随机数唯一标识文件
@@ -20,16 +21,28 @@ import (
 )
 
 type MetaData struct {
-	RootHash        []byte      `json:"rootHash"`                   // 变色龙哈希（CID）或常规Merkle根哈希
-	RegularRootHash []byte      `json:"regularRootHash,omitempty"`  // 常规Merkle根哈希（仅chameleon模式需要）
-	RandomNum       []byte      `json:"randomNum,omitempty"`        // 随机数（仅chameleon模式）
-	PublicKey       []byte      `json:"publicKey,omitempty"`        // 公钥（仅chameleon模式）
-	Description     string      `json:"description,omitempty"`      // 文件描述
-	FileSize        uint64      `json:"fileSize"`                   // 文件大小（字节）
-	FileName        string      `json:"fileName"`                   // 文件名
-	Encryption      string      `json:"encryption,omitempty"`       // 加密方式
-	TreeType        string      `json:"treeType"`                   // Merkle树类型: "chameleon" | "regular"
-	Leaves          []ChunkData `json:"leaves"`                     // 所有chunk的哈希列表
+	RootHash        []byte        `json:"rootHash"`                  // 变色龙哈希（CID）或常规Merkle根哈希
+	RegularRootHash []byte        `json:"regularRootHash,omitempty"` // 常规Merkle根哈希（仅chameleon模式需要）
+	RandomNum       []byte        `json:"randomNum,omitempty"`       // 随机数（仅chameleon模式）
+	PublicKey       []byte        `json:"publicKey,omitempty"`       // 公钥（仅chameleon模式）
+	Description     string        `json:"description,omitempty"`     // 文件描述
+	FileSize        uint64        `json:"fileSize"`                  // 文件大小（字节）
+	FileName        string        `json:"fileName"`                  // 文件名
+	Encryption      string        `json:"encryption,omitempty"`      // 加密方式
+	TreeType        string        `json:"treeType"`                  // Merkle树类型: "chameleon" | "regular"
+	Leaves          []ChunkData   `json:"leaves"`                    // 所有chunk的哈希列表
+	Chunking        *ChunkingInfo `json:"chunking,omitempty"`        // 分块方式，nil 表示旧版固定大小分块
+}
+
+// ChunkingInfo 文件的分块方式和参数（见 pkg/chunker）
+// 固定分块的叶子 ChunkSize 都等于 BlockSize（最后一个分块可能更小），
+// 内容定义分块的每个叶子 ChunkSize 都是该分块的实际大小
+type ChunkingInfo struct {
+	Mode      string `json:"mode"`                // "fixed" | "fastcdc"
+	BlockSize int    `json:"blockSize,omitempty"` // 固定分块的块大小
+	MinSize   int    `json:"minSize,omitempty"`   // FastCDC 最小分块大小
+	AvgSize   int    `json:"avgSize,omitempty"`   // FastCDC 平均分块大小
+	MaxSize   int    `json:"maxSize,omitempty"`   // FastCDC 最大分块大小
 }
 
 // MarshalJSON 自定义 JSON 序列化，使用 hex 编码而不是 base64
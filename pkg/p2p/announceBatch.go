@@ -0,0 +1,352 @@
+// Package p2p 提供批量 Chunk 公告功能
+//
+// AnnounceBatch 功能:
+//   - 批量公告: 一条消息携带多个 chunkHash，一个流发送多条消息
+//   - 本地分组: 使用路由表计算每个 chunkHash 的最近节点，按节点分组，无需逐个进行 DHT 查询
+//   - 大小限制: 每条消息最多 MaxAnnounceBatchSize 个哈希，每个流最多 MaxAnnounceBatchMessages 条消息，
+//     且必须在 announceBatchStreamTimeout 内完成，超过后发送方需要打开新的流
+//   - 协议回退: 对端不支持 Announce/2.0.0 时回退到逐个 Announce/1.0.0
+//
+// 协议定义:
+//   - p2pFileTransfer/Announce/2.0.0: 批量公告协议
+//     请求: 多行 JSON（announceBatchMsg），每行一批
+//     响应: 每收到一批返回一行 JSON（announceBatchResponse）
+//
+// 注意事项:
+//   - 处理方只接受与流的远端节点一致的 PeerInfo，防止替其他节点公告
+//   - 路由表为空时只写入本地 ProviderStore，与 Announce 行为一致
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multistream"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AnnounceBatchProtocol = "p2pFileTransfer/Announce/2.0.0"
+
+	// MaxAnnounceBatchSize 每条批量公告消息最多包含的 chunkHash 数量
+	MaxAnnounceBatchSize = 1024
+
+	// MaxAnnounceBatchMessageSize 批量公告消息的最大大小（1024 个 64 字符哈希 + 节点地址）
+	MaxAnnounceBatchMessageSize = 128 * 1024 // 128KB
+
+	// MaxAnnounceBatchMessages 每个流最多处理的消息数量
+	MaxAnnounceBatchMessages = 64
+
+	// announceBatchStreamTimeout 处理方为单个流设置的总时限，防止一个节点长期占用处理器
+	announceBatchStreamTimeout = 2 * time.Minute
+
+	// announceReplication 每个 chunkHash 公告的最近节点数量
+	announceReplication = 20
+
+	// announceBatchConcurrency 同时进行批量公告的节点数量
+	announceBatchConcurrency = 16
+)
+
+// announceBatchMsg 批量公告消息
+type announceBatchMsg struct {
+	ChunkHashes []string      `json:"chunk_hashes"`
+	PeerInfo    peer.AddrInfo `json:"peer_info"`
+}
+
+// announceBatchResponse 批量公告响应
+type announceBatchResponse struct {
+	Accepted int    `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AnnounceBatch 向网络批量公告多个 chunkHash
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - chunkHashes: 要公告的 chunkHash 列表（hex 编码）
+//
+// 返回值:
+//   - error: 所有节点都公告失败时返回错误
+func (d *P2PService) AnnounceBatch(ctx context.Context, chunkHashes []string) error {
+	if len(chunkHashes) == 0 {
+		return nil
+	}
+	for _, h := range chunkHashes {
+		if len(h) == 0 {
+			return errors.New("empty chunk hash")
+		}
+	}
+
+	// 1. 按最近节点分组
+	groups := make(map[peer.ID][]string)
+	rt := d.DHT.RoutingTable()
+	for _, h := range chunkHashes {
+		for _, p := range rt.NearestPeers(kb.ConvertKey(h), announceReplication) {
+			groups[p] = append(groups[p], h)
+		}
+	}
+
+	// 路由表为空时只写入本地提供者存储
+	if len(groups) == 0 {
+		logrus.Infof("no peers in routing table, adding self as provider for %d chunks", len(chunkHashes))
+		self := peer.AddrInfo{ID: d.Host.ID(), Addrs: d.Host.Addrs()}
+		for _, h := range chunkHashes {
+			if err := d.DHT.ProviderStore().AddProvider(ctx, []byte(h), self); err != nil {
+				return fmt.Errorf("failed to add provider for chunk %s: %w", h, err)
+			}
+		}
+		return nil
+	}
+
+	// 2. 并发向每个节点发送
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		success  int
+		lastErr  error
+		sem      = make(chan struct{}, announceBatchConcurrency)
+		start    = time.Now()
+		peerInfo = peer.AddrInfo{ID: d.Host.ID(), Addrs: d.Host.Addrs()}
+	)
+	for peerID, hashes := range groups {
+		wg.Add(1)
+		go func(peerID peer.ID, hashes []string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			err := d.announceBatchToPeer(ctx, peerID, peerInfo, hashes)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = err
+				logrus.WithFields(logrus.Fields{
+					"peer":   peerID,
+					"chunks": len(hashes),
+					"error":  err,
+				}).Debug("batch announce to peer failed")
+				return
+			}
+			success++
+		}(peerID, hashes)
+	}
+	wg.Wait()
+
+	logrus.Infof("batch announced %d chunks to %d/%d peers in %v",
+		len(chunkHashes), success, len(groups), time.Since(start))
+
+	if success == 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("batch announce failed on all %d peers: %w", len(groups), lastErr)
+	}
+	return nil
+}
+
+// announceBatchToPeer 向单个节点发送所有批次，每个流最多发送 MaxAnnounceBatchMessages 条消息，
+// 对端不支持 v2 时回退到 v1
+func (d *P2PService) announceBatchToPeer(ctx context.Context, peerID peer.ID, peerInfo peer.AddrInfo, hashes []string) error {
+	const perStream = MaxAnnounceBatchSize * MaxAnnounceBatchMessages
+	for start := 0; start < len(hashes); start += perStream {
+		end := min(start+perStream, len(hashes))
+		err := d.announceBatchStream(ctx, peerID, peerInfo, hashes[start:end])
+		if isProtocolNotSupported(err) {
+			return d.announceV1ToPeer(ctx, peerID, hashes[start:])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// announceBatchStream 通过一个流发送最多 MaxAnnounceBatchMessages 批
+// 对端不支持 v2 时返回 NewStream 的原始错误，由调用方回退
+func (d *P2PService) announceBatchStream(ctx context.Context, peerID peer.ID, peerInfo peer.AddrInfo, hashes []string) error {
+	streamCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+	s, err := d.Host.NewStream(streamCtx, peerID, AnnounceBatchProtocol)
+	cancel()
+	if err != nil {
+		if isProtocolNotSupported(err) {
+			return err
+		}
+		return fmt.Errorf("open stream failed: %w", err)
+	}
+	defer s.Close()
+
+	rdr := bufio.NewReader(s)
+	for start := 0; start < len(hashes); start += MaxAnnounceBatchSize {
+		end := min(start+MaxAnnounceBatchSize, len(hashes))
+
+		data, err := json.Marshal(announceBatchMsg{ChunkHashes: hashes[start:end], PeerInfo: peerInfo})
+		if err != nil {
+			return fmt.Errorf("marshal batch announce message failed: %w", err)
+		}
+		data = append(data, '\n')
+
+		s.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := s.Write(data); err != nil {
+			s.Reset()
+			return fmt.Errorf("write batch announce message failed: %w", err)
+		}
+
+		s.SetReadDeadline(time.Now().Add(readTimeout))
+		line, err := rdr.ReadBytes('\n')
+		if err != nil {
+			s.Reset()
+			return fmt.Errorf("read batch announce response failed: %w", err)
+		}
+		var resp announceBatchResponse
+		if err := json.Unmarshal(bytes.TrimSpace(line), &resp); err != nil {
+			s.Reset()
+			return fmt.Errorf("invalid batch announce response: %w", err)
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("peer rejected batch announce: %s", resp.Error)
+		}
+	}
+	return nil
+}
+
+// announceV1ToPeer 逐个使用 Announce/1.0.0 向不支持批量协议的旧节点公告
+func (d *P2PService) announceV1ToPeer(ctx context.Context, peerID peer.ID, hashes []string) error {
+	logrus.Debugf("peer %s does not support %s, falling back to %s", peerID, AnnounceBatchProtocol, AnnounceProtocol)
+
+	var lastErr error
+	success := 0
+	for _, h := range hashes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.announceToPeer(ctx, peerID, h); err != nil {
+			lastErr = err
+			continue
+		}
+		success++
+	}
+	if success == 0 && lastErr != nil {
+		return lastErr
+	}
+	return nil
+}
+
+// isProtocolNotSupported 判断 NewStream 失败是否由于对端不支持协议
+func isProtocolNotSupported(err error) bool {
+	var e multistream.ErrNotSupported[protocol.ID]
+	return errors.As(err, &e)
+}
+
+// AnnounceBatchHandler 注册批量公告协议处理器，将收到的 chunkHash 批量写入 ProviderStore
+func (d *P2PService) AnnounceBatchHandler(ctx context.Context) {
+	d.Host.SetStreamHandler(AnnounceBatchProtocol, func(s network.Stream) {
+		defer s.Close()
+
+		// 检查服务是否已关闭
+		select {
+		case <-d.Ctx.Done():
+			logrus.Debug("Service is shutting down, ignoring batch announce request")
+			return
+		default:
+		}
+
+		remotePeer := s.Conn().RemotePeer()
+		scanner := bufio.NewScanner(s)
+		// 限制单条消息大小防止DoS攻击
+		scanner.Buffer(make([]byte, 0, 64*1024), MaxAnnounceBatchMessageSize)
+
+		total := 0
+		deadline := time.Now().Add(announceBatchStreamTimeout)
+		for messages := 0; messages < MaxAnnounceBatchMessages; messages++ {
+			readDeadline := time.Now().Add(readTimeout)
+			if readDeadline.After(deadline) {
+				readDeadline = deadline
+			}
+			s.SetReadDeadline(readDeadline)
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					logrus.WithFields(logrus.Fields{
+						"remotePeer": remotePeer,
+						"error":      err,
+						"action":     "read_message",
+					}).Warn("failed to read batch announce message")
+				}
+				break
+			}
+
+			resp := d.handleAnnounceBatchMsg(ctx, remotePeer, scanner.Bytes())
+			total += resp.Accepted
+
+			respBytes, err := json.Marshal(resp)
+			if err != nil {
+				logrus.WithError(err).Error("marshal batch announce response failed")
+				return
+			}
+			respBytes = append(respBytes, '\n')
+
+			s.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := s.Write(respBytes); err != nil {
+				logrus.WithError(err).Warn("failed to write batch announce response")
+				return
+			}
+			if resp.Error != "" {
+				return
+			}
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"remotePeer": remotePeer,
+			"chunks":     total,
+			"action":     "providers_added",
+		}).Info("added providers from batch announce")
+	})
+}
+
+// handleAnnounceBatchMsg 校验一条批量公告消息并写入 ProviderStore
+func (d *P2PService) handleAnnounceBatchMsg(ctx context.Context, remotePeer peer.ID, line []byte) announceBatchResponse {
+	var msg announceBatchMsg
+	if err := json.Unmarshal(bytes.TrimSpace(line), &msg); err != nil {
+		return announceBatchResponse{Error: "invalid message format"}
+	}
+	if len(msg.ChunkHashes) == 0 {
+		return announceBatchResponse{}
+	}
+	if len(msg.ChunkHashes) > MaxAnnounceBatchSize {
+		return announceBatchResponse{Error: fmt.Sprintf("too many chunk hashes: %d (max %d)", len(msg.ChunkHashes), MaxAnnounceBatchSize)}
+	}
+	if msg.PeerInfo.ID != remotePeer {
+		return announceBatchResponse{Error: "peer info does not match remote peer"}
+	}
+
+	accepted := 0
+	for _, h := range msg.ChunkHashes {
+		if _, err := hex.DecodeString(h); err != nil || len(h) == 0 {
+			continue
+		}
+		if err := d.DHT.ProviderStore().AddProvider(ctx, []byte(h), msg.PeerInfo); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"chunk":    h,
+				"provider": msg.PeerInfo.ID,
+				"error":    err,
+				"action":   "add_provider",
+			}).Error("failed to add provider to DHT")
+			continue
+		}
+		accepted++
+	}
+	return announceBatchResponse{Accepted: accepted}
+}
@@ -0,0 +1,391 @@
+// Package p2p 提供带宽限制功能
+//
+// Bandwidth 功能:
+//   - 令牌桶限速: 全局上传、全局下载、每节点上传、每节点下载，0 表示不限速
+//   - 接入点: chunk 数据协议处理器的发送（上传）和 DownloadChunk 的接收（下载）经过限速
+//   - 运行时调整: SetLimits / SetSchedules 立即生效，HTTP API 为 /api/v1/node/bandwidth
+//   - 时段计划: 按本地时间的时段覆盖默认限速，时段可以跨越午夜（如 22:00-06:00）
+//
+// 注意事项:
+//   - 限速单位为字节/秒，按网络上传输的字节计算（压缩后的大小）
+//   - 令牌桶的突发容量为 1 秒的流量（至少 ReadBufferSize）
+//   - 限速很低时单个 chunk 的传输可能超过 data_timeout，需要相应调大
+//   - 每节点令牌桶空闲 peerBucketIdleTimeout 后回收
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// peerBucketIdleTimeout 每节点令牌桶空闲超过此时间后回收
+const peerBucketIdleTimeout = 10 * time.Minute
+
+// BandwidthLimits 带宽限制（字节/秒），0 表示不限速
+type BandwidthLimits struct {
+	UploadRate       int64 `json:"uploadRate"`       // 全局上传
+	DownloadRate     int64 `json:"downloadRate"`     // 全局下载
+	PeerUploadRate   int64 `json:"peerUploadRate"`   // 每个节点的上传
+	PeerDownloadRate int64 `json:"peerDownloadRate"` // 每个节点的下载
+}
+
+// Validate 检查限速值是否合法
+func (l BandwidthLimits) Validate() error {
+	if l.UploadRate < 0 || l.DownloadRate < 0 || l.PeerUploadRate < 0 || l.PeerDownloadRate < 0 {
+		return fmt.Errorf("bandwidth rates must be >= 0")
+	}
+	return nil
+}
+
+func (l BandwidthLimits) unlimited() bool {
+	return l == BandwidthLimits{}
+}
+
+// BandwidthSchedule 时段限速计划，在 [Start, End) 时段内使用其中的限速代替默认值
+// Start 和 End 为本地时间 "HH:MM"；Start 大于 End 表示跨越午夜，相等表示全天
+type BandwidthSchedule struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	BandwidthLimits
+}
+
+// window 返回时段的起止分钟数
+func (s BandwidthSchedule) window() (start, end int, err error) {
+	start, err = parseClock(s.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err = parseClock(s.End)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// contains 判断一天中的第 minute 分钟是否在时段内
+func (s BandwidthSchedule) contains(minute int) bool {
+	start, end, err := s.window()
+	if err != nil {
+		return false
+	}
+	switch {
+	case start < end:
+		return minute >= start && minute < end
+	case start > end:
+		return minute >= start || minute < end
+	default:
+		return true
+	}
+}
+
+// parseClock 解析 "HH:MM"，返回一天中的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (must be HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// BandwidthStatus 带宽限制的当前状态
+type BandwidthStatus struct {
+	Limits     BandwidthLimits     `json:"limits"`    // 默认限速
+	Schedules  []BandwidthSchedule `json:"schedules"` // 时段计划
+	Active     BandwidthLimits     `json:"active"`    // 当前生效的限速
+	Uploaded   int64               `json:"uploaded"`  // 累计上传字节数
+	Downloaded int64               `json:"downloaded"`
+}
+
+// bandwidthDirection 流量方向
+type bandwidthDirection int
+
+const (
+	directionUpload bandwidthDirection = iota
+	directionDownload
+)
+
+// tokenBucket 令牌桶，令牌可以透支，透支部分换算为等待时间
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64 // 字节/秒，0 表示不限速
+	tokens  float64
+	last    time.Time
+	lastUse time.Time
+}
+
+func (b *tokenBucket) burst() float64 {
+	return max(b.rate, ReadBufferSize)
+}
+
+// setRate 修改速率，从不限速切换到限速时令牌桶从满开始
+func (b *tokenBucket) setRate(rate int64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == 0 {
+		b.tokens = max(float64(rate), ReadBufferSize)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		// 先按旧速率补充到 now，否则每分钟的刷新会丢弃期间积累的令牌
+		b.tokens = min(b.burst(), b.tokens+elapsed.Seconds()*b.rate)
+	}
+	b.rate = float64(rate)
+	b.last = now
+	b.tokens = min(b.tokens, b.burst())
+}
+
+// take 取出 n 个令牌，返回需要等待的时间
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastUse = now
+	if b.rate == 0 {
+		return 0
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst(), b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) idleSince(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.lastUse)
+}
+
+// peerBuckets 一个节点的上传和下载令牌桶
+type peerBuckets struct {
+	upload, download tokenBucket
+}
+
+// BandwidthLimiter 全局和每节点的上传、下载限速
+type BandwidthLimiter struct {
+	mu           sync.Mutex
+	base         BandwidthLimits
+	schedules    []BandwidthSchedule
+	active       BandwidthLimits
+	activeMinute int // 上次计算生效限速的时刻（自 Unix 纪元的分钟数），-1 表示需要重新计算
+	upload       tokenBucket
+	download     tokenBucket
+	peers        map[peer.ID]*peerBuckets
+
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+
+	now func() time.Time
+}
+
+// NewBandwidthLimiter 创建带宽限制器
+// 参数:
+//   - limits: 默认限速
+//   - schedules: 时段计划（可为 nil），按顺序匹配，第一个包含当前时刻的计划生效
+//
+// 返回值:
+//   - *BandwidthLimiter: 限制器
+//   - error: 限速值或时段格式不合法时返回错误
+func NewBandwidthLimiter(limits BandwidthLimits, schedules []BandwidthSchedule) (*BandwidthLimiter, error) {
+	if err := validateBandwidth(limits, schedules); err != nil {
+		return nil, err
+	}
+	return &BandwidthLimiter{
+		base:         limits,
+		schedules:    schedules,
+		activeMinute: -1,
+		peers:        make(map[peer.ID]*peerBuckets),
+		now:          time.Now,
+	}, nil
+}
+
+func validateBandwidth(limits BandwidthLimits, schedules []BandwidthSchedule) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	for i, s := range schedules {
+		if _, _, err := s.window(); err != nil {
+			return fmt.Errorf("schedule %d: %w", i, err)
+		}
+		if err := s.BandwidthLimits.Validate(); err != nil {
+			return fmt.Errorf("schedule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// SetLimits 修改默认限速，立即生效
+func (l *BandwidthLimiter) SetLimits(limits BandwidthLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.base = limits
+	l.activeMinute = -1
+	l.refreshLocked(l.now())
+	return nil
+}
+
+// SetSchedules 替换时段计划，立即生效；传入空列表清除所有计划
+func (l *BandwidthLimiter) SetSchedules(schedules []BandwidthSchedule) error {
+	if err := validateBandwidth(BandwidthLimits{}, schedules); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedules = append([]BandwidthSchedule(nil), schedules...)
+	l.activeMinute = -1
+	l.refreshLocked(l.now())
+	return nil
+}
+
+// Status 返回当前的限速配置、生效值和流量统计
+func (l *BandwidthLimiter) Status() BandwidthStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refreshLocked(l.now())
+	schedules := append([]BandwidthSchedule{}, l.schedules...)
+	return BandwidthStatus{
+		Limits:     l.base,
+		Schedules:  schedules,
+		Active:     l.active,
+		Uploaded:   l.uploaded.Load(),
+		Downloaded: l.downloaded.Load(),
+	}
+}
+
+// refreshLocked 每分钟重新计算一次生效的限速，并回收空闲的节点令牌桶（调用方持有 l.mu）
+func (l *BandwidthLimiter) refreshLocked(now time.Time) {
+	minute := int(now.Unix() / 60)
+	if minute == l.activeMinute {
+		return
+	}
+	l.activeMinute = minute
+
+	active := l.base
+	minuteOfDay := now.Hour()*60 + now.Minute()
+	for _, s := range l.schedules {
+		if s.contains(minuteOfDay) {
+			active = s.BandwidthLimits
+			break
+		}
+	}
+
+	l.active = active
+	l.upload.setRate(active.UploadRate, now)
+	l.download.setRate(active.DownloadRate, now)
+	for id, pb := range l.peers {
+		if pb.upload.idleSince(now) > peerBucketIdleTimeout && pb.download.idleSince(now) > peerBucketIdleTimeout {
+			delete(l.peers, id)
+			continue
+		}
+		pb.upload.setRate(active.PeerUploadRate, now)
+		pb.download.setRate(active.PeerDownloadRate, now)
+	}
+}
+
+// wait 按方向记录 n 字节流量，超出限速时阻塞到令牌足够或 ctx 取消
+func (l *BandwidthLimiter) wait(ctx context.Context, dir bandwidthDirection, peerID peer.ID, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	if dir == directionUpload {
+		l.uploaded.Add(int64(n))
+	} else {
+		l.downloaded.Add(int64(n))
+	}
+
+	now := l.now()
+	l.mu.Lock()
+	l.refreshLocked(now)
+	if l.active.unlimited() {
+		l.mu.Unlock()
+		return nil
+	}
+	pb, ok := l.peers[peerID]
+	if !ok {
+		pb = &peerBuckets{}
+		pb.upload.setRate(l.active.PeerUploadRate, now)
+		pb.download.setRate(l.active.PeerDownloadRate, now)
+		l.peers[peerID] = pb
+	}
+	global, perPeer := &l.upload, &pb.upload
+	if dir == directionDownload {
+		global, perPeer = &l.download, &pb.download
+	}
+	l.mu.Unlock()
+
+	delay := max(global.take(n, now), perPeer.take(n, now))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// uploadWriter 返回经过上传限速的 Writer
+func (l *BandwidthLimiter) uploadWriter(ctx context.Context, peerID peer.ID, w io.Writer) io.Writer {
+	return &limitedWriter{ctx: ctx, limiter: l, peerID: peerID, w: w}
+}
+
+// downloadReader 返回经过下载限速的 Reader
+func (l *BandwidthLimiter) downloadReader(ctx context.Context, peerID peer.ID, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, limiter: l, peerID: peerID, r: r}
+}
+
+// limitedWriter 写入前等待令牌，大块数据按 ReadBufferSize 分段写入以保持平滑
+type limitedWriter struct {
+	ctx     context.Context
+	limiter *BandwidthLimiter
+	peerID  peer.ID
+	w       io.Writer
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), ReadBufferSize)
+		if err := lw.limiter.wait(lw.ctx, directionUpload, lw.peerID, n); err != nil {
+			return written, err
+		}
+		m, err := lw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// limitedReader 读取后按读到的字节数等待令牌，阻塞期间对端受流控背压
+type limitedReader struct {
+	ctx     context.Context
+	limiter *BandwidthLimiter
+	peerID  peer.ID
+	r       io.Reader
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.wait(lr.ctx, directionDownload, lr.peerID, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
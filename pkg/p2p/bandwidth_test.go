@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var b tokenBucket
+	if d := b.take(1<<20, now); d != 0 {
+		t.Fatalf("unlimited bucket: wait %v, want 0", d)
+	}
+
+	// 从不限速切换到限速时从满桶开始，突发容量至少 ReadBufferSize
+	b.setRate(1000, now)
+	if d := b.take(ReadBufferSize, now); d != 0 {
+		t.Fatalf("full bucket: wait %v, want 0", d)
+	}
+	// 透支 1000 字节，按 1000 字节/秒需要等待 1 秒
+	if d := b.take(1000, now); d != time.Second {
+		t.Fatalf("overdrawn bucket: wait %v, want 1s", d)
+	}
+	// 2 秒后补充 2000 个令牌，还清透支后剩余 1000
+	now = now.Add(2 * time.Second)
+	if d := b.take(1000, now); d != 0 {
+		t.Fatalf("refilled bucket: wait %v, want 0", d)
+	}
+	if d := b.take(500, now); d != 500*time.Millisecond {
+		t.Fatalf("empty bucket: wait %v, want 500ms", d)
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var b tokenBucket
+	b.setRate(100000, now)
+	if d := b.take(100000, now); d != 0 {
+		t.Fatalf("full bucket: wait %v, want 0", d)
+	}
+
+	// 长时间空闲后令牌不超过 1 秒的流量
+	now = now.Add(time.Minute)
+	if d := b.take(150000, now); d != 500*time.Millisecond {
+		t.Fatalf("after idle: wait %v, want 500ms", d)
+	}
+
+	// 降低速率时令牌按新的突发容量截断，透支保留
+	now = now.Add(time.Minute)
+	b.setRate(50000, now)
+	if d := b.take(50000, now); d != 0 {
+		t.Fatalf("after rate decrease: wait %v, want 0", d)
+	}
+	if d := b.take(50000, now); d != time.Second {
+		t.Fatalf("after rate decrease: wait %v, want 1s", d)
+	}
+
+	// 切换为不限速后立即放行
+	b.setRate(0, now)
+	if d := b.take(1<<20, now); d != 0 {
+		t.Fatalf("unlimited again: wait %v, want 0", d)
+	}
+}
+
+// newTestLimiter 创建时钟固定在 now 的限制器
+func newTestLimiter(t *testing.T, limits BandwidthLimits, schedules []BandwidthSchedule, now *time.Time) *BandwidthLimiter {
+	t.Helper()
+	l, err := NewBandwidthLimiter(limits, schedules)
+	if err != nil {
+		t.Fatalf("NewBandwidthLimiter: %v", err)
+	}
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestBandwidthLimiterSetLimits(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	l := newTestLimiter(t, BandwidthLimits{UploadRate: 1000}, nil, &now)
+
+	if err := l.SetLimits(BandwidthLimits{DownloadRate: -1}); err == nil {
+		t.Fatal("SetLimits accepted a negative rate")
+	}
+	if got := l.Status().Active; got != (BandwidthLimits{UploadRate: 1000}) {
+		t.Fatalf("active after rejected update = %+v", got)
+	}
+
+	// 修改立即生效，不必等到下一分钟
+	if err := l.SetLimits(BandwidthLimits{UploadRate: 2000, PeerDownloadRate: 500}); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	if got := l.Status().Active; got != (BandwidthLimits{UploadRate: 2000, PeerDownloadRate: 500}) {
+		t.Fatalf("active = %+v", got)
+	}
+	if l.upload.rate != 2000 || l.download.rate != 0 {
+		t.Fatalf("bucket rates = %v/%v, want 2000/0", l.upload.rate, l.download.rate)
+	}
+}
+
+func TestBandwidthLimiterSchedules(t *testing.T) {
+	now := time.Date(2026, 1, 1, 23, 30, 0, 0, time.Local)
+	base := BandwidthLimits{UploadRate: 1000}
+	night := BandwidthLimits{UploadRate: 5000}
+	l := newTestLimiter(t, base, []BandwidthSchedule{
+		{Start: "22:00", End: "06:00", BandwidthLimits: night},
+	}, &now)
+
+	// 跨越午夜的时段
+	if got := l.Status().Active; got != night {
+		t.Fatalf("active at 23:30 = %+v, want %+v", got, night)
+	}
+	now = time.Date(2026, 1, 2, 5, 59, 0, 0, time.Local)
+	if got := l.Status().Active; got != night {
+		t.Fatalf("active at 05:59 = %+v, want %+v", got, night)
+	}
+	now = time.Date(2026, 1, 2, 6, 0, 0, 0, time.Local)
+	if got := l.Status().Active; got != base {
+		t.Fatalf("active at 06:00 = %+v, want %+v", got, base)
+	}
+
+	if err := l.SetSchedules([]BandwidthSchedule{{Start: "6:00pm", End: "06:00"}}); err == nil {
+		t.Fatal("SetSchedules accepted an invalid time")
+	}
+	if err := l.SetSchedules([]BandwidthSchedule{{Start: "06:00", End: "06:00", BandwidthLimits: night}}); err != nil {
+		t.Fatalf("SetSchedules: %v", err)
+	}
+	if got := l.Status().Active; got != night {
+		t.Fatalf("active with all-day schedule = %+v, want %+v", got, night)
+	}
+}
+
+func TestBandwidthLimiterWait(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	l := newTestLimiter(t, BandwidthLimits{PeerDownloadRate: 1000}, nil, &now)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 突发容量内不等待；上传不限速
+	if err := l.wait(ctx, directionDownload, "peer-a", ReadBufferSize); err != nil {
+		t.Fatalf("wait within burst: %v", err)
+	}
+	if err := l.wait(ctx, directionUpload, "peer-a", 1<<20); err != nil {
+		t.Fatalf("unlimited upload: %v", err)
+	}
+	// 超出后需要等待，ctx 已取消时返回
+	if err := l.wait(ctx, directionDownload, "peer-a", 1000); !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait over limit: got %v, want context.Canceled", err)
+	}
+	// 每个节点的令牌桶相互独立
+	if err := l.wait(ctx, directionDownload, "peer-b", ReadBufferSize); err != nil {
+		t.Fatalf("other peer: %v", err)
+	}
+
+	status := l.Status()
+	if status.Downloaded != 2*ReadBufferSize+1000 || status.Uploaded != 1<<20 {
+		t.Fatalf("counters = %d/%d", status.Uploaded, status.Downloaded)
+	}
+}
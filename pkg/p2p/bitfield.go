@@ -0,0 +1,602 @@
+// Package p2p 提供 Chunk 可用性位图交换功能
+//
+// Bitfield 功能:
+//   - 位图查询: 下载方发送文件 CID，对端返回其持有的叶子索引位图
+//   - Have 推送: 节点完成某个 Chunk 后，向查询过该文件位图的节点推送增量更新
+//   - 可用性表: 下载期间维护 peer -> 位图 的映射，按索引直接选择节点，无需逐个探测
+//
+// 协议定义:
+//   - /p2pFileTransfer/bitfield/1.0.0: 位图查询协议
+//     请求: {"cid": "<hex>"}
+//     响应: {"cid": "<hex>", "leafCount": N, "bitfield": "<base64>"} 或 {"error": "..."}
+//   - /p2pFileTransfer/have/1.0.0: Have 推送协议（单向）
+//     消息: {"cid": "<hex>", "leafCount": N, "indexes": [i, ...]}
+//
+// 位图格式:
+//   - 第 i 个叶子对应第 i/8 个字节的第 7-i%8 位（高位在前，与 BitTorrent 一致）
+//
+// 注意事项:
+//   - 对端根据本地元数据（或正在进行的下载）计算位图，叶子数量与下载方不一致时（如 chameleon 文件已更新）位图被忽略
+//   - 下载完成并校验的 Chunk 写入本地存储，下载期间即可对外提供并推送 Have
+//   - 只为本地已知的文件记录 Have 订阅，每个节点最多订阅 maxHaveInterestPerPeer 个文件
+//   - 位图只用于选择节点，下载后仍会校验 Chunk 哈希
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 协议名定义
+	BitfieldProtocol = "/p2pFileTransfer/bitfield/1.0.0"
+	HaveProtocol     = "/p2pFileTransfer/have/1.0.0"
+
+	// MaxBitfieldMessageSize 位图消息的最大大小（约 500 万个叶子）
+	MaxBitfieldMessageSize = 1024 * 1024 // 1MB
+
+	// maxBitfieldRequestSize 位图查询请求的最大大小
+	maxBitfieldRequestSize = 1024 // 1KB
+
+	// maxBitfieldPeers 每次下载最多查询位图的节点数量
+	maxBitfieldPeers = 32
+
+	// haveInterestTTL 查询过位图的节点在此时间内接收 Have 推送
+	haveInterestTTL = 30 * time.Minute
+
+	// maxHaveInterestPerPeer 每个节点最多订阅的文件数量，超出后不再记录新的订阅
+	maxHaveInterestPerPeer = 64
+)
+
+// Bitfield 叶子索引位图
+type Bitfield []byte
+
+// NewBitfield 创建可容纳 n 个索引的空位图
+func NewBitfield(n int) Bitfield {
+	return make(Bitfield, (n+7)/8)
+}
+
+// Has 返回索引 i 是否被设置
+func (b Bitfield) Has(i int) bool {
+	if i < 0 || i/8 >= len(b) {
+		return false
+	}
+	return b[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+// Set 设置索引 i
+func (b Bitfield) Set(i int) {
+	if i < 0 || i/8 >= len(b) {
+		return
+	}
+	b[i/8] |= 0x80 >> uint(i%8)
+}
+
+// Count 返回被设置的索引数量
+func (b Bitfield) Count() int {
+	count := 0
+	for _, v := range b {
+		for ; v != 0; v &= v - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// bitfieldRequest 位图查询请求
+type bitfieldRequest struct {
+	CID string `json:"cid"`
+}
+
+// bitfieldResponse 位图查询响应
+type bitfieldResponse struct {
+	CID       string   `json:"cid,omitempty"`
+	LeafCount int      `json:"leafCount,omitempty"`
+	Bitfield  Bitfield `json:"bitfield,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// haveMessage Have 推送消息
+type haveMessage struct {
+	CID       string `json:"cid"`
+	LeafCount int    `json:"leafCount"`
+	Indexes   []int  `json:"indexes"`
+}
+
+// -----------------------------
+// 客户端方法
+// -----------------------------
+
+// QueryBitfield 查询指定 peer 持有的文件叶子位图
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - peerID: 目标节点
+//   - cid: 文件 CID（hex 编码）
+//
+// 返回值:
+//   - Bitfield: 对端持有的叶子位图
+//   - int: 对端元数据中的叶子数量
+//   - error: 错误信息（对端没有该文件的元数据时也返回错误）
+func (p *P2PService) QueryBitfield(ctx context.Context, peerID peer.ID, cid string) (Bitfield, int, error) {
+	requestTimeout := DefaultRequestTimeout
+	if p.Config.RequestTimeout > 0 {
+		requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
+	}
+	clientCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	s, err := p.Host.NewStream(clientCtx, peerID, BitfieldProtocol)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open stream: %w", err)
+	}
+	defer s.Close()
+
+	s.SetReadDeadline(time.Now().Add(requestTimeout))
+	s.SetWriteDeadline(time.Now().Add(requestTimeout))
+
+	if err := json.NewEncoder(s).Encode(bitfieldRequest{CID: cid}); err != nil {
+		return nil, 0, fmt.Errorf("encode request: %w", err)
+	}
+
+	var resp bitfieldResponse
+	if err := json.NewDecoder(io.LimitReader(s, MaxBitfieldMessageSize)).Decode(&resp); err != nil {
+		return nil, 0, fmt.Errorf("decode response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, 0, fmt.Errorf("peer returned error: %s", resp.Error)
+	}
+	if len(resp.Bitfield) != (resp.LeafCount+7)/8 {
+		return nil, 0, fmt.Errorf("invalid bitfield length %d for %d leaves", len(resp.Bitfield), resp.LeafCount)
+	}
+	return resp.Bitfield, resp.LeafCount, nil
+}
+
+// NotifyHave 向最近查询过该文件位图的节点推送本节点新完成的 Chunk（异步）
+// 参数:
+//   - cid: 文件 CID（hex 编码）
+//   - leafCount: 文件叶子数量
+//   - index: 新完成的叶子索引
+func (p *P2PService) NotifyHave(cid string, leafCount, index int) {
+	peers := p.haveInterest.peers(strings.ToLower(cid))
+	if len(peers) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(haveMessage{CID: strings.ToLower(cid), LeafCount: leafCount, Indexes: []int{index}})
+	if err != nil {
+		logrus.Errorf("Failed to marshal have message: %v", err)
+		return
+	}
+
+	requestTimeout := DefaultRequestTimeout
+	if p.Config.RequestTimeout > 0 {
+		requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
+	}
+	for _, peerID := range peers {
+		go func(peerID peer.ID) {
+			ctx, cancel := context.WithTimeout(p.Ctx, requestTimeout)
+			defer cancel()
+
+			s, err := p.Host.NewStream(ctx, peerID, HaveProtocol)
+			if err != nil {
+				logrus.Debugf("Failed to push have to %s, dropping interest: %v", peerID, err)
+				p.haveInterest.remove(strings.ToLower(cid), peerID)
+				return
+			}
+			defer s.Close()
+
+			s.SetWriteDeadline(time.Now().Add(requestTimeout))
+			if _, err := s.Write(append(data, '\n')); err != nil {
+				s.Reset()
+				logrus.Debugf("Failed to write have message to %s: %v", peerID, err)
+			}
+		}(peerID)
+	}
+}
+
+// seedDownloadedChunk 将下载并校验过的 chunk 写入本地存储，并向订阅了该文件的节点推送 Have
+// 下载期间本节点即可对外提供已完成的 chunk；叶子没有哈希或写入失败（如超出配额）时不推送
+func (p *P2PService) seedDownloadedChunk(cid string, leafCount int, task chunkTask, data []byte) {
+	if cid == "" || task.chunkHashStr == "" {
+		return
+	}
+	if !p.hasLocalChunk(task.chunkHashStr) {
+		// 证明协议下载的 chunk 只对 Merkle 根校验过，按内容哈希存储前再确认一次
+		hash := sha256.Sum256(data)
+		if !bytesEqual(hash[:], task.chunk.ChunkHash) {
+			return
+		}
+		if err := p.ChunkStore.Put(task.chunkHashStr, data); err != nil {
+			logrus.Debugf("Failed to store downloaded chunk %s: %v", task.chunkHashStr, err)
+			return
+		}
+	}
+	p.NotifyHave(cid, leafCount, task.index)
+}
+
+// LocalBitfield 根据本地元数据和 Chunk 存储计算文件的叶子位图
+// 本地没有元数据但该文件正在下载时，按下载任务的叶子计算（已下载的 chunk 可以对外提供）
+// 参数:
+//   - cid: 文件 CID（hex 编码）
+//
+// 返回值:
+//   - Bitfield: 本节点持有的叶子位图
+//   - int: 叶子数量
+//   - error: 本地没有该文件的元数据且未在下载时返回错误
+func (p *P2PService) LocalBitfield(cid string) (Bitfield, int, error) {
+	var hashes []string
+	if metaData, err := p.LoadLocalMetaData(cid); err == nil {
+		hashes = make([]string, len(metaData.Leaves))
+		for i, leaf := range metaData.Leaves {
+			hashes[i] = hex.EncodeToString(leaf.ChunkHash)
+		}
+	} else if hashes = p.availability.localHashes(cid); hashes == nil {
+		return nil, 0, err
+	}
+
+	bf := NewBitfield(len(hashes))
+	for i, hash := range hashes {
+		if p.hasLocalChunk(hash) {
+			bf.Set(i)
+		}
+	}
+	return bf, len(hashes), nil
+}
+
+// hasLocalChunk 检查 Chunk 是否在本地存储中
+func (p *P2PService) hasLocalChunk(chunkHash string) bool {
+	// 轻量元数据的叶子没有哈希，不能按哈希判断
+	if chunkHash == "" {
+		return false
+	}
+	size, err := p.ChunkStore.Size(chunkHash)
+	return err == nil && size <= MaxChunkSize
+}
+
+// -----------------------------
+// 服务端注册处理器
+// -----------------------------
+
+// RegisterBitfieldHandler 处理位图查询请求，并记录查询方以便推送 Have 更新
+func (p *P2PService) RegisterBitfieldHandler(ctx context.Context) {
+	p.Host.SetStreamHandler(BitfieldProtocol, func(s network.Stream) {
+		peerID := s.Conn().RemotePeer()
+		defer s.Close()
+
+		// 检查服务是否已关闭
+		select {
+		case <-p.Ctx.Done():
+			logrus.Debug("Service is shutting down, ignoring bitfield request")
+			return
+		default:
+		}
+
+		requestTimeout := DefaultRequestTimeout
+		if p.Config.RequestTimeout > 0 {
+			requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
+		}
+		s.SetReadDeadline(time.Now().Add(requestTimeout))
+		s.SetWriteDeadline(time.Now().Add(requestTimeout))
+
+		var req bitfieldRequest
+		if err := json.NewDecoder(io.LimitReader(s, maxBitfieldRequestSize)).Decode(&req); err != nil {
+			logrus.Errorf("Invalid bitfield request from %s: %v", peerID, err)
+			return
+		}
+		cid := strings.ToLower(strings.TrimSpace(req.CID))
+		if _, err := hex.DecodeString(cid); err != nil || cid == "" {
+			json.NewEncoder(s).Encode(bitfieldResponse{Error: "invalid cid"})
+			return
+		}
+
+		resp := bitfieldResponse{CID: cid}
+		bf, leafCount, err := p.LocalBitfield(cid)
+		if err != nil {
+			resp.Error = "unknown file"
+		} else {
+			resp.LeafCount = leafCount
+			resp.Bitfield = bf
+			// 查询方正在下载该文件，之后完成的 Chunk 推送给它
+			p.haveInterest.add(cid, peerID)
+		}
+
+		if err := json.NewEncoder(s).Encode(resp); err != nil {
+			logrus.Errorf("Failed to send bitfield to %s: %v", peerID, err)
+			return
+		}
+		logrus.Debugf("Bitfield for %s (%d/%d leaves) sent to peer %s", cid, bf.Count(), leafCount, peerID)
+	})
+}
+
+// RegisterHaveHandler 处理 Have 推送，更新正在进行的下载的可用性表
+func (p *P2PService) RegisterHaveHandler(ctx context.Context) {
+	p.Host.SetStreamHandler(HaveProtocol, func(s network.Stream) {
+		peerID := s.Conn().RemotePeer()
+		defer s.Close()
+
+		requestTimeout := DefaultRequestTimeout
+		if p.Config.RequestTimeout > 0 {
+			requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
+		}
+		s.SetReadDeadline(time.Now().Add(requestTimeout))
+
+		var msg haveMessage
+		if err := json.NewDecoder(io.LimitReader(s, MaxBitfieldMessageSize)).Decode(&msg); err != nil {
+			logrus.Debugf("Invalid have message from %s: %v", peerID, err)
+			return
+		}
+
+		for _, avail := range p.availability.get(strings.ToLower(msg.CID)) {
+			if avail.leafCount != msg.LeafCount {
+				continue
+			}
+			for _, index := range msg.Indexes {
+				avail.set(peerID, index)
+			}
+		}
+	})
+}
+
+// -----------------------------
+// Have 推送订阅
+// -----------------------------
+
+// haveInterestSet 记录查询过各文件位图的节点
+type haveInterestSet struct {
+	mu      sync.Mutex
+	entries map[string]map[peer.ID]time.Time // cid -> peer -> 过期时间
+	counts  map[peer.ID]int                  // peer -> 订阅的文件数量
+}
+
+func newHaveInterestSet() *haveInterestSet {
+	return &haveInterestSet{
+		entries: make(map[string]map[peer.ID]time.Time),
+		counts:  make(map[peer.ID]int),
+	}
+}
+
+// add 记录或续期订阅，节点订阅数已达 maxHaveInterestPerPeer 时忽略新文件
+func (h *haveInterestSet) add(cid string, peerID peer.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	peers, ok := h.entries[cid]
+	if _, subscribed := peers[peerID]; !subscribed {
+		if h.counts[peerID] >= maxHaveInterestPerPeer {
+			return
+		}
+		h.counts[peerID]++
+	}
+	if !ok {
+		peers = make(map[peer.ID]time.Time)
+		h.entries[cid] = peers
+	}
+	peers[peerID] = time.Now().Add(haveInterestTTL)
+}
+
+func (h *haveInterestSet) remove(cid string, peerID peer.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(cid, peerID)
+}
+
+func (h *haveInterestSet) removeLocked(cid string, peerID peer.ID) {
+	if _, ok := h.entries[cid][peerID]; !ok {
+		return
+	}
+	delete(h.entries[cid], peerID)
+	if len(h.entries[cid]) == 0 {
+		delete(h.entries, cid)
+	}
+	if h.counts[peerID]--; h.counts[peerID] <= 0 {
+		delete(h.counts, peerID)
+	}
+}
+
+// peers 返回未过期的订阅节点，并清理过期条目
+func (h *haveInterestSet) peers(cid string) []peer.ID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	var result []peer.ID
+	for peerID, expiresAt := range h.entries[cid] {
+		if now.After(expiresAt) {
+			h.removeLocked(cid, peerID)
+			continue
+		}
+		result = append(result, peerID)
+	}
+	return result
+}
+
+// -----------------------------
+// 下载可用性表
+// -----------------------------
+
+// availabilityMap 一次下载期间各节点持有的叶子位图
+type availabilityMap struct {
+	mu          sync.RWMutex
+	leafCount   int
+	peers       map[peer.ID]Bitfield
+	localHashes []string // 本次下载各叶子的 hex 哈希，本地没有元数据时用于计算本节点的位图
+}
+
+func newAvailabilityMap(leafCount int) *availabilityMap {
+	return &availabilityMap{
+		leafCount: leafCount,
+		peers:     make(map[peer.ID]Bitfield),
+	}
+}
+
+// peersWith 返回持有索引 index 的节点
+func (a *availabilityMap) peersWith(index int) []peer.ID {
+	if a == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var result []peer.ID
+	for peerID, bf := range a.peers {
+		if bf.Has(index) {
+			result = append(result, peerID)
+		}
+	}
+	return result
+}
+
+// count 返回已知持有索引 index 的节点数量
+func (a *availabilityMap) count(index int) int {
+	return len(a.peersWith(index))
+}
+
+// peerCount 返回已获得位图的节点数量
+func (a *availabilityMap) peerCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.peers)
+}
+
+// known 返回是否已获得该节点的位图
+func (a *availabilityMap) known(peerID peer.ID) bool {
+	if a == nil {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.peers[peerID]
+	return ok
+}
+
+func (a *availabilityMap) setBitfield(peerID peer.ID, bf Bitfield) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.peers[peerID] = bf
+}
+
+func (a *availabilityMap) set(peerID peer.ID, index int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	bf, ok := a.peers[peerID]
+	if !ok {
+		bf = NewBitfield(a.leafCount)
+		a.peers[peerID] = bf
+	}
+	bf.Set(index)
+}
+
+// availabilityRegistry 正在进行的下载的可用性表，供 Have 处理器更新
+type availabilityRegistry struct {
+	mu   sync.Mutex
+	maps map[string][]*availabilityMap
+}
+
+func newAvailabilityRegistry() *availabilityRegistry {
+	return &availabilityRegistry{maps: make(map[string][]*availabilityMap)}
+}
+
+func (r *availabilityRegistry) add(cid string, a *availabilityMap) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maps[cid] = append(r.maps[cid], a)
+}
+
+func (r *availabilityRegistry) remove(cid string, a *availabilityMap) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	maps := r.maps[cid]
+	for i, m := range maps {
+		if m == a {
+			maps = append(maps[:i], maps[i+1:]...)
+			break
+		}
+	}
+	if len(maps) == 0 {
+		delete(r.maps, cid)
+	} else {
+		r.maps[cid] = maps
+	}
+}
+
+func (r *availabilityRegistry) get(cid string) []*availabilityMap {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*availabilityMap(nil), r.maps[cid]...)
+}
+
+// localHashes 返回该文件正在进行的下载的叶子哈希，没有下载时返回 nil
+func (r *availabilityRegistry) localHashes(cid string) []string {
+	for _, a := range r.get(cid) {
+		if a.localHashes != nil {
+			return a.localHashes
+		}
+	}
+	return nil
+}
+
+// buildAvailability 向候选节点（做种者和已连接节点）查询位图，构建可用性表并注册以接收 Have 更新
+// 调用方在下载结束后需调用 releaseAvailability
+func (p *P2PService) buildAvailability(ctx context.Context, cid string, tasks []chunkTask, seeders []peer.ID) *availabilityMap {
+	leafCount := len(tasks)
+	avail := newAvailabilityMap(leafCount)
+	avail.localHashes = make([]string, leafCount)
+	for i, task := range tasks {
+		avail.localHashes[i] = task.chunkHashStr
+	}
+	p.availability.add(cid, avail)
+
+	// 候选节点: 做种者优先，其次是已连接节点
+	seen := make(map[peer.ID]bool)
+	var candidates []peer.ID
+	for _, peerID := range append(append([]peer.ID(nil), seeders...), p.Host.Network().Peers()...) {
+		if seen[peerID] || peerID == p.Host.ID() {
+			continue
+		}
+		seen[peerID] = true
+		candidates = append(candidates, peerID)
+		if len(candidates) >= maxBitfieldPeers {
+			break
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, peerID := range candidates {
+		wg.Add(1)
+		go func(peerID peer.ID) {
+			defer wg.Done()
+			bf, n, err := p.QueryBitfield(ctx, peerID, cid)
+			if err != nil {
+				logrus.Debugf("Bitfield query to %s failed: %v", peerID, err)
+				return
+			}
+			if n != leafCount {
+				logrus.Debugf("Ignoring bitfield from %s: leaf count %d != %d", peerID, n, leafCount)
+				return
+			}
+			avail.setBitfield(peerID, bf)
+		}(peerID)
+	}
+	wg.Wait()
+
+	logrus.Infof("Availability for file %s: %d/%d peers returned bitfields", cid, avail.peerCount(), len(candidates))
+	return avail
+}
+
+// releaseAvailability 下载结束后注销可用性表
+func (p *P2PService) releaseAvailability(cid string, avail *availabilityMap) {
+	p.availability.remove(cid, avail)
+}
@@ -3,12 +3,18 @@
 // Chunk 功能:
 //   - Chunk 存在性检查: 验证目标节点是否拥有特定 Chunk
 //   - Chunk 数据下载: 从提供者节点下载 Chunk 数据
+//   - 范围下载: 只下载 Chunk 中指定偏移和长度的数据（DownloadChunkRange，data/1.1.0）
 //   - 流式传输: 使用 32KB 缓冲区进行高效数据传输
 //   - 超时控制: 支持请求超时和数据传输超时
+//   - 带宽限制: 数据发送和接收经过 P2PService.Bandwidth 限速
 //
 // 协议定义:
 //   - /p2pFileTransfer/getChunk/exists/1.0.0: Chunk 存在性检查协议
 //   - /p2pFileTransfer/getChunk/data/1.0.0: Chunk 数据下载协议
+//   - /p2pFileTransfer/getChunk/data/1.1.0: Chunk 数据下载协议（请求可携带 offset/length）
+//   - /p2pFileTransfer/getChunk/data/1.2.0: 按文件 CID 和叶子索引下载，可附带 Merkle 证明（见 chunkProof.go）
+//   - /p2pFileTransfer/getChunk/data/1.3.0: 请求携带可接受的压缩算法，响应为 算法(1) + 数据（见 compression.go）
+//   - /p2pFileTransfer/chunk/2.0.0: 分帧、可流水线的 Chunk 传输协议（见 chunkFrame.go），下载时优先使用
 //
 // 常量配置:
 //   - MaxChunkSize: 4MB (最大 Chunk 大小)
@@ -19,27 +25,42 @@
 //   1. CheckChunkExists: 检查 Chunk 是否存在
 //   2. DownloadChunk: 下载完整 Chunk 数据
 //   3. 验证 SHA256 哈希确保数据完整性
+//
+// 压缩协商:
+//   - 对端不支持分帧协议时 DownloadChunk 先尝试 data/1.3.0，不支持时再回退到 data/1.0.0
+//   - 数据在 SHA256 校验之前解压
+//
+// 范围下载:
+//   - DownloadChunkRange 优先使用分帧协议的 offset/length，对端不支持时使用 data/1.1.0，
+//     仍不支持时下载完整 Chunk、校验哈希后截取
+//   - 部分数据无法用 Chunk 哈希校验，由调用方负责（如对照已校验的完整 Chunk 或文件级校验）
+//   - data/1.1.0 中 Chunk 不存在、请求无效或 offset 不小于 Chunk 大小时重置流；
+//     offset 超出 Chunk 大小时 DownloadChunkRange 返回 ErrRangeNotSatisfiable
 package p2p
 
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/sirupsen/logrus"
 	"io"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
 
 const (
 	// 协议名定义
-	GetChunkExistProtocol = "/p2pFileTransfer/getChunk/exists/1.0.0"
-	GetChunkDataProtocol  = "/p2pFileTransfer/getChunk/data/1.0.0"
+	GetChunkExistProtocol      = "/p2pFileTransfer/getChunk/exists/1.0.0"
+	GetChunkDataProtocol       = "/p2pFileTransfer/getChunk/data/1.0.0"
+	GetChunkRangeProtocol      = "/p2pFileTransfer/getChunk/data/1.1.0"
+	GetChunkCompressedProtocol = "/p2pFileTransfer/getChunk/data/1.3.0"
 
 	// 超时与限制（保留常量作为回退值）
 	DefaultRequestTimeout = 5 * time.Second
@@ -48,20 +69,50 @@ const (
 	ReadBufferSize        = 32 * 1024        // 32KB 读取缓冲区
 )
 
+// ErrPeerBusy 与该节点的并发流数量已达到 ConnManager 的上限，稍后可重试
+var ErrPeerBusy = errors.New("peer has too many active streams")
+
+// ErrRangeNotSatisfiable 范围请求的起始偏移不小于 Chunk 大小
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
 // 请求结构体
 type RequestMessage struct {
 	ChunkHash string `json:"chunkHash"`
 }
 
-// getChunkPathFromHash 从 hex 字符串获取 chunk 路径（使用子目录分片）
-// 格式: <chunkPath>/<前2字符>/<剩余62字符>
-func getChunkPathFromHash(chunkPath string, hashHex string) string {
-	if len(hashHex) < 4 {
-		return filepath.Join(chunkPath, hashHex)
+// RangeRequestMessage data/1.1.0 请求结构体
+// Length 为 0 表示从 Offset 读取到 Chunk 末尾
+type RangeRequestMessage struct {
+	ChunkHash string `json:"chunkHash"`
+	Offset    int64  `json:"offset,omitempty"`
+	Length    int64  `json:"length,omitempty"`
+}
+
+// CompressedRequestMessage data/1.3.0 请求结构体
+type CompressedRequestMessage struct {
+	ChunkHash string   `json:"chunkHash"`
+	Accept    []string `json:"accept,omitempty"` // 可接受的压缩算法（zstd、gzip），按优先级
+}
+
+// readLocalChunk 从本地 ChunkStore 读取 chunk，超过 MaxChunkSize 的数据不对外提供
+func (p *P2PService) readLocalChunk(chunkHash string) ([]byte, error) {
+	size, err := p.ChunkStore.Size(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxChunkSize {
+		return nil, fmt.Errorf("chunk %s is too large: %d bytes", chunkHash, size)
+	}
+	return p.ChunkStore.Get(chunkHash)
+}
+
+// isChunkHashHex 检查是否为 64 个字符的 hex 编码 SHA256 哈希
+func isChunkHashHex(chunkHash string) bool {
+	if len(chunkHash) != 2*sha256.Size {
+		return false
 	}
-	subDir := hashHex[:2]
-	fileName := hashHex[2:]
-	return filepath.Join(chunkPath, subDir, fileName)
+	_, err := hex.DecodeString(chunkHash)
+	return err == nil
 }
 
 // -----------------------------
@@ -75,9 +126,9 @@ func (p *P2PService) CheckChunkExists(ctx context.Context, peerID peer.ID, chunk
 	startTime := time.Now()
 
 	// 检查是否允许创建流
+	// 本地流配额已满不是节点故障，不记录失败
 	if !p.ConnManager.AcquireStream(peerID) {
-		p.ConnManager.RecordFailure(peerID)
-		return false, NewRetryableError(fmt.Errorf("peer %s has too many active streams", peerID))
+		return false, NewRetryableError(fmt.Errorf("%w: %s", ErrPeerBusy, peerID))
 	}
 	defer p.ConnManager.ReleaseStream(peerID)
 
@@ -122,13 +173,127 @@ func (p *P2PService) CheckChunkExists(ctx context.Context, peerID peer.ID, chunk
 }
 
 // DownloadChunk 从指定 peer 下载 chunk 数据
+// 优先使用分帧协议（见 chunkFrame.go），对端不支持时使用 data/1.3.0（压缩）或 data/1.0.0
 func (p *P2PService) DownloadChunk(ctx context.Context, peerID peer.ID, chunkHash string) ([]byte, error) {
+	data, err := p.requestChunkFramed(ctx, peerID, chunkHash, 0, 0)
+	if !errors.Is(err, errFramedUnsupported) {
+		if err == nil && len(data) == 0 {
+			return nil, fmt.Errorf("chunk is empty")
+		}
+		return data, err
+	}
+
+	data, err = p.requestChunkCompressed(ctx, peerID, chunkHash)
+	if err != nil && isProtocolNotSupported(err) {
+		logrus.Debugf("Peer %s does not support %s, falling back to %s", peerID, GetChunkCompressedProtocol, GetChunkDataProtocol)
+		data, err = p.requestChunkData(ctx, peerID, GetChunkDataProtocol, RequestMessage{ChunkHash: chunkHash})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, fmt.Errorf("chunk is empty")
+	}
+	return data, nil
+}
+
+// requestChunkCompressed 通过 data/1.3.0 下载 chunk，返回解压后的数据
+func (p *P2PService) requestChunkCompressed(ctx context.Context, peerID peer.ID, chunkHash string) ([]byte, error) {
+	req := CompressedRequestMessage{ChunkHash: chunkHash, Accept: p.compressor.acceptNames()}
+	// 压缩结果不会大于原始数据（见 chunkCompressor.compress），另加 1 字节算法
+	raw, err := p.requestChunkDataLimit(ctx, peerID, GetChunkCompressedProtocol, req, MaxChunkSize+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	codec := Codec(raw[0])
+	data, err := p.compressor.decode(codec, raw[1:])
+	if err != nil {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, fmt.Errorf("decompress chunk %s from %s: %w", chunkHash, peerID, err)
+	}
+	logrus.Debugf("Chunk %s received from %s via %s (%d bytes, %s %d bytes)",
+		chunkHash, peerID, GetChunkCompressedProtocol, len(data), codec, len(raw)-1)
+	return data, nil
+}
+
+// DownloadChunkRange 从指定 peer 下载 chunk 中的一段数据
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - peerID: 目标节点
+//   - chunkHash: chunk 哈希（hex 编码）
+//   - offset: chunk 内的起始偏移
+//   - length: 长度，0 表示读取到 chunk 末尾
+//
+// 返回值:
+//   - []byte: 数据（无法用 chunk 哈希校验，由调用方负责）
+//   - error: offset 不小于 chunk 大小时返回 ErrRangeNotSatisfiable；chunk 不存在时对端重置流，返回读取错误
+func (p *P2PService) DownloadChunkRange(ctx context.Context, peerID peer.ID, chunkHash string, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 || offset >= MaxChunkSize {
+		return nil, fmt.Errorf("invalid range: offset=%d length=%d", offset, length)
+	}
+
+	data, err := p.requestChunkFramed(ctx, peerID, chunkHash, offset, length)
+	if !errors.Is(err, errFramedUnsupported) {
+		// 分帧协议对超出 chunk 大小的 offset 返回空数据
+		if err == nil && len(data) == 0 {
+			return nil, fmt.Errorf("%w: offset %d of chunk %s", ErrRangeNotSatisfiable, offset, chunkHash)
+		}
+		return data, err
+	}
+
+	req := RangeRequestMessage{ChunkHash: chunkHash, Offset: offset, Length: length}
+	data, err = p.requestChunkData(ctx, peerID, GetChunkRangeProtocol, req)
+	if err != nil && isProtocolNotSupported(err) {
+		// 对端不支持范围请求，下载完整 chunk 并校验后截取
+		logrus.Debugf("Peer %s does not support %s, falling back to a full chunk download", peerID, GetChunkRangeProtocol)
+		full, err := p.DownloadChunk(ctx, peerID, chunkHash)
+		if err != nil {
+			return nil, err
+		}
+		if hash := sha256.Sum256(full); hex.EncodeToString(hash[:]) != strings.ToLower(chunkHash) {
+			p.ConnManager.RecordFailure(peerID)
+			return nil, fmt.Errorf("chunk %s: hash validation failed", chunkHash)
+		}
+		if offset >= int64(len(full)) {
+			return nil, fmt.Errorf("%w: offset %d of chunk %s", ErrRangeNotSatisfiable, offset, chunkHash)
+		}
+		full = full[offset:]
+		if length > 0 && length < int64(len(full)) {
+			full = full[:length]
+		}
+		return full, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: offset %d of chunk %s", ErrRangeNotSatisfiable, offset, chunkHash)
+	}
+	if length > 0 && int64(len(data)) > length {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, fmt.Errorf("range response too long: %d > %d", len(data), length)
+	}
+	return data, nil
+}
+
+// requestChunkData 发送 chunk 数据请求，并读取响应直到 EOF
+func (p *P2PService) requestChunkData(ctx context.Context, peerID peer.ID, protocolID protocol.ID, req interface{}) ([]byte, error) {
+	return p.requestChunkDataLimit(ctx, peerID, protocolID, req, MaxChunkSize)
+}
+
+// requestChunkDataLimit 同 requestChunkData，响应超过 limit 字节时返回错误
+func (p *P2PService) requestChunkDataLimit(ctx context.Context, peerID peer.ID, protocolID protocol.ID, req interface{}, limit int64) ([]byte, error) {
 	startTime := time.Now()
 
 	// 检查是否允许创建流
+	// 本地流配额已满不是节点故障，不记录失败
 	if !p.ConnManager.AcquireStream(peerID) {
-		p.ConnManager.RecordFailure(peerID)
-		return nil, NewRetryableError(fmt.Errorf("peer %s has too many active streams", peerID))
+		return nil, NewRetryableError(fmt.Errorf("%w: %s", ErrPeerBusy, peerID))
 	}
 	defer p.ConnManager.ReleaseStream(peerID)
 
@@ -140,8 +305,12 @@ func (p *P2PService) DownloadChunk(ctx context.Context, peerID peer.ID, chunkHas
 	clientCtx, cancel := context.WithTimeout(ctx, dataTimeout)
 	defer cancel()
 
-	s, err := p.Host.NewStream(clientCtx, peerID, GetChunkDataProtocol)
+	s, err := p.Host.NewStream(clientCtx, peerID, protocolID)
 	if err != nil {
+		// 协议不支持不算节点故障，由调用方决定是否回退
+		if isProtocolNotSupported(err) {
+			return nil, err
+		}
 		p.ConnManager.RecordFailure(peerID)
 		return nil, NewRetryableError(fmt.Errorf("open stream: %w", err))
 	}
@@ -151,7 +320,6 @@ func (p *P2PService) DownloadChunk(ctx context.Context, peerID peer.ID, chunkHas
 	s.SetReadDeadline(time.Now().Add(dataTimeout))
 	s.SetWriteDeadline(time.Now().Add(dataTimeout))
 
-	req := RequestMessage{ChunkHash: chunkHash}
 	if err := json.NewEncoder(s).Encode(req); err != nil {
 		p.ConnManager.RecordFailure(peerID)
 		return nil, NewRetryableError(fmt.Errorf("encode request: %w", err))
@@ -161,14 +329,15 @@ func (p *P2PService) DownloadChunk(ctx context.Context, peerID peer.ID, chunkHas
 	buffer := make([]byte, 0, ReadBufferSize)
 	chunkBuffer := make([]byte, ReadBufferSize)
 	totalRead := int64(0)
+	rdr := p.Bandwidth.downloadReader(clientCtx, peerID, s)
 
 	for {
-		n, err := s.Read(chunkBuffer)
+		n, err := rdr.Read(chunkBuffer)
 		if n > 0 {
 			totalRead += int64(n)
-			if totalRead > MaxChunkSize {
+			if totalRead > limit {
 				p.ConnManager.RecordFailure(peerID)
-				return nil, fmt.Errorf("chunk size exceeds limit: %d > %d", totalRead, MaxChunkSize)
+				return nil, fmt.Errorf("chunk size exceeds limit: %d > %d", totalRead, limit)
 			}
 			buffer = append(buffer, chunkBuffer[:n]...)
 		}
@@ -182,16 +351,11 @@ func (p *P2PService) DownloadChunk(ctx context.Context, peerID peer.ID, chunkHas
 		}
 	}
 
-	if totalRead == 0 {
-		p.ConnManager.RecordFailure(peerID)
-		return nil, fmt.Errorf("chunk is empty")
-	}
-
 	// 记录成功请求
 	responseTime := time.Since(startTime)
 	p.ConnManager.RecordSuccess(peerID, responseTime)
 
-	logrus.Debugf("Chunk %s downloaded successfully (%d bytes, took %v)", chunkHash, totalRead, responseTime)
+	logrus.Debugf("Chunk data received from %s via %s (%d bytes, took %v)", peerID, protocolID, totalRead, responseTime)
 	return buffer, nil
 }
 
@@ -230,9 +394,8 @@ func (p *P2PService) RegisterChunkExistHandler(ctx context.Context) {
 		}
 
 		chunkHash := strings.TrimSpace(req.ChunkHash)
-		filePath := getChunkPathFromHash(p.Config.ChunkStoragePath, chunkHash)
-		fileInfo, err := os.Stat(filePath)
-		exists := err == nil && fileInfo.Size() <= MaxChunkSize
+		size, err := p.ChunkStore.Size(chunkHash)
+		exists := err == nil && size <= MaxChunkSize
 
 		resp := "false"
 		if exists {
@@ -248,66 +411,130 @@ func (p *P2PService) RegisterChunkExistHandler(ctx context.Context) {
 }
 
 // RegisterChunkDataHandler 处理 chunk 数据传输请求
+// 同时注册 data/1.0.0（完整 chunk）、data/1.1.0（范围请求）、data/1.2.0（Merkle 证明）和 data/1.3.0（压缩协商）
 func (p *P2PService) RegisterChunkDataHandler(ctx context.Context) {
-	p.Host.SetStreamHandler(GetChunkDataProtocol, func(s network.Stream) {
-		defer s.Close()
-		peerID := s.Conn().RemotePeer()
+	for _, protocolID := range []protocol.ID{GetChunkDataProtocol, GetChunkRangeProtocol, GetChunkCompressedProtocol} {
+		p.Host.SetStreamHandler(protocolID, func(s network.Stream) {
+			p.handleChunkDataStream(ctx, s, protocolID)
+		})
+	}
+	p.Host.SetStreamHandler(GetChunkProofProtocol, func(s network.Stream) {
+		p.handleChunkProofStream(ctx, s)
+	})
+}
 
-		// 检查服务是否已关闭
-		select {
-		case <-p.Ctx.Done():
-			logrus.Debug("Service is shutting down, ignoring chunk data request")
-			return
-		default:
-		}
+// handleChunkDataStream 读取请求并发送 chunk 数据
+// data/1.1.0 只发送请求的范围；data/1.3.0 按请求方可接受的算法压缩，数据前附带 1 字节算法
+func (p *P2PService) handleChunkDataStream(ctx context.Context, s network.Stream, protocolID protocol.ID) {
+	defer s.Close()
+	peerID := s.Conn().RemotePeer()
+	ranged := protocolID == GetChunkRangeProtocol
+	compressed := protocolID == GetChunkCompressedProtocol
+
+	// 检查服务是否已关闭
+	select {
+	case <-p.Ctx.Done():
+		logrus.Debug("Service is shutting down, ignoring chunk data request")
+		return
+	default:
+	}
 
-		// 从配置获取超时时间，使用默认值作为回退
-		requestTimeout := DefaultRequestTimeout
-		dataTimeout := DefaultDataTimeout
-		if p.Config.RequestTimeout > 0 {
-			requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
-		}
-		if p.Config.DataTimeout > 0 {
-			dataTimeout = time.Duration(p.Config.DataTimeout) * time.Second
+	// 从配置获取超时时间，使用默认值作为回退
+	requestTimeout := DefaultRequestTimeout
+	dataTimeout := DefaultDataTimeout
+	if p.Config.RequestTimeout > 0 {
+		requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
+	}
+	if p.Config.DataTimeout > 0 {
+		dataTimeout = time.Duration(p.Config.DataTimeout) * time.Second
+	}
+
+	// 设置读取请求超时
+	s.SetReadDeadline(time.Now().Add(requestTimeout))
+
+	if p.AntiLeecher.Refuse(ctx, peerID) {
+		logrus.Warnf("Refused chunk data request from peer %s (leecher)", peerID)
+		return
+	}
+	// data/1.0.0 的请求体是 RangeRequestMessage 的子集，data/1.3.0 另带 accept
+	var req struct {
+		RangeRequestMessage
+		Accept []string `json:"accept,omitempty"`
+	}
+	if err := json.NewDecoder(s).Decode(&req); err != nil {
+		logrus.Errorf("Invalid data request from %s: %v", peerID, err)
+		return
+	}
+	if !ranged {
+		req.Offset, req.Length = 0, 0
+	}
+	// 范围请求和压缩请求的错误重置流，对端不会把空响应当作数据
+	fail := func() {
+		if ranged || compressed {
+			s.Reset()
 		}
+	}
+	if req.Offset < 0 || req.Length < 0 {
+		logrus.Warnf("Invalid range offset=%d length=%d requested by %s", req.Offset, req.Length, peerID)
+		fail()
+		return
+	}
+	req.ChunkHash = strings.ToLower(strings.TrimSpace(req.ChunkHash))
+	if !isChunkHashHex(req.ChunkHash) {
+		logrus.Warnf("Invalid chunk hash %q requested by %s", req.ChunkHash, peerID)
+		fail()
+		return
+	}
 
-		// 设置读取请求超时
-		s.SetReadDeadline(time.Now().Add(requestTimeout))
+	data, err := p.readLocalChunk(req.ChunkHash)
+	if err != nil {
+		logrus.Warnf("Chunk %s not available, requested by %s: %v", req.ChunkHash, peerID, err)
+		fail()
+		return
+	}
 
-		if p.AntiLeecher.Refuse(ctx, peerID) {
-			logrus.Warnf("Refused chunk data request from peer %s (leecher)", peerID)
+	if ranged {
+		// 起始偏移超出 chunk 大小时没有可发送的数据
+		if req.Offset >= int64(len(data)) {
+			logrus.Warnf("Range offset %d beyond chunk %s (%d bytes) requested by %s", req.Offset, req.ChunkHash, len(data), peerID)
+			fail()
 			return
 		}
-		var req RequestMessage
-		if err := json.NewDecoder(s).Decode(&req); err != nil {
-			logrus.Errorf("Invalid data request from %s: %v", peerID, err)
-			return
+		data = data[req.Offset:]
+		if req.Length > 0 && req.Length < int64(len(data)) {
+			data = data[:req.Length]
 		}
+	}
 
-		filePath := getChunkPathFromHash(p.Config.ChunkStoragePath, req.ChunkHash)
-		f, err := os.Open(filePath)
-		if err != nil {
-			logrus.Warnf("Chunk %s not found, requested by %s", req.ChunkHash, peerID)
-			return
-		}
-		defer f.Close()
+	codec, wire := CodecNone, data
+	if compressed {
+		codec, wire = p.compressor.encode(req.ChunkHash, data, acceptMaskOf(req.Accept))
+	}
 
-		// 为数据传输设置更长的超时时间
-		s.SetWriteDeadline(time.Now().Add(dataTimeout))
+	// 为数据传输设置更长的超时时间
+	s.SetWriteDeadline(time.Now().Add(dataTimeout))
 
-		// 使用 buffered writer 优化性能
-		bufferedWriter := bufio.NewWriterSize(s, ReadBufferSize)
-		written, err := io.Copy(bufferedWriter, f)
-		if err != nil {
-			logrus.Errorf("Send chunk %s to %s failed: %v", req.ChunkHash, peerID, err)
-			return
-		}
-		// 确保所有数据都刷新到网络
-		if err := bufferedWriter.Flush(); err != nil {
-			logrus.Errorf("Flush chunk data to %s failed: %v", peerID, err)
-			return
-		}
+	// 使用 buffered writer 优化性能
+	bufferedWriter := bufio.NewWriterSize(p.Bandwidth.uploadWriter(p.Ctx, peerID, s), ReadBufferSize)
+	if compressed {
+		bufferedWriter.WriteByte(byte(codec))
+	}
+	written, err := bufferedWriter.Write(wire)
+	if err != nil {
+		logrus.Errorf("Send chunk %s to %s failed: %v", req.ChunkHash, peerID, err)
+		return
+	}
+	// 确保所有数据都刷新到网络
+	if err := bufferedWriter.Flush(); err != nil {
+		logrus.Errorf("Flush chunk data to %s failed: %v", peerID, err)
+		return
+	}
 
+	if ranged {
+		logrus.Infof("Chunk %s range [%d, +%d) sent successfully to peer %s", req.ChunkHash, req.Offset, written, peerID)
+	} else if codec != CodecNone {
+		logrus.Infof("Chunk %s (%d bytes, %s %d bytes) sent successfully to peer %s", req.ChunkHash, len(data), codec, written, peerID)
+	} else {
 		logrus.Infof("Chunk %s (%d bytes) sent successfully to peer %s", req.ChunkHash, written, peerID)
-	})
+	}
 }
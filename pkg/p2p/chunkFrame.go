@@ -0,0 +1,688 @@
+// Package p2p 提供分帧的 Chunk 传输协议
+//
+// ChunkFrame 功能:
+//   - 长度前缀分帧: 每个帧带类型、请求 ID 和负载长度，不再依赖 "读到 EOF" 判断结束
+//   - 流水线: 同一个流上可以同时有多个未完成的 Chunk 请求，响应按完成顺序返回
+//   - 状态码: 响应携带 OK、NOT_FOUND、REFUSED、BUSY、TOO_LARGE 等状态，缺失与拒绝不再和空响应混淆
+//   - 取消: 客户端可以取消进行中的请求（endgame 中落后的请求、超时的请求），服务端不再发送数据
+//   - 会话复用: 客户端对每个节点保持一个流（会话），空闲一段时间后关闭
+//   - 压缩协商: 2.1.0 版本的请求携带可接受的压缩算法，响应携带实际使用的算法（见 compression.go）
+//
+// 协议定义:
+//   - /p2pFileTransfer/chunk/2.0.0: 原始数据
+//   - /p2pFileTransfer/chunk/2.1.0: 支持压缩协商
+//
+// 帧格式（大端序）:
+//   - 帧头: 类型(1) + 请求 ID(4) + 负载长度(4)
+//   - REQUEST(0x01): offset(8) + length(8) + [accept(1)] + chunk 哈希（原始字节）；length 为 0 表示到 chunk 末尾
+//   - CANCEL(0x02): 无负载
+//   - RESPONSE(0x81): 状态(1) + [算法(1)] + 数据
+//   - 方括号中的字段只在 2.1.0 中存在；accept 是可接受算法的位掩码（1 << Codec）
+//
+// 兼容性:
+//   - getChunk/exists、getChunk/data 1.0.0/1.1.0 协议保持注册
+//   - DownloadChunk 优先使用本协议，对端不支持时回退到旧协议
+//   - 客户端打开流时优先协商 2.1.0，对端只支持 2.0.0 时不压缩
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chunkStore"
+)
+
+const (
+	ChunkFrameProtocol           = "/p2pFileTransfer/chunk/2.0.0"
+	ChunkFrameCompressedProtocol = "/p2pFileTransfer/chunk/2.1.0"
+
+	// MaxFramedInflight 每个流最多同时进行的请求数，服务端超过时返回 BUSY
+	MaxFramedInflight = 16
+
+	frameHeaderSize        = 9
+	maxFrameRequestPayload = 16 + 1 + 64 // offset + length + accept + 哈希
+	maxChunkHashSize       = 64
+
+	// 客户端会话空闲超过此时间后关闭；服务端的空闲超时更长，由客户端先关闭
+	framedSessionIdleTimeout = 30 * time.Second
+	framedStreamIdleTimeout  = 2 * framedSessionIdleTimeout
+
+	// 对端不支持本协议时，在此时间内直接使用旧协议
+	framedUnsupportedTTL = 10 * time.Minute
+)
+
+// 帧类型
+const (
+	frameRequest  byte = 0x01
+	frameCancel   byte = 0x02
+	frameResponse byte = 0x81
+)
+
+// ChunkStatus 分帧协议的响应状态码
+type ChunkStatus byte
+
+const (
+	ChunkStatusOK         ChunkStatus = 0
+	ChunkStatusNotFound   ChunkStatus = 1
+	ChunkStatusRefused    ChunkStatus = 2
+	ChunkStatusBusy       ChunkStatus = 3
+	ChunkStatusTooLarge   ChunkStatus = 4
+	ChunkStatusBadRequest ChunkStatus = 5
+)
+
+func (s ChunkStatus) String() string {
+	switch s {
+	case ChunkStatusOK:
+		return "OK"
+	case ChunkStatusNotFound:
+		return "NOT_FOUND"
+	case ChunkStatusRefused:
+		return "REFUSED"
+	case ChunkStatusBusy:
+		return "BUSY"
+	case ChunkStatusTooLarge:
+		return "TOO_LARGE"
+	case ChunkStatusBadRequest:
+		return "BAD_REQUEST"
+	default:
+		return fmt.Sprintf("STATUS(%d)", byte(s))
+	}
+}
+
+var (
+	// ErrChunkNotFound 对端没有请求的 chunk
+	ErrChunkNotFound = errors.New("chunk not found")
+
+	// ErrChunkRefused 对端拒绝提供数据（如判定为吸血节点）
+	ErrChunkRefused = errors.New("chunk request refused")
+
+	// ErrChunkTooLarge chunk 超过 MaxChunkSize
+	ErrChunkTooLarge = errors.New("chunk too large")
+
+	// errFramedUnsupported 对端不支持分帧协议，调用方应回退到旧协议
+	errFramedUnsupported = errors.New("framed chunk protocol not supported")
+
+	// errSessionClosed 会话在发送请求前已关闭（如空闲超时），可以立即用新会话重试
+	errSessionClosed = errors.New("chunk session closed")
+)
+
+// -----------------------------
+// 帧编解码
+// -----------------------------
+
+// writeFrame 写入一个帧，负载由 payload 各部分拼接而成
+func writeFrame(w io.Writer, typ byte, id uint32, payload ...[]byte) error {
+	var size int
+	for _, part := range payload {
+		size += len(part)
+	}
+	header := make([]byte, frameHeaderSize)
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:5], id)
+	binary.BigEndian.PutUint32(header[5:9], uint32(size))
+
+	bufs := append([][]byte{header}, payload...)
+	for _, buf := range bufs {
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFrame 读取一个帧，负载超过 maxPayload 时返回错误
+func readFrame(r io.Reader, maxPayload uint32) (typ byte, id uint32, payload []byte, err error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	typ = header[0]
+	id = binary.BigEndian.Uint32(header[1:5])
+	size := binary.BigEndian.Uint32(header[5:9])
+	if size > maxPayload {
+		return 0, 0, nil, fmt.Errorf("frame payload too large: %d > %d", size, maxPayload)
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return typ, id, payload, nil
+}
+
+// frameRequestPayload 编码 REQUEST 负载，compressed 为 true 时使用 2.1.0 格式（带 accept 掩码）
+func frameRequestPayload(hash []byte, offset, length int64, compressed bool, accept byte) []byte {
+	prefix := 16
+	if compressed {
+		prefix++
+	}
+	payload := make([]byte, prefix+len(hash))
+	binary.BigEndian.PutUint64(payload[0:8], uint64(offset))
+	binary.BigEndian.PutUint64(payload[8:16], uint64(length))
+	if compressed {
+		payload[16] = accept
+	}
+	copy(payload[prefix:], hash)
+	return payload
+}
+
+// parseFrameRequest 解码 REQUEST 负载
+func parseFrameRequest(payload []byte, compressed bool) (hash []byte, offset, length int64, accept byte, ok bool) {
+	prefix := 16
+	if compressed {
+		prefix++
+	}
+	if len(payload) <= prefix || len(payload)-prefix > maxChunkHashSize {
+		return nil, 0, 0, 0, false
+	}
+	offset = int64(binary.BigEndian.Uint64(payload[0:8]))
+	length = int64(binary.BigEndian.Uint64(payload[8:16]))
+	if offset < 0 || length < 0 {
+		return nil, 0, 0, 0, false
+	}
+	if compressed {
+		accept = payload[16]
+	}
+	return payload[prefix:], offset, length, accept, true
+}
+
+// -----------------------------
+// 服务端
+// -----------------------------
+
+// RegisterChunkFrameHandler 注册分帧 chunk 传输协议处理器（2.0.0 和支持压缩的 2.1.0）
+func (p *P2PService) RegisterChunkFrameHandler(ctx context.Context) {
+	p.Host.SetStreamHandler(ChunkFrameProtocol, func(s network.Stream) {
+		p.handleChunkFrameStream(ctx, s, false)
+	})
+	p.Host.SetStreamHandler(ChunkFrameCompressedProtocol, func(s network.Stream) {
+		p.handleChunkFrameStream(ctx, s, true)
+	})
+}
+
+// handleChunkFrameStream 循环读取请求帧，每个请求在独立 goroutine 中处理，响应按完成顺序写回
+// compressed 为 true 时按 2.1.0 格式收发帧，并按请求方的 accept 掩码压缩数据
+func (p *P2PService) handleChunkFrameStream(ctx context.Context, s network.Stream, compressed bool) {
+	peerID := s.Conn().RemotePeer()
+
+	dataTimeout := DefaultDataTimeout
+	if p.Config.DataTimeout > 0 {
+		dataTimeout = time.Duration(p.Config.DataTimeout) * time.Second
+	}
+
+	streamCtx, cancel := context.WithCancel(p.Ctx)
+	var (
+		writeMu  sync.Mutex
+		mu       sync.Mutex
+		inflight = make(map[uint32]context.CancelFunc)
+		wg       sync.WaitGroup
+	)
+	defer func() {
+		cancel()
+		wg.Wait()
+		s.Close()
+	}()
+
+	out := p.Bandwidth.uploadWriter(streamCtx, peerID, s)
+	respond := func(id uint32, status ChunkStatus, codec Codec, data []byte) {
+		prefix := []byte{byte(status)}
+		if compressed {
+			prefix = append(prefix, byte(codec))
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		s.SetWriteDeadline(time.Now().Add(dataTimeout))
+		if err := writeFrame(out, frameResponse, id, prefix, data); err != nil {
+			logrus.Debugf("Write chunk response to %s failed: %v", peerID, err)
+			s.Reset()
+		}
+	}
+
+	rdr := bufio.NewReader(s)
+	for {
+		s.SetReadDeadline(time.Now().Add(framedStreamIdleTimeout))
+		typ, id, payload, err := readFrame(rdr, maxFrameRequestPayload)
+		if err != nil {
+			if err != io.EOF {
+				logrus.Debugf("Chunk frame stream from %s closed: %v", peerID, err)
+			}
+			return
+		}
+
+		switch typ {
+		case frameCancel:
+			// 取消的请求立即让出进行中名额，即使它的读取尚未结束
+			mu.Lock()
+			if cancelReq, ok := inflight[id]; ok {
+				cancelReq()
+				delete(inflight, id)
+			}
+			mu.Unlock()
+
+		case frameRequest:
+			hash, offset, length, accept, ok := parseFrameRequest(payload, compressed)
+			if !ok {
+				respond(id, ChunkStatusBadRequest, CodecNone, nil)
+				continue
+			}
+			if p.AntiLeecher.Refuse(ctx, peerID) {
+				logrus.Warnf("Refused chunk request from peer %s (leecher)", peerID)
+				respond(id, ChunkStatusRefused, CodecNone, nil)
+				continue
+			}
+
+			mu.Lock()
+			if _, dup := inflight[id]; dup {
+				mu.Unlock()
+				respond(id, ChunkStatusBadRequest, CodecNone, nil)
+				continue
+			}
+			if len(inflight) >= MaxFramedInflight {
+				mu.Unlock()
+				respond(id, ChunkStatusBusy, CodecNone, nil)
+				continue
+			}
+			reqCtx, reqCancel := context.WithCancel(streamCtx)
+			inflight[id] = reqCancel
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer reqCancel()
+
+				hashHex := hex.EncodeToString(hash)
+				status, data := p.readChunkForFrame(hashHex, offset, length)
+				codec, wire := CodecNone, data
+				if compressed && status == ChunkStatusOK {
+					// 只缓存完整 chunk 的压缩结果
+					key := ""
+					if offset == 0 && length == 0 {
+						key = hashHex
+					}
+					codec, wire = p.compressor.encode(key, data, accept)
+				}
+
+				// 先移出进行中集合再响应，客户端收到响应后立即发出的新请求不会被误判为 BUSY
+				// 已取消的请求在收到 CANCEL 时已移出，该 ID 可能已被新请求复用，不能再删除
+				mu.Lock()
+				if reqCtx.Err() == nil {
+					delete(inflight, id)
+				}
+				mu.Unlock()
+				if reqCtx.Err() != nil {
+					// 请求已取消，不再发送数据
+					logrus.Debugf("Chunk request %d for %s canceled by %s", id, hashHex, peerID)
+					return
+				}
+				respond(id, status, codec, wire)
+				if status == ChunkStatusOK {
+					if codec != CodecNone {
+						logrus.Infof("Chunk %s (%d bytes, %s %d bytes) sent successfully to peer %s", hashHex, len(data), codec, len(wire), peerID)
+					} else {
+						logrus.Infof("Chunk %s (%d bytes) sent successfully to peer %s", hashHex, len(data), peerID)
+					}
+				}
+			}()
+
+		default:
+			logrus.Warnf("Unknown chunk frame type 0x%02x from %s", typ, peerID)
+			return
+		}
+	}
+}
+
+// readChunkForFrame 读取本地 chunk 的 [offset, offset+length) 部分
+func (p *P2PService) readChunkForFrame(hashHex string, offset, length int64) (ChunkStatus, []byte) {
+	size, err := p.ChunkStore.Size(hashHex)
+	if err != nil {
+		return ChunkStatusNotFound, nil
+	}
+	if size > MaxChunkSize {
+		return ChunkStatusTooLarge, nil
+	}
+	if offset >= size {
+		return ChunkStatusOK, nil
+	}
+
+	data, err := p.ChunkStore.Get(hashHex)
+	if err != nil {
+		if !errors.Is(err, chunkStore.ErrNotFound) {
+			logrus.Errorf("Read chunk %s failed: %v", hashHex, err)
+		}
+		return ChunkStatusNotFound, nil
+	}
+	if offset >= int64(len(data)) {
+		return ChunkStatusOK, nil
+	}
+	data = data[offset:]
+	if length > 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return ChunkStatusOK, data
+}
+
+// -----------------------------
+// 客户端
+// -----------------------------
+
+// frameResult 一个请求的响应
+type frameResult struct {
+	status ChunkStatus
+	codec  Codec
+	data   []byte
+}
+
+// chunkSession 与一个节点之间的分帧协议流，多个请求共享
+type chunkSession struct {
+	pool       *chunkSessionPool
+	peerID     peer.ID
+	s          network.Stream
+	compressed bool   // 协商到 2.1.0，请求和响应带压缩字段
+	release    func() // 归还会话占用的流配额，在 shutdown 中调用一次
+	writeMu    sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan frameResult
+	closed  bool
+	err     error
+	idle    *time.Timer
+}
+
+// chunkSessionPool 每个节点一个会话
+type chunkSessionPool struct {
+	mu          sync.Mutex
+	sessions    map[peer.ID]*chunkSession
+	unsupported map[peer.ID]time.Time // 不支持分帧协议的节点及记录时间
+}
+
+func newChunkSessionPool() *chunkSessionPool {
+	return &chunkSessionPool{
+		sessions:    make(map[peer.ID]*chunkSession),
+		unsupported: make(map[peer.ID]time.Time),
+	}
+}
+
+// chunkSession 获取或创建到 peerID 的会话
+func (p *P2PService) chunkSession(ctx context.Context, peerID peer.ID) (*chunkSession, error) {
+	pool := p.chunkSessions
+	pool.mu.Lock()
+	if t, ok := pool.unsupported[peerID]; ok {
+		if time.Since(t) < framedUnsupportedTTL {
+			pool.mu.Unlock()
+			return nil, errFramedUnsupported
+		}
+		delete(pool.unsupported, peerID)
+	}
+	if sess, ok := pool.sessions[peerID]; ok {
+		pool.mu.Unlock()
+		return sess, nil
+	}
+	pool.mu.Unlock()
+
+	// 会话占用一个流配额，直到关闭
+	if !p.ConnManager.AcquireStream(peerID) {
+		return nil, NewRetryableError(fmt.Errorf("%w: %s", ErrPeerBusy, peerID))
+	}
+
+	requestTimeout := DefaultRequestTimeout
+	if p.Config.RequestTimeout > 0 {
+		requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
+	}
+	openCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	s, err := p.Host.NewStream(openCtx, peerID, ChunkFrameCompressedProtocol, ChunkFrameProtocol)
+	cancel()
+	if err != nil {
+		p.ConnManager.ReleaseStream(peerID)
+		if isProtocolNotSupported(err) {
+			pool.mu.Lock()
+			pool.unsupported[peerID] = time.Now()
+			pool.mu.Unlock()
+			return nil, errFramedUnsupported
+		}
+		p.ConnManager.RecordFailure(peerID)
+		return nil, NewRetryableError(fmt.Errorf("open stream: %w", err))
+	}
+
+	sess := &chunkSession{
+		pool:       pool,
+		peerID:     peerID,
+		s:          s,
+		compressed: s.Protocol() == ChunkFrameCompressedProtocol,
+		release:    func() { p.ConnManager.ReleaseStream(peerID) },
+		pending:    make(map[uint32]chan frameResult),
+	}
+	sess.idle = time.AfterFunc(framedSessionIdleTimeout, sess.closeIfIdle)
+
+	pool.mu.Lock()
+	if existing, ok := pool.sessions[peerID]; ok {
+		// 并发创建，使用先创建的会话
+		pool.mu.Unlock()
+		sess.idle.Stop()
+		s.Close()
+		p.ConnManager.ReleaseStream(peerID)
+		return existing, nil
+	}
+	pool.sessions[peerID] = sess
+	pool.mu.Unlock()
+
+	go sess.readLoop(p.Bandwidth.downloadReader(p.Ctx, peerID, s))
+	return sess, nil
+}
+
+// request 发送一个请求并等待响应，ctx 取消时向对端发送 CANCEL
+// accept 为可接受的压缩算法位掩码，会话未协商到 2.1.0 时忽略
+func (sess *chunkSession) request(ctx context.Context, hash []byte, offset, length int64, accept byte) (frameResult, error) {
+	sess.mu.Lock()
+	if sess.closed {
+		sess.mu.Unlock()
+		return frameResult{}, errSessionClosed
+	}
+	if len(sess.pending) >= MaxFramedInflight {
+		sess.mu.Unlock()
+		return frameResult{}, NewRetryableError(fmt.Errorf("%w: %s", ErrPeerBusy, sess.peerID))
+	}
+	id := sess.nextID
+	sess.nextID++
+	ch := make(chan frameResult, 1)
+	sess.pending[id] = ch
+	sess.idle.Stop()
+	sess.mu.Unlock()
+
+	if err := sess.write(frameRequest, id, frameRequestPayload(hash, offset, length, sess.compressed, accept)); err != nil {
+		sess.fail(err)
+		return frameResult{}, NewRetryableError(fmt.Errorf("send request: %w", err))
+	}
+
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			return frameResult{}, NewRetryableError(fmt.Errorf("chunk session closed: %w", sess.closedErr()))
+		}
+		return res, nil
+	case <-ctx.Done():
+		sess.mu.Lock()
+		_, stillPending := sess.pending[id]
+		delete(sess.pending, id)
+		sess.resetIdleLocked()
+		sess.mu.Unlock()
+		if stillPending {
+			// 尽力通知对端停止处理，失败时会话由读循环关闭
+			sess.write(frameCancel, id)
+		}
+		return frameResult{}, ctx.Err()
+	}
+}
+
+func (sess *chunkSession) write(typ byte, id uint32, payload ...[]byte) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	sess.s.SetWriteDeadline(time.Now().Add(DefaultRequestTimeout))
+	return writeFrame(sess.s, typ, id, payload...)
+}
+
+// readLoop 从 r（经过下载限速的流）读取响应帧并分发给等待的请求；已取消的请求的响应被丢弃
+func (sess *chunkSession) readLoop(r io.Reader) {
+	rdr := bufio.NewReader(r)
+	for {
+		typ, id, payload, err := readFrame(rdr, MaxChunkSize+2)
+		if err != nil {
+			sess.fail(err)
+			return
+		}
+		prefix := 1
+		if sess.compressed {
+			prefix++
+		}
+		if typ != frameResponse || len(payload) < prefix {
+			sess.fail(fmt.Errorf("unexpected frame type 0x%02x", typ))
+			return
+		}
+		res := frameResult{status: ChunkStatus(payload[0]), data: payload[prefix:]}
+		if sess.compressed {
+			res.codec = Codec(payload[1])
+		}
+
+		sess.mu.Lock()
+		ch, ok := sess.pending[id]
+		delete(sess.pending, id)
+		sess.resetIdleLocked()
+		sess.mu.Unlock()
+		if ok {
+			ch <- res
+		}
+	}
+}
+
+// resetIdleLocked 没有未完成的请求时重新开始空闲计时（调用方持有 sess.mu）
+func (sess *chunkSession) resetIdleLocked() {
+	if len(sess.pending) == 0 && !sess.closed {
+		sess.idle.Reset(framedSessionIdleTimeout)
+	}
+}
+
+// closeIfIdle 空闲超时后关闭会话
+func (sess *chunkSession) closeIfIdle() {
+	sess.mu.Lock()
+	idle := len(sess.pending) == 0
+	sess.mu.Unlock()
+	if idle {
+		sess.shutdown(io.EOF, false)
+	}
+}
+
+// fail 流出错时关闭会话，所有等待中的请求返回错误
+func (sess *chunkSession) fail(err error) {
+	sess.shutdown(err, true)
+}
+
+func (sess *chunkSession) shutdown(err error, reset bool) {
+	sess.mu.Lock()
+	if sess.closed {
+		sess.mu.Unlock()
+		return
+	}
+	sess.closed = true
+	sess.err = err
+	for id, ch := range sess.pending {
+		close(ch)
+		delete(sess.pending, id)
+	}
+	sess.idle.Stop()
+	sess.mu.Unlock()
+
+	pool := sess.pool
+	pool.mu.Lock()
+	if pool.sessions[sess.peerID] == sess {
+		delete(pool.sessions, sess.peerID)
+	}
+	pool.mu.Unlock()
+
+	if reset {
+		sess.s.Reset()
+	} else {
+		sess.s.Close()
+	}
+	sess.release()
+}
+
+func (sess *chunkSession) closedErr() error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.err
+}
+
+// requestChunkFramed 通过分帧协议请求 chunk 的 [offset, offset+length) 部分
+// 对端不支持分帧协议时返回 errFramedUnsupported
+func (p *P2PService) requestChunkFramed(ctx context.Context, peerID peer.ID, chunkHash string, offset, length int64) ([]byte, error) {
+	hash, err := hex.DecodeString(chunkHash)
+	if err != nil || len(hash) == 0 || len(hash) > maxChunkHashSize {
+		return nil, fmt.Errorf("invalid chunk hash: %s", chunkHash)
+	}
+
+	dataTimeout := DefaultDataTimeout
+	if p.Config.DataTimeout > 0 {
+		dataTimeout = time.Duration(p.Config.DataTimeout) * time.Second
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, dataTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	var res frameResult
+	for {
+		var sess *chunkSession
+		sess, err = p.chunkSession(reqCtx, peerID)
+		if err != nil {
+			return nil, err
+		}
+		res, err = sess.request(reqCtx, hash, offset, length, p.compressor.acceptMask())
+		if errors.Is(err, errSessionClosed) {
+			continue
+		}
+		break
+	}
+	if err != nil {
+		if ctx.Err() == nil && reqCtx.Err() != nil {
+			// 请求超时（而非调用方取消）
+			p.ConnManager.RecordFailure(peerID)
+			return nil, NewRetryableError(fmt.Errorf("chunk request timed out: %w", err))
+		}
+		return nil, err
+	}
+
+	switch res.status {
+	case ChunkStatusOK:
+		data, err := p.compressor.decode(res.codec, res.data)
+		if err != nil {
+			p.ConnManager.RecordFailure(peerID)
+			return nil, fmt.Errorf("decompress chunk %s from %s: %w", chunkHash, peerID, err)
+		}
+		responseTime := time.Since(startTime)
+		p.ConnManager.RecordSuccess(peerID, responseTime)
+		logrus.Debugf("Chunk data received from %s via %s (%d bytes, %s %d bytes, took %v)",
+			peerID, ChunkFrameProtocol, len(data), res.codec, len(res.data), responseTime)
+		return data, nil
+	case ChunkStatusBusy:
+		return nil, NewRetryableError(fmt.Errorf("%w: %s", ErrPeerBusy, peerID))
+	case ChunkStatusNotFound:
+		p.ConnManager.RecordFailure(peerID)
+		return nil, fmt.Errorf("%w: %s on %s", ErrChunkNotFound, chunkHash, peerID)
+	case ChunkStatusRefused:
+		return nil, fmt.Errorf("%w by %s", ErrChunkRefused, peerID)
+	case ChunkStatusTooLarge:
+		p.ConnManager.RecordFailure(peerID)
+		return nil, fmt.Errorf("%w: %s on %s", ErrChunkTooLarge, chunkHash, peerID)
+	default:
+		return nil, fmt.Errorf("chunk request to %s failed: %s", peerID, res.status)
+	}
+}
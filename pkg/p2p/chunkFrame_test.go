@@ -0,0 +1,369 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"p2pFileTransfer/pkg/chunkStore"
+)
+
+// idleSession 模拟空闲超时关闭 a 到 b 的分帧会话
+func idleSession(t *testing.T, a, b *P2PService) {
+	t.Helper()
+	a.chunkSessions.mu.Lock()
+	sess, ok := a.chunkSessions.sessions[b.Host.ID()]
+	a.chunkSessions.mu.Unlock()
+	if !ok {
+		t.Fatal("no chunk session to peer")
+	}
+	sess.closeIfIdle()
+}
+
+func TestChunkSessionReleasesStreamSlot(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	connectServices(t, a, b)
+
+	data := []byte("framed chunk data")
+	chunkHash := putTestChunk(t, b, data)
+
+	// 每次下载打开一个会话，随后空闲关闭；次数超过每个节点的流配额
+	for i := 0; i < a.ConnManager.maxStreams+2; i++ {
+		got, err := a.DownloadChunk(context.Background(), b.Host.ID(), chunkHash)
+		if err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("download %d: got %q", i, got)
+		}
+		idleSession(t, a, b)
+	}
+
+	if !a.ConnManager.AcquireStream(b.Host.ID()) {
+		t.Fatal("stream slots leaked by closed chunk sessions")
+	}
+	a.ConnManager.ReleaseStream(b.Host.ID())
+}
+
+// refuseAllLeecher 拒绝所有节点的反吸血策略
+type refuseAllLeecher struct{}
+
+func (refuseAllLeecher) Refuse(ctx context.Context, peerID peer.ID) bool { return true }
+
+// gatedStore 读取指定 chunk 时阻塞，直到 release 关闭；每次进入阻塞向 entered 发送一次
+type gatedStore struct {
+	chunkStore.ChunkStore
+	hash    string
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newGatedStore(inner chunkStore.ChunkStore, hash string) *gatedStore {
+	return &gatedStore{
+		ChunkStore: inner,
+		hash:       hash,
+		entered:    make(chan struct{}, 4*MaxFramedInflight),
+		release:    make(chan struct{}),
+	}
+}
+
+func (g *gatedStore) Get(hash string) ([]byte, error) {
+	if hash == g.hash {
+		g.entered <- struct{}{}
+		<-g.release
+	}
+	return g.ChunkStore.Get(hash)
+}
+
+// waitEntered 等待 n 个请求阻塞在 gatedStore 中
+func (g *gatedStore) waitEntered(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-g.entered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d requests reached the store", i, n)
+		}
+	}
+}
+
+// rawFrameStream 直接打开 a 到 b 的 2.0.0 分帧流，用于逐帧控制请求
+type rawFrameStream struct {
+	t   *testing.T
+	s   network.Stream
+	rdr *bufio.Reader
+}
+
+func openRawFrameStream(t *testing.T, a, b *P2PService) *rawFrameStream {
+	t.Helper()
+	s, err := a.Host.NewStream(context.Background(), b.Host.ID(), ChunkFrameProtocol)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	t.Cleanup(func() { s.Reset() })
+	return &rawFrameStream{t: t, s: s, rdr: bufio.NewReader(s)}
+}
+
+func (r *rawFrameStream) request(id uint32, chunkHash string) {
+	r.t.Helper()
+	hash, err := hex.DecodeString(chunkHash)
+	if err != nil {
+		r.t.Fatalf("decode hash: %v", err)
+	}
+	if err := writeFrame(r.s, frameRequest, id, frameRequestPayload(hash, 0, 0, false, 0)); err != nil {
+		r.t.Fatalf("write request %d: %v", id, err)
+	}
+}
+
+func (r *rawFrameStream) cancel(id uint32) {
+	r.t.Helper()
+	if err := writeFrame(r.s, frameCancel, id); err != nil {
+		r.t.Fatalf("write cancel %d: %v", id, err)
+	}
+}
+
+// response 读取下一个响应帧
+func (r *rawFrameStream) response() (uint32, ChunkStatus, []byte) {
+	r.t.Helper()
+	r.s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, id, payload, err := readFrame(r.rdr, MaxChunkSize+1)
+	if err != nil {
+		r.t.Fatalf("read response: %v", err)
+	}
+	if typ != frameResponse || len(payload) < 1 {
+		r.t.Fatalf("unexpected frame 0x%02x (%d bytes)", typ, len(payload))
+	}
+	return id, ChunkStatus(payload[0]), payload[1:]
+}
+
+func TestChunkFrameStatusErrors(t *testing.T) {
+	missing := sha256.Sum256([]byte("missing chunk"))
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, b *P2PService) string
+		want  error
+		retry bool
+	}{
+		{
+			name: "not found",
+			setup: func(t *testing.T, b *P2PService) string {
+				return hex.EncodeToString(missing[:])
+			},
+			want: ErrChunkNotFound,
+		},
+		{
+			name: "refused leecher",
+			setup: func(t *testing.T, b *P2PService) string {
+				b.AntiLeecher = refuseAllLeecher{}
+				return putTestChunk(t, b, []byte("refused chunk"))
+			},
+			want: ErrChunkRefused,
+		},
+		{
+			name: "busy",
+			setup: func(t *testing.T, b *P2PService) string {
+				// 服务端对每个请求都回复 BUSY（如进行中的请求已满）
+				busy := func(s network.Stream) {
+					defer s.Close()
+					rdr := bufio.NewReader(s)
+					for {
+						_, id, _, err := readFrame(rdr, maxFrameRequestPayload)
+						if err != nil {
+							return
+						}
+						writeFrame(s, frameResponse, id, []byte{byte(ChunkStatusBusy)})
+					}
+				}
+				b.Host.RemoveStreamHandler(ChunkFrameCompressedProtocol)
+				b.Host.SetStreamHandler(ChunkFrameProtocol, busy)
+				return putTestChunk(t, b, []byte("busy chunk"))
+			},
+			want:  ErrPeerBusy,
+			retry: true,
+		},
+		{
+			name: "too large",
+			setup: func(t *testing.T, b *P2PService) string {
+				return putTestChunk(t, b, make([]byte, MaxChunkSize+1))
+			},
+			want: ErrChunkTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := newTestService(t), newTestService(t)
+			chunkHash := tt.setup(t, b)
+			connectServices(t, a, b)
+
+			_, err := a.requestChunkFramed(context.Background(), b.Host.ID(), chunkHash, 0, 0)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			var retryable *RetryableError
+			if errors.As(err, &retryable) != tt.retry {
+				t.Fatalf("retryable = %v, want %v (err %v)", !tt.retry, tt.retry, err)
+			}
+		})
+	}
+}
+
+func TestChunkSessionPipelinesOutOfOrder(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	slow := putTestChunk(t, b, []byte("slow chunk"))
+	gate := newGatedStore(b.ChunkStore, slow)
+	b.ChunkStore = gate
+	fast := [][]byte{[]byte("fast chunk 1"), []byte("fast chunk 2"), []byte("fast chunk 3")}
+	var fastHashes []string
+	for _, data := range fast {
+		fastHashes = append(fastHashes, putTestChunk(t, b, data))
+	}
+	connectServices(t, a, b)
+
+	slowDone := make(chan error, 1)
+	go func() {
+		data, err := a.requestChunkFramed(context.Background(), b.Host.ID(), slow, 0, 0)
+		if err == nil && string(data) != "slow chunk" {
+			err = errors.New("wrong slow chunk data")
+		}
+		slowDone <- err
+	}()
+	gate.waitEntered(t, 1)
+
+	a.chunkSessions.mu.Lock()
+	sess := a.chunkSessions.sessions[b.Host.ID()]
+	a.chunkSessions.mu.Unlock()
+	if sess == nil {
+		t.Fatal("no chunk session to peer")
+	}
+
+	// 慢请求仍在进行中，后发的请求在同一个流上先完成
+	for i, chunkHash := range fastHashes {
+		got, err := a.requestChunkFramed(context.Background(), b.Host.ID(), chunkHash, 0, 0)
+		if err != nil {
+			t.Fatalf("fast request %d: %v", i, err)
+		}
+		if !bytes.Equal(got, fast[i]) {
+			t.Fatalf("fast request %d: got %q", i, got)
+		}
+	}
+	select {
+	case err := <-slowDone:
+		t.Fatalf("slow request finished before release: %v", err)
+	default:
+	}
+
+	a.chunkSessions.mu.Lock()
+	same := a.chunkSessions.sessions[b.Host.ID()] == sess
+	a.chunkSessions.mu.Unlock()
+	if !same {
+		t.Fatal("requests did not share one chunk session")
+	}
+
+	close(gate.release)
+	select {
+	case err := <-slowDone:
+		if err != nil {
+			t.Fatalf("slow request: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow request did not finish")
+	}
+}
+
+func TestChunkFrameResponsesOutOfOrder(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	slow := putTestChunk(t, b, []byte("slow chunk"))
+	gate := newGatedStore(b.ChunkStore, slow)
+	b.ChunkStore = gate
+	fast1 := putTestChunk(t, b, []byte("fast chunk 1"))
+	fast2 := putTestChunk(t, b, []byte("fast chunk 2"))
+	connectServices(t, a, b)
+
+	raw := openRawFrameStream(t, a, b)
+	raw.request(0, slow)
+	gate.waitEntered(t, 1)
+	raw.request(1, fast1)
+	raw.request(2, fast2)
+
+	got := make(map[uint32]string)
+	var order []uint32
+	for len(order) < 2 {
+		id, status, data := raw.response()
+		if status != ChunkStatusOK {
+			t.Fatalf("request %d: status %s", id, status)
+		}
+		got[id] = string(data)
+		order = append(order, id)
+	}
+	close(gate.release)
+	id, status, data := raw.response()
+	if status != ChunkStatusOK {
+		t.Fatalf("request %d: status %s", id, status)
+	}
+	got[id] = string(data)
+	order = append(order, id)
+
+	if order[2] != 0 {
+		t.Fatalf("response order = %v, want slow request 0 last", order)
+	}
+	want := map[uint32]string{0: "slow chunk", 1: "fast chunk 1", 2: "fast chunk 2"}
+	for id, data := range want {
+		if got[id] != data {
+			t.Fatalf("response %d = %q, want %q", id, got[id], data)
+		}
+	}
+}
+
+func TestChunkFrameCancelFreesInflightSlot(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	slow := putTestChunk(t, b, []byte("slow chunk"))
+	gate := newGatedStore(b.ChunkStore, slow)
+	b.ChunkStore = gate
+	fast := putTestChunk(t, b, []byte("fast chunk"))
+	connectServices(t, a, b)
+
+	raw := openRawFrameStream(t, a, b)
+	for id := uint32(0); id < MaxFramedInflight; id++ {
+		raw.request(id, slow)
+	}
+	gate.waitEntered(t, MaxFramedInflight)
+
+	// 进行中的请求已满
+	raw.request(MaxFramedInflight, fast)
+	if id, status, _ := raw.response(); id != MaxFramedInflight || status != ChunkStatusBusy {
+		t.Fatalf("response %d status %s, want %d BUSY", id, status, MaxFramedInflight)
+	}
+
+	// 取消一个仍阻塞在读取中的请求后，新请求可以立即得到处理
+	raw.cancel(0)
+	raw.request(MaxFramedInflight+1, fast)
+	id, status, data := raw.response()
+	if id != MaxFramedInflight+1 || status != ChunkStatusOK || string(data) != "fast chunk" {
+		t.Fatalf("response %d status %s data %q, want %d OK", id, status, data, MaxFramedInflight+1)
+	}
+
+	// 放行后其余请求正常响应，已取消的请求不再响应
+	close(gate.release)
+	for i := 1; i < MaxFramedInflight; i++ {
+		id, status, _ := raw.response()
+		if id == 0 {
+			t.Fatal("canceled request 0 got a response")
+		}
+		if status != ChunkStatusOK {
+			t.Fatalf("response %d status %s", id, status)
+		}
+	}
+	raw.request(MaxFramedInflight+2, fast)
+	if id, status, _ := raw.response(); id != MaxFramedInflight+2 || status != ChunkStatusOK {
+		t.Fatalf("response %d status %s, want %d OK", id, status, MaxFramedInflight+2)
+	}
+}
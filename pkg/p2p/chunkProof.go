@@ -0,0 +1,465 @@
+// Package p2p 提供携带 Merkle 证明的 Chunk 传输功能
+//
+// ChunkProof 功能:
+//   - 证明协议: 按 文件 CID + 叶子索引 请求 Chunk，可选地在数据前附带叶子哈希和 Merkle 审计路径
+//   - 根校验: 下载方只需常规 Merkle 根（chameleon 为 RegularRootHash，regular 为 RootHash）即可校验每个 Chunk，
+//     不依赖元数据中的叶子哈希，篡改过的叶子列表会被发现
+//   - 轻量元数据: 叶子只含 Index 和 ChunkSize、不含哈希的元数据（LightMetaData）也能安全下载
+//   - 叶子数绑定: 证明路径长度必须等于 ceil(log2(叶子数))，最后一个叶子的证明必须是最右侧叶子，
+//     因此截断或追加的叶子列表、与数据不符的 ChunkSize 和 FileSize 都会被发现
+//   - 证明树缓存: 服务端缓存最近使用文件的 Merkle 树，元数据文件更新后自动重建
+//
+// 协议定义:
+//   - /p2pFileTransfer/getChunk/data/1.2.0
+//     请求: ProofRequestMessage（JSON）
+//     响应: Proof 为 true 时先发送 4 字节大端长度 + ChunkProof（JSON），之后是 Chunk 数据直到 EOF；
+//     无法提供时直接关闭流
+//
+// 使用场景:
+//   - P2PConfig.VerifyChunkProofs 为 true 时所有文件下载都使用证明协议
+//   - 叶子没有哈希（轻量元数据）时总是使用证明协议，此时只能从做种者获取（无法按 Chunk 哈希查找提供者）
+//
+// 注意事项:
+//   - 证明按叶子索引生成，校验时同时检查路径方向，不能用同一数据在其他位置的证明冒充
+//   - 只有奇数节点与自身拼接的树（HTTP API 和变色龙树）能生成证明，CLI 旧版 regular 树不支持
+//   - 证明协议每个 Chunk 使用一个流，不经过分帧协议的流水线和压缩
+//   - 奇数节点与自身拼接的树无法区分末尾重复的叶子（如 3 个和 4 个相同 Chunk 的根相同），完整元数据同样如此
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chameleonMerkleTree"
+	"p2pFileTransfer/pkg/file"
+)
+
+const (
+	// GetChunkProofProtocol 按文件 CID 和叶子索引下载 Chunk，可选附带 Merkle 证明
+	GetChunkProofProtocol = "/p2pFileTransfer/getChunk/data/1.2.0"
+
+	// DefaultProofTreeCacheSize 服务端缓存的 Merkle 树数量（文件数）
+	DefaultProofTreeCacheSize = 16
+
+	// maxProofHeaderSize 证明响应头的最大长度
+	maxProofHeaderSize = 64 * 1024
+)
+
+// ProofRequestMessage data/1.2.0 请求结构体
+type ProofRequestMessage struct {
+	ChunkHash string `json:"chunkHash,omitempty"` // 可为空（轻量元数据），此时由 FileCID 和 Index 确定
+	FileCID   string `json:"fileCid,omitempty"`   // 文件 CID（hex 编码），请求证明时必填
+	Index     int    `json:"index"`               // 叶子索引
+	Proof     bool   `json:"proof,omitempty"`     // 是否附带 Merkle 证明
+}
+
+// ChunkProof 一个叶子的 Merkle 证明
+type ChunkProof struct {
+	Index    int        `json:"index"`    // 叶子索引
+	LeafHash []byte     `json:"leafHash"` // 叶子哈希（Chunk 数据的 SHA256）
+	Path     [][][]byte `json:"path"`     // 审计路径，格式同 ChameleonMerkleNode.GenerateProof
+}
+
+// proofTree 一个文件的 Merkle 树
+type proofTree struct {
+	leaves  [][]byte
+	tree    *chameleonMerkleTree.ChameleonMerkleNode // nil 表示该文件的树无法生成证明
+	modTime time.Time                                // 元数据文件修改时间，用于发现更新
+}
+
+// proofTreeEntry LRU 中的一个文件
+type proofTreeEntry struct {
+	cid  string
+	tree *proofTree
+}
+
+// proofTreeCache 按文件 CID 缓存 Merkle 树
+type proofTreeCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // 前端为最近使用
+	items    map[string]*list.Element
+}
+
+func newProofTreeCache(capacity int) *proofTreeCache {
+	return &proofTreeCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get 返回缓存的树，元数据文件已更新时视为未命中
+func (c *proofTreeCache) get(cid string, modTime time.Time) *proofTree {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[cid]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*proofTreeEntry)
+	if !entry.tree.modTime.Equal(modTime) {
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return entry.tree
+}
+
+func (c *proofTreeCache) put(cid string, tree *proofTree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[cid]; ok {
+		elem.Value.(*proofTreeEntry).tree = tree
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[cid] = c.order.PushFront(&proofTreeEntry{cid: cid, tree: tree})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*proofTreeEntry).cid)
+	}
+}
+
+// LightMetaData 返回去掉所有叶子哈希的元数据副本（只保留 Index 和 ChunkSize）
+// 轻量元数据不含每个叶子的哈希，体积远小于完整元数据；下载时每个 Chunk 通过 Merkle 证明校验
+func LightMetaData(metaData *file.MetaData) *file.MetaData {
+	light := *metaData
+	light.Leaves = make([]file.ChunkData, len(metaData.Leaves))
+	for i, leaf := range metaData.Leaves {
+		light.Leaves[i] = file.ChunkData{Index: leaf.Index, ChunkSize: leaf.ChunkSize}
+	}
+	return &light
+}
+
+// isLightMetaData 检查元数据是否为轻量元数据（所有叶子都没有哈希）
+// 部分叶子有哈希时返回错误
+func isLightMetaData(metaData *file.MetaData) (bool, error) {
+	withHash := 0
+	for _, leaf := range metaData.Leaves {
+		if len(leaf.ChunkHash) > 0 {
+			withHash++
+		}
+	}
+	if withHash > 0 && withHash < len(metaData.Leaves) {
+		return false, errors.New("metadata mixes leaves with and without hashes")
+	}
+	return withHash == 0, nil
+}
+
+// proofRoot 返回校验 Merkle 证明使用的根哈希
+func proofRoot(metaData *file.MetaData) []byte {
+	if metaData.TreeType == TreeTypeChameleon {
+		return metaData.RegularRootHash
+	}
+	return metaData.RootHash
+}
+
+// VerifyChunkProof 校验 data 是根为 root 的 Merkle 树中第 index 个叶子的数据
+// 参数:
+//   - root: 常规 Merkle 根（chameleon 文件为 RegularRootHash，regular 文件为 RootHash）
+//   - index: 叶子索引
+//   - data: chunk 数据
+//   - proof: 对端返回的证明
+//
+// 返回值:
+//   - error: 校验失败的原因
+func VerifyChunkProof(root []byte, index int, data []byte, proof *ChunkProof) error {
+	if proof == nil {
+		return errors.New("missing merkle proof")
+	}
+	if proof.Index != index {
+		return fmt.Errorf("merkle proof is for leaf %d, expected %d", proof.Index, index)
+	}
+	hash := sha256.Sum256(data)
+	if !bytes.Equal(hash[:], proof.LeafHash) {
+		return errors.New("chunk data does not match proven leaf hash")
+	}
+	if !chameleonMerkleTree.VerifyProofByIndex(root, proof.LeafHash, index, proof.Path) {
+		return errors.New("merkle proof does not match root hash")
+	}
+	return nil
+}
+
+// proofHeight 返回 leafCount 个叶子的 Merkle 树高度（证明路径长度），即 ceil(log2(leafCount))
+func proofHeight(leafCount int) int {
+	height := 0
+	for 1<<height < leafCount {
+		height++
+	}
+	return height
+}
+
+// verifyProofShape 检查证明与元数据中的叶子数一致
+// 所有叶子位于同一深度，路径长度必须等于树高；最后一个叶子在索引位为 0 的每一层都没有右侧兄弟，
+// 右侧节点必须是自身的复制，否则根下还有更多叶子（叶子列表被截断）
+func verifyProofShape(leafCount, index int, proof *ChunkProof) error {
+	if len(proof.Path) != proofHeight(leafCount) {
+		return fmt.Errorf("merkle proof has %d levels, %d leaves need %d", len(proof.Path), leafCount, proofHeight(leafCount))
+	}
+	if index != leafCount-1 {
+		return nil
+	}
+	current := proof.LeafHash
+	for level, layer := range proof.Path {
+		left, right := layer[0], layer[1]
+		var combined []byte
+		if (index>>level)&1 == 0 {
+			if !bytes.Equal(right, current) {
+				return fmt.Errorf("leaf %d is not the last leaf of the merkle tree", index)
+			}
+			combined = append(append(combined, current...), right...)
+		} else {
+			combined = append(append(combined, left...), current...)
+		}
+		hash := sha256.Sum256(combined)
+		current = hash[:]
+	}
+	return nil
+}
+
+// verifyProvenLeafSize 检查已证明的 chunk 数据与叶子的 ChunkSize 和文件大小一致
+// 除最后一个叶子外数据长度都等于 ChunkSize；最后一个叶子不超过 ChunkSize，且结束位置等于 FileSize
+func verifyProvenLeafSize(task chunkTask, size int) error {
+	if task.index < task.leafCount-1 {
+		if size != task.chunk.ChunkSize {
+			return fmt.Errorf("chunk is %d bytes, leaf size is %d", size, task.chunk.ChunkSize)
+		}
+		return nil
+	}
+	if size > task.chunk.ChunkSize {
+		return fmt.Errorf("last chunk is %d bytes, leaf size is %d", size, task.chunk.ChunkSize)
+	}
+	if end := task.offset + int64(size); end != task.fileSize {
+		return fmt.Errorf("file ends at %d bytes, metadata file size is %d", end, task.fileSize)
+	}
+	return nil
+}
+
+// buildFileChunkTasks 为文件的叶子构建下载任务
+// 启用 VerifyChunkProofs 或叶子没有哈希（轻量元数据）时，任务通过证明协议下载并对 Merkle 根校验
+func (p *P2PService) buildFileChunkTasks(fileHash string, metaData *file.MetaData) []chunkTask {
+	tasks := buildChunkTasks(metaData.Leaves)
+	if fileHash == "" {
+		return tasks
+	}
+	root := proofRoot(metaData)
+	for i := range tasks {
+		if p.Config.VerifyChunkProofs || len(tasks[i].chunk.ChunkHash) == 0 {
+			tasks[i].fileCID = fileHash
+			tasks[i].proofRoot = root
+			tasks[i].leafCount = len(tasks)
+			tasks[i].fileSize = int64(metaData.FileSize)
+		}
+	}
+	return tasks
+}
+
+// DownloadChunkWithProof 从指定 peer 下载文件的第 index 个 chunk 及其 Merkle 证明
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - peerID: 目标节点
+//   - fileCID: 文件 CID（hex 编码）
+//   - index: 叶子索引
+//   - chunkHash: chunk 哈希（hex 编码，可为空）；非空时对端会检查与叶子哈希一致
+//
+// 返回值:
+//   - []byte: chunk 数据（尚未校验，调用方使用 VerifyChunkProof 校验）
+//   - *ChunkProof: 对端返回的证明
+//   - error: 错误信息
+func (p *P2PService) DownloadChunkWithProof(ctx context.Context, peerID peer.ID, fileCID string, index int, chunkHash string) ([]byte, *ChunkProof, error) {
+	req := ProofRequestMessage{ChunkHash: chunkHash, FileCID: fileCID, Index: index, Proof: true}
+	raw, err := p.requestChunkDataLimit(ctx, peerID, GetChunkProofProtocol, req, MaxChunkSize+maxProofHeaderSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(raw) < 4 {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, nil, fmt.Errorf("chunk %d with proof not available from %s", index, peerID)
+	}
+	n := int(binary.BigEndian.Uint32(raw[:4]))
+	if n > maxProofHeaderSize || 4+n > len(raw) {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, nil, fmt.Errorf("invalid proof header length: %d", n)
+	}
+	var proof ChunkProof
+	if err := json.Unmarshal(raw[4:4+n], &proof); err != nil {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, nil, fmt.Errorf("decode proof: %w", err)
+	}
+	data := raw[4+n:]
+	if len(data) == 0 {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, nil, fmt.Errorf("chunk is empty")
+	}
+	return data, &proof, nil
+}
+
+// downloadProvenChunk 通过证明协议下载任务对应的 chunk，并对 Merkle 根校验
+func (p *P2PService) downloadProvenChunk(ctx context.Context, peerID peer.ID, task chunkTask) ([]byte, error) {
+	data, proof, err := p.DownloadChunkWithProof(ctx, peerID, task.fileCID, task.index, task.chunkHashStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyChunkProof(task.proofRoot, task.index, data, proof); err != nil {
+		p.ConnManager.RecordFailure(peerID)
+		return nil, fmt.Errorf("chunk %d: %w", task.index, err)
+	}
+	// 证明的树高和最右侧叶子把叶子数绑定到根，数据长度把 ChunkSize 和 FileSize 绑定到已证明的数据
+	if err := verifyProofShape(task.leafCount, task.index, proof); err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", task.index, err)
+	}
+	if err := verifyProvenLeafSize(task, len(data)); err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", task.index, err)
+	}
+	// 元数据中的叶子哈希必须与证明一致，否则元数据的叶子列表被篡改
+	if len(task.chunk.ChunkHash) > 0 && !bytesEqual(proof.LeafHash, task.chunk.ChunkHash) {
+		return nil, fmt.Errorf("chunk %d: leaf hash in metadata does not match merkle proof", task.index)
+	}
+	return data, nil
+}
+
+// loadProofTree 返回本地文件的 Merkle 树（优先使用缓存）
+func (p *P2PService) loadProofTree(cid string) (*proofTree, error) {
+	info, err := os.Stat(p.getMetaDataPath(cid))
+	if err != nil {
+		return nil, fmt.Errorf("metadata not found: %w", err)
+	}
+	if t := p.proofTrees.get(cid, info.ModTime()); t != nil {
+		return t, nil
+	}
+
+	metaData, err := p.LoadLocalMetaData(cid)
+	if err != nil {
+		return nil, err
+	}
+	t := &proofTree{leaves: make([][]byte, len(metaData.Leaves)), modTime: info.ModTime()}
+	for i, leaf := range metaData.Leaves {
+		if len(leaf.ChunkHash) == 0 {
+			return nil, errors.New("local metadata has no leaf hashes")
+		}
+		t.leaves[i] = leaf.ChunkHash
+	}
+	if tree, err := chameleonMerkleTree.NewMerkleTreeFromHashes(t.leaves); err == nil && bytes.Equal(tree.GetRootHash(), proofRoot(metaData)) {
+		t.tree = tree
+	}
+	p.proofTrees.put(cid, t)
+	return t, nil
+}
+
+// readChunkWithProof 读取请求的 chunk，需要时生成证明
+func (p *P2PService) readChunkWithProof(req ProofRequestMessage) ([]byte, *ChunkProof, error) {
+	hashHex := strings.ToLower(req.ChunkHash)
+	var proof *ChunkProof
+	if req.FileCID != "" {
+		cid := strings.ToLower(req.FileCID)
+		if _, err := hex.DecodeString(cid); err != nil {
+			return nil, nil, fmt.Errorf("invalid file CID: %s", req.FileCID)
+		}
+		t, err := p.loadProofTree(cid)
+		if err != nil {
+			return nil, nil, err
+		}
+		if req.Index < 0 || req.Index >= len(t.leaves) {
+			return nil, nil, fmt.Errorf("leaf index %d out of range", req.Index)
+		}
+		leafHex := hex.EncodeToString(t.leaves[req.Index])
+		if hashHex != "" && hashHex != leafHex {
+			return nil, nil, fmt.Errorf("chunk %s is not leaf %d of %s", hashHex, req.Index, cid)
+		}
+		hashHex = leafHex
+
+		if req.Proof {
+			if t.tree == nil {
+				return nil, nil, fmt.Errorf("file %s does not support merkle proofs", cid)
+			}
+			path, err := t.tree.GenerateProofByIndex(req.Index)
+			if err != nil {
+				return nil, nil, err
+			}
+			proof = &ChunkProof{Index: req.Index, LeafHash: t.leaves[req.Index], Path: path}
+		}
+	} else if req.Proof {
+		return nil, nil, errors.New("merkle proof requires file CID")
+	}
+
+	data, err := p.readLocalChunk(hashHex)
+	if err != nil {
+		return nil, nil, fmt.Errorf("chunk %s: %w", hashHex, err)
+	}
+	return data, proof, nil
+}
+
+// handleChunkProofStream 处理 data/1.2.0 请求
+func (p *P2PService) handleChunkProofStream(ctx context.Context, s network.Stream) {
+	defer s.Close()
+	peerID := s.Conn().RemotePeer()
+
+	select {
+	case <-p.Ctx.Done():
+		logrus.Debug("Service is shutting down, ignoring chunk proof request")
+		return
+	default:
+	}
+
+	requestTimeout := DefaultRequestTimeout
+	dataTimeout := DefaultDataTimeout
+	if p.Config.RequestTimeout > 0 {
+		requestTimeout = time.Duration(p.Config.RequestTimeout) * time.Second
+	}
+	if p.Config.DataTimeout > 0 {
+		dataTimeout = time.Duration(p.Config.DataTimeout) * time.Second
+	}
+	s.SetReadDeadline(time.Now().Add(requestTimeout))
+
+	if p.AntiLeecher.Refuse(ctx, peerID) {
+		logrus.Warnf("Refused chunk proof request from peer %s (leecher)", peerID)
+		return
+	}
+	var req ProofRequestMessage
+	if err := json.NewDecoder(s).Decode(&req); err != nil {
+		logrus.Errorf("Invalid chunk proof request from %s: %v", peerID, err)
+		return
+	}
+
+	data, proof, err := p.readChunkWithProof(req)
+	if err != nil {
+		logrus.Warnf("Cannot serve leaf %d of %s to %s: %v", req.Index, req.FileCID, peerID, err)
+		return
+	}
+
+	s.SetWriteDeadline(time.Now().Add(dataTimeout))
+	w := bufio.NewWriterSize(p.Bandwidth.uploadWriter(p.Ctx, peerID, s), ReadBufferSize)
+	if proof != nil {
+		header, err := json.Marshal(proof)
+		if err != nil {
+			logrus.Errorf("Encode proof for leaf %d of %s failed: %v", req.Index, req.FileCID, err)
+			return
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(header)))
+		w.Write(size[:])
+		w.Write(header)
+	}
+	w.Write(data)
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Send chunk with proof to %s failed: %v", peerID, err)
+		return
+	}
+	logrus.Infof("Leaf %d of %s (%d bytes, proof=%v) sent successfully to peer %s", req.Index, req.FileCID, len(data), proof != nil, peerID)
+}
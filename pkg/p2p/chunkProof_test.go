@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"p2pFileTransfer/pkg/chameleonMerkleTree"
+)
+
+// testProofs 为 n 个不同叶子构建 Merkle 树，返回每个叶子的证明
+func testProofs(t *testing.T, n int) []*ChunkProof {
+	t.Helper()
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		sum := sha256.Sum256([]byte{byte(i)})
+		leaves[i] = sum[:]
+	}
+	tree, err := chameleonMerkleTree.NewMerkleTreeFromHashes(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofs := make([]*ChunkProof, n)
+	for i := range leaves {
+		path, err := tree.GenerateProofByIndex(i)
+		if err != nil {
+			t.Fatalf("GenerateProofByIndex(%d): %v", i, err)
+		}
+		proofs[i] = &ChunkProof{Index: i, LeafHash: leaves[i], Path: path}
+	}
+	return proofs
+}
+
+func TestProofHeight(t *testing.T) {
+	for leafCount, want := range map[int]int{1: 0, 2: 1, 3: 2, 4: 2, 5: 3, 8: 3, 9: 4} {
+		if got := proofHeight(leafCount); got != want {
+			t.Errorf("proofHeight(%d) = %d, want %d", leafCount, got, want)
+		}
+	}
+}
+
+func TestVerifyProofShape(t *testing.T) {
+	proofs := testProofs(t, 5)
+	for i, proof := range proofs {
+		if err := verifyProofShape(5, i, proof); err != nil {
+			t.Fatalf("leaf %d of 5: %v", i, err)
+		}
+	}
+
+	// 截断的叶子列表: 同一树高内最后一个叶子不是最右侧叶子，跨树高时路径长度不符
+	if err := verifyProofShape(4, 3, proofs[3]); err == nil {
+		t.Fatal("truncated to 4 leaves: last leaf accepted")
+	}
+	if err := verifyProofShape(3, 0, proofs[0]); err == nil {
+		t.Fatal("truncated to 3 leaves: path length accepted")
+	}
+	// 追加的叶子超出树高
+	if err := verifyProofShape(9, 0, proofs[0]); err == nil {
+		t.Fatal("padded to 9 leaves: path length accepted")
+	}
+
+	// 单个叶子没有路径
+	if err := verifyProofShape(1, 0, testProofs(t, 1)[0]); err != nil {
+		t.Fatalf("single leaf: %v", err)
+	}
+}
+
+func TestVerifyProvenLeafSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		task  chunkTask
+		size  int
+		valid bool
+	}{
+		{"full leaf", chunkTask{index: 0, leafCount: 3, offset: 0, fileSize: 10}, 4, true},
+		{"short leaf", chunkTask{index: 1, leafCount: 3, offset: 4, fileSize: 10}, 3, false},
+		{"last leaf", chunkTask{index: 2, leafCount: 3, offset: 8, fileSize: 10}, 2, true},
+		{"last leaf over size", chunkTask{index: 2, leafCount: 3, offset: 8, fileSize: 13}, 5, false},
+		{"file size mismatch", chunkTask{index: 2, leafCount: 3, offset: 8, fileSize: 11}, 2, false},
+	}
+	for _, tt := range tests {
+		tt.task.chunk.ChunkSize = 4
+		if err := verifyProvenLeafSize(tt.task, tt.size); (err == nil) != tt.valid {
+			t.Errorf("%s: err = %v, want valid=%v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// putTestChunk 将 data 存入 service 的 ChunkStore，返回 hex 哈希
+func putTestChunk(t *testing.T, service *P2PService, data []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	chunkHash := hex.EncodeToString(sum[:])
+	if err := service.ChunkStore.Put(chunkHash, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	return chunkHash
+}
+
+func TestDownloadChunkCompressedLegacyProtocol(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	// 对端不支持分帧协议，使用 getChunk/data
+	b.Host.RemoveStreamHandler(ChunkFrameProtocol)
+	b.Host.RemoveStreamHandler(ChunkFrameCompressedProtocol)
+	connectServices(t, a, b)
+
+	data := bytes.Repeat([]byte("ts,level,message\n"), 4096)
+	chunkHash := putTestChunk(t, b, data)
+	ctx := context.Background()
+
+	req := CompressedRequestMessage{ChunkHash: chunkHash, Accept: []string{"brotli", "gzip"}}
+	raw, err := a.requestChunkDataLimit(ctx, b.Host.ID(), GetChunkCompressedProtocol, req, MaxChunkSize+1)
+	if err != nil {
+		t.Fatalf("data/1.3.0 request: %v", err)
+	}
+	if Codec(raw[0]) != CodecGzip || len(raw) >= len(data) {
+		t.Fatalf("response codec %s, %d bytes: want gzip smaller than %d", Codec(raw[0]), len(raw), len(data))
+	}
+
+	got, err := a.DownloadChunk(ctx, b.Host.ID(), chunkHash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("DownloadChunk: %d bytes, %v", len(got), err)
+	}
+	if _, err := a.DownloadChunk(ctx, b.Host.ID(), "00"+chunkHash[2:]); err == nil {
+		t.Fatal("DownloadChunk of missing chunk succeeded")
+	}
+
+	// 对端也不支持 data/1.3.0 时回退到 data/1.0.0
+	b.Host.RemoveStreamHandler(GetChunkCompressedProtocol)
+	got, err = a.DownloadChunk(ctx, b.Host.ID(), chunkHash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("DownloadChunk via data/1.0.0: %d bytes, %v", len(got), err)
+	}
+}
+
+func TestDownloadChunkRange(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	connectServices(t, a, b)
+
+	data := []byte("0123456789abcdefghij")
+	chunkHash := putTestChunk(t, b, data)
+	missing := "00" + chunkHash[2:]
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{"middle", 5, 6, "56789a"},
+		{"to end", 15, 0, "fghij"},
+		{"length past end", 18, 10, "ij"},
+	}
+	check := func(protocol string) {
+		t.Helper()
+		for _, tt := range tests {
+			got, err := a.DownloadChunkRange(ctx, b.Host.ID(), chunkHash, tt.offset, tt.length)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("%s %s: got %q, %v; want %q", protocol, tt.name, got, err, tt.want)
+			}
+		}
+		if _, err := a.DownloadChunkRange(ctx, b.Host.ID(), chunkHash, int64(len(data)), 0); !errors.Is(err, ErrRangeNotSatisfiable) {
+			t.Errorf("%s offset at size: got %v, want ErrRangeNotSatisfiable", protocol, err)
+		}
+		if _, err := a.DownloadChunkRange(ctx, b.Host.ID(), missing, 0, 4); err == nil {
+			t.Errorf("%s missing chunk: range download succeeded", protocol)
+		}
+	}
+
+	// 分帧协议
+	check("framed")
+	if _, err := a.DownloadChunkRange(ctx, b.Host.ID(), chunkHash, -1, 4); err == nil {
+		t.Error("negative offset accepted")
+	}
+
+	// data/1.1.0: offset 超出 chunk 大小或 chunk 不存在时重置流，而不是返回空数据
+	b.Host.RemoveStreamHandler(ChunkFrameProtocol)
+	b.Host.RemoveStreamHandler(ChunkFrameCompressedProtocol)
+	for _, req := range []RangeRequestMessage{
+		{ChunkHash: chunkHash, Offset: int64(len(data))},
+		{ChunkHash: chunkHash, Offset: 100},
+		{ChunkHash: missing},
+	} {
+		got, err := a.requestChunkData(ctx, b.Host.ID(), GetChunkRangeProtocol, req)
+		if err == nil {
+			t.Errorf("data/1.1.0 %+v: got %q, want stream reset", req, got)
+		}
+	}
+	check("data/1.1.0")
+
+	// 对端不支持 data/1.1.0 时下载完整 chunk 后截取
+	b.Host.RemoveStreamHandler(GetChunkRangeProtocol)
+	check("data/1.0.0")
+}
@@ -0,0 +1,383 @@
+// Package p2p 提供 Chunk 传输的压缩协商功能
+//
+// Compression 功能:
+//   - 协商: 请求方携带可接受的压缩算法（REQUEST 帧中的位掩码，或 data/1.3.0 请求中的算法名称），
+//     服务端选择双方都支持且优先级最高的算法
+//   - 按需压缩: 服务端在发送时压缩，响应帧携带实际使用的算法
+//   - 不可压缩检测: 先压缩数据开头的样本，压缩率不够时直接发送原始数据（如已压缩的视频、归档文件）
+//   - 压缩缓存: 可选的 LRU 缓存，保存完整 chunk 的压缩结果和不可压缩判定，热门 chunk 不必重复压缩
+//   - 透明解压: 请求方在 SHA256 校验之前解压，校验与存储都基于原始数据
+//
+// 支持的算法:
+//   - zstd（默认优先）、gzip、none
+//
+// 注意事项:
+//   - 只有 /p2pFileTransfer/chunk/2.1.0 和 /p2pFileTransfer/getChunk/data/1.3.0 协议支持压缩，其他协议总是发送原始数据
+//   - 解压后的数据超过 MaxChunkSize 时视为错误，防止解压炸弹
+package p2p
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// Codec Chunk 传输使用的压缩算法
+type Codec byte
+
+const (
+	CodecNone Codec = 0
+	CodecGzip Codec = 1
+	CodecZstd Codec = 2
+)
+
+const (
+	// DefaultCompressionCacheSize 压缩缓存的默认大小（字节）
+	DefaultCompressionCacheSize = 64 * 1024 * 1024
+
+	// compressionSampleSize 不可压缩检测使用的样本大小
+	compressionSampleSize = 16 * 1024
+
+	// minCompressSize 小于此大小的数据不压缩
+	minCompressSize = 512
+
+	// maxCompressRatio 样本压缩后超过原始大小的此比例时视为不可压缩
+	maxCompressRatio = 0.9
+
+	// incompressibleEntryCost 缓存不可压缩判定时计入的大小
+	incompressibleEntryCost = 64
+)
+
+// DefaultCompressionCodecs 默认支持的压缩算法（按优先级）
+var DefaultCompressionCodecs = []string{"zstd", "gzip"}
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecGzip:
+		return "gzip"
+	case CodecZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+// mask 返回算法在协商位掩码中的位
+func (c Codec) mask() byte {
+	return 1 << c
+}
+
+// ParseCompressionCodecs 解析压缩算法名称列表
+// 参数:
+//   - names: 算法名称（zstd、gzip、none），按优先级排列；none 不参与协商，只包含 none 时表示禁用压缩
+//
+// 返回值:
+//   - []Codec: 去重后的压缩算法（不含 none）
+//   - error: 包含未知算法时返回错误
+func ParseCompressionCodecs(names []string) ([]Codec, error) {
+	var codecs []Codec
+	seen := make(map[Codec]bool)
+	for _, name := range names {
+		var codec Codec
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "zstd":
+			codec = CodecZstd
+		case "gzip":
+			codec = CodecGzip
+		case "none", "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown compression codec: %s", name)
+		}
+		if !seen[codec] {
+			seen[codec] = true
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs, nil
+}
+
+// chunkCompressor 压缩和解压 chunk 数据，服务端和请求方共用
+type chunkCompressor struct {
+	codecs []Codec           // 本节点支持的算法（按优先级）
+	cache  *compressionCache // 为 nil 时不缓存
+
+	zstdEncoder *zstd.Encoder // EncodeAll/DecodeAll 可并发调用
+	zstdDecoder *zstd.Decoder
+}
+
+// newChunkCompressor 创建压缩器
+// 参数:
+//   - names: 支持的算法名称（按优先级）
+//   - cacheSize: 压缩缓存大小（字节），0 表示不缓存
+func newChunkCompressor(names []string, cacheSize int64) (*chunkCompressor, error) {
+	codecs, err := ParseCompressionCodecs(names)
+	if err != nil {
+		return nil, err
+	}
+	c := &chunkCompressor{codecs: codecs}
+	if cacheSize > 0 {
+		c.cache = newCompressionCache(cacheSize)
+	}
+
+	c.zstdEncoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("create zstd encoder: %w", err)
+	}
+	c.zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxChunkSize), zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return nil, fmt.Errorf("create zstd decoder: %w", err)
+	}
+	return c, nil
+}
+
+// acceptMask 返回请求方可接受的算法位掩码
+func (c *chunkCompressor) acceptMask() byte {
+	var mask byte
+	for _, codec := range c.codecs {
+		mask |= codec.mask()
+	}
+	return mask
+}
+
+// acceptNames 返回本节点支持的算法名称（按优先级），用于 data/1.3.0 请求
+func (c *chunkCompressor) acceptNames() []string {
+	names := make([]string, len(c.codecs))
+	for i, codec := range c.codecs {
+		names[i] = codec.String()
+	}
+	return names
+}
+
+// acceptMaskOf 将对端发送的算法名称转换为位掩码，忽略本节点不认识的算法
+func acceptMaskOf(names []string) byte {
+	var mask byte
+	for _, name := range names {
+		if codecs, err := ParseCompressionCodecs([]string{name}); err == nil && len(codecs) == 1 {
+			mask |= codecs[0].mask()
+		}
+	}
+	return mask
+}
+
+// choose 选择本节点支持且对端可接受的优先级最高的算法
+func (c *chunkCompressor) choose(accept byte) Codec {
+	for _, codec := range c.codecs {
+		if accept&codec.mask() != 0 {
+			return codec
+		}
+	}
+	return CodecNone
+}
+
+// encode 按对端可接受的算法压缩数据，不值得压缩时返回 CodecNone 和原始数据
+// 参数:
+//   - key: 缓存键（chunk 哈希），为空表示不缓存（如范围请求的部分数据）
+//   - data: 原始数据
+//   - accept: 对端可接受的算法位掩码
+func (c *chunkCompressor) encode(key string, data []byte, accept byte) (Codec, []byte) {
+	codec := c.choose(accept)
+	if codec == CodecNone || len(data) < minCompressSize {
+		return CodecNone, data
+	}
+
+	if key != "" && c.cache != nil {
+		if compressed, ok := c.cache.get(key, codec); ok {
+			if compressed == nil {
+				return CodecNone, data
+			}
+			return codec, compressed
+		}
+	}
+
+	compressed := c.compress(codec, data)
+	if key != "" && c.cache != nil {
+		c.cache.put(key, codec, compressed)
+	}
+	if compressed == nil {
+		return CodecNone, data
+	}
+	return codec, compressed
+}
+
+// invalidate 删除 chunk 的压缩缓存（本地 chunk 被替换或删除时调用）
+func (c *chunkCompressor) invalidate(key string) {
+	if c.cache != nil {
+		c.cache.invalidate(key)
+	}
+}
+
+// compress 压缩数据，不可压缩时返回 nil
+func (c *chunkCompressor) compress(codec Codec, data []byte) []byte {
+	// 先压缩样本，压缩率不够时跳过整个 chunk
+	if len(data) > compressionSampleSize {
+		sample, err := c.compressWith(codec, data[:compressionSampleSize])
+		if err != nil || float64(len(sample)) > maxCompressRatio*compressionSampleSize {
+			return nil
+		}
+	}
+
+	compressed, err := c.compressWith(codec, data)
+	if err != nil {
+		logrus.Warnf("Compress chunk with %s failed: %v", codec, err)
+		return nil
+	}
+	if float64(len(compressed)) > maxCompressRatio*float64(len(data)) {
+		return nil
+	}
+	return compressed
+}
+
+func (c *chunkCompressor) compressWith(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecZstd:
+		return c.zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	case CodecGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", codec)
+	}
+}
+
+// decode 解压对端发送的数据，解压后超过 MaxChunkSize 时返回 ErrChunkTooLarge
+func (c *chunkCompressor) decode(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecZstd:
+		out, err := c.zstdDecoder.DecodeAll(data, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			// 解码器按 MaxChunkSize 限制内存，超出时与 gzip 一样视为 chunk 过大
+			return nil, ErrChunkTooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zstd decode: %w", err)
+		}
+		if len(out) > MaxChunkSize {
+			return nil, ErrChunkTooLarge
+		}
+		return out, nil
+	case CodecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip decode: %w", err)
+		}
+		defer zr.Close()
+		out, err := io.ReadAll(io.LimitReader(zr, MaxChunkSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("gzip decode: %w", err)
+		}
+		if len(out) > MaxChunkSize {
+			return nil, ErrChunkTooLarge
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", codec)
+	}
+}
+
+// -----------------------------
+// 压缩缓存
+// -----------------------------
+
+type compressionKey struct {
+	hash  string
+	codec Codec
+}
+
+type compressionEntry struct {
+	key  compressionKey
+	data []byte // nil 表示不可压缩
+}
+
+// compressionCache 按字节数限制大小的 LRU 缓存
+type compressionCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	order    *list.List // 队首为最近使用
+	entries  map[compressionKey]*list.Element
+}
+
+func newCompressionCache(maxBytes int64) *compressionCache {
+	return &compressionCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[compressionKey]*list.Element),
+	}
+}
+
+func entryCost(data []byte) int64 {
+	if data == nil {
+		return incompressibleEntryCost
+	}
+	return int64(len(data))
+}
+
+// get 返回缓存的压缩结果，ok 为 true 且 data 为 nil 表示已判定不可压缩
+func (cc *compressionCache) get(hash string, codec Codec) (data []byte, ok bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	elem, ok := cc.entries[compressionKey{hash, codec}]
+	if !ok {
+		return nil, false
+	}
+	cc.order.MoveToFront(elem)
+	return elem.Value.(*compressionEntry).data, true
+}
+
+// invalidate 删除 hash 在所有编码下的缓存
+func (cc *compressionCache) invalidate(hash string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for key, elem := range cc.entries {
+		if key.hash == hash {
+			cc.used -= entryCost(elem.Value.(*compressionEntry).data)
+			cc.order.Remove(elem)
+			delete(cc.entries, key)
+		}
+	}
+}
+
+func (cc *compressionCache) put(hash string, codec Codec, data []byte) {
+	cost := entryCost(data)
+	if cost > cc.maxBytes {
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	key := compressionKey{hash, codec}
+	if elem, ok := cc.entries[key]; ok {
+		cc.used -= entryCost(elem.Value.(*compressionEntry).data)
+		cc.order.Remove(elem)
+		delete(cc.entries, key)
+	}
+	for cc.used+cost > cc.maxBytes {
+		oldest := cc.order.Back()
+		entry := oldest.Value.(*compressionEntry)
+		cc.used -= entryCost(entry.data)
+		cc.order.Remove(oldest)
+		delete(cc.entries, entry.key)
+	}
+	cc.entries[key] = cc.order.PushFront(&compressionEntry{key: key, data: data})
+	cc.used += cost
+}
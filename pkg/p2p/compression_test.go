@@ -0,0 +1,259 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// compressibleData 返回可压缩的测试数据
+func compressibleData(size int) []byte {
+	return bytes.Repeat([]byte("compressible chunk data "), size/24+1)[:size]
+}
+
+// randomData 返回不可压缩的测试数据
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func newTestCompressor(t *testing.T, names []string, cacheSize int64) *chunkCompressor {
+	t.Helper()
+	c, err := newChunkCompressor(names, cacheSize)
+	if err != nil {
+		t.Fatalf("newChunkCompressor: %v", err)
+	}
+	return c
+}
+
+func TestChunkFrameCompressedNegotiation(t *testing.T) {
+	large := compressibleData(8 * 1024)
+	small := compressibleData(minCompressSize - 1)
+
+	tests := []struct {
+		name   string
+		codecs []string // 服务端支持的算法，nil 表示默认
+		data   []byte
+		accept byte
+		want   Codec
+	}{
+		{name: "zstd preferred", data: large, accept: CodecZstd.mask() | CodecGzip.mask(), want: CodecZstd},
+		{name: "gzip only accepted", data: large, accept: CodecGzip.mask(), want: CodecGzip},
+		{name: "server gzip only", codecs: []string{"gzip"}, data: large, accept: CodecZstd.mask() | CodecGzip.mask(), want: CodecGzip},
+		{name: "nothing accepted", data: large, accept: 0, want: CodecNone},
+		{name: "below min size", data: small, accept: CodecZstd.mask() | CodecGzip.mask(), want: CodecNone},
+		{name: "exactly min size", data: compressibleData(minCompressSize), accept: CodecZstd.mask(), want: CodecZstd},
+	}
+
+	a, b := newTestService(t), newTestService(t)
+	connectServices(t, a, b)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.codecs != nil {
+				saved := b.compressor
+				b.compressor = newTestCompressor(t, tt.codecs, 0)
+				defer func() { b.compressor = saved }()
+			}
+			chunkHash := putTestChunk(t, b, tt.data)
+			hash, _ := hex.DecodeString(chunkHash)
+
+			s, err := a.Host.NewStream(context.Background(), b.Host.ID(), ChunkFrameCompressedProtocol)
+			if err != nil {
+				t.Fatalf("NewStream: %v", err)
+			}
+			defer s.Reset()
+			if err := writeFrame(s, frameRequest, 1, frameRequestPayload(hash, 0, 0, true, tt.accept)); err != nil {
+				t.Fatalf("write request: %v", err)
+			}
+			s.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, payload, err := readFrame(bufio.NewReader(s), MaxChunkSize+2)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			if len(payload) < 2 || ChunkStatus(payload[0]) != ChunkStatusOK {
+				t.Fatalf("unexpected response %v", payload)
+			}
+
+			codec, wire := Codec(payload[1]), payload[2:]
+			if codec != tt.want {
+				t.Fatalf("codec = %s, want %s", codec, tt.want)
+			}
+			if codec != CodecNone && len(wire) >= len(tt.data) {
+				t.Fatalf("compressed %d bytes to %d", len(tt.data), len(wire))
+			}
+			got, err := a.compressor.decode(codec, wire)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Fatal("decoded data mismatch")
+			}
+		})
+	}
+
+	// 客户端协商到 2.1.0 并透明解压
+	chunkHash := putTestChunk(t, b, large)
+	got, err := a.DownloadChunk(context.Background(), b.Host.ID(), chunkHash)
+	if err != nil {
+		t.Fatalf("DownloadChunk: %v", err)
+	}
+	if !bytes.Equal(got, large) {
+		t.Fatal("DownloadChunk data mismatch")
+	}
+	a.chunkSessions.mu.Lock()
+	sess := a.chunkSessions.sessions[b.Host.ID()]
+	a.chunkSessions.mu.Unlock()
+	if sess == nil || !sess.compressed {
+		t.Fatal("chunk session did not negotiate the compressed protocol")
+	}
+}
+
+func TestCompressionCacheLRU(t *testing.T) {
+	cc := newCompressionCache(100)
+	cc.put("a", CodecZstd, make([]byte, 40))
+	cc.put("b", CodecZstd, make([]byte, 40))
+
+	// 访问 a 后 b 成为最久未使用的条目
+	if _, ok := cc.get("a", CodecZstd); !ok {
+		t.Fatal("a missing")
+	}
+	cc.put("c", CodecZstd, make([]byte, 40))
+	if _, ok := cc.get("b", CodecZstd); ok {
+		t.Fatal("b not evicted")
+	}
+	for _, hash := range []string{"a", "c"} {
+		if _, ok := cc.get(hash, CodecZstd); !ok {
+			t.Fatalf("%s evicted", hash)
+		}
+	}
+	if cc.used != 80 {
+		t.Fatalf("used = %d, want 80", cc.used)
+	}
+
+	// 覆盖同一个键时不重复计数
+	cc.put("c", CodecZstd, make([]byte, 20))
+	if cc.used != 60 {
+		t.Fatalf("used after overwrite = %d, want 60", cc.used)
+	}
+
+	// 超过缓存大小的条目不缓存
+	cc.put("huge", CodecZstd, make([]byte, 101))
+	if _, ok := cc.get("huge", CodecZstd); ok {
+		t.Fatal("entry larger than the cache was stored")
+	}
+
+	// 不可压缩判定按 incompressibleEntryCost 计数
+	cc.put("a", CodecGzip, nil)
+	if data, ok := cc.get("a", CodecGzip); !ok || data != nil {
+		t.Fatalf("incompressible entry = %v, %v", data, ok)
+	}
+	if cc.used > cc.maxBytes {
+		t.Fatalf("used %d exceeds max %d", cc.used, cc.maxBytes)
+	}
+
+	// invalidate 删除所有编码下的条目
+	cc.invalidate("a")
+	for _, codec := range []Codec{CodecZstd, CodecGzip} {
+		if _, ok := cc.get("a", codec); ok {
+			t.Fatalf("a/%s survived invalidate", codec)
+		}
+	}
+	var want int64
+	for _, elem := range cc.entries {
+		want += entryCost(elem.Value.(*compressionEntry).data)
+	}
+	if cc.used != want || cc.order.Len() != len(cc.entries) {
+		t.Fatalf("used = %d (%d in list), want %d (%d entries)", cc.used, cc.order.Len(), want, len(cc.entries))
+	}
+}
+
+func TestCompressSkipsIncompressibleSample(t *testing.T) {
+	c := newTestCompressor(t, DefaultCompressionCodecs, 1024*1024)
+
+	// 开头的样本不可压缩，即使剩余部分压缩率很高也整体跳过
+	data := append(randomData(compressionSampleSize, 1), make([]byte, 4*compressionSampleSize)...)
+	whole, err := c.compressWith(CodecZstd, data)
+	if err != nil {
+		t.Fatalf("compressWith: %v", err)
+	}
+	if float64(len(whole)) > maxCompressRatio*float64(len(data)) {
+		t.Fatalf("test data compresses to %d of %d bytes, expected compressible as a whole", len(whole), len(data))
+	}
+	for _, codec := range []Codec{CodecZstd, CodecGzip} {
+		if got := c.compress(codec, data); got != nil {
+			t.Fatalf("%s: compressed despite incompressible sample (%d bytes)", codec, len(got))
+		}
+	}
+
+	// 可压缩的数据正常压缩
+	if got := c.compress(CodecZstd, compressibleData(4*compressionSampleSize)); got == nil {
+		t.Fatal("compressible data was skipped")
+	}
+
+	// 不可压缩判定被缓存，再次请求直接返回原始数据
+	codec, wire := c.encode("hash", data, CodecZstd.mask())
+	if codec != CodecNone || !bytes.Equal(wire, data) {
+		t.Fatalf("encode = %s (%d bytes), want raw data", codec, len(wire))
+	}
+	if cached, ok := c.cache.get("hash", CodecZstd); !ok || cached != nil {
+		t.Fatalf("incompressible verdict not cached: %v, %v", cached, ok)
+	}
+	c.invalidate("hash")
+	if _, ok := c.cache.get("hash", CodecZstd); ok {
+		t.Fatal("cache entry survived invalidate")
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	c := newTestCompressor(t, DefaultCompressionCodecs, 0)
+
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+	zstded := func(data []byte) []byte {
+		return c.zstdEncoder.EncodeAll(data, nil)
+	}
+
+	tests := []struct {
+		name  string
+		codec Codec
+		data  []byte
+		want  error
+	}{
+		{name: "zstd at limit", codec: CodecZstd, data: zstded(make([]byte, MaxChunkSize))},
+		{name: "zstd over limit", codec: CodecZstd, data: zstded(make([]byte, MaxChunkSize+1)), want: ErrChunkTooLarge},
+		{name: "zstd bomb", codec: CodecZstd, data: zstded(make([]byte, 4*MaxChunkSize)), want: ErrChunkTooLarge},
+		{name: "gzip at limit", codec: CodecGzip, data: gzipped(make([]byte, MaxChunkSize))},
+		{name: "gzip over limit", codec: CodecGzip, data: gzipped(make([]byte, MaxChunkSize+1)), want: ErrChunkTooLarge},
+		{name: "gzip bomb", codec: CodecGzip, data: gzipped(make([]byte, 4*MaxChunkSize)), want: ErrChunkTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := c.decode(tt.codec, tt.data)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("err = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if len(out) != MaxChunkSize {
+				t.Fatalf("decoded %d bytes, want %d", len(out), MaxChunkSize)
+			}
+		})
+	}
+}
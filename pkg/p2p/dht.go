@@ -10,17 +10,22 @@
 //   - Put: 在 DHT 中存储键值对
 //   - Get: 从 DHT 中检索值
 //   - Announce: 向网络公告自己是某个 Chunk 的提供者
+//   - AnnounceBatch: 批量公告多个 Chunk（见 announceBatch.go）
 //   - Lookup: 查找特定 Chunk 的提供者
+//   - QueryMetaData: 向其他节点查询并校验文件元数据
 //
 // 协议定义:
 //   - p2pFileTransfer/Announce/1.0.0: Chunk 公告协议
+//   - p2pFileTransfer/Announce/2.0.0: Chunk 批量公告协议
 //   - p2pFileTransfer/Lookup/1.0.0: 提供者查询协议
+//   - p2pFileTransfer/QueryMetaData/1.0.0: 文件元数据查询协议
 package p2p
 
 import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -45,6 +50,9 @@ const (
 	writeTimeout     = 5 * time.Second
 	readTimeout      = 5 * time.Second
 	ioTimeout        = 5 * time.Second
+
+	// MaxMetaDataMessageSize 元数据响应的最大大小（包含所有叶子哈希）
+	MaxMetaDataMessageSize = 64 * 1024 * 1024 // 64MB
 )
 
 type announceMsg struct {
@@ -62,6 +70,17 @@ type lookupResponse struct {
 	Providers []peer.AddrInfo `json:"providers"`
 }
 
+// queryMetaDataRequest is sent by clients to ask for the metadata of a CID.
+type queryMetaDataRequest struct {
+	Key string `json:"key"`
+}
+
+// queryMetaDataResponse carries the metadata if the peer holds it locally.
+type queryMetaDataResponse struct {
+	Found    bool           `json:"found"`
+	MetaData *file.MetaData `json:"metadata,omitempty"`
+}
+
 // newDHT 创建一个 DHT 实例
 // 参数:
 //   - ctx: 上下文，用于控制生命周期
@@ -75,6 +94,7 @@ func newDHT(ctx context.Context, host host.Host, config P2PConfig) (*dht.IpfsDHT
 	opts := []dht.Option{
 		dht.ProtocolPrefix(protocol.ID(config.ProtocolPrefix)),
 		dht.NamespacedValidator(config.NameSpace, config.Validator),
+		dht.NamespacedValidator(MetaDataNameSpace, MetaDataValidator{}), // 元数据记录需签名校验
 	}
 
 	if !config.EnableAutoRefresh {
@@ -153,7 +173,11 @@ func newDHT(ctx context.Context, host host.Host, config P2PConfig) (*dht.IpfsDHT
 //   - error: 错误信息
 func (d *P2PService) Put(ctx context.Context, key string, value []byte) error {
 	key = "/" + d.Config.NameSpace + "/" + key
-	err := d.DHT.PutValue(ctx, key, value)
+	signed, err := d.signValue(key, value)
+	if err != nil {
+		return xerrors.Errorf("failed to sign value: %w", err)
+	}
+	err = d.DHT.PutValue(ctx, key, signed)
 	if err != nil {
 		return xerrors.Errorf("failed to put value: %w", err)
 	}
@@ -171,21 +195,204 @@ func (d *P2PService) Put(ctx context.Context, key string, value []byte) error {
 //   - error: 错误信息
 func (d *P2PService) Get(ctx context.Context, key string) (string, error) {
 	key = "/" + d.Config.NameSpace + "/" + key
-	value, err := d.DHT.GetValue(ctx, key)
+	data, err := d.DHT.GetValue(ctx, key)
 	if err != nil {
 		return "", xerrors.Errorf("failed to get value: %w", err)
 	}
+	// 记录已由 Validator 校验签名，这里只取出原始值
+	var signed SignedValue
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return "", xerrors.Errorf("failed to parse signed value: %w", err)
+	}
+	value := signed.Value
 	logrus.Infof("Retrieved value for key %s: %s", key, string(value))
 	return string(value), nil
 }
 
-// TODO : 实现 QueryMetaData 方法
+// QueryMetaData 向已连接的节点查询指定 CID 的文件元数据
+// 每个节点返回的元数据都会经过 VerifyMetaData 校验，返回第一个校验通过的结果
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - key: 文件 CID（hex 编码）
+//
+// 返回值:
+//   - *file.MetaData: 校验通过的元数据
+//   - error: 错误信息
 func (d *P2PService) QueryMetaData(ctx context.Context, key string) (*file.MetaData, error) {
-	return nil, errors.New("QueryMetaData is not implemented yet")
+	if len(key) == 0 {
+		return nil, errors.New("empty metadata key")
+	}
+
+	peers := d.Host.Network().Peers()
+	if len(peers) == 0 {
+		return nil, errors.New("no connected peers to query metadata from")
+	}
+
+	req := queryMetaDataRequest{Key: key}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+	reqBytes = append(reqBytes, '\n')
+
+	queryTimeout := DefaultDataTimeout
+	if d.Config.DataTimeout > 0 {
+		queryTimeout = time.Duration(d.Config.DataTimeout) * time.Second
+	}
+
+	var (
+		wg                    sync.WaitGroup
+		resultChan            = make(chan *file.MetaData, 1)
+		ctxQuery, cancelQuery = context.WithTimeout(ctx, queryTimeout)
+	)
+	defer cancelQuery()
+
+	for _, p := range peers {
+		wg.Add(1)
+		go func(peerID peer.ID) {
+			defer wg.Done()
+
+			metaData, err := d.queryMetaDataFromPeer(ctxQuery, peerID, reqBytes)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"peer": peerID, "key": key, "err": err}).Debug("query metadata failed")
+				return
+			}
+
+			// 校验元数据，防止恶意节点返回伪造数据
+			if err := VerifyMetaData(metaData, key); err != nil {
+				logrus.WithFields(logrus.Fields{"peer": peerID, "key": key, "err": err}).Warn("peer returned invalid metadata")
+				return
+			}
+
+			select {
+			case resultChan <- metaData: // 只发送第一个有效结果
+			default:
+			}
+		}(p)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case metaData := <-resultChan:
+		logrus.WithField("key", key).Info("metadata resolved from peers")
+		return metaData, nil
+	case <-done:
+		// 所有 goroutine 结束后再检查一次，避免与最后一个结果竞争
+		select {
+		case metaData := <-resultChan:
+			return metaData, nil
+		default:
+		}
+		return nil, fmt.Errorf("metadata for %s not found on %d peers", key, len(peers))
+	case <-ctxQuery.Done():
+		return nil, fmt.Errorf("query metadata timed out: %w", ctxQuery.Err())
+	}
+}
+
+// queryMetaDataFromPeer 通过 QueryMetaData 协议向单个节点请求元数据
+func (d *P2PService) queryMetaDataFromPeer(ctx context.Context, peerID peer.ID, reqBytes []byte) (*file.MetaData, error) {
+	peerCtx, cancel := context.WithTimeout(ctx, ioTimeout)
+	defer cancel()
+
+	s, err := d.Host.NewStream(peerCtx, peerID, QueryMetaData)
+	if err != nil {
+		return nil, fmt.Errorf("open stream: %w", err)
+	}
+	defer s.Close()
+
+	s.SetWriteDeadline(time.Now().Add(ioTimeout))
+	if _, err := s.Write(reqBytes); err != nil {
+		return nil, fmt.Errorf("write request: %w", err)
+	}
+
+	// 元数据可能包含大量叶子哈希，读取时间使用数据传输超时
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetReadDeadline(deadline)
+	}
+	rdr := bufio.NewReader(io.LimitReader(s, MaxMetaDataMessageSize))
+	line, err := rdr.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var resp queryMetaDataResponse
+	if err := json.Unmarshal(bytes.TrimSpace(line), &resp); err != nil {
+		return nil, fmt.Errorf("invalid response JSON: %w", err)
+	}
+	if !resp.Found || resp.MetaData == nil {
+		return nil, errors.New("metadata not found on peer")
+	}
+	return resp.MetaData, nil
 }
 
-func (d *P2PService) QueryMetaDataHandler() {
-	// 未实现，保留占位符
+// QueryMetaDataHandler 注册 QueryMetaData 协议处理器，从本地元数据目录返回元数据
+func (d *P2PService) QueryMetaDataHandler(ctx context.Context) {
+	d.Host.SetStreamHandler(QueryMetaData, func(s network.Stream) {
+		defer s.Close()
+
+		// 检查服务是否已关闭
+		select {
+		case <-d.Ctx.Done():
+			logrus.Debug("Service is shutting down, ignoring metadata query")
+			return
+		default:
+		}
+
+		s.SetReadDeadline(time.Now().Add(readTimeout))
+		rdr := bufio.NewReader(io.LimitReader(s, MaxAnnounceMessageSize))
+		line, err := rdr.ReadBytes('\n')
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"remotePeer": s.Conn().RemotePeer(),
+				"error":      err,
+				"action":     "read_message",
+			}).Warn("failed to read metadata query")
+			return
+		}
+
+		var req queryMetaDataRequest
+		if err := json.Unmarshal(bytes.TrimSpace(line), &req); err != nil {
+			logrus.WithError(err).Warn("invalid metadata query JSON")
+			return
+		}
+		// CID 必须是 hex 编码，防止路径穿越
+		if _, err := hex.DecodeString(req.Key); err != nil || len(req.Key) == 0 {
+			logrus.WithField("key", req.Key).Warn("invalid metadata query key")
+			return
+		}
+
+		resp := queryMetaDataResponse{}
+		metaData, err := d.LoadLocalMetaData(req.Key)
+		if err == nil {
+			resp.Found = true
+			resp.MetaData = metaData
+		} else {
+			logrus.WithFields(logrus.Fields{"key": req.Key, "err": err}).Debug("metadata not found locally")
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			logrus.WithError(err).Error("marshal metadata response failed")
+			return
+		}
+		respBytes = append(respBytes, '\n')
+
+		dataTimeout := DefaultDataTimeout
+		if d.Config.DataTimeout > 0 {
+			dataTimeout = time.Duration(d.Config.DataTimeout) * time.Second
+		}
+		s.SetWriteDeadline(time.Now().Add(dataTimeout))
+		if _, err := s.Write(respBytes); err != nil {
+			logrus.WithError(err).Error("failed to write metadata response")
+			return
+		}
+		logrus.WithFields(logrus.Fields{"key": req.Key, "found": resp.Found}).Info("metadata query served")
+	})
 }
 
 // Announce 向网络中的节点宣布一个 chunkHash
@@ -273,46 +480,12 @@ func (d *P2PService) Announce(ctx context.Context, chunkHash string) error {
 		go func(peerID peer.ID) {
 			defer wg.Done()
 
-			// 创建带超时的上下文
-			peerCtx, peerCancel := context.WithTimeout(lowerCtx, writeTimeout)
-			defer peerCancel()
-
-			// 尝试建立流
-			s, err := d.Host.NewStream(peerCtx, peerID, AnnounceProtocol)
-			if err != nil {
+			if err := d.announceToPeer(lowerCtx, peerID, chunkHash); err != nil {
 				logrus.WithFields(logrus.Fields{
 					"peer":   peerID,
 					"error":  err,
-					"action": "open_stream",
-				}).Debug("failed to open stream to peer")
-				return
-			}
-			defer s.Close()
-
-			// 准备消息
-			msg := announceMsg{
-				ChunkHash: chunkHash,
-				PeerInfo: peer.AddrInfo{
-					ID:    d.Host.ID(),
-					Addrs: d.Host.Addrs(),
-				},
-			}
-
-			data, err := json.Marshal(msg)
-			if err != nil {
-				logrus.WithError(err).Error("failed to marshal announce message")
-				return
-			}
-			data = append(data, '\n')
-
-			// 设置写超时
-			s.SetWriteDeadline(time.Now().Add(writeTimeout))
-			if _, err = s.Write(data); err != nil {
-				logrus.WithFields(logrus.Fields{
-					"peer":   peerID,
-					"error":  err,
-					"action": "write_message",
-				}).Debug("failed to write announce message")
+					"action": "announce",
+				}).Debug("failed to announce chunk to peer")
 				return
 			}
 
@@ -344,6 +517,42 @@ func (d *P2PService) Announce(ctx context.Context, chunkHash string) error {
 	}
 }
 
+// announceToPeer 通过 Announce/1.0.0 协议向单个节点公告一个 chunkHash
+func (d *P2PService) announceToPeer(ctx context.Context, peerID peer.ID, chunkHash string) error {
+	// 创建带超时的上下文
+	peerCtx, peerCancel := context.WithTimeout(ctx, writeTimeout)
+	defer peerCancel()
+
+	// 尝试建立流
+	s, err := d.Host.NewStream(peerCtx, peerID, AnnounceProtocol)
+	if err != nil {
+		return fmt.Errorf("open stream failed: %w", err)
+	}
+	defer s.Close()
+
+	// 准备消息
+	msg := announceMsg{
+		ChunkHash: chunkHash,
+		PeerInfo: peer.AddrInfo{
+			ID:    d.Host.ID(),
+			Addrs: d.Host.Addrs(),
+		},
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal announce message failed: %w", err)
+	}
+	data = append(data, '\n')
+
+	// 设置写超时
+	s.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err = s.Write(data); err != nil {
+		return fmt.Errorf("write announce message failed: %w", err)
+	}
+	return nil
+}
+
 func (d *P2PService) AnnounceHandler(ctx context.Context) {
 	d.Host.SetStreamHandler(AnnounceProtocol, func(s network.Stream) {
 		defer s.Close()
@@ -0,0 +1,485 @@
+// Package p2p 提供后台下载任务管理功能
+//
+// DownloadManager 功能:
+//   - 任务队列: 每个任务有 ID、优先级和状态，按优先级（高者优先）和创建时间调度
+//   - 并发控制: 同时运行的任务数不超过 maxActive
+//   - 暂停/继续/取消: 暂停会中断下载并保留 .part 文件和进度日志，继续时只下载缺失的 Chunk
+//   - 进度: 通过 ProgressCallback 更新已下载字节数和 Chunk 数
+//   - 持久化: 任务列表保存在 <dir>/downloads.json，重启后中断的任务重新排队
+//
+// 状态转换:
+//   - queued -> running -> done / failed
+//   - queued / running -> paused -> queued（Resume）
+//   - failed -> queued（Resume 即重试）
+//   - 任意状态 -> 删除（Cancel，未完成的任务同时删除临时文件）
+//
+// 注意事项:
+//   - 下载基于 DownloadFileResumable，目标文件为 <dir>/<文件名>，与已有文件重名时追加序号
+//   - 文件名未指定时使用元数据中的文件名，在任务开始运行时确定
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxActiveDownloads 默认同时运行的下载任务数
+	DefaultMaxActiveDownloads = 2
+
+	// downloadStateFile 任务列表的持久化文件名
+	downloadStateFile = "downloads.json"
+
+	// downloadSaveInterval 进度更新时持久化的最小间隔（状态变化时立即保存）
+	downloadSaveInterval = 5 * time.Second
+)
+
+var (
+	// ErrDownloadJobNotFound 任务不存在
+	ErrDownloadJobNotFound = errors.New("download job not found")
+
+	// ErrInvalidJobState 任务当前状态不允许该操作
+	ErrInvalidJobState = errors.New("invalid download job state")
+)
+
+// DownloadState 下载任务状态
+type DownloadState string
+
+const (
+	DownloadQueued  DownloadState = "queued"
+	DownloadRunning DownloadState = "running"
+	DownloadPaused  DownloadState = "paused"
+	DownloadFailed  DownloadState = "failed"
+	DownloadDone    DownloadState = "done"
+)
+
+// DownloadJob 下载任务
+type DownloadJob struct {
+	ID              string        `json:"id"`
+	CID             string        `json:"cid"`
+	FileName        string        `json:"fileName,omitempty"`
+	TargetPath      string        `json:"targetPath,omitempty"`
+	Priority        int           `json:"priority"`
+	State           DownloadState `json:"state"`
+	Downloaded      int64         `json:"downloaded"`
+	Total           int64         `json:"total"`
+	CompletedChunks int           `json:"completedChunks"`
+	TotalChunks     int           `json:"totalChunks"`
+	Error           string        `json:"error,omitempty"`
+	CreatedAt       time.Time     `json:"createdAt"`
+	UpdatedAt       time.Time     `json:"updatedAt"`
+}
+
+// DownloadManager 管理后台下载任务
+type DownloadManager struct {
+	p         *P2PService
+	dir       string
+	maxActive int
+
+	mu       sync.Mutex
+	jobs     map[string]*DownloadJob
+	running  map[string]context.CancelFunc // 正在运行（goroutine 尚未退出）的任务
+	lastSave time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDownloadManager 创建下载管理器，加载持久化的任务并开始调度
+// 参数:
+//   - p: P2P 服务
+//   - dir: 下载目录，同时保存任务列表
+//   - maxActive: 同时运行的任务数，<= 0 时使用 DefaultMaxActiveDownloads
+//
+// 返回值:
+//   - *DownloadManager: 下载管理器
+//   - error: 创建目录或读取任务列表失败时返回错误
+func NewDownloadManager(p *P2PService, dir string, maxActive int) (*DownloadManager, error) {
+	if maxActive <= 0 {
+		maxActive = DefaultMaxActiveDownloads
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(p.Ctx)
+	m := &DownloadManager{
+		p:         p,
+		dir:       dir,
+		maxActive: maxActive,
+		jobs:      make(map[string]*DownloadJob),
+		running:   make(map[string]context.CancelFunc),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if err := m.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.scheduleLocked()
+	m.mu.Unlock()
+	return m, nil
+}
+
+// load 读取持久化的任务列表，上次退出时运行中的任务重新排队
+func (m *DownloadManager) load() error {
+	data, err := os.ReadFile(filepath.Join(m.dir, downloadStateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read download jobs: %w", err)
+	}
+
+	var jobs []*DownloadJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return fmt.Errorf("failed to parse download jobs: %w", err)
+	}
+	for _, job := range jobs {
+		if job.State == DownloadRunning {
+			job.State = DownloadQueued
+		}
+		m.jobs[job.ID] = job
+	}
+	logrus.Infof("Loaded %d download jobs from %s", len(jobs), m.dir)
+	return nil
+}
+
+// saveLocked 原子写入任务列表（调用方持有 m.mu）
+func (m *DownloadManager) saveLocked() {
+	m.lastSave = time.Now()
+	data, err := json.MarshalIndent(m.sortedLocked(), "", "  ")
+	if err != nil {
+		logrus.Errorf("Failed to marshal download jobs: %v", err)
+		return
+	}
+	path := filepath.Join(m.dir, downloadStateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		logrus.Errorf("Failed to save download jobs: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logrus.Errorf("Failed to save download jobs: %v", err)
+	}
+}
+
+// sortedLocked 按优先级（高者优先）和创建时间排序的任务列表
+func (m *DownloadManager) sortedLocked() []*DownloadJob {
+	jobs := make([]*DownloadJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// scheduleLocked 按优先级启动排队的任务，直到运行数达到上限（调用方持有 m.mu）
+func (m *DownloadManager) scheduleLocked() {
+	if m.ctx.Err() != nil {
+		return
+	}
+	for _, job := range m.sortedLocked() {
+		if len(m.running) >= m.maxActive {
+			return
+		}
+		// 暂停后立即继续的任务要等上一次运行退出
+		if job.State != DownloadQueued || m.running[job.ID] != nil {
+			continue
+		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.running[job.ID] = cancel
+		job.State = DownloadRunning
+		job.Error = ""
+		job.UpdatedAt = time.Now()
+		m.wg.Add(1)
+		go m.run(ctx, job.ID)
+	}
+}
+
+// run 执行一个任务，结束后更新状态并调度下一个任务
+func (m *DownloadManager) run(ctx context.Context, id string) {
+	defer m.wg.Done()
+	m.mu.Lock()
+	m.saveLocked()
+	m.mu.Unlock()
+
+	target, err := m.download(ctx, id)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, id)
+
+	job, ok := m.jobs[id]
+	switch {
+	case !ok:
+		// 已取消: 下载已退出，可以安全删除临时文件
+		if target != "" {
+			removePartialDownload(target)
+		}
+	case job.State != DownloadRunning:
+		// 已暂停，保留临时文件
+	case err == nil:
+		job.State = DownloadDone
+		job.Downloaded = job.Total
+		job.CompletedChunks = job.TotalChunks
+		logrus.Infof("Download job %s finished: %s", id, job.TargetPath)
+	case m.ctx.Err() != nil:
+		// 管理器关闭，下次启动时继续
+		job.State = DownloadQueued
+	default:
+		job.State = DownloadFailed
+		job.Error = err.Error()
+		logrus.Warnf("Download job %s failed: %v", id, err)
+	}
+	if ok {
+		job.UpdatedAt = time.Now()
+	}
+	m.saveLocked()
+	m.scheduleLocked()
+}
+
+// download 加载元数据、确定目标路径并下载，返回目标路径（尚未确定时为空）
+func (m *DownloadManager) download(ctx context.Context, id string) (string, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return "", ErrDownloadJobNotFound
+	}
+	cid, target := job.CID, job.TargetPath
+	m.mu.Unlock()
+
+	metaData, err := m.p.loadMetaData(ctx, cid)
+	if err != nil {
+		return target, err
+	}
+
+	m.mu.Lock()
+	job, ok = m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return target, ErrDownloadJobNotFound
+	}
+	if job.TargetPath == "" {
+		job.FileName = m.uniqueNameLocked(metaData.FileName, cid)
+		job.TargetPath = filepath.Join(m.dir, job.FileName)
+	}
+	job.Total = int64(metaData.FileSize)
+	job.TotalChunks = len(metaData.Leaves)
+	target = job.TargetPath
+	m.saveLocked()
+	m.mu.Unlock()
+
+	return target, m.p.downloadResumable(ctx, cid, metaData, target, func(downloaded, total int64, chunkIndex, totalChunks int) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		job, ok := m.jobs[id]
+		if !ok {
+			return
+		}
+		job.Downloaded = downloaded
+		job.CompletedChunks = chunkIndex
+		job.UpdatedAt = time.Now()
+		if time.Since(m.lastSave) >= downloadSaveInterval {
+			m.saveLocked()
+		}
+	})
+}
+
+// uniqueNameLocked 在下载目录中选择不冲突的文件名（调用方持有 m.mu）
+func (m *DownloadManager) uniqueNameLocked(name, cid string) string {
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "." || name == string(filepath.Separator) || name == "" || name == downloadStateFile {
+		name = cid
+	}
+
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		if !m.nameTakenLocked(candidate) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+	}
+}
+
+func (m *DownloadManager) nameTakenLocked(name string) bool {
+	path := filepath.Join(m.dir, name)
+	for _, job := range m.jobs {
+		if job.TargetPath == path {
+			return true
+		}
+	}
+	for _, p := range []string{path, path + PartFileSuffix} {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// removePartialDownload 删除未完成下载的临时文件和进度日志
+func removePartialDownload(target string) {
+	for _, p := range []string{target + PartFileSuffix, target + JournalFileSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("Failed to remove %s: %v", p, err)
+		}
+	}
+}
+
+// Add 添加下载任务
+// 参数:
+//   - cid: 文件 CID（hex 编码）
+//   - fileName: 保存的文件名（可为空，使用元数据中的文件名）；只取最后一级，不能指定目录
+//   - priority: 优先级，数值大的先运行
+//
+// 返回值:
+//   - DownloadJob: 新任务的快照
+//   - error: CID 格式错误时返回错误
+func (m *DownloadManager) Add(cid, fileName string, priority int) (DownloadJob, error) {
+	cid = strings.ToLower(strings.TrimSpace(cid))
+	if _, err := hex.DecodeString(cid); err != nil || cid == "" {
+		return DownloadJob{}, fmt.Errorf("invalid CID: %s", cid)
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return DownloadJob{}, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	job := &DownloadJob{
+		ID:        hex.EncodeToString(idBytes),
+		CID:       cid,
+		Priority:  priority,
+		State:     DownloadQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if fileName != "" {
+		job.FileName = m.uniqueNameLocked(fileName, cid)
+		job.TargetPath = filepath.Join(m.dir, job.FileName)
+	}
+	m.jobs[job.ID] = job
+	logrus.Infof("Download job %s added: cid=%s priority=%d", job.ID, cid, priority)
+
+	m.scheduleLocked()
+	m.saveLocked()
+	return *job, nil
+}
+
+// Get 返回任务快照
+func (m *DownloadManager) Get(id string) (DownloadJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return DownloadJob{}, ErrDownloadJobNotFound
+	}
+	return *job, nil
+}
+
+// List 返回所有任务的快照，按优先级和创建时间排序
+func (m *DownloadManager) List() []DownloadJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]DownloadJob, 0, len(m.jobs))
+	for _, job := range m.sortedLocked() {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// Pause 暂停排队或运行中的任务，保留已下载的数据
+func (m *DownloadManager) Pause(id string) (DownloadJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return DownloadJob{}, ErrDownloadJobNotFound
+	}
+	if job.State != DownloadQueued && job.State != DownloadRunning {
+		return *job, fmt.Errorf("%w: cannot pause %s job", ErrInvalidJobState, job.State)
+	}
+	job.State = DownloadPaused
+	job.UpdatedAt = time.Now()
+	if cancel := m.running[id]; cancel != nil {
+		cancel()
+	}
+	m.saveLocked()
+	m.scheduleLocked()
+	return *job, nil
+}
+
+// Resume 继续暂停的任务或重试失败的任务
+func (m *DownloadManager) Resume(id string) (DownloadJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return DownloadJob{}, ErrDownloadJobNotFound
+	}
+	if job.State != DownloadPaused && job.State != DownloadFailed {
+		return *job, fmt.Errorf("%w: cannot resume %s job", ErrInvalidJobState, job.State)
+	}
+	job.State = DownloadQueued
+	job.Error = ""
+	job.UpdatedAt = time.Now()
+	m.scheduleLocked()
+	m.saveLocked()
+	return *job, nil
+}
+
+// Cancel 取消并删除任务；未完成的任务同时删除临时文件，已完成的文件保留
+func (m *DownloadManager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return ErrDownloadJobNotFound
+	}
+	delete(m.jobs, id)
+	if cancel := m.running[id]; cancel != nil {
+		// 临时文件由 run 在下载退出后删除
+		cancel()
+	} else if job.State != DownloadDone && job.TargetPath != "" {
+		removePartialDownload(job.TargetPath)
+	}
+	logrus.Infof("Download job %s canceled", id)
+	m.saveLocked()
+	m.scheduleLocked()
+	return nil
+}
+
+// Close 停止所有运行中的任务并保存任务列表，运行中的任务在下次启动时继续
+func (m *DownloadManager) Close() {
+	m.cancel()
+	m.wg.Wait()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saveLocked()
+}
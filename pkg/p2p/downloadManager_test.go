@@ -0,0 +1,397 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/file"
+)
+
+const testDownloadChunkSize = 1024
+
+// seedTestFile 在 seeder 上保存 content 的 Chunk 和元数据，并在 leecher 的提供者存储中记录 seeder
+// （相当于 leecher 收到了 seeder 的公告），返回文件 CID 和元数据
+func seedTestFile(t *testing.T, seeder, leecher *P2PService, name string, content []byte) (string, *file.MetaData) {
+	t.Helper()
+	metaData := testResumeMetaData(content, testDownloadChunkSize, -1)
+	metaData.FileName = name
+	metaData.TreeType = TreeTypeRegular
+	chunks := make([]Chunk, len(metaData.Leaves))
+	for i, leaf := range metaData.Leaves {
+		chunks[i] = Chunk{Hash: leaf.ChunkHash}
+	}
+	metaData.RootHash = BuildMerkleRoot(chunks)
+	cid := hex.EncodeToString(metaData.RootHash)
+
+	keys := []string{cid}
+	for i := range metaData.Leaves {
+		end := min((i+1)*testDownloadChunkSize, len(content))
+		keys = append(keys, putTestChunk(t, seeder, content[i*testDownloadChunkSize:end]))
+	}
+	data, err := json.Marshal(metaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(seeder.getMetaDataPath(cid), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info := peer.AddrInfo{ID: seeder.Host.ID(), Addrs: seeder.Host.Addrs()}
+	for _, key := range keys {
+		if err := leecher.DHT.ProviderStore().AddProvider(context.Background(), []byte(key), info); err != nil {
+			t.Fatalf("AddProvider: %v", err)
+		}
+	}
+	return cid, metaData
+}
+
+// countingStore 记录每个 Chunk 被读取的次数
+type countingStore struct {
+	chunkStore.ChunkStore
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (c *countingStore) Get(hash string) ([]byte, error) {
+	c.mu.Lock()
+	c.gets[hash]++
+	c.mu.Unlock()
+	return c.ChunkStore.Get(hash)
+}
+
+func (c *countingStore) snapshot() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int, len(c.gets))
+	for hash, n := range c.gets {
+		out[hash] = n
+	}
+	return out
+}
+
+func newTestDownloadManager(t *testing.T, p *P2PService, dir string, maxActive int) *DownloadManager {
+	t.Helper()
+	m, err := NewDownloadManager(p, dir, maxActive)
+	if err != nil {
+		t.Fatalf("NewDownloadManager: %v", err)
+	}
+	t.Cleanup(m.Close)
+	return m
+}
+
+// waitJob 等待任务满足 cond，超时时报告最后的状态
+func waitJob(t *testing.T, m *DownloadManager, id string, what string, cond func(DownloadJob) bool) DownloadJob {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("Get %s: %v", id, err)
+		}
+		if cond(job) {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s not %s: state=%s chunks=%d/%d err=%q", id, what, job.State, job.CompletedChunks, job.TotalChunks, job.Error)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func waitJobState(t *testing.T, m *DownloadManager, id string, state DownloadState) DownloadJob {
+	t.Helper()
+	return waitJob(t, m, id, string(state), func(job DownloadJob) bool { return job.State == state })
+}
+
+// waitIdle 等待所有任务的 goroutine 退出
+func waitIdle(t *testing.T, m *DownloadManager) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		m.mu.Lock()
+		running := len(m.running)
+		m.mu.Unlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d jobs still running", running)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func readPersistedJobs(t *testing.T, dir string) map[string]DownloadJob {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, downloadStateFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jobs []DownloadJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]DownloadJob, len(jobs))
+	for _, job := range jobs {
+		out[job.ID] = job
+	}
+	return out
+}
+
+func TestDownloadManagerCompletes(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	connectServices(t, a, b)
+	content := randomData(4*testDownloadChunkSize-100, 1)
+	cid, _ := seedTestFile(t, b, a, "report.bin", content)
+
+	dir := t.TempDir()
+	m := newTestDownloadManager(t, a, dir, 0)
+	job, err := m.Add(cid, "", 0)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	job = waitJobState(t, m, job.ID, DownloadDone)
+
+	if job.FileName != "report.bin" || job.TargetPath != filepath.Join(dir, "report.bin") {
+		t.Fatalf("target = %q (%q), want metadata file name", job.TargetPath, job.FileName)
+	}
+	if job.Downloaded != int64(len(content)) || job.Total != int64(len(content)) || job.CompletedChunks != 4 || job.TotalChunks != 4 {
+		t.Fatalf("progress = %d/%d bytes, %d/%d chunks", job.Downloaded, job.Total, job.CompletedChunks, job.TotalChunks)
+	}
+	got, err := os.ReadFile(job.TargetPath)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("downloaded file mismatch: %v", err)
+	}
+	for _, suffix := range []string{PartFileSuffix, JournalFileSuffix} {
+		if _, err := os.Stat(job.TargetPath + suffix); !os.IsNotExist(err) {
+			t.Fatalf("%s left behind", suffix)
+		}
+	}
+
+	waitIdle(t, m)
+	if persisted := readPersistedJobs(t, dir)[job.ID]; persisted.State != DownloadDone {
+		t.Fatalf("persisted state = %s, want done", persisted.State)
+	}
+}
+
+func TestDownloadManagerPauseResume(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	connectServices(t, a, b)
+	content := randomData(4*testDownloadChunkSize, 2)
+	cid, metaData := seedTestFile(t, b, a, "paused.bin", content)
+
+	// 最后一个 Chunk 在放行前阻塞，其余 Chunk 先完成
+	lastHash := hex.EncodeToString(metaData.Leaves[3].ChunkHash)
+	counter := &countingStore{ChunkStore: b.ChunkStore, gets: make(map[string]int)}
+	gate := newGatedStore(counter, lastHash)
+	b.ChunkStore = gate
+
+	m := newTestDownloadManager(t, a, t.TempDir(), 0)
+	job, err := m.Add(cid, "", 0)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	gate.waitEntered(t, 1)
+	waitJob(t, m, job.ID, "3 chunks complete", func(job DownloadJob) bool { return job.CompletedChunks == 3 })
+
+	job, err = m.Pause(job.ID)
+	if err != nil || job.State != DownloadPaused {
+		t.Fatalf("Pause = %s, %v", job.State, err)
+	}
+	waitIdle(t, m)
+	if job, _ = m.Get(job.ID); job.State != DownloadPaused {
+		t.Fatalf("state after pause = %s", job.State)
+	}
+
+	// 暂停保留 .part 文件和日志，目标文件尚未生成
+	for _, suffix := range []string{PartFileSuffix, JournalFileSuffix} {
+		if _, err := os.Stat(job.TargetPath + suffix); err != nil {
+			t.Fatalf("%s missing after pause: %v", suffix, err)
+		}
+	}
+	if _, err := os.Stat(job.TargetPath); !os.IsNotExist(err) {
+		t.Fatal("target created by paused download")
+	}
+
+	close(gate.release)
+	before := counter.snapshot()
+	if _, err := m.Resume(job.ID); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	job = waitJobState(t, m, job.ID, DownloadDone)
+	got, err := os.ReadFile(job.TargetPath)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("resumed file mismatch: %v", err)
+	}
+
+	// 续传只下载缺失的 Chunk
+	after := counter.snapshot()
+	for i, leaf := range metaData.Leaves[:3] {
+		hash := hex.EncodeToString(leaf.ChunkHash)
+		if after[hash] != before[hash] {
+			t.Fatalf("chunk %d downloaded again after resume (%d -> %d)", i, before[hash], after[hash])
+		}
+	}
+	if after[lastHash] <= before[lastHash] {
+		t.Fatal("missing chunk not downloaded after resume")
+	}
+
+	if _, err := m.Resume(job.ID); err == nil {
+		t.Fatal("Resume of a done job succeeded")
+	}
+}
+
+func TestDownloadManagerPriorityAndMaxActive(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	connectServices(t, a, b)
+	first, firstMeta := seedTestFile(t, b, a, "first.bin", randomData(2*testDownloadChunkSize, 3))
+	low, _ := seedTestFile(t, b, a, "low.bin", randomData(2*testDownloadChunkSize, 4))
+	high, highMeta := seedTestFile(t, b, a, "high.bin", randomData(2*testDownloadChunkSize, 5))
+
+	firstGate := newGatedStore(b.ChunkStore, hex.EncodeToString(firstMeta.Leaves[0].ChunkHash))
+	highGate := newGatedStore(firstGate, hex.EncodeToString(highMeta.Leaves[0].ChunkHash))
+	b.ChunkStore = highGate
+
+	m := newTestDownloadManager(t, a, t.TempDir(), 1)
+	firstJob, err := m.Add(first, "", 0)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	firstGate.waitEntered(t, 1)
+	lowJob, _ := m.Add(low, "", 1)
+	highJob, _ := m.Add(high, "", 5)
+
+	// 运行数达到上限，新任务排队
+	for _, tc := range []struct {
+		id    string
+		state DownloadState
+	}{{firstJob.ID, DownloadRunning}, {lowJob.ID, DownloadQueued}, {highJob.ID, DownloadQueued}} {
+		if job, _ := m.Get(tc.id); job.State != tc.state {
+			t.Fatalf("job %s state = %s, want %s", job.FileName, job.State, tc.state)
+		}
+	}
+	var order []string
+	for _, job := range m.List() {
+		order = append(order, job.ID)
+	}
+	if len(order) != 3 || order[0] != highJob.ID || order[1] != lowJob.ID || order[2] != firstJob.ID {
+		t.Fatalf("List order = %v, want high, low, first", order)
+	}
+
+	// 第一个任务完成后先运行优先级高的任务
+	close(firstGate.release)
+	waitJobState(t, m, firstJob.ID, DownloadDone)
+	highGate.waitEntered(t, 1)
+	if job, _ := m.Get(highJob.ID); job.State != DownloadRunning {
+		t.Fatalf("high priority job state = %s, want running", job.State)
+	}
+	if job, _ := m.Get(lowJob.ID); job.State != DownloadQueued {
+		t.Fatalf("low priority job state = %s, want queued", job.State)
+	}
+
+	close(highGate.release)
+	waitJobState(t, m, highJob.ID, DownloadDone)
+	waitJobState(t, m, lowJob.ID, DownloadDone)
+}
+
+func TestDownloadManagerRestoresState(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	connectServices(t, a, b)
+	content := randomData(3*testDownloadChunkSize, 6)
+	cid, metaData := seedTestFile(t, b, a, "restart.bin", content)
+	gate := newGatedStore(b.ChunkStore, hex.EncodeToString(metaData.Leaves[2].ChunkHash))
+	b.ChunkStore = gate
+
+	dir := t.TempDir()
+	m1, err := NewDownloadManager(a, dir, 0)
+	if err != nil {
+		t.Fatalf("NewDownloadManager: %v", err)
+	}
+	job, err := m1.Add(cid, "", 0)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	gate.waitEntered(t, 1)
+	waitJob(t, m1, job.ID, "2 chunks complete", func(job DownloadJob) bool { return job.CompletedChunks == 2 })
+	paused, _ := m1.Add(cid, "paused.bin", 0)
+	m1.Pause(paused.ID)
+
+	// 模拟进程崩溃: 任务列表停留在运行中的状态
+	m1.mu.Lock()
+	m1.saveLocked()
+	crashed, err := os.ReadFile(filepath.Join(dir, downloadStateFile))
+	m1.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1.Close()
+	if err := os.WriteFile(filepath.Join(dir, downloadStateFile), crashed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if state := readPersistedJobs(t, dir)[job.ID].State; state != DownloadRunning {
+		t.Fatalf("persisted state = %s, want running", state)
+	}
+
+	// 加载时运行中的任务重新排队，其他状态保持不变
+	loaded := &DownloadManager{dir: dir, jobs: make(map[string]*DownloadJob)}
+	if err := loaded.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if state := loaded.jobs[job.ID].State; state != DownloadQueued {
+		t.Fatalf("loaded state = %s, want queued", state)
+	}
+	if state := loaded.jobs[paused.ID].State; state != DownloadPaused {
+		t.Fatalf("loaded paused job state = %s", state)
+	}
+
+	// 新的管理器继续下载，只需要未完成的 Chunk
+	close(gate.release)
+	m2 := newTestDownloadManager(t, a, dir, 0)
+	job = waitJobState(t, m2, job.ID, DownloadDone)
+	got, err := os.ReadFile(job.TargetPath)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("restored download mismatch: %v", err)
+	}
+	if job, _ := m2.Get(paused.ID); job.State != DownloadPaused {
+		t.Fatalf("paused job state after restart = %s", job.State)
+	}
+}
+
+func TestDownloadManagerUniqueName(t *testing.T) {
+	dir := t.TempDir()
+	m := &DownloadManager{dir: dir, jobs: make(map[string]*DownloadJob)}
+	for _, name := range []string{"taken.txt", "taken (1).txt", "noext", "partial.bin" + PartFileSuffix} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.jobs["job"] = &DownloadJob{ID: "job", TargetPath: filepath.Join(dir, "queued.iso")}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"free.txt", "free.txt"},
+		{"taken.txt", "taken (2).txt"},
+		{"noext", "noext (1)"},
+		{"partial.bin", "partial (1).bin"},
+		{"queued.iso", "queued (1).iso"},
+		{"../../etc/evil.txt", "evil.txt"},
+		{"  ", "abcd"},
+		{downloadStateFile, "abcd"},
+	}
+	for _, tt := range tests {
+		if got := m.uniqueNameLocked(tt.name, "abcd"); got != tt.want {
+			t.Errorf("uniqueNameLocked(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
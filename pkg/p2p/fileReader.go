@@ -0,0 +1,317 @@
+// Package p2p 提供可随机访问的文件读取器
+//
+// OpenFile 功能:
+//   - 按需获取: Read 只获取覆盖当前读取位置的 Chunk，本地已有（且哈希正确）的 Chunk 直接读取
+//   - 预取窗口: 读取第 i 个 Chunk 时在后台预取 i+1 .. i+ReadAheadChunks
+//   - LRU 缓存: 保留最近使用的 ReaderCacheChunks 个 Chunk，播放器来回拖动时无需重复下载
+//   - 随机访问: 实现 io.ReadSeekCloser，Seek 只移动读取位置，不触发下载
+//
+// 注意事项:
+//   - 做种者查找和可用性表在第一次需要从网络获取时才建立，本地完整的文件不访问 DHT
+//   - Seek 后预取窗口之外仍在下载的 Chunk 会被取消
+//   - 传入 OpenFile 的 ctx 在读取器的整个生命周期内有效，取消后 Read 返回错误
+package p2p
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/file"
+)
+
+const (
+	// DefaultReadAheadChunks 读取器默认预取的 Chunk 数量
+	DefaultReadAheadChunks = 4
+
+	// DefaultReaderCacheChunks 读取器默认缓存的 Chunk 数量
+	DefaultReaderCacheChunks = 16
+)
+
+// readerFetch 一个 Chunk 的获取结果，done 关闭后 data/err 可读
+type readerFetch struct {
+	done   chan struct{}
+	data   []byte
+	err    error
+	cancel context.CancelFunc
+}
+
+// readerChunk LRU 中的一个 Chunk
+type readerChunk struct {
+	index int
+	data  []byte
+}
+
+// fileReader OpenFile 返回的读取器
+type fileReader struct {
+	p         *P2PService
+	ctx       context.Context
+	cancel    context.CancelFunc
+	fileHash  string
+	tasks     []chunkTask
+	size      int64
+	readAhead int
+	cacheSize int
+	retryCfg  *retryConfig
+
+	// 网络来源（首次从网络获取时建立）
+	sourcesOnce  sync.Once
+	avail        *availabilityMap
+	probeSeeders []peer.ID
+
+	mu       sync.Mutex
+	pos      int64
+	closed   bool
+	lru      *list.List // 前端为最近使用，元素为 *readerChunk
+	cache    map[int]*list.Element
+	inflight map[int]*readerFetch
+	wg       sync.WaitGroup
+}
+
+// OpenFile 打开网络中的文件，返回可随机访问的读取器
+// 参数:
+//   - ctx: 上下文，控制读取器的生命周期（包括后台预取）
+//   - fileHash: 文件 CID（hex 编码）
+//
+// 返回值:
+//   - io.ReadSeekCloser: 读取器，使用完毕后必须 Close
+//   - error: 元数据加载失败时返回错误
+func (p *P2PService) OpenFile(ctx context.Context, fileHash string) (io.ReadSeekCloser, error) {
+	metaData, err := p.loadMetaData(ctx, fileHash)
+	if err != nil {
+		return nil, err
+	}
+	return p.openFileReader(ctx, fileHash, metaData), nil
+}
+
+// openFileReader 使用已加载的元数据创建读取器（见 OpenFile）
+func (p *P2PService) openFileReader(ctx context.Context, fileHash string, metaData *file.MetaData) *fileReader {
+	readAhead := p.Config.ReadAheadChunks
+	if readAhead < 0 {
+		readAhead = 0
+	}
+	cacheSize := p.Config.ReaderCacheChunks
+	if cacheSize <= 0 {
+		cacheSize = DefaultReaderCacheChunks
+	}
+	// 缓存至少能容纳当前 Chunk 和整个预取窗口，否则预取的数据会在读取前被淘汰
+	cacheSize = max(cacheSize, readAhead+1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &fileReader{
+		p:         p,
+		ctx:       ctx,
+		cancel:    cancel,
+		fileHash:  fileHash,
+		tasks:     p.buildFileChunkTasks(fileHash, metaData),
+		size:      int64(metaData.FileSize),
+		readAhead: readAhead,
+		cacheSize: cacheSize,
+		retryCfg:  p.getRetryConfig(),
+		lru:       list.New(),
+		cache:     make(map[int]*list.Element),
+		inflight:  make(map[int]*readerFetch),
+	}
+}
+
+// Read 从当前位置读取数据，每次最多返回一个 Chunk 内的数据
+func (r *fileReader) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, os.ErrClosed
+	}
+	if r.pos >= r.size {
+		r.mu.Unlock()
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		r.mu.Unlock()
+		return 0, nil
+	}
+	pos := r.pos
+	index := r.leafAt(pos)
+	f := r.fetchLocked(index)
+	r.prefetchLocked(index)
+	r.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	skip := pos - r.tasks[index].offset
+	if skip >= int64(len(f.data)) {
+		return 0, fmt.Errorf("chunk %d is shorter than expected: %w", index, io.ErrUnexpectedEOF)
+	}
+	data := f.data[skip:]
+	if remaining := r.size - pos; int64(len(data)) > remaining {
+		data = data[:remaining]
+	}
+	n := copy(b, data)
+
+	r.mu.Lock()
+	r.pos = pos + int64(n)
+	r.mu.Unlock()
+	return n, nil
+}
+
+// Seek 设置下一次 Read 的位置，允许超出文件末尾（之后的 Read 返回 io.EOF）
+func (r *fileReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.pos + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = abs
+	return abs, nil
+}
+
+// Close 取消所有后台获取并释放缓存
+func (r *fileReader) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.lru.Init()
+	r.cache = make(map[int]*list.Element)
+	r.mu.Unlock()
+
+	r.cancel()
+	r.wg.Wait()
+	if r.avail != nil {
+		r.p.releaseAvailability(r.fileHash, r.avail)
+	}
+	return nil
+}
+
+// leafAt 返回覆盖文件偏移 pos 的叶子索引（pos 必须小于文件大小）
+func (r *fileReader) leafAt(pos int64) int {
+	return sort.Search(len(r.tasks), func(i int) bool {
+		return r.tasks[i].offset+int64(r.tasks[i].chunk.ChunkSize) > pos
+	})
+}
+
+// fetchLocked 返回 index 的获取结果：命中缓存时立即完成，否则复用或发起后台获取
+func (r *fileReader) fetchLocked(index int) *readerFetch {
+	if elem, ok := r.cache[index]; ok {
+		r.lru.MoveToFront(elem)
+		f := &readerFetch{done: make(chan struct{}), data: elem.Value.(*readerChunk).data}
+		close(f.done)
+		return f
+	}
+	if f, ok := r.inflight[index]; ok {
+		return f
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	f := &readerFetch{done: make(chan struct{}), cancel: cancel}
+	r.inflight[index] = f
+	r.wg.Add(1)
+	go r.fetch(ctx, index, f)
+	return f
+}
+
+// prefetchLocked 取消预取窗口之外的获取，并为窗口内未缓存的 Chunk 发起获取
+func (r *fileReader) prefetchLocked(index int) {
+	last := min(index+r.readAhead, len(r.tasks)-1)
+	for i, f := range r.inflight {
+		if i < index || i > last {
+			f.cancel()
+			delete(r.inflight, i)
+		}
+	}
+	for i := index + 1; i <= last; i++ {
+		// 叶子可能因 Merkle 树补齐而位于文件末尾之后
+		if r.tasks[i].offset >= r.size {
+			break
+		}
+		if _, ok := r.cache[i]; !ok {
+			r.fetchLocked(i)
+		}
+	}
+}
+
+// fetch 获取一个 Chunk，成功后放入缓存
+func (r *fileReader) fetch(ctx context.Context, index int, f *readerFetch) {
+	defer r.wg.Done()
+	defer close(f.done)
+	defer f.cancel()
+
+	f.data, f.err = r.loadChunk(ctx, index)
+	if f.err != nil && ctx.Err() == nil {
+		logrus.Warnf("Reader failed to fetch chunk %d of %s: %v", index, r.fileHash, f.err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inflight[index] == f {
+		delete(r.inflight, index)
+	}
+	if f.err == nil && !r.closed {
+		r.addCacheLocked(index, f.data)
+	}
+}
+
+// loadChunk 优先读取本地 Chunk，本地不存在或损坏时从网络获取
+func (r *fileReader) loadChunk(ctx context.Context, index int) ([]byte, error) {
+	task := r.tasks[index]
+	// 轻量元数据的叶子没有哈希，无法查找本地 chunk
+	if task.chunkHashStr != "" {
+		if data, err := r.p.ChunkStore.Get(task.chunkHashStr); err == nil {
+			hash := sha256.Sum256(data)
+			if bytesEqual(hash[:], task.chunk.ChunkHash) {
+				return data, nil
+			}
+			logrus.Warnf("Local chunk %s is corrupted, fetching from network", task.chunkHashStr)
+		}
+	}
+
+	r.sourcesOnce.Do(func() {
+		r.avail, r.probeSeeders = r.p.prepareChunkSources(r.ctx, r.fileHash, r.tasks)
+	})
+	return r.p.fetchChunk(ctx, task, r.avail, r.probeSeeders, r.retryCfg)
+}
+
+// addCacheLocked 放入缓存，超出容量时淘汰最久未使用的 Chunk
+func (r *fileReader) addCacheLocked(index int, data []byte) {
+	if elem, ok := r.cache[index]; ok {
+		r.lru.MoveToFront(elem)
+		return
+	}
+	r.cache[index] = r.lru.PushFront(&readerChunk{index: index, data: data})
+	for r.lru.Len() > r.cacheSize {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.cache, oldest.Value.(*readerChunk).index)
+	}
+}
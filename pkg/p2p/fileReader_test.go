@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readerTestContent 6 个 4 字节的 Chunk，最后一个不满
+var readerTestContent = []byte("0123456789abcdefghijklm")
+
+func TestFileReaderSeekAcrossChunks(t *testing.T) {
+	service := newTestService(t)
+	metaData := testResumeMetaData(readerTestContent, 4, -1)
+	for _, leaf := range metaData.Leaves {
+		offset := leaf.Index * 4
+		putTestChunk(t, service, readerTestContent[offset:min(offset+4, len(readerTestContent))])
+	}
+
+	r := service.openFileReader(context.Background(), "", metaData)
+	defer r.Close()
+
+	all, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(all, readerTestContent) {
+		t.Fatalf("ReadAll = %q, %v", all, err)
+	}
+
+	// 跨越 Chunk 边界的读取
+	tests := []struct {
+		offset int64
+		whence int
+		n      int
+		want   string
+	}{
+		{3, io.SeekStart, 6, "345678"},
+		{2, io.SeekCurrent, 5, "bcdef"},
+		{-4, io.SeekEnd, 4, "jklm"},
+		{7, io.SeekStart, 13, "789abcdefghij"},
+	}
+	for _, tt := range tests {
+		if _, err := r.Seek(tt.offset, tt.whence); err != nil {
+			t.Fatalf("Seek(%d, %d): %v", tt.offset, tt.whence, err)
+		}
+		buf := make([]byte, tt.n)
+		if _, err := io.ReadFull(r, buf); err != nil || string(buf) != tt.want {
+			t.Fatalf("after Seek(%d, %d): read %q, %v; want %q", tt.offset, tt.whence, buf, err, tt.want)
+		}
+	}
+
+	// 每次 Read 最多返回一个 Chunk 内的数据
+	r.Seek(2, io.SeekStart)
+	buf := make([]byte, 10)
+	if n, err := r.Read(buf); err != nil || n != 2 {
+		t.Fatalf("Read at chunk tail = %d, %v; want 2", n, err)
+	}
+
+	// 超出末尾的位置之后读取返回 EOF，负位置返回错误
+	if pos, err := r.Seek(100, io.SeekStart); err != nil || pos != 100 {
+		t.Fatalf("Seek past end = %d, %v", pos, err)
+	}
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Fatalf("Read past end: %v, want io.EOF", err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("Seek to negative position succeeded")
+	}
+
+	r.Close()
+	if _, err := r.Read(buf); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Read after Close: %v, want os.ErrClosed", err)
+	}
+}
+
+// cachedChunks 返回读取器缓存中的 Chunk 索引
+func cachedChunks(r *fileReader) map[int]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cached := make(map[int]bool)
+	for index := range r.cache {
+		cached[index] = true
+	}
+	return cached
+}
+
+func TestFileReaderPrefetch(t *testing.T) {
+	a, b := newTestService(t), newTestService(t)
+	a.Config.ReadAheadChunks = 2
+	connectServices(t, a, b)
+
+	// b 持有文件的元数据和所有 Chunk，通过位图协议告知 a
+	metaData := testResumeMetaData(readerTestContent, 4, -1)
+	hashes := make([]string, len(metaData.Leaves))
+	for i, leaf := range metaData.Leaves {
+		offset := leaf.Index * 4
+		hashes[i] = putTestChunk(t, b, readerTestContent[offset:min(offset+4, len(readerTestContent))])
+	}
+	cid := hex.EncodeToString([]byte("reader-test"))
+	data, err := json.Marshal(metaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(b.Config.MetadataStoragePath, cid+".json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := a.openFileReader(context.Background(), cid, metaData)
+	defer r.Close()
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "0123" {
+		t.Fatalf("first chunk = %q, %v", buf, err)
+	}
+
+	// 读取 Chunk 0 后在后台预取 Chunk 1、2，不超出预取窗口
+	deadline := time.Now().Add(10 * time.Second)
+	for cached := cachedChunks(r); !cached[1] || !cached[2]; cached = cachedChunks(r) {
+		if time.Now().After(deadline) {
+			t.Fatalf("chunks 1 and 2 not prefetched, cache = %v", cached)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cachedChunks(r)[3] {
+		t.Fatal("chunk 3 prefetched outside the read-ahead window")
+	}
+
+	// 预取的 Chunk 从缓存读取，不再访问对端
+	b.ChunkStore.Delete(hashes[1])
+	b.ChunkStore.Delete(hashes[2])
+	buf = make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "456789ab" {
+		t.Fatalf("prefetched chunks = %q, %v", buf, err)
+	}
+}
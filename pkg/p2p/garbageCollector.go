@@ -0,0 +1,363 @@
+// Package p2p 提供本地 Chunk 的垃圾回收功能
+//
+// GarbageCollector 功能:
+//   - 引用计数: 统计元数据目录中所有 file.MetaData 对每个叶子哈希的引用数
+//   - 标记: 被任意本地元数据引用、或属于被固定文件的 Chunk 为存活
+//   - 清除: 删除 ChunkStore 中未被引用且未被固定的 Chunk
+//   - 试运行: dryRun 时只统计将删除的 Chunk 数量和可回收的字节数，不删除
+//   - 定期回收: GCInterval 大于 0 时按间隔自动执行
+//   - 后台回收: Trigger 立即返回，回收在后台进行，通过 Status 查询进度和结果
+//
+// 注意事项:
+//   - 重新公告遍历 ChunkStore，被删除的 Chunk 不再被公告；DHT 中已有的提供者记录到期后失效
+//   - 上传在写入 Chunk 前用 Hold 把新 Chunk 标记为存活，通过 Commit 保存元数据后再释放，否则新 Chunk 可能在被引用前被删除
+//   - 回收（非试运行）期间 Commit 等待回收结束，上传写入 Chunk 时不被阻塞
+//   - 任一元数据文件无法读取或解析时标记阶段不完整，本轮回收中止而不删除任何 Chunk
+//   - Hold 只在进程内有效，CLI 离线回收时不应有其他进程正在上传
+//   - 被固定但本地没有元数据的文件无法确定其 Chunk，只在结果中报告
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/file"
+)
+
+// ErrGCRunning 已有一轮垃圾回收正在进行
+var ErrGCRunning = errors.New("garbage collection already running")
+
+// GCResult 一轮垃圾回收的结果
+type GCResult struct {
+	DryRun           bool      `json:"dryRun"`                  // 是否为试运行
+	StartedAt        time.Time `json:"startedAt"`               // 开始时间
+	FinishedAt       time.Time `json:"finishedAt"`              // 结束时间
+	Files            int       `json:"files"`                   // 本地元数据文件数
+	PinnedFiles      int       `json:"pinnedFiles"`             // 被固定的文件数
+	MissingPinned    []string  `json:"missingPinned,omitempty"` // 被固定但本地没有元数据的文件
+	TotalChunks      int       `json:"totalChunks"`             // 存储中的 Chunk 数
+	TotalBytes       int64     `json:"totalBytes"`              // 存储中的 Chunk 总字节数
+	ReferencedChunks int       `json:"referencedChunks"`        // 被引用的不同 Chunk 数
+	RemovedChunks    int       `json:"removedChunks"`           // 删除（试运行时为将删除）的 Chunk 数
+	ReclaimedBytes   int64     `json:"reclaimedBytes"`          // 回收（试运行时为可回收）的字节数
+	Failed           int       `json:"failed"`                  // 删除失败的 Chunk 数
+}
+
+// GCStatus 垃圾回收的状态
+type GCStatus struct {
+	Running    bool      `json:"running"`              // 是否正在回收
+	Runs       int       `json:"runs"`                 // 已完成的轮数（含试运行）
+	Interval   int       `json:"interval"`             // 定期回收间隔（秒），0 表示不定期回收
+	NextRun    time.Time `json:"nextRun,omitempty"`    // 下一次定期回收时间
+	LastResult *GCResult `json:"lastResult,omitempty"` // 最近一轮结果
+	LastError  string    `json:"lastError,omitempty"`  // 最近一次错误
+}
+
+// GarbageCollector 标记-清除式的 Chunk 垃圾回收器
+type GarbageCollector struct {
+	store        chunkStore.ChunkStore
+	metadataPath string
+	pins         *PinSet
+	interval     time.Duration
+
+	// hold 上传保存元数据时持有读锁，回收（非试运行）持有写锁
+	hold sync.RWMutex
+
+	mu          sync.Mutex
+	status      GCStatus
+	held        map[string]int // 上传中的 Chunk 哈希（hex）-> Hold 次数
+	heldVersion uint64         // held 每次变化时递增
+}
+
+// NewGarbageCollector 创建垃圾回收器
+// 参数:
+//   - store: 本地 Chunk 存储
+//   - metadataPath: 元数据目录（<CID>.json）
+//   - pins: 固定集合
+//   - interval: 定期回收间隔，0 表示只支持手动触发
+func NewGarbageCollector(store chunkStore.ChunkStore, metadataPath string, pins *PinSet, interval time.Duration) *GarbageCollector {
+	return &GarbageCollector{
+		store:        store,
+		metadataPath: metadataPath,
+		pins:         pins,
+		interval:     interval,
+		status:       GCStatus{Interval: int(interval / time.Second)},
+		held:         make(map[string]int),
+	}
+}
+
+// Start 启动定期回收，interval 为 0 时不做任何事；ctx 取消时退出
+func (gc *GarbageCollector) Start(ctx context.Context) {
+	if gc.interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(gc.interval)
+		defer ticker.Stop()
+		gc.setNextRun(time.Now().Add(gc.interval))
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := gc.Run(ctx, false); err != nil && !errors.Is(err, ErrGCRunning) {
+				logrus.Warnf("Scheduled garbage collection failed: %v", err)
+			}
+			gc.setNextRun(time.Now().Add(gc.interval))
+		}
+	}()
+}
+
+func (gc *GarbageCollector) setNextRun(t time.Time) {
+	gc.mu.Lock()
+	gc.status.NextRun = t
+	gc.mu.Unlock()
+}
+
+// Hold 把上传即将写入的 Chunk 标记为存活，返回的函数取消标记
+// 上传在写入第一个 Chunk 前调用，通过 Commit 保存元数据后释放
+// 参数:
+//   - leafHashes: 新文件的叶子哈希
+func (gc *GarbageCollector) Hold(leafHashes [][]byte) func() {
+	hashes := make([]string, len(leafHashes))
+	gc.mu.Lock()
+	for i, leaf := range leafHashes {
+		hashes[i] = hex.EncodeToString(leaf)
+		gc.held[hashes[i]]++
+	}
+	gc.heldVersion++
+	gc.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			gc.mu.Lock()
+			defer gc.mu.Unlock()
+			for _, hash := range hashes {
+				if gc.held[hash]--; gc.held[hash] <= 0 {
+					delete(gc.held, hash)
+				}
+			}
+			gc.heldVersion++
+		})
+	}
+}
+
+// Commit 执行 fn 保存上传的元数据，回收（非试运行）进行中时等待其结束
+// 回收的标记阶段要么看到新的元数据，要么整轮都看到 Hold 的标记，新 Chunk 不会在两者之间被删除
+func (gc *GarbageCollector) Commit(fn func() error) error {
+	gc.hold.RLock()
+	defer gc.hold.RUnlock()
+	return fn()
+}
+
+// isHeld 检查 Chunk 是否被进行中的上传标记为存活
+func (gc *GarbageCollector) isHeld(hash string) bool {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.held[hash] > 0
+}
+
+// heldSince 返回当前被 Hold 的 Chunk 哈希，Hold 集合自 version 以来没有变化时 changed 为 false 且不复制集合
+// 返回值:
+//   - uint64: 当前版本
+//   - []string: 被 Hold 的 Chunk 哈希（hex）
+//   - bool: 版本是否与 version 不同
+func (gc *GarbageCollector) heldSince(version uint64) (uint64, []string, bool) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.heldVersion == version {
+		return version, nil, false
+	}
+	hashes := make([]string, 0, len(gc.held))
+	for hash := range gc.held {
+		hashes = append(hashes, hash)
+	}
+	return gc.heldVersion, hashes, true
+}
+
+// deleteUnlessHeld 在没有上传 Hold 该 Chunk 时删除它，返回是否已删除
+// 上传的 Chunk 可能在标记之后写入；检查与删除都持有 gc.mu，并发的 Hold 等待删除完成，
+// 随后上传的 Put 重新写入该 Chunk，而不会在 Put 之后被删除
+func (gc *GarbageCollector) deleteUnlessHeld(hash string) (bool, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.held[hash] > 0 {
+		return false, nil
+	}
+	if err := gc.store.Delete(hash); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Status 返回当前状态的副本
+func (gc *GarbageCollector) Status() GCStatus {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.status
+}
+
+// Run 同步执行一轮垃圾回收
+// 参数:
+//   - ctx: 上下文，取消时停止清除剩余的 Chunk
+//   - dryRun: 为 true 时只统计，不删除
+//
+// 返回值:
+//   - *GCResult: 本轮结果（ctx 取消时为已完成部分）
+//   - error: 已有回收在进行时返回 ErrGCRunning；扫描元数据或存储失败时返回错误
+func (gc *GarbageCollector) Run(ctx context.Context, dryRun bool) (*GCResult, error) {
+	if !gc.begin() {
+		return nil, ErrGCRunning
+	}
+	return gc.execute(ctx, dryRun)
+}
+
+// Trigger 在后台执行一轮垃圾回收，结果通过 Status 查询
+// 参数:
+//   - ctx: 上下文，取消时停止清除剩余的 Chunk（应为服务的生命周期，而不是请求的）
+//   - dryRun: 为 true 时只统计，不删除
+//
+// 返回值:
+//   - error: 已有回收在进行时返回 ErrGCRunning
+func (gc *GarbageCollector) Trigger(ctx context.Context, dryRun bool) error {
+	if !gc.begin() {
+		return ErrGCRunning
+	}
+	go func() {
+		if _, err := gc.execute(ctx, dryRun); err != nil {
+			logrus.Warnf("Garbage collection failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// begin 标记回收开始，已有回收在进行时返回 false
+func (gc *GarbageCollector) begin() bool {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.status.Running {
+		return false
+	}
+	gc.status.Running = true
+	return true
+}
+
+// execute 执行已由 begin 标记开始的一轮回收，并记录结果
+func (gc *GarbageCollector) execute(ctx context.Context, dryRun bool) (*GCResult, error) {
+	if !dryRun {
+		gc.hold.Lock()
+	}
+	result, err := gc.run(ctx, dryRun)
+	if !dryRun {
+		gc.hold.Unlock()
+	}
+
+	gc.mu.Lock()
+	gc.status.Running = false
+	gc.status.Runs++
+	gc.status.LastResult = result
+	gc.status.LastError = ""
+	if err != nil {
+		gc.status.LastError = err.Error()
+	}
+	gc.mu.Unlock()
+
+	if err == nil {
+		verb := "removed"
+		if dryRun {
+			verb = "would remove"
+		}
+		logrus.Infof("Garbage collection finished: %s %d/%d chunks, %d bytes (%d referenced, %d failed, took %v)",
+			verb, result.RemovedChunks, result.TotalChunks, result.ReclaimedBytes,
+			result.ReferencedChunks, result.Failed, result.FinishedAt.Sub(result.StartedAt))
+	}
+	return result, err
+}
+
+func (gc *GarbageCollector) run(ctx context.Context, dryRun bool) (*GCResult, error) {
+	result := &GCResult{DryRun: dryRun, StartedAt: time.Now()}
+	defer func() { result.FinishedAt = time.Now() }()
+
+	// 标记
+	refs, files, err := chunkRefCounts(gc.metadataPath)
+	if err != nil {
+		return result, fmt.Errorf("mark phase incomplete, nothing removed: %w", err)
+	}
+	result.Files = len(files)
+	result.ReferencedChunks = len(refs)
+	for _, pin := range gc.pins.List() {
+		result.PinnedFiles++
+		if !files[pin.CID] {
+			result.MissingPinned = append(result.MissingPinned, pin.CID)
+		}
+	}
+	if len(result.MissingPinned) > 0 {
+		logrus.Warnf("Garbage collection: %d pinned files have no local metadata", len(result.MissingPinned))
+	}
+
+	// 清除
+	err = gc.store.Iterate(func(hash string, size int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result.TotalChunks++
+		result.TotalBytes += size
+		if refs[hash] > 0 {
+			return nil
+		}
+		if dryRun {
+			if gc.isHeld(hash) {
+				return nil
+			}
+		} else {
+			deleted, err := gc.deleteUnlessHeld(hash)
+			if err != nil {
+				logrus.Warnf("Garbage collection: failed to delete chunk %s: %v", hash, err)
+				result.Failed++
+				return nil
+			}
+			if !deleted {
+				return nil
+			}
+			logrus.Debugf("Garbage collection: removed chunk %s (%d bytes)", hash, size)
+		}
+		result.RemovedChunks++
+		result.ReclaimedBytes += size
+		return nil
+	})
+	return result, err
+}
+
+// chunkRefCounts 统计元数据目录中每个叶子哈希（hex）被多少个文件引用
+// 同一文件中重复的叶子（如 Merkle 树补齐的叶子）只计一次
+// 返回值:
+//   - map[string]int: 叶子哈希 -> 引用的文件数
+//   - map[string]bool: 扫描到的文件 CID
+//   - error: 读取元数据目录或任一元数据文件失败时返回错误，此时引用计数不完整
+func chunkRefCounts(metadataPath string) (map[string]int, map[string]bool, error) {
+	refs := make(map[string]int)
+	files := make(map[string]bool)
+	err := forEachLocalMetaData(metadataPath, func(cid string, metaData *file.MetaData) {
+		files[cid] = true
+		seen := make(map[string]bool, len(metaData.Leaves))
+		for _, leaf := range metaData.Leaves {
+			if len(leaf.ChunkHash) == 0 {
+				continue
+			}
+			hash := hex.EncodeToString(leaf.ChunkHash)
+			if !seen[hash] {
+				seen[hash] = true
+				refs[hash]++
+			}
+		}
+	})
+	return refs, files, err
+}
@@ -0,0 +1,207 @@
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/file"
+)
+
+// newTestGC 创建使用内存存储和临时元数据目录的垃圾回收器
+func newTestGC(t *testing.T) (*GarbageCollector, *chunkStore.MemoryStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	pins, err := LoadPinSet(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := chunkStore.NewMemoryStore()
+	return NewGarbageCollector(store, dir, pins, 0), store, dir
+}
+
+// putGCChunk 写入一个 Chunk，返回其叶子哈希
+func putGCChunk(t *testing.T, store chunkStore.ChunkStore, data string) []byte {
+	t.Helper()
+	sum := sha256.Sum256([]byte(data))
+	if err := store.Put(hex.EncodeToString(sum[:]), []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return sum[:]
+}
+
+// writeGCMetaData 写入引用 leaves 的元数据文件
+func writeGCMetaData(t *testing.T, dir, cid string, leaves ...[]byte) {
+	t.Helper()
+	metaData := file.MetaData{TreeType: TreeTypeRegular}
+	for i, leaf := range leaves {
+		metaData.Leaves = append(metaData.Leaves, file.ChunkData{Index: i, ChunkHash: leaf})
+	}
+	data, err := json.Marshal(metaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, cid+".json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGarbageCollectorHold(t *testing.T) {
+	gc, store, dir := newTestGC(t)
+	referenced := putGCChunk(t, store, "referenced")
+	writeGCMetaData(t, dir, "aa", referenced)
+
+	// 上传中的 Chunk 在保存元数据前不被删除
+	uploading := [][]byte{putGCChunk(t, store, "uploading")}
+	release := gc.Hold(uploading)
+	orphan := putGCChunk(t, store, "orphan")
+
+	result, err := gc.Run(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.RemovedChunks != 1 || store.Has(hex.EncodeToString(orphan)) {
+		t.Fatalf("removed %d chunks, orphan present = %v", result.RemovedChunks, store.Has(hex.EncodeToString(orphan)))
+	}
+	if !store.Has(hex.EncodeToString(referenced)) || !store.Has(hex.EncodeToString(uploading[0])) {
+		t.Fatal("referenced or held chunk removed")
+	}
+
+	// 释放后未被引用的 Chunk 可以回收；重复释放无影响
+	release()
+	release()
+	if _, err := gc.Run(context.Background(), false); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if store.Has(hex.EncodeToString(uploading[0])) {
+		t.Fatal("released chunk not removed")
+	}
+}
+
+// deleteHookStore 在删除 Chunk 前调用 beforeDelete
+type deleteHookStore struct {
+	chunkStore.ChunkStore
+	beforeDelete func(hash string)
+}
+
+func (s *deleteHookStore) Delete(hash string) error {
+	if s.beforeDelete != nil {
+		s.beforeDelete(hash)
+	}
+	return s.ChunkStore.Delete(hash)
+}
+
+func TestGarbageCollectorHoldDuringSweep(t *testing.T) {
+	gc, store, _ := newTestGC(t)
+	data := "re-uploaded"
+	leaf := putGCChunk(t, store, data)
+	hash := hex.EncodeToString(leaf)
+
+	// 清除阶段已判定该 Chunk 未被 Hold，正要删除时一个上传开始 Hold 并写入同一个 Chunk
+	uploaded := make(chan struct{})
+	var blocked bool
+	gc.store = &deleteHookStore{ChunkStore: store, beforeDelete: func(h string) {
+		if h != hash {
+			return
+		}
+		go func() {
+			release := gc.Hold([][]byte{leaf})
+			defer release()
+			store.Put(hash, []byte(data))
+			close(uploaded)
+		}()
+		select {
+		case <-uploaded:
+		case <-time.After(100 * time.Millisecond):
+			blocked = true
+		}
+	}}
+
+	result, err := gc.Run(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	select {
+	case <-uploaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upload still blocked after garbage collection")
+	}
+	if !blocked {
+		t.Fatal("Hold did not wait for the in-progress delete")
+	}
+	if result.RemovedChunks != 1 {
+		t.Fatalf("removed %d chunks, want 1", result.RemovedChunks)
+	}
+	// 上传的 Put 在删除之后执行，Chunk 仍然存在
+	if !store.Has(hash) {
+		t.Fatal("chunk written by the concurrent upload was removed")
+	}
+}
+
+func TestGarbageCollectorCommitWaitsForSweep(t *testing.T) {
+	gc, _, _ := newTestGC(t)
+
+	// 回收（非试运行）持有写锁期间保存元数据需要等待
+	gc.hold.Lock()
+	done := make(chan struct{})
+	go func() {
+		gc.Commit(func() error { return nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Commit did not wait for garbage collection")
+	case <-time.After(50 * time.Millisecond):
+	}
+	gc.hold.Unlock()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Commit still blocked after garbage collection")
+	}
+}
+
+func TestGarbageCollectorUnreadableMetaData(t *testing.T) {
+	gc, store, dir := newTestGC(t)
+	orphan := putGCChunk(t, store, "orphan")
+	if err := os.WriteFile(filepath.Join(dir, "bb.json"), []byte("{truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 标记阶段不完整时中止，不删除任何 Chunk
+	if _, err := gc.Run(context.Background(), false); err == nil {
+		t.Fatal("Run succeeded with unreadable metadata")
+	}
+	if !store.Has(hex.EncodeToString(orphan)) {
+		t.Fatal("chunk removed after incomplete mark phase")
+	}
+	if gc.Status().LastError == "" {
+		t.Fatal("status has no last error")
+	}
+}
+
+func TestGarbageCollectorTrigger(t *testing.T) {
+	gc, store, _ := newTestGC(t)
+	orphan := putGCChunk(t, store, "orphan")
+
+	if err := gc.Trigger(context.Background(), true); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for status := gc.Status(); status.Running || status.Runs == 0; status = gc.Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("garbage collection did not finish: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	result := gc.Status().LastResult
+	if result == nil || !result.DryRun || result.RemovedChunks != 1 || !store.Has(hex.EncodeToString(orphan)) {
+		t.Fatalf("dry run result = %+v", result)
+	}
+}
@@ -12,12 +12,18 @@
 //   - 指数退避: 重试延迟 500ms → 1s → 2s → 4s → 8s → 10s
 //   - 错误分类: RetryableError 标记可重试的网络错误
 //   - 连接管理: 使用 ConnManager 限制并发和统计性能
+//   - 提供者发现: 通过 FindChunkProviders 查找真正持有 Chunk 的节点（带本地缓存）
+//   - 做种者优先: 先按文件 CID 查找做种者下载所有 Chunk，缺失的再逐块查找
+//   - 可用性表: 向做种者和已连接节点查询位图，按位图选择节点，避免逐个探测
+//   - 调度策略: 顺序、最稀缺优先或 endgame（见 scheduler.go）
 //
 // 下载模式:
 //   - GetFileOrdered: 顺序下载，保证 Chunk 顺序
+//   - StreamFile: 顺序流式写入 io.Writer，内存占用受重排窗口限制（见 streamFile.go）
 //   - GetFileRandom: 随机下载，最大化并发效率
 //   - GetFileOrderedWithProgress: 顺序下载 + 进度回调
 //   - GetFileRandomWithProgress: 随机下载 + 进度回调
+//   - DownloadFileResumable: 写入 .part 临时文件，支持断点续传（见 resume.go）
 //
 // 使用示例:
 //
@@ -42,7 +48,7 @@ package p2p
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -118,6 +124,10 @@ const (
 	ConcurrentLimit   = 16
 	MaxRetries        = 3
 	DefaultDHTTimeout = 10 * time.Second // 默认 DHT 超时 10 秒
+
+	// 节点流配额已满时的等待间隔和最大等待次数（约 30 秒）
+	busyRetryDelay = 50 * time.Millisecond
+	maxBusyWaits   = 600
 )
 
 // 重试配置（从 P2PConfig 获取）
@@ -194,30 +204,65 @@ func randFloat64() float64 {
 	return float64(time.Now().UnixNano()%1000) / 1000.0
 }
 
+// loadMetaData 获取文件元数据
+// 优先从 DHT 读取，DHT 中不存在或校验失败时通过 QueryMetaData 协议向其他节点查询
 func (p *P2PService) loadMetaData(ctx context.Context, fileHash string) (*file.MetaData, error) {
-	metaInfo, err := p.Get(ctx, fileHash)
+	metaData, dhtErr := p.loadMetaDataFromDHT(ctx, fileHash)
+	if dhtErr != nil {
+		logrus.Warnf("Failed to load metadata from DHT, querying peers: %v", dhtErr)
+
+		var err error
+		metaData, err = p.QueryMetaData(ctx, fileHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get metadata: %w (dht: %v)", err, dhtErr)
+		}
+	}
+
+	logrus.Infof("Metadata parsed: FileName=%s, FileSize=%d, Leaves=%d chunks",
+		metaData.FileName, metaData.FileSize, len(metaData.Leaves))
+
+	return metaData, nil
+}
+
+// loadMetaDataFromDHT 从 DHT 读取并校验元数据
+// 记录的签名和版本已由 MetaDataValidator 在 GetValue 中校验并选择
+func (p *P2PService) loadMetaDataFromDHT(ctx context.Context, fileHash string) (*file.MetaData, error) {
+	value, err := p.DHT.GetValue(ctx, metaDataKey(fileHash))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get metadata: %w", err)
+		return nil, fmt.Errorf("failed to get metadata record: %w", err)
 	}
 
-	var metaData file.MetaData
-	if err := json.Unmarshal([]byte(metaInfo), &metaData); err != nil {
-		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	_, record, err := parseSignedMetaDataRecord(value)
+	if err != nil {
+		return nil, err
 	}
 
-	logrus.Infof("Metadata parsed: FileName=%s, FileSize=%d, Leaves=%d chunks",
-		metaData.FileName, metaData.FileSize, len(metaData.Leaves))
+	metaData, err := p.resolveMetaDataRecord(ctx, fileHash, record)
+	if err != nil {
+		return nil, err
+	}
 
-	return &metaData, nil
+	if err := VerifyMetaData(metaData, fileHash); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	return metaData, nil
 }
 
+// downloadChunksConcurrently 并发下载所有 chunk
+// fileHash 非空时先查找做种者并向候选节点查询位图，按可用性表选择节点；
+// 可用性表中没有节点持有的 chunk 再按哈希查找提供者。下载顺序和 endgame 由 p.Scheduler 决定
+// done 中已设置的索引视为已完成（断点续传），不再下载；nil 表示全部下载
 func (p *P2PService) downloadChunksConcurrently(
 	ctx context.Context,
-	leaves []file.ChunkData,
+	fileHash string,
+	metaData *file.MetaData,
+	done Bitfield,
 	concurrency int,
 	handleChunk func(i int, chunk file.ChunkData, offset int64, data []byte) error,
 	progress *downloadProgress,
 ) error {
+	leaves := metaData.Leaves
 	errCh := make(chan error, len(leaves))
 
 	// 使用配置的并发数，如果传入的 concurrency 为 0，则使用配置的值
@@ -230,159 +275,87 @@ func (p *P2PService) downloadChunksConcurrently(
 
 	retryCfg := p.getRetryConfig()
 
-	// 使用工作池模式，限制 goroutine 创建数量
-	type chunkTask struct {
-		index       int
-		chunk       file.ChunkData
-		offset      int64
-		chunkHashStr string
+	tasks := p.buildFileChunkTasks(fileHash, metaData)
+	avail, probeSeeders := p.prepareChunkSources(ctx, fileHash, tasks)
+	defer p.releaseAvailability(fileHash, avail)
+
+	// 按调度策略确定下载顺序
+	scheduler := p.Scheduler
+	if scheduler == nil {
+		scheduler = &SequentialScheduler{}
+	}
+	order := scheduler.Order(len(leaves), func(i int) int {
+		count := avail.count(i)
+		if providers, ok := p.providerCache.get(tasks[i].chunkHashStr); ok {
+			count += len(providers)
+		}
+		return count
+	})
+	if done != nil {
+		missing := order[:0]
+		for _, i := range order {
+			if !done.Has(i) {
+				missing = append(missing, i)
+			}
+		}
+		order = missing
 	}
+	queue := newDownloadQueue(ctx, scheduler, order)
+	defer queue.close()
 
-	// 创建任务通道
-	taskCh := make(chan chunkTask, len(leaves))
+	// 使用工作池模式，限制 goroutine 创建数量
 	// 启动 worker goroutines（固定数量）
 	var wg sync.WaitGroup
 	for i := 0; i < concurrency; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for task := range taskCh {
-				// 检查 context 是否已取消
-				select {
-				case <-ctx.Done():
-					errCh <- fmt.Errorf("chunk %d: context canceled", task.index)
-					continue
-				default:
+			for {
+				index, chunkCtx, ok := queue.next()
+				if !ok {
+					return
 				}
+				task := tasks[index]
+				task.queue = queue
 
-				// 为 DHT 操作添加超时控制
-				dhtTimeout := DefaultDHTTimeout
-				if p.Config.DHTTimeout > 0 {
-					dhtTimeout = time.Duration(p.Config.DHTTimeout) * time.Second
+				// 检查 context 是否已取消
+				var (
+					chunkData []byte
+					err       error
+				)
+				if ctx.Err() != nil {
+					err = fmt.Errorf("chunk %d: context canceled", task.index)
+				} else {
+					chunkData, err = p.fetchChunk(chunkCtx, task, avail, probeSeeders, retryCfg)
 				}
-				dhtCtx, cancel := context.WithTimeout(ctx, dhtTimeout)
-				defer cancel()
 
-				peers, err := p.DHT.GetClosestPeers(dhtCtx, task.chunkHashStr)
-				if err != nil {
-					if dhtCtx.Err() == context.DeadlineExceeded {
-						errCh <- fmt.Errorf("chunk %d: get peers timed out after %v", task.index, dhtTimeout)
-					} else {
-						errCh <- fmt.Errorf("chunk %d: get peers failed: %w", task.index, err)
-					}
-					continue
+				// endgame 中只有第一个成功的请求处理数据，最后一个失败的请求报告错误
+				first, failed := queue.finish(index, err == nil)
+				if failed {
+					errCh <- err
 				}
-				if len(peers) == 0 {
-					errCh <- fmt.Errorf("chunk %d: no peers found", task.index)
+				if !first {
 					continue
 				}
 
-				// 带指数退避的重试逻辑
-				var lastErr error
-				for attempt := 0; attempt < retryCfg.maxRetries; attempt++ {
-					if attempt > 0 {
-						// 检查错误是否可重试
-						if !IsRetryable(lastErr) {
-							logrus.Warnf("Chunk %d encountered non-retryable error: %v", task.index, lastErr)
-							break
-						}
-						delay := retryCfg.calculateDelay(attempt)
-						logrus.Warnf("Retrying chunk %d after %v (attempt %d/%d)",
-							task.index, delay, attempt, retryCfg.maxRetries)
-						time.Sleep(delay)
-					}
-
-					// 从可用 peers 中选择一个（失败后排除，尝试下一个）
-					availablePeers := make([]peer.ID, len(peers))
-					copy(availablePeers, peers)
-					var selectedPeer peer.ID
-
-					for len(availablePeers) > 0 {
-						// 选择一个 peer
-						selectedPeer, err = p.PeerSelector.SelectPeer(availablePeers)
-						if err != nil {
-							lastErr = fmt.Errorf("chunk %d: select peer failed: %w", task.index, err)
-							break
-						}
-
-						// 验证该 peer 是否拥有 chunk
-						hasChunk, err := p.CheckChunkExists(ctx, selectedPeer, task.chunkHashStr)
-						if err != nil {
-							logrus.Warnf("Peer %s check failed for chunk %d: %v", selectedPeer, task.index, err)
-							lastErr = err
-							// 移除该 peer，尝试下一个
-							availablePeers = removePeer(availablePeers, selectedPeer)
-							continue
-						}
-						if !hasChunk {
-							// 移除该 peer，尝试下一个
-							availablePeers = removePeer(availablePeers, selectedPeer)
-							continue
-						}
-
-						// 下载 chunk
-						chunkData, err := p.DownloadChunk(ctx, selectedPeer, task.chunkHashStr)
-						if err != nil {
-							logrus.Warnf("Download chunk %d from %s failed: %v", task.index, selectedPeer, err)
-							lastErr = fmt.Errorf("chunk %d: download from peer %s failed: %w", task.index, selectedPeer, err)
-							// 移除该 peer，尝试下一个
-							availablePeers = removePeer(availablePeers, selectedPeer)
-							continue
-						}
-
-						// 验证 chunk 哈希
-						hash := sha256.Sum256(chunkData)
-						if !bytesEqual(hash[:], task.chunk.ChunkHash) {
-							logrus.Warnf("Chunk %d hash mismatch from peer %s", task.index, selectedPeer)
-							lastErr = fmt.Errorf("chunk %d: hash validation failed", task.index)
-							// 移除该 peer，尝试下一个
-							availablePeers = removePeer(availablePeers, selectedPeer)
-							continue
-						}
-
-						// 处理 chunk（写入文件等）
-						if err := handleChunk(task.index, task.chunk, task.offset, chunkData); err != nil {
-							// 这是本地错误（如写入文件），重试没有意义
-							errCh <- fmt.Errorf("chunk %d: handle failed: %w", task.index, err)
-							return
-						}
-
-						// 更新进度
-						if progress != nil {
-							progress.completeChunk(int64(task.chunk.ChunkSize))
-						}
-
-						// 成功，退出所有循环
-						logrus.Debugf("Chunk %d downloaded successfully (attempt %d)", task.index, attempt+1)
-						return
-					}
+				// 处理 chunk（写入文件等）
+				if err := handleChunk(task.index, task.chunk, task.offset, chunkData); err != nil {
+					// 这是本地错误（如写入文件），重试没有意义
+					errCh <- fmt.Errorf("chunk %d: handle failed: %w", task.index, err)
+					return
+				}
 
-					// 如果所有 peers 都试过了，跳出重试循环
-					if len(availablePeers) == 0 {
-						break
-					}
+				// 更新进度
+				if progress != nil {
+					progress.completeChunk(int64(task.chunk.ChunkSize))
 				}
 
-				// 所有重试都失败
-				errCh <- lastErr
+				// 写入本地存储并通知其他下载方，下载期间即可对外提供
+				p.seedDownloadedChunk(fileHash, len(leaves), task, chunkData)
 			}
 		}()
 	}
 
-	// 提交所有任务
-	var offset int64 = 0
-	for i, chunk := range leaves {
-		chunkOffset := offset
-		offset += int64(chunk.ChunkSize)
-		taskCh <- chunkTask{
-			index:       i,
-			chunk:       chunk,
-			offset:      chunkOffset,
-			chunkHashStr: string(chunk.ChunkHash),
-		}
-	}
-	close(taskCh)
-
 	// 等待所有 worker 完成
 	wg.Wait()
 	close(errCh)
@@ -406,6 +379,256 @@ func (p *P2PService) downloadChunksConcurrently(
 	return nil
 }
 
+// chunkTask 一个待下载的 chunk
+type chunkTask struct {
+	index        int
+	chunk        file.ChunkData
+	offset       int64
+	chunkHashStr string
+	fileCID      string         // 使用证明协议时的文件 CID
+	proofRoot    []byte         // 非空时通过证明协议下载并对该 Merkle 根校验（见 chunkProof.go）
+	leafCount    int            // 使用证明协议时元数据中的叶子数，用于校验证明路径长度和最后一个叶子
+	fileSize     int64          // 使用证明协议时元数据中的文件大小
+	queue        *downloadQueue // 非空时按队列记录的进行中请求选择节点（endgame 的重复请求发往不同节点）
+}
+
+// buildChunkTasks 为每个叶子计算文件内偏移和 hex 哈希
+func buildChunkTasks(leaves []file.ChunkData) []chunkTask {
+	tasks := make([]chunkTask, len(leaves))
+	var offset int64 = 0
+	for i, chunk := range leaves {
+		tasks[i] = chunkTask{
+			index:        i,
+			chunk:        chunk,
+			offset:       offset,
+			chunkHashStr: hex.EncodeToString(chunk.ChunkHash), // 与 Announce 使用相同的 hex 格式
+		}
+		offset += int64(chunk.ChunkSize)
+	}
+	return tasks
+}
+
+// prepareChunkSources 查找做种者并构建可用性表
+// fileHash 为空时返回 nil（所有 chunk 按哈希查找提供者）
+// 未返回位图的做种者（不支持位图协议）在 probeSeeders 中返回，仍需逐个探测
+// 调用方负责用 releaseAvailability 释放可用性表
+func (p *P2PService) prepareChunkSources(ctx context.Context, fileHash string, tasks []chunkTask) (avail *availabilityMap, probeSeeders []peer.ID) {
+	if fileHash == "" {
+		return nil, nil
+	}
+	seeders := p.findSeederIDs(ctx, fileHash)
+	avail = p.buildAvailability(ctx, fileHash, tasks, seeders)
+	for _, peerID := range seeders {
+		if !avail.known(peerID) {
+			probeSeeders = append(probeSeeders, peerID)
+		}
+	}
+	return avail, probeSeeders
+}
+
+// fetchChunk 按 可用性表 -> 做种者 -> 提供者查找 的顺序获取一个 chunk
+func (p *P2PService) fetchChunk(
+	ctx context.Context,
+	task chunkTask,
+	avail *availabilityMap,
+	probeSeeders []peer.ID,
+	retryCfg *retryConfig,
+) ([]byte, error) {
+	// 1. 可用性表中持有该 chunk 的节点，无需探测
+	if peers := avail.peersWith(task.index); len(peers) > 0 {
+		data, err := p.fetchChunkFromPeers(ctx, task, peers, false, 1)
+		if err == nil {
+			return data, nil
+		}
+		logrus.Debugf("Chunk %d not available from bitfield peers: %v", task.index, err)
+	}
+
+	// 2. 未返回位图的做种者，先探测再下载（叶子没有哈希时无法探测，直接请求）
+	if len(probeSeeders) > 0 && ctx.Err() == nil {
+		data, err := p.fetchChunkFromPeers(ctx, task, probeSeeders, task.chunkHashStr != "", 1)
+		if err == nil {
+			return data, nil
+		}
+		logrus.Debugf("Chunk %d not available from seeders, falling back to provider lookup: %v", task.index, err)
+	}
+
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("chunk %d: %w", task.index, ctx.Err())
+	}
+	if task.chunkHashStr == "" {
+		return nil, fmt.Errorf("chunk %d: not available from seeders (leaf hash unknown, cannot look up providers)", task.index)
+	}
+
+	// 3. 按 Chunk 哈希查找提供者
+	// 为 DHT 操作添加超时控制
+	dhtTimeout := DefaultDHTTimeout
+	if p.Config.DHTTimeout > 0 {
+		dhtTimeout = time.Duration(p.Config.DHTTimeout) * time.Second
+	}
+	dhtCtx, cancel := context.WithTimeout(ctx, dhtTimeout)
+	providers, err := p.FindChunkProviders(dhtCtx, task.chunkHashStr)
+	timedOut := dhtCtx.Err() == context.DeadlineExceeded
+	cancel()
+	if err != nil {
+		if timedOut {
+			return nil, fmt.Errorf("chunk %d: find providers timed out after %v", task.index, dhtTimeout)
+		}
+		return nil, fmt.Errorf("chunk %d: find providers failed: %w", task.index, err)
+	}
+	peers := make([]peer.ID, len(providers))
+	for i, info := range providers {
+		peers[i] = info.ID
+	}
+
+	data, err := p.fetchChunkFromPeers(ctx, task, peers, true, retryCfg.maxRetries)
+	if err != nil {
+		// 所有重试都失败，提供者记录可能已过期，下次重新查询（endgame 中被取消的请求除外）
+		if ctx.Err() == nil {
+			p.InvalidateProviders(task.chunkHashStr)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// selectChunkPeer 为任务选择一个节点，返回的函数在对该节点的请求结束后调用
+func (p *P2PService) selectChunkPeer(task chunkTask, peers []peer.ID) (peer.ID, func(), error) {
+	if task.queue == nil {
+		selected, err := p.PeerSelector.SelectPeer(peers)
+		return selected, func() {}, err
+	}
+	return task.queue.selectPeer(task.index, peers, p.PeerSelector)
+}
+
+// fetchChunkFromPeers 依次尝试从 peers 下载并校验一个 chunk
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - task: 待下载的 chunk（task.proofRoot 非空时通过证明协议下载并对 Merkle 根校验，否则校验叶子哈希）
+//   - peers: 候选节点
+//   - probe: 是否先用 CheckChunkExists 确认节点持有该 chunk（位图已确认时为 false）
+//   - maxAttempts: 最大尝试轮数（带指数退避）
+//
+// 返回值:
+//   - []byte: 校验通过的 chunk 数据
+//   - error: 所有节点都失败时返回最后一个错误
+func (p *P2PService) fetchChunkFromPeers(ctx context.Context, task chunkTask, peers []peer.ID, probe bool, maxAttempts int) ([]byte, error) {
+	index, chunk, chunkHashStr := task.index, task.chunk, task.chunkHashStr
+	retryCfg := p.getRetryConfig()
+
+	// 带指数退避的重试逻辑
+	lastErr := fmt.Errorf("chunk %d: no peer has the chunk", index)
+	busyWaits := 0
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			// 检查错误是否可重试
+			if !IsRetryable(lastErr) {
+				logrus.Warnf("Chunk %d encountered non-retryable error: %v", index, lastErr)
+				break
+			}
+			delay := retryCfg.calculateDelay(attempt)
+			logrus.Warnf("Retrying chunk %d after %v (attempt %d/%d)",
+				index, delay, attempt, maxAttempts)
+			time.Sleep(delay)
+		}
+
+		// 从可用 peers 中选择一个（失败后排除，尝试下一个）
+		availablePeers := make([]peer.ID, len(peers))
+		copy(availablePeers, peers)
+
+		for len(availablePeers) > 0 {
+			// 选择一个 peer（endgame 的重复请求避开该 Chunk 正在请求的节点）
+			selectedPeer, releasePeer, err := p.selectChunkPeer(task, availablePeers)
+			if err != nil {
+				lastErr = fmt.Errorf("chunk %d: select peer failed: %w", index, err)
+				break
+			}
+
+			// 验证该 peer 是否拥有 chunk
+			if probe {
+				hasChunk, err := p.CheckChunkExists(ctx, selectedPeer, chunkHashStr)
+				if err != nil || !hasChunk {
+					releasePeer()
+				}
+				if errors.Is(err, ErrPeerBusy) && busyWaits < maxBusyWaits {
+					// 节点流配额已满，等待其他请求完成后重试该节点
+					busyWaits++
+					if !sleepContext(ctx, busyRetryDelay) {
+						return nil, fmt.Errorf("chunk %d: %w", index, ctx.Err())
+					}
+					continue
+				}
+				if err != nil {
+					logrus.Warnf("Peer %s check failed for chunk %d: %v", selectedPeer, index, err)
+					lastErr = err
+					// 移除该 peer，尝试下一个
+					availablePeers = removePeer(availablePeers, selectedPeer)
+					continue
+				}
+				if !hasChunk {
+					// 移除该 peer，尝试下一个
+					availablePeers = removePeer(availablePeers, selectedPeer)
+					continue
+				}
+			}
+
+			// 下载 chunk
+			var chunkData []byte
+			if task.proofRoot != nil {
+				chunkData, err = p.downloadProvenChunk(ctx, selectedPeer, task)
+			} else {
+				chunkData, err = p.DownloadChunk(ctx, selectedPeer, chunkHashStr)
+			}
+			releasePeer()
+			if errors.Is(err, ErrPeerBusy) && busyWaits < maxBusyWaits {
+				busyWaits++
+				if !sleepContext(ctx, busyRetryDelay) {
+					return nil, fmt.Errorf("chunk %d: %w", index, ctx.Err())
+				}
+				continue
+			}
+			if err != nil {
+				logrus.Warnf("Download chunk %d from %s failed: %v", index, selectedPeer, err)
+				lastErr = fmt.Errorf("chunk %d: download from peer %s failed: %w", index, selectedPeer, err)
+				// 移除该 peer，尝试下一个
+				availablePeers = removePeer(availablePeers, selectedPeer)
+				continue
+			}
+
+			// 验证 chunk 哈希（证明协议已对 Merkle 根校验）
+			hash := sha256.Sum256(chunkData)
+			if task.proofRoot == nil && !bytesEqual(hash[:], chunk.ChunkHash) {
+				logrus.Warnf("Chunk %d hash mismatch from peer %s", index, selectedPeer)
+				lastErr = fmt.Errorf("chunk %d: hash validation failed", index)
+				// 移除该 peer，尝试下一个
+				availablePeers = removePeer(availablePeers, selectedPeer)
+				continue
+			}
+
+			// 成功
+			logrus.Debugf("Chunk %d downloaded successfully (attempt %d)", index, attempt+1)
+			return chunkData, nil
+		}
+
+		// 如果所有 peers 都试过了，跳出重试循环
+		if len(availablePeers) == 0 {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// sleepContext 等待 d，ctx 取消时提前返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 // bytesEqual 比较两个字节数组是否相等
 func bytesEqual(a, b []byte) bool {
 	if len(a) != len(b) {
@@ -428,42 +651,9 @@ func (p *P2PService) GetFileRandom(ctx context.Context, fileHash string, f io.Re
 }
 
 // GetFileOrderedWithProgress 下载文件（顺序写入）并支持进度回调
+// 数据通过有界重排窗口按顺序写入 f，不在内存中缓存整个文件（见 StreamFile）
 func (p *P2PService) GetFileOrderedWithProgress(ctx context.Context, fileHash string, f io.ReadWriter, progressCB ProgressCallback) error {
-	metaData, err := p.loadMetaData(ctx, fileHash)
-	if err != nil {
-		return err
-	}
-
-	// 创建进度跟踪器
-	var progress *downloadProgress
-	if progressCB != nil {
-		progress = &downloadProgress{
-			total:        int64(metaData.FileSize),
-			totalChunks:  len(metaData.Leaves),
-			callback:     progressCB,
-		}
-	}
-
-	// 使用 0 让 downloadChunksConcurrently 自动使用配置的并发数
-	results := make([][]byte, len(metaData.Leaves))
-	err = p.downloadChunksConcurrently(ctx, metaData.Leaves, 0, func(i int, chunk file.ChunkData, _ int64, data []byte) error {
-		results[i] = data
-		return nil
-	}, progress)
-	if err != nil {
-		return err
-	}
-
-	for i, data := range results {
-		if data == nil {
-			return fmt.Errorf("chunk %d data is nil (download may have failed)", i)
-		}
-		if _, err := f.Write(data); err != nil {
-			return fmt.Errorf("write chunk %d failed: %w", i, err)
-		}
-	}
-	logrus.Infof("File %s downloaded successfully (ordered)", metaData.FileName)
-	return nil
+	return p.StreamFile(ctx, fileHash, f, progressCB)
 }
 
 // GetFileRandomWithProgress 下载文件（随机位置写入）并支持进度回调
@@ -489,7 +679,7 @@ func (p *P2PService) GetFileRandomWithProgress(ctx context.Context, fileHash str
 	}
 
 	// 使用 0 让 downloadChunksConcurrently 自动使用配置的并发数
-	err = p.downloadChunksConcurrently(ctx, metaData.Leaves, 0, func(i int, chunk file.ChunkData, offset int64, data []byte) error {
+	err = p.downloadChunksConcurrently(ctx, fileHash, metaData, nil, 0, func(i int, chunk file.ChunkData, offset int64, data []byte) error {
 		if _, err := writeAtFile.WriteAt(data, offset); err != nil {
 			return fmt.Errorf("chunk %d: write failed at offset %d: %w", i, offset, err)
 		}
@@ -527,7 +717,7 @@ func (p *P2PService) DownloadFileStreaming(
 	}
 
 	// 使用 0 让 downloadChunksConcurrently 自动使用配置的并发数
-	return p.downloadChunksConcurrently(ctx, metaData.Leaves, 0, func(i int, chunk file.ChunkData, offset int64, data []byte) error {
+	return p.downloadChunksConcurrently(ctx, fileHash, metaData, nil, 0, func(i int, chunk file.ChunkData, offset int64, data []byte) error {
 		// 直接写入目标文件，不在内存中缓存
 		if _, err := target.WriteAt(data, offset); err != nil {
 			return fmt.Errorf("write chunk %d at offset %d failed: %w", i, offset, err)
@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"io"
 	"os"
+
+	"p2pFileTransfer/pkg/chunker"
 )
 
 // Chunk represents a file chunk with its hash and data
@@ -13,36 +15,40 @@ type Chunk struct {
 	Data []byte
 }
 
-// CalculateChunkHashes reads a file and calculates SHA256 hash for each chunk
+// CalculateChunkHashes reads a file and calculates SHA256 hash for each fixed-size chunk
 func CalculateChunkHashes(file *os.File, chunkSize uint) ([]Chunk, error) {
-	var chunks []Chunk
-	buffer := make([]byte, chunkSize)
+	chunks, err := CalculateChunks(file, chunker.Config{Mode: chunker.ModeFixed, BlockSize: int(chunkSize)})
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, err
-		}
-		if n == 0 {
-			break
-		}
+	// Reset file pointer to beginning
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
 
-		data := make([]byte, n)
-		copy(data, buffer[:n])
+	return chunks, nil
+}
+
+// CalculateChunks splits r with the given chunking config (fixed-size or FastCDC)
+// and calculates SHA256 hash for each chunk
+func CalculateChunks(r io.Reader, cfg chunker.Config) ([]Chunk, error) {
+	var chunks []Chunk
+	err := chunker.Split(r, cfg, func(chunk []byte) error {
+		data := make([]byte, len(chunk))
+		copy(data, chunk)
 
 		hash := sha256.Sum256(data)
 		chunks = append(chunks, Chunk{
 			Hash: hash[:],
 			Data: data,
 		})
-	}
-
-	// Reset file pointer to beginning
-	_, err := file.Seek(0, 0)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
 	return chunks, nil
 }
 
@@ -0,0 +1,507 @@
+// Package p2p 提供本地元数据索引
+//
+// MetaDataIndex 功能:
+//   - 索引: 记录元数据目录中每个文件的名称、大小、树类型和时间，列出文件时无需逐个读取 <CID>.json
+//   - 查询: 按名称、树类型和更新时间过滤，按名称、大小或时间排序，基于游标分页
+//   - 持久化: 索引保存在 <MetadataStoragePath>/index.json，每次修改后经临时文件原子替换
+//
+// 注意事项:
+//   - 上传、更新和删除元数据时需要同步更新索引
+//   - 加载时与元数据目录对账：修改时间变化的元数据重新读取，已删除的元数据移出索引
+//   - 查询时只在元数据目录的修改时间变化或距上次对账超过 metaDataReconcileInterval 时重新对账，
+//     因此其他进程（如 CLI 上传）新增或删除的元数据在下一次查询时出现在索引中，原地改写的元数据最晚在该间隔后生效
+//   - index.json 只是缓存，多个进程同时保存时以最后写入的为准，缺失的项在下一次对账时补回
+//   - 游标记录上一页最后一个文件的排序键，翻页期间新增或删除文件不会导致重复或遗漏
+package p2p
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/file"
+)
+
+// MetaDataIndexFileName 元数据索引的文件名（位于元数据目录）
+const MetaDataIndexFileName = "index.json"
+
+// 分页大小
+const (
+	DefaultMetaDataPageSize = 50
+	MaxMetaDataPageSize     = 1000
+)
+
+// metaDataReconcileInterval 元数据目录修改时间未变化时，查询重新对账的最长间隔
+// 原地改写已有的元数据文件不改变目录的修改时间，由该间隔兜底
+const metaDataReconcileInterval = 30 * time.Second
+
+// 排序字段
+const (
+	MetaDataSortName    = "name"
+	MetaDataSortSize    = "size"
+	MetaDataSortCreated = "created"
+	MetaDataSortUpdated = "updated"
+)
+
+var (
+	// ErrInvalidCursor 游标无法解析或与查询的排序方式不一致
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrUnknownSortField 查询的排序字段未知
+	ErrUnknownSortField = errors.New("unknown sort field")
+)
+
+// MetaDataIndexEntry 索引中的一个文件
+type MetaDataIndexEntry struct {
+	CID         string    `json:"cid"`
+	FileName    string    `json:"fileName"`
+	FileSize    uint64    `json:"fileSize"`
+	TreeType    string    `json:"treeType"`
+	Description string    `json:"description,omitempty"`
+	Chunking    string    `json:"chunking"`   // 分块方式: "fixed" | "fastcdc"
+	ChunkCount  int       `json:"chunkCount"` // 分块数量
+	CreatedAt   time.Time `json:"createdAt"`  // 首次加入索引的时间
+	UpdatedAt   time.Time `json:"updatedAt"`  // 元数据最后一次修改的时间
+	ModTime     int64     `json:"modTime"`    // 元数据文件的修改时间（UnixNano），用于加载时对账
+}
+
+// MetaDataQuery 文件列表查询条件，零值表示不过滤
+type MetaDataQuery struct {
+	Name     string    // 文件名包含该字符串（不区分大小写）
+	TreeType string    // 树类型完全匹配
+	Since    time.Time // UpdatedAt 不早于该时间
+	Until    time.Time // UpdatedAt 早于该时间
+	Sort     string    // 排序字段，空表示 MetaDataSortUpdated
+	Desc     bool      // 降序
+	Limit    int       // 每页数量，<= 0 表示 DefaultMetaDataPageSize
+	Cursor   string    // 上一页返回的 NextCursor
+}
+
+// MetaDataPage 一页查询结果
+type MetaDataPage struct {
+	Files      []MetaDataIndexEntry `json:"files"`
+	Total      int                  `json:"total"`                // 满足过滤条件的文件总数
+	NextCursor string               `json:"nextCursor,omitempty"` // 为空表示没有下一页
+}
+
+// metaDataCursor 游标内容，记录上一页最后一个文件的排序键
+type metaDataCursor struct {
+	Sort string    `json:"s"`
+	Desc bool      `json:"d,omitempty"`
+	CID  string    `json:"c"`
+	Name string    `json:"n,omitempty"`
+	Size uint64    `json:"z,omitempty"`
+	Time time.Time `json:"t,omitempty"`
+}
+
+// MetaDataIndex 本地元数据索引
+type MetaDataIndex struct {
+	metadataPath string
+	path         string
+
+	mu           sync.RWMutex
+	entries      map[string]*MetaDataIndexEntry
+	dirModTime   int64     // 上次对账前元数据目录的修改时间（UnixNano）
+	reconciledAt time.Time // 上次对账的时间
+}
+
+// LoadMetaDataIndex 加载元数据目录的索引并与目录中的 <CID>.json 对账
+// 索引文件不存在或损坏时从元数据目录重建
+// 参数:
+//   - metadataPath: 元数据目录
+//
+// 返回值:
+//   - *MetaDataIndex: 元数据索引
+//   - error: 读取元数据目录或保存索引失败时返回错误
+func LoadMetaDataIndex(metadataPath string) (*MetaDataIndex, error) {
+	idx := &MetaDataIndex{
+		metadataPath: metadataPath,
+		path:         filepath.Join(metadataPath, MetaDataIndexFileName),
+		entries:      make(map[string]*MetaDataIndexEntry),
+	}
+
+	data, err := os.ReadFile(idx.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read metadata index: %w", err)
+	}
+	if err == nil {
+		var entries []MetaDataIndexEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			logrus.Warnf("Failed to parse metadata index %s, rebuilding: %v", idx.path, err)
+			entries = nil
+		}
+		for i := range entries {
+			idx.entries[entries[i].CID] = &entries[i]
+		}
+	}
+
+	dirModTime, err := metaDataDirModTime(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := idx.reconcile()
+	if err != nil {
+		return nil, err
+	}
+	idx.dirModTime, idx.reconciledAt = dirModTime, time.Now()
+	if changed {
+		if err := idx.saveLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// metaDataDirModTime 返回元数据目录的修改时间（UnixNano），目录不存在时返回 0
+func metaDataDirModTime(metadataPath string) (int64, error) {
+	info, err := os.Stat(metadataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to stat metadata directory: %w", err)
+	}
+	return info.ModTime().UnixNano(), nil
+}
+
+// refresh 元数据目录的修改时间变化或距上次对账超过 metaDataReconcileInterval 时与目录对账
+// 目录的修改时间在对账前读取，对账期间写入的文件在下一次查询时再次对账
+func (idx *MetaDataIndex) refresh() error {
+	dirModTime, err := metaDataDirModTime(idx.metadataPath)
+	if err != nil {
+		return err
+	}
+	idx.mu.RLock()
+	fresh := idx.freshLocked(dirModTime)
+	idx.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	// 等待写锁期间其他查询可能已完成对账
+	if idx.freshLocked(dirModTime) {
+		return nil
+	}
+	changed, err := idx.reconcile()
+	if err != nil {
+		return err
+	}
+	idx.dirModTime, idx.reconciledAt = dirModTime, time.Now()
+	if changed {
+		if err := idx.saveLocked(); err != nil {
+			logrus.Warnf("Failed to save metadata index: %v", err)
+		}
+	}
+	return nil
+}
+
+// freshLocked 检查上次对账是否仍然有效（调用方持有 idx.mu）
+func (idx *MetaDataIndex) freshLocked(dirModTime int64) bool {
+	return dirModTime == idx.dirModTime && time.Since(idx.reconciledAt) < metaDataReconcileInterval
+}
+
+// reconcile 使索引与元数据目录一致，返回索引是否有变化（调用方持有 idx.mu 写锁或独占 idx）
+func (idx *MetaDataIndex) reconcile() (bool, error) {
+	dirEntries, err := os.ReadDir(idx.metadataPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read metadata directory: %w", err)
+	}
+
+	changed := false
+	seen := make(map[string]bool, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		cid, err := normalizeCID(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		seen[cid] = true
+
+		if entry, ok := idx.entries[cid]; ok && entry.ModTime == info.ModTime().UnixNano() {
+			continue
+		}
+		metaData, err := readMetaDataFile(filepath.Join(idx.metadataPath, name))
+		if err != nil {
+			logrus.Warnf("Failed to index metadata %s: %v", name, err)
+			continue
+		}
+		idx.putLocked(cid, metaData, info.ModTime())
+		changed = true
+	}
+
+	for cid := range idx.entries {
+		if !seen[cid] {
+			delete(idx.entries, cid)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// Put 在元数据文件写入后更新索引
+// 参数:
+//   - cid: 文件 CID（hex）
+//   - metaData: 已写入 <MetadataStoragePath>/<cid>.json 的元数据
+func (idx *MetaDataIndex) Put(cid string, metaData *file.MetaData) error {
+	cid, err := normalizeCID(cid)
+	if err != nil {
+		return err
+	}
+	updatedAt := time.Now()
+	if info, err := os.Stat(filepath.Join(idx.metadataPath, cid+".json")); err == nil {
+		updatedAt = info.ModTime()
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.putLocked(cid, metaData, updatedAt)
+	return idx.saveLocked()
+}
+
+// putLocked 写入索引项，已存在时保留创建时间（调用方持有 idx.mu 写锁或独占 idx）
+func (idx *MetaDataIndex) putLocked(cid string, metaData *file.MetaData, modTime time.Time) {
+	entry := &MetaDataIndexEntry{
+		CID:         cid,
+		FileName:    metaData.FileName,
+		FileSize:    metaData.FileSize,
+		TreeType:    metaData.TreeType,
+		Description: metaData.Description,
+		Chunking:    "fixed",
+		ChunkCount:  len(metaData.Leaves),
+		CreatedAt:   modTime,
+		UpdatedAt:   modTime,
+		ModTime:     modTime.UnixNano(),
+	}
+	if metaData.Chunking != nil && metaData.Chunking.Mode != "" {
+		entry.Chunking = metaData.Chunking.Mode
+	}
+	if old, ok := idx.entries[cid]; ok {
+		entry.CreatedAt = old.CreatedAt
+	}
+	idx.entries[cid] = entry
+}
+
+// Remove 在元数据文件删除后移出索引，文件不在索引中时不做任何事
+func (idx *MetaDataIndex) Remove(cid string) error {
+	cid, err := normalizeCID(cid)
+	if err != nil {
+		return err
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.entries[cid]; !ok {
+		return nil
+	}
+	delete(idx.entries, cid)
+	return idx.saveLocked()
+}
+
+// Query 按需与元数据目录对账（见 refresh），再按条件过滤、排序并返回一页文件
+// 返回值:
+//   - *MetaDataPage: 查询结果
+//   - error: 排序字段未知时返回 ErrUnknownSortField，游标无效时返回 ErrInvalidCursor，读取元数据目录失败时返回其他错误
+func (idx *MetaDataIndex) Query(q MetaDataQuery) (*MetaDataPage, error) {
+	if q.Sort == "" {
+		q.Sort = MetaDataSortUpdated
+	}
+	switch q.Sort {
+	case MetaDataSortName, MetaDataSortSize, MetaDataSortCreated, MetaDataSortUpdated:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSortField, q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultMetaDataPageSize
+	}
+	if q.Limit > MaxMetaDataPageSize {
+		q.Limit = MaxMetaDataPageSize
+	}
+
+	var after *MetaDataIndexEntry
+	if q.Cursor != "" {
+		cursor, err := decodeMetaDataCursor(q.Cursor)
+		if err != nil || cursor.Sort != q.Sort || cursor.Desc != q.Desc {
+			return nil, ErrInvalidCursor
+		}
+		after = cursor.entry()
+	}
+
+	// 其他进程写入或删除的元数据文件在查询时生效
+	if err := idx.refresh(); err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(q.Name)
+	idx.mu.RLock()
+	matched := make([]MetaDataIndexEntry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		if name != "" && !strings.Contains(strings.ToLower(entry.FileName), name) {
+			continue
+		}
+		if q.TreeType != "" && entry.TreeType != q.TreeType {
+			continue
+		}
+		if !q.Since.IsZero() && entry.UpdatedAt.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && !entry.UpdatedAt.Before(q.Until) {
+			continue
+		}
+		matched = append(matched, *entry)
+	}
+	idx.mu.RUnlock()
+
+	less := metaDataLess(q.Sort, q.Desc)
+	sort.Slice(matched, func(i, j int) bool { return less(&matched[i], &matched[j]) })
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matched), func(i int) bool { return less(after, &matched[i]) })
+	}
+	end := min(start+q.Limit, len(matched))
+
+	page := &MetaDataPage{Files: matched[start:end], Total: len(matched)}
+	if end < len(matched) {
+		page.NextCursor = encodeMetaDataCursor(q.Sort, q.Desc, &matched[end-1])
+	}
+	return page, nil
+}
+
+// metaDataLess 返回按 sortBy 排序的比较函数，排序键相同时按 CID 排序保证顺序唯一
+func metaDataLess(sortBy string, desc bool) func(a, b *MetaDataIndexEntry) bool {
+	return func(a, b *MetaDataIndexEntry) bool {
+		c := 0
+		switch sortBy {
+		case MetaDataSortName:
+			c = strings.Compare(strings.ToLower(a.FileName), strings.ToLower(b.FileName))
+		case MetaDataSortSize:
+			c = compareUint64(a.FileSize, b.FileSize)
+		case MetaDataSortCreated:
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case MetaDataSortUpdated:
+			c = a.UpdatedAt.Compare(b.UpdatedAt)
+		}
+		if c == 0 {
+			c = strings.Compare(a.CID, b.CID)
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+}
+
+func compareUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func encodeMetaDataCursor(sortBy string, desc bool, last *MetaDataIndexEntry) string {
+	cursor := metaDataCursor{Sort: sortBy, Desc: desc, CID: last.CID}
+	switch sortBy {
+	case MetaDataSortName:
+		cursor.Name = last.FileName
+	case MetaDataSortSize:
+		cursor.Size = last.FileSize
+	case MetaDataSortCreated:
+		cursor.Time = last.CreatedAt
+	case MetaDataSortUpdated:
+		cursor.Time = last.UpdatedAt
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMetaDataCursor(s string) (*metaDataCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cursor metaDataCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// entry 返回只包含排序键的索引项，用于在排序结果中定位游标
+func (c *metaDataCursor) entry() *MetaDataIndexEntry {
+	return &MetaDataIndexEntry{
+		CID:       c.CID,
+		FileName:  c.Name,
+		FileSize:  c.Size,
+		CreatedAt: c.Time,
+		UpdatedAt: c.Time,
+	}
+}
+
+// saveLocked 原子写入索引（调用方持有 idx.mu 写锁或独占 idx）
+func (idx *MetaDataIndex) saveLocked() error {
+	entries := make([]*MetaDataIndexEntry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CID < entries[j].CID })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata index: %w", err)
+	}
+	if err := os.MkdirAll(idx.metadataPath, 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	// 每次保存使用唯一的临时文件，多个进程同时保存时不会互相覆盖写了一半的文件
+	tmp, err := os.CreateTemp(idx.metadataPath, MetaDataIndexFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save metadata index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), idx.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save metadata index: %w", err)
+	}
+	return nil
+}
+
+// readMetaDataFile 读取并解析一个元数据文件
+func readMetaDataFile(path string) (*file.MetaData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var metaData file.MetaData
+	if err := json.Unmarshal(data, &metaData); err != nil {
+		return nil, err
+	}
+	return &metaData, nil
+}
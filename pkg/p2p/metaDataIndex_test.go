@@ -0,0 +1,225 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"p2pFileTransfer/pkg/file"
+)
+
+// indexBaseTime 测试元数据文件修改时间的起点
+var indexBaseTime = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// writeIndexMetaData 写入元数据文件并把修改时间设为 indexBaseTime 之后 minutes 分钟
+func writeIndexMetaData(t *testing.T, dir, cid, name string, size uint64, minutes int) {
+	t.Helper()
+	data, err := json.Marshal(file.MetaData{FileName: name, FileSize: size, TreeType: TreeTypeRegular})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, cid+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := indexBaseTime.Add(time.Duration(minutes) * time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// queryAll 逐页查询直到没有下一页，返回按顺序的 CID
+func queryAll(t *testing.T, idx *MetaDataIndex, q MetaDataQuery) []string {
+	t.Helper()
+	var cids []string
+	for {
+		page, err := idx.Query(q)
+		if err != nil {
+			t.Fatalf("Query(%+v): %v", q, err)
+		}
+		for _, entry := range page.Files {
+			cids = append(cids, entry.CID)
+		}
+		if page.NextCursor == "" {
+			return cids
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestMetaDataIndexQueryOrder(t *testing.T) {
+	dir := t.TempDir()
+	// aa 和 cc 大小相同，bb 和 dd 修改时间相同
+	writeIndexMetaData(t, dir, "cc", "Gamma.txt", 100, 1)
+	writeIndexMetaData(t, dir, "aa", "alpha.txt", 100, 3)
+	writeIndexMetaData(t, dir, "dd", "delta.txt", 300, 2)
+	writeIndexMetaData(t, dir, "bb", "beta.txt", 200, 2)
+	idx, err := LoadMetaDataIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadMetaDataIndex: %v", err)
+	}
+
+	tests := []struct {
+		sort string
+		desc bool
+		want string
+	}{
+		{MetaDataSortName, false, "aa bb dd cc"}, // 不区分大小写
+		{MetaDataSortName, true, "cc dd bb aa"},
+		{MetaDataSortSize, false, "aa cc bb dd"}, // 大小相同时按 CID
+		{MetaDataSortSize, true, "dd bb cc aa"},
+		{MetaDataSortUpdated, false, "cc bb dd aa"},
+		{MetaDataSortUpdated, true, "aa dd bb cc"},
+	}
+	for _, tt := range tests {
+		// 每页 1 个文件，排序键相同的文件跨页时既不重复也不遗漏
+		for _, limit := range []int{1, 10} {
+			got := strings.Join(queryAll(t, idx, MetaDataQuery{Sort: tt.sort, Desc: tt.desc, Limit: limit}), " ")
+			if got != tt.want {
+				t.Errorf("sort=%s desc=%v limit=%d: got %q, want %q", tt.sort, tt.desc, limit, got, tt.want)
+			}
+		}
+	}
+
+	// 翻页期间删除游标所在的文件，下一页从其后继续
+	page, err := idx.Query(MetaDataQuery{Sort: MetaDataSortSize, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "cc.json")); err != nil {
+		t.Fatal(err)
+	}
+	page, err = idx.Query(MetaDataQuery{Sort: MetaDataSortSize, Limit: 2, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Files) != 2 || page.Files[0].CID != "bb" || page.Total != 3 {
+		t.Fatalf("page after removing cursor entry = %+v", page)
+	}
+}
+
+func TestMetaDataIndexQueryInvalidCursor(t *testing.T) {
+	dir := t.TempDir()
+	writeIndexMetaData(t, dir, "aa", "a.txt", 1, 0)
+	writeIndexMetaData(t, dir, "bb", "b.txt", 2, 0)
+	idx, err := LoadMetaDataIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadMetaDataIndex: %v", err)
+	}
+
+	page, err := idx.Query(MetaDataQuery{Sort: MetaDataSortName, Limit: 1})
+	if err != nil || page.NextCursor == "" {
+		t.Fatalf("first page = %+v, %v", page, err)
+	}
+
+	for _, q := range []MetaDataQuery{
+		{Sort: MetaDataSortName, Cursor: "not base64!"},
+		{Sort: MetaDataSortName, Cursor: "bm90IGpzb24"},               // base64 但不是 JSON
+		{Sort: MetaDataSortSize, Cursor: page.NextCursor},             // 排序字段不同
+		{Sort: MetaDataSortName, Desc: true, Cursor: page.NextCursor}, // 排序方向不同
+	} {
+		if _, err := idx.Query(q); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Query(%+v): got %v, want ErrInvalidCursor", q, err)
+		}
+	}
+	if _, err := idx.Query(MetaDataQuery{Sort: "color"}); !errors.Is(err, ErrUnknownSortField) {
+		t.Errorf("unknown sort field: got %v, want ErrUnknownSortField", err)
+	}
+}
+
+func TestMetaDataIndexQueryReconciles(t *testing.T) {
+	dir := t.TempDir()
+	writeIndexMetaData(t, dir, "aa", "a.txt", 1, 0)
+	idx, err := LoadMetaDataIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadMetaDataIndex: %v", err)
+	}
+
+	// 其他进程写入和删除的元数据在下一次查询时生效，并保存到索引文件
+	writeIndexMetaData(t, dir, "bb", "b.txt", 2, 1)
+	if err := os.Remove(filepath.Join(dir, "aa.json")); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(queryAll(t, idx, MetaDataQuery{}), " "); got != "bb" {
+		t.Fatalf("after external changes: got %q, want \"bb\"", got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, MetaDataIndexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []MetaDataIndexEntry
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 1 || saved[0].CID != "bb" {
+		t.Fatalf("saved index = %s, %v", data, err)
+	}
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(tmps) != 0 {
+		t.Fatalf("temporary files left behind: %v", tmps)
+	}
+}
+
+func TestMetaDataIndexQueryReconcilesOnDirChangeOrInterval(t *testing.T) {
+	dir := t.TempDir()
+	writeIndexMetaData(t, dir, "aa", "a.txt", 1, 0)
+	idx, err := LoadMetaDataIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadMetaDataIndex: %v", err)
+	}
+	queryAll(t, idx, MetaDataQuery{})
+
+	// 原地改写已有的元数据不改变目录的修改时间，间隔内的查询不重新对账
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeIndexMetaData(t, dir, "aa", "renamed.txt", 1, 5)
+	if err := os.Chtimes(dir, dirInfo.ModTime(), dirInfo.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	page, err := idx.Query(MetaDataQuery{})
+	if err != nil || len(page.Files) != 1 || page.Files[0].FileName != "a.txt" {
+		t.Fatalf("query within interval = %+v, %v; want cached a.txt", page, err)
+	}
+
+	// 超过间隔后重新对账
+	idx.mu.Lock()
+	idx.reconciledAt = time.Now().Add(-metaDataReconcileInterval)
+	idx.mu.Unlock()
+	page, err = idx.Query(MetaDataQuery{})
+	if err != nil || len(page.Files) != 1 || page.Files[0].FileName != "renamed.txt" {
+		t.Fatalf("query after interval = %+v, %v; want renamed.txt", page, err)
+	}
+
+	// 目录变化（新增文件）时立即对账
+	writeIndexMetaData(t, dir, "bb", "b.txt", 2, 1)
+	if got := strings.Join(queryAll(t, idx, MetaDataQuery{Sort: MetaDataSortName}), " "); got != "bb aa" {
+		t.Fatalf("after adding a file: got %q, want \"bb aa\"", got)
+	}
+}
+
+func TestMetaDataIndexQueryDirectoryError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "metadata")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := LoadMetaDataIndex(dir)
+	if err != nil {
+		t.Fatalf("LoadMetaDataIndex: %v", err)
+	}
+
+	// 元数据目录被替换为普通文件，对账时读取目录失败
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = idx.Query(MetaDataQuery{})
+	if err == nil || errors.Is(err, ErrInvalidCursor) || errors.Is(err, ErrUnknownSortField) {
+		t.Fatalf("Query = %v, want a directory read error", err)
+	}
+}
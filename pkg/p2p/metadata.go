@@ -0,0 +1,341 @@
+// Package p2p 提供文件元数据的发布、本地读取和校验功能
+//
+// MetaData 功能:
+//   - 本地读取: 从 MetadataStoragePath 读取 <CID>.json
+//   - 完整性校验: 校验元数据与 CID 是否匹配
+//   - DHT 发布: 上传后将签名的元数据记录写入 DHT，键为 /meta/<CID>
+//   - 指针记录: 大元数据只在 DHT 中存储指针，完整内容通过 QueryMetaData 获取
+//
+// 校验规则:
+//   - regular: 由 Leaves 计算出的 Merkle 根必须等于 RootHash (CID)
+//   - chameleon: 由 Leaves 计算出的 Merkle 根必须等于 RegularRootHash，
+//     且 RegularRootHash、RandomNum、PublicKey 必须能验证变色龙哈希 RootHash (CID)
+//   - 轻量元数据: 所有叶子都没有哈希时跳过叶子列表校验（chameleon 仍校验变色龙哈希），
+//     下载时每个 Chunk 通过 Merkle 证明校验（见 chunkProof.go）；部分叶子缺少哈希视为无效
+//   - 叶子大小: 叶子 ChunkSize 之和必须覆盖文件大小，FastCDC 分块的叶子大小之和必须等于文件大小
+//
+// 注意事项:
+//   - 从网络获取的元数据在使用前必须调用 VerifyMetaData
+//   - 历史上 CLI 与 HTTP API 使用了两种奇数节点处理方式构建 regular 树，两种都被接受
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chameleonMerkleTree"
+	"p2pFileTransfer/pkg/chunker"
+	"p2pFileTransfer/pkg/file"
+)
+
+const (
+	TreeTypeChameleon = "chameleon"
+	TreeTypeRegular   = "regular"
+)
+
+// VerifyMetaData 校验元数据是否属于给定的 CID（hex 编码）
+func VerifyMetaData(metaData *file.MetaData, cid string) error {
+	if metaData == nil {
+		return errors.New("metadata is nil")
+	}
+
+	cidBytes, err := hex.DecodeString(cid)
+	if err != nil {
+		return fmt.Errorf("invalid cid format: %w", err)
+	}
+	if !bytes.Equal(metaData.RootHash, cidBytes) {
+		return fmt.Errorf("root hash %x does not match cid %s", metaData.RootHash, cid)
+	}
+	if len(metaData.Leaves) == 0 {
+		return errors.New("metadata has no leaves")
+	}
+	if err := verifyLeafSizes(metaData); err != nil {
+		return err
+	}
+
+	light, err := isLightMetaData(metaData)
+	if err != nil {
+		return err
+	}
+	leafHashes := make([][]byte, len(metaData.Leaves))
+	for i, leaf := range metaData.Leaves {
+		leafHashes[i] = leaf.ChunkHash
+	}
+
+	// 轻量元数据没有叶子哈希，无法在此校验叶子列表；下载时每个 Chunk 通过 Merkle 证明对根校验，
+	// 证明路径长度和最后一个叶子的证明把叶子数、ChunkSize 和 FileSize 绑定到根（见 chunkProof.go verifyProofShape）
+	switch metaData.TreeType {
+	case TreeTypeRegular:
+		if !light && !merkleRootMatches(leafHashes, metaData.RootHash) {
+			return errors.New("leaves do not match root hash")
+		}
+	case TreeTypeChameleon:
+		if !light && !merkleRootMatches(leafHashes, metaData.RegularRootHash) {
+			return errors.New("leaves do not match regular root hash")
+		}
+		ok, err := chameleonMerkleTree.VerifySerializedChameleonHash(
+			metaData.RegularRootHash, metaData.RootHash, metaData.RandomNum, metaData.PublicKey)
+		if err != nil {
+			return fmt.Errorf("chameleon hash verification failed: %w", err)
+		}
+		if !ok {
+			return errors.New("chameleon hash does not match public key and random number")
+		}
+	default:
+		return fmt.Errorf("unknown tree type: %q", metaData.TreeType)
+	}
+
+	return nil
+}
+
+// verifyLeafSizes 检查叶子大小
+// 下载按 ChunkSize 累加计算每个叶子在文件中的偏移，叶子必须覆盖整个文件且每个都不超过 MaxChunkSize；
+// 内容定义分块的叶子都是实际大小，且没有 Merkle 树补齐的叶子，大小之和必须等于文件大小
+func verifyLeafSizes(metaData *file.MetaData) error {
+	var total int64
+	for i, leaf := range metaData.Leaves {
+		if leaf.ChunkSize < 0 || leaf.ChunkSize > MaxChunkSize {
+			return fmt.Errorf("leaf %d has invalid chunk size %d", i, leaf.ChunkSize)
+		}
+		total += int64(leaf.ChunkSize)
+	}
+	if total < int64(metaData.FileSize) {
+		return fmt.Errorf("leaves cover %d bytes, less than file size %d", total, metaData.FileSize)
+	}
+	if metaData.Chunking != nil && metaData.Chunking.Mode == chunker.ModeFastCDC && total != int64(metaData.FileSize) {
+		return fmt.Errorf("content-defined leaves cover %d bytes, file size is %d", total, metaData.FileSize)
+	}
+	return nil
+}
+
+// merkleRootMatches 检查叶子哈希构建出的 Merkle 根是否等于 root
+func merkleRootMatches(leafHashes [][]byte, root []byte) bool {
+	if len(root) == 0 {
+		return false
+	}
+
+	// HTTP API 与变色龙树使用的构建方式（奇数节点与自身拼接）
+	if computed, err := chameleonMerkleTree.BuildMerkleRootFromHashes(leafHashes); err == nil && bytes.Equal(computed, root) {
+		return true
+	}
+
+	// CLI regular 上传使用的构建方式（奇数节点直接上提）
+	chunks := make([]Chunk, len(leafHashes))
+	for i, h := range leafHashes {
+		chunks[i] = Chunk{Hash: h}
+	}
+	return bytes.Equal(BuildMerkleRoot(chunks), root)
+}
+
+// getMetaDataPath 返回本地元数据文件路径: <MetadataStoragePath>/<cid>.json
+func (p *P2PService) getMetaDataPath(cid string) string {
+	return filepath.Join(p.Config.MetadataStoragePath, cid+".json")
+}
+
+// LoadLocalMetaData 从本地元数据目录读取元数据
+func (p *P2PService) LoadLocalMetaData(cid string) (*file.MetaData, error) {
+	data, err := os.ReadFile(p.getMetaDataPath(cid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local metadata: %w", err)
+	}
+
+	var metaData file.MetaData
+	if err := json.Unmarshal(data, &metaData); err != nil {
+		return nil, fmt.Errorf("failed to parse local metadata: %w", err)
+	}
+	return &metaData, nil
+}
+
+// forEachLocalMetaData 遍历元数据目录中的所有 <CID>.json
+// 文件名不是 hex CID（如 pins.json）的文件被跳过；目录不存在时不调用 fn。
+// 无法读取或解析的元数据文件不调用 fn，遍历结束后返回这些错误，
+// 依赖完整扫描结果的调用方（垃圾回收、配额保护）必须把错误视为扫描不完整
+func forEachLocalMetaData(metadataPath string, fn func(cid string, metaData *file.MetaData)) error {
+	entries, err := os.ReadDir(metadataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read metadata directory: %w", err)
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		cid := strings.ToLower(strings.TrimSuffix(name, ".json"))
+		if _, err := hex.DecodeString(cid); err != nil || cid == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(metadataPath, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read metadata %s: %w", name, err))
+			continue
+		}
+		var metaData file.MetaData
+		if err := json.Unmarshal(data, &metaData); err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse metadata %s: %w", name, err))
+			continue
+		}
+		fn(cid, &metaData)
+	}
+	return errors.Join(errs...)
+}
+
+// MetaDataRecord DHT 中存储的元数据记录
+// 元数据较小时直接内联；超过 MaxInlineMetaDataSize 时只存储指针，
+// 完整元数据通过 QueryMetaData 协议从发布者获取
+type MetaDataRecord struct {
+	Type     string           `json:"type"`               // "inline" | "pointer"
+	MetaData *file.MetaData   `json:"metadata,omitempty"` // 内联的完整元数据
+	Pointer  *MetaDataPointer `json:"pointer,omitempty"`  // 指向完整元数据的指针
+}
+
+// MetaDataPointer 大元数据的指针记录，仅包含校验所需的根信息
+type MetaDataPointer struct {
+	RootHash        string        `json:"rootHash"`                  // CID（hex）
+	RegularRootHash string        `json:"regularRootHash,omitempty"` // 常规 Merkle 根（hex，仅chameleon）
+	RandomNum       string        `json:"randomNum,omitempty"`       // 变色龙随机数（hex，仅chameleon）
+	PublicKey       string        `json:"publicKey,omitempty"`       // 变色龙公钥（hex，仅chameleon）
+	TreeType        string        `json:"treeType"`
+	FileName        string        `json:"fileName"`
+	FileSize        uint64        `json:"fileSize"`
+	LeafCount       int           `json:"leafCount"`
+	Publisher       peer.AddrInfo `json:"publisher"` // 持有完整元数据的节点
+}
+
+const (
+	MetaDataRecordInline  = "inline"
+	MetaDataRecordPointer = "pointer"
+
+	// MaxInlineMetaDataSize 内联到 DHT 记录中的元数据最大大小
+	// 超过此大小（约 500 个叶子）时改为存储指针记录
+	MaxInlineMetaDataSize = 64 * 1024 // 64KB
+)
+
+// PublishMetaData 将签名的元数据记录发布到 DHT，键为 /meta/<CID>（hex 编码）
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - metaData: 文件元数据（需同时保存在 MetadataStoragePath 中，以便响应指针记录的查询）
+//   - chameleonPrivKey: 变色龙私钥，chameleon 文件必须提供；regular 文件传 nil，使用节点密钥签名
+//
+// 返回值:
+//   - error: 错误信息（单节点网络中本地存储成功但无法复制到其他节点时也会返回错误）
+func (p *P2PService) PublishMetaData(ctx context.Context, metaData *file.MetaData, chameleonPrivKey []byte) error {
+	if metaData == nil || len(metaData.RootHash) == 0 {
+		return errors.New("metadata without root hash")
+	}
+	cid := hex.EncodeToString(metaData.RootHash)
+
+	record, err := p.newMetaDataRecord(metaData)
+	if err != nil {
+		return err
+	}
+	signed, err := p.signMetaDataRecord(cid, record, metaData, chameleonPrivKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal signed metadata record: %w", err)
+	}
+
+	if err := p.DHT.PutValue(ctx, metaDataKey(cid), data); err != nil {
+		return fmt.Errorf("failed to publish metadata %s: %w", cid, err)
+	}
+	logrus.Infof("Metadata %s published to DHT (%s record, seq %d, %d bytes)", cid, record.Type, signed.Seq, len(data))
+	return nil
+}
+
+// newMetaDataRecord 根据元数据大小构建内联记录或指针记录
+func (p *P2PService) newMetaDataRecord(metaData *file.MetaData) (*MetaDataRecord, error) {
+	raw, err := json.Marshal(metaData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if len(raw) <= MaxInlineMetaDataSize {
+		return &MetaDataRecord{Type: MetaDataRecordInline, MetaData: metaData}, nil
+	}
+
+	return &MetaDataRecord{
+		Type: MetaDataRecordPointer,
+		Pointer: &MetaDataPointer{
+			RootHash:        hex.EncodeToString(metaData.RootHash),
+			RegularRootHash: hex.EncodeToString(metaData.RegularRootHash),
+			RandomNum:       hex.EncodeToString(metaData.RandomNum),
+			PublicKey:       hex.EncodeToString(metaData.PublicKey),
+			TreeType:        metaData.TreeType,
+			FileName:        metaData.FileName,
+			FileSize:        metaData.FileSize,
+			LeafCount:       len(metaData.Leaves),
+			Publisher: peer.AddrInfo{
+				ID:    p.Host.ID(),
+				Addrs: p.Host.Addrs(),
+			},
+		},
+	}, nil
+}
+
+// resolveMetaDataRecord 解析 DHT 记录，指针记录会通过 QueryMetaData 获取完整元数据
+func (p *P2PService) resolveMetaDataRecord(ctx context.Context, cid string, record *MetaDataRecord) (*file.MetaData, error) {
+	switch record.Type {
+	case MetaDataRecordInline:
+		if record.MetaData == nil {
+			return nil, errors.New("inline record without metadata")
+		}
+		return record.MetaData, nil
+	case MetaDataRecordPointer:
+		if record.Pointer == nil {
+			return nil, errors.New("pointer record without pointer")
+		}
+		return p.resolveMetaDataPointer(ctx, cid, record.Pointer)
+	default:
+		return nil, fmt.Errorf("unknown metadata record type: %q", record.Type)
+	}
+}
+
+// resolveMetaDataPointer 连接指针中的发布者并查询完整元数据
+func (p *P2PService) resolveMetaDataPointer(ctx context.Context, cid string, pointer *MetaDataPointer) (*file.MetaData, error) {
+	if !strings.EqualFold(pointer.RootHash, cid) {
+		return nil, fmt.Errorf("pointer root hash %s does not match cid %s", pointer.RootHash, cid)
+	}
+
+	// 本节点就是发布者时直接读取本地元数据
+	metaData, err := p.LoadLocalMetaData(pointer.RootHash)
+	if err != nil {
+		// 确保与发布者建立连接，QueryMetaData 只查询已连接节点
+		if pointer.Publisher.ID != "" && pointer.Publisher.ID != p.Host.ID() {
+			if err := p.Host.Connect(ctx, pointer.Publisher); err != nil {
+				logrus.Warnf("Failed to connect to metadata publisher %s: %v", pointer.Publisher.ID, err)
+			}
+		}
+
+		metaData, err = p.QueryMetaData(ctx, cid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve metadata pointer: %w", err)
+		}
+	}
+
+	// chameleon 文件的 CID 不变，需要确认拿到的是指针对应的版本
+	if pointer.RegularRootHash != "" && hex.EncodeToString(metaData.RegularRootHash) != pointer.RegularRootHash {
+		return nil, fmt.Errorf("resolved metadata version %x does not match pointer %s",
+			metaData.RegularRootHash, pointer.RegularRootHash)
+	}
+	if len(metaData.Leaves) != pointer.LeafCount {
+		return nil, fmt.Errorf("resolved metadata has %d leaves, pointer expects %d",
+			len(metaData.Leaves), pointer.LeafCount)
+	}
+	return metaData, nil
+}
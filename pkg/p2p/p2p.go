@@ -6,12 +6,24 @@
 //   - 连接管理: 管理节点连接、统计和黑名单
 //   - 节点选择: 支持随机和轮询两种节点选择策略
 //   - 反吸血虫: 防止只下载不上传的节点
+//   - 重新公告: 周期性重新公告本地存储的 Chunk
+//   - 带宽限制: 全局和每节点的上传、下载限速
+//   - 垃圾回收: 删除未被本地元数据引用且未被固定的 Chunk
+//   - 存储配额: 超过配额时按最久未读取的顺序淘汰未固定的 Chunk
+//   - 完整性巡检: 限速校验本地 Chunk，隔离损坏的 Chunk 并从其他节点恢复
+//   - 元数据索引: 按名称、树类型和时间列出本地保存的文件元数据
 //
 // 主要组件:
 //   - P2PService: 核心服务，整合所有功能
 //   - ConnManager: 连接管理器，限制并发连接数
 //   - PeerSelector: 节点选择器接口
 //   - AntiLeecher: 反吸血虫机制
+//   - Reprovider: Chunk 重新公告
+//   - BandwidthLimiter: 令牌桶带宽限制
+//   - ChunkStore: 本地 Chunk 存储（见 pkg/chunkStore）
+//   - PinSet / GarbageCollector: 文件固定和未引用 Chunk 的垃圾回收
+//   - Scrubber: 本地 Chunk 完整性巡检
+//   - MetaDataIndex: 本地元数据索引
 //
 // 使用示例:
 //
@@ -34,45 +46,83 @@ import (
 	"github.com/multiformats/go-multiaddr"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/xerrors"
+	"p2pFileTransfer/pkg/chunkStore"
 	"p2pFileTransfer/pkg/file"
+	"path/filepath"
 	"time"
 )
 
-// 默认的 ProtocolPrefix 和 Validator 配置
+// 默认的 ProtocolPrefix 配置
 var defaultPrefix = "/default"
 
-type blankValidator struct{}
-
-func (blankValidator) Validate(_ string, _ []byte) error        { return nil }
-func (blankValidator) Select(_ string, _ [][]byte) (int, error) { return 0, nil }
-
 type P2PService struct {
 	Host         host.Host
 	DHT          *dht.IpfsDHT
 	Config       *P2PConfig
 	PeerSelector PeerSelector
+	Scheduler    Scheduler // Chunk 下载调度策略
 	AntiLeecher  AntiLeecher
 	FSAdapter    file.LocalFileSystemAdapter
-	ConnManager  *ConnManager // 连接管理器
-	Ctx          context.Context     // 服务上下文，用于优雅关闭
-	Cancel       context.CancelFunc  // 取消函数
+	ChunkStore   chunkStore.ChunkStore // 本地 Chunk 存储（即 Quota，读写都经过用量统计）
+	Quota        *chunkStore.QuotaStore // 存储用量和配额
+	Pins         *PinSet               // 被固定的文件
+	GC           *GarbageCollector     // Chunk 垃圾回收
+	ConnManager  *ConnManager       // 连接管理器
+	Reprovider   *Reprovider        // Chunk 重新公告
+	Scrubber     *Scrubber          // 本地 Chunk 完整性巡检
+	MetaIndex    *MetaDataIndex     // 本地元数据索引
+	Bandwidth    *BandwidthLimiter  // 带宽限制
+	Ctx          context.Context    // 服务上下文，用于优雅关闭
+	Cancel       context.CancelFunc // 取消函数
+
+	providerCache *providerCache        // Chunk 提供者缓存
+	haveInterest  *haveInterestSet      // 查询过位图、需要接收 Have 推送的节点
+	availability  *availabilityRegistry // 正在进行的下载的可用性表
+	chunkSessions *chunkSessionPool     // 分帧 chunk 协议的客户端会话
+	compressor    *chunkCompressor      // chunk 传输的压缩与解压
+	proofTrees    *proofTreeCache       // 生成 Merkle 证明使用的树
 }
 
 type P2PConfig struct {
-	Port              int
-	Insecure          bool
-	Seed              int64
-	BootstrapPeers    []multiaddr.Multiaddr
-	ProtocolPrefix    string
-	EnableAutoRefresh bool
-	NameSpace         string
-	Validator         record.Validator
-	ChunkStoragePath  string  // Chunk 文件存储路径
-	MaxRetries        int     // 最大重试次数
-	MaxConcurrency    int     // 最大并发下载数
-	RequestTimeout    int     // 请求超时时间（秒）
-	DataTimeout       int     // 数据传输超时时间（秒）
-	DHTTimeout        int     // DHT 操作超时时间（秒）
+	Port                int
+	Insecure            bool
+	Seed                int64
+	BootstrapPeers      []multiaddr.Multiaddr
+	ProtocolPrefix      string
+	EnableAutoRefresh   bool
+	NameSpace           string
+	Validator           record.Validator
+	ChunkStoragePath    string // Chunk 文件存储路径
+	ChunkStore          chunkStore.ChunkStore // 自定义 Chunk 存储，nil 时使用 ChunkStoragePath 下的分片文件系统存储
+	MetadataStoragePath string // 元数据文件存储路径（<CID>.json）
+	MaxRetries          int    // 最大重试次数
+	MaxConcurrency      int    // 最大并发下载数
+	RequestTimeout      int    // 请求超时时间（秒）
+	DataTimeout         int    // 数据传输超时时间（秒）
+	DHTTimeout          int    // DHT 操作超时时间（秒）
+	ProviderCacheTTL    int    // Chunk 提供者缓存有效期（秒）
+	Scheduler           string // Chunk 下载调度策略（sequential、rarest-first、endgame）
+	ReadAheadChunks     int    // OpenFile 读取器预取的 Chunk 数量，0 表示不预取
+	ReaderCacheChunks   int    // OpenFile 读取器缓存的 Chunk 数量
+	VerifyChunkProofs   bool   // 下载时通过 Merkle 证明对根哈希校验每个 Chunk（叶子没有哈希时总是启用）
+
+	CompressionCodecs    []string // Chunk 传输支持的压缩算法（按优先级，zstd、gzip、none），只含 none 时禁用压缩
+	CompressionCacheSize int64    // 压缩结果缓存大小（字节），0 表示不缓存
+
+	BandwidthLimits    BandwidthLimits     // 默认带宽限制（字节/秒），0 表示不限速
+	BandwidthSchedules []BandwidthSchedule // 时段限速计划
+
+	ReprovideInterval    int  // 重新公告本地 Chunk 的间隔（秒），0 表示禁用周期公告
+	ReprovideJitter      int  // 每轮重新公告附加的最大随机抖动（秒）
+	ReprovideConcurrency int  // 重新公告的最大并发数
+	ReprovideOnStart     bool // 启动时是否立即重新公告
+
+	GCInterval      int   // 定期垃圾回收的间隔（秒），0 表示只支持手动触发
+	MaxStorageBytes int64 // Chunk 存储配额（字节），0 表示不限制
+
+	ScrubInterval  int    // 完整性巡检的间隔（秒），0 表示只支持手动触发
+	ScrubRate      int64  // 巡检读取速率（字节/秒），0 表示不限速
+	QuarantinePath string // 损坏 Chunk 的隔离目录，为空时使用 ChunkStoragePath 下的 .quarantine
 }
 
 // NewP2PConfig 返回一个包含默认配置的 P2PConfig 实例
@@ -81,19 +131,35 @@ type P2PConfig struct {
 func NewP2PConfig() P2PConfig {
 	return P2PConfig{
 		// 此处Port设为0，即可随机分配一个端口；指定可能会导致端口占用，从而连接失败
-		Port:              0,
-		Insecure:          false,
-		Seed:              0,
-		ProtocolPrefix:    defaultPrefix,
-		EnableAutoRefresh: true,
-		NameSpace:         "v",
-		Validator:         blankValidator{}, // 使用默认的 blankValidator
-		ChunkStoragePath:  "files",          // 默认使用相对路径 ./files
-		MaxRetries:        3,                // 默认重试3次
-		MaxConcurrency:    16,               // 默认最大并发16
-		RequestTimeout:    5,                // 默认请求超时5秒
-		DataTimeout:       30,               // 默认数据传输超时30秒
-		DHTTimeout:        10,               // 默认DHT操作超时10秒
+		Port:                0,
+		Insecure:            false,
+		Seed:                0,
+		ProtocolPrefix:      defaultPrefix,
+		EnableAutoRefresh:   true,
+		NameSpace:           "v",
+		Validator:           SignedValueValidator{}, // 通用命名空间的值必须带签名
+		ChunkStoragePath:    "files",          // 默认使用相对路径 ./files
+		MetadataStoragePath: "metadata",       // 默认使用相对路径 ./metadata
+		MaxRetries:          3,                // 默认重试3次
+		MaxConcurrency:      16,               // 默认最大并发16
+		RequestTimeout:      5,                // 默认请求超时5秒
+		DataTimeout:         30,               // 默认数据传输超时30秒
+		DHTTimeout:          10,               // 默认DHT操作超时10秒
+		ProviderCacheTTL:    60,               // 默认提供者缓存60秒
+		Scheduler:           SchedulerSequential,
+		ReadAheadChunks:     DefaultReadAheadChunks,
+		ReaderCacheChunks:   DefaultReaderCacheChunks,
+
+		CompressionCodecs:    DefaultCompressionCodecs,
+		CompressionCacheSize: DefaultCompressionCacheSize,
+
+		ReprovideInterval:    12 * 60 * 60, // 默认每12小时重新公告（Provider 记录有效期为48小时）
+		ReprovideJitter:      10 * 60,      // 默认抖动10分钟
+		ReprovideConcurrency: DefaultReprovideConcurrency,
+		ReprovideOnStart:     true,
+
+		ScrubInterval: 24 * 60 * 60, // 默认每天巡检一次
+		ScrubRate:     DefaultScrubRate,
 	}
 }
 
@@ -106,6 +172,47 @@ func NewP2PConfig() P2PConfig {
 //   - *P2PService: DHT 服务实例
 //   - error: 错误信息
 func NewP2PService(ctx context.Context, config P2PConfig) (*P2PService, error) {
+	scheduler, err := NewScheduler(config.Scheduler)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid scheduler: %w", err)
+	}
+
+	compressor, err := newChunkCompressor(config.CompressionCodecs, config.CompressionCacheSize)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid compression config: %w", err)
+	}
+
+	bandwidth, err := NewBandwidthLimiter(config.BandwidthLimits, config.BandwidthSchedules)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid bandwidth config: %w", err)
+	}
+
+	store := config.ChunkStore
+	if store == nil {
+		fsStore, err := chunkStore.NewFSStore(config.ChunkStoragePath)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to open chunk store: %w", err)
+		}
+		store = fsStore
+	}
+
+	pins, err := LoadPinSet(filepath.Join(config.MetadataStoragePath, PinSetFileName))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load pin set: %w", err)
+	}
+
+	metaIndex, err := LoadMetaDataIndex(config.MetadataStoragePath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load metadata index: %w", err)
+	}
+
+	// 被固定文件引用的 Chunk 和上传中的 Chunk 不会因超过配额被淘汰
+	protected := newPinnedChunkSet(config.MetadataStoragePath, pins)
+	quota, err := chunkStore.NewQuotaStore(store, config.MaxStorageBytes, protected.Snapshot)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open chunk store: %w", err)
+	}
+
 	host, err := newBasicHost(config.Port, config.Insecure, config.Seed)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to create host: %w", err)
@@ -116,6 +223,9 @@ func NewP2PService(ctx context.Context, config P2PConfig) (*P2PService, error) {
 		return nil, xerrors.Errorf("failed to create DHT instance: %w", err)
 	}
 
+	gc := NewGarbageCollector(quota, config.MetadataStoragePath, pins, time.Duration(config.GCInterval)*time.Second)
+	protected.protectHeld(gc)
+
 	// 创建可取消的上下文用于服务生命周期管理
 	serviceCtx, cancel := context.WithCancel(context.Background())
 
@@ -124,16 +234,50 @@ func NewP2PService(ctx context.Context, config P2PConfig) (*P2PService, error) {
 		DHT:          kdht,
 		Config:       &config,
 		PeerSelector: &RandomPeerSelector{},
+		Scheduler:    scheduler,
 		AntiLeecher:  &DefaultAntiLeecher{},
 		FSAdapter:    file.LocalFileSystemAdapter{},
+		ChunkStore:   quota,
+		Quota:        quota,
+		Pins:         pins,
+		MetaIndex:    metaIndex,
+		GC:           gc,
 		ConnManager:  NewConnManager(5, 10*time.Minute), // 每个节点最多5个并发流，黑名单超时10分钟
+		Bandwidth:    bandwidth,
 		Ctx:          serviceCtx,
 		Cancel:       cancel,
+
+		providerCache: newProviderCache(time.Duration(config.ProviderCacheTTL) * time.Second),
+		haveInterest:  newHaveInterestSet(),
+		availability:  newAvailabilityRegistry(),
+		chunkSessions: newChunkSessionPool(),
+		compressor:    compressor,
+		proofTrees:    newProofTreeCache(DefaultProofTreeCacheSize),
 	}
 	p.AnnounceHandler(ctx)
+	p.AnnounceBatchHandler(ctx)
 	p.LookupHandler(ctx)
 	p.RegisterChunkExistHandler(ctx)
 	p.RegisterChunkDataHandler(ctx)
+	p.RegisterChunkFrameHandler(ctx)
+	p.RegisterBitfieldHandler(ctx)
+	p.RegisterHaveHandler(ctx)
+	p.QueryMetaDataHandler(ctx)
+
+	// 启动重新公告，使用服务上下文以便 Shutdown 时退出
+	p.Reprovider = NewReprovider(p,
+		time.Duration(config.ReprovideInterval)*time.Second,
+		time.Duration(config.ReprovideJitter)*time.Second,
+		config.ReprovideConcurrency)
+	p.Reprovider.Start(serviceCtx, config.ReprovideOnStart)
+	p.GC.Start(serviceCtx)
+
+	quarantinePath := config.QuarantinePath
+	if quarantinePath == "" {
+		quarantinePath = filepath.Join(config.ChunkStoragePath, QuarantineDirName)
+	}
+	p.Scrubber = NewScrubber(p, time.Duration(config.ScrubInterval)*time.Second, config.ScrubRate, quarantinePath)
+	p.Scrubber.Start(serviceCtx)
 	return p, nil
 }
 
@@ -0,0 +1,34 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newTestService 创建使用临时目录、不启动后台重新公告和巡检的服务
+func newTestService(t *testing.T) *P2PService {
+	t.Helper()
+	cfg := NewP2PConfig()
+	cfg.ChunkStoragePath = t.TempDir()
+	cfg.MetadataStoragePath = t.TempDir()
+	cfg.ReprovideOnStart = false
+	cfg.ScrubInterval = 0
+
+	service, err := NewP2PService(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewP2PService: %v", err)
+	}
+	t.Cleanup(func() { service.Shutdown() })
+	return service
+}
+
+// connectServices 将 a 连接到 b
+func connectServices(t *testing.T, a, b *P2PService) {
+	t.Helper()
+	info := peer.AddrInfo{ID: b.Host.ID(), Addrs: b.Host.Addrs()}
+	if err := a.Host.Connect(context.Background(), info); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package p2p 提供文件固定（pin）功能
+//
+// PinSet 功能:
+//   - 固定文件: 按文件 CID 固定，被固定文件的 Chunk 不会被垃圾回收或配额淘汰
+//   - 持久化: 固定列表保存在 <MetadataStoragePath>/pins.json，每次修改后原子写入
+//
+// 注意事项:
+//   - 上传（HTTP API 和 CLI）的文件自动固定
+//   - 固定只保护本地存储，不影响 DHT 公告
+package p2p
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PinSetFileName 固定列表的文件名（位于元数据目录）
+const PinSetFileName = "pins.json"
+
+// ErrNotPinned 文件未被固定
+var ErrNotPinned = errors.New("file is not pinned")
+
+// PinInfo 一个被固定的文件
+type PinInfo struct {
+	CID      string    `json:"cid"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// PinSet 被固定的文件 CID 集合
+type PinSet struct {
+	path string
+
+	mu      sync.RWMutex
+	pins    map[string]time.Time
+	version uint64 // 每次修改递增，用于使依赖固定列表的缓存失效
+}
+
+// LoadPinSet 从 path 加载固定列表，文件不存在时返回空集合
+// 参数:
+//   - path: 固定列表文件路径
+//
+// 返回值:
+//   - *PinSet: 固定集合
+//   - error: 文件存在但无法解析时返回错误
+func LoadPinSet(path string) (*PinSet, error) {
+	s := &PinSet{path: path, pins: make(map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read pin set: %w", err)
+	}
+
+	var pins []PinInfo
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("failed to parse pin set %s: %w", path, err)
+	}
+	for _, pin := range pins {
+		s.pins[strings.ToLower(pin.CID)] = pin.PinnedAt
+	}
+	return s, nil
+}
+
+// Pin 固定文件，已固定时保留原固定时间
+func (s *PinSet) Pin(cid string) error {
+	cid, err := normalizeCID(cid)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pins[cid]; ok {
+		return nil
+	}
+	s.pins[cid] = time.Now()
+	if err := s.saveLocked(); err != nil {
+		delete(s.pins, cid)
+		return err
+	}
+	s.version++
+	return nil
+}
+
+// Unpin 取消固定，文件未固定时返回 ErrNotPinned
+func (s *PinSet) Unpin(cid string) error {
+	cid, err := normalizeCID(cid)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pinnedAt, ok := s.pins[cid]
+	if !ok {
+		return ErrNotPinned
+	}
+	delete(s.pins, cid)
+	if err := s.saveLocked(); err != nil {
+		s.pins[cid] = pinnedAt
+		return err
+	}
+	s.version++
+	return nil
+}
+
+// IsPinned 检查文件是否被固定
+func (s *PinSet) IsPinned(cid string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.pins[strings.ToLower(cid)]
+	return ok
+}
+
+// Version 返回固定列表的修改版本号
+func (s *PinSet) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// List 返回按固定时间排序的固定列表
+func (s *PinSet) List() []PinInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listLocked()
+}
+
+func (s *PinSet) listLocked() []PinInfo {
+	pins := make([]PinInfo, 0, len(s.pins))
+	for cid, pinnedAt := range s.pins {
+		pins = append(pins, PinInfo{CID: cid, PinnedAt: pinnedAt})
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		if !pins[i].PinnedAt.Equal(pins[j].PinnedAt) {
+			return pins[i].PinnedAt.Before(pins[j].PinnedAt)
+		}
+		return pins[i].CID < pins[j].CID
+	})
+	return pins
+}
+
+// saveLocked 原子写入固定列表（调用方持有 s.mu 写锁）
+func (s *PinSet) saveLocked() error {
+	data, err := json.MarshalIndent(s.listLocked(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal pin set: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create pin set directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save pin set: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to save pin set: %w", err)
+	}
+	return nil
+}
+
+// normalizeCID 校验 hex 编码的文件 CID 并转换为小写
+func normalizeCID(cid string) (string, error) {
+	cid = strings.ToLower(strings.TrimSpace(cid))
+	if cid == "" {
+		return "", errors.New("cid is required")
+	}
+	if _, err := hex.DecodeString(cid); err != nil {
+		return "", fmt.Errorf("invalid cid format: %s", cid)
+	}
+	return cid, nil
+}
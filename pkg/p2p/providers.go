@@ -0,0 +1,192 @@
+// Package p2p 提供 Chunk 提供者发现功能
+//
+// Provider 发现流程:
+//  1. 本地缓存: 命中且未过期时直接返回
+//  2. 本地 ProviderStore: Announce 协议写入本节点的提供者记录
+//  3. Lookup: 向距离 Key 最近的节点查询 Announce 写入的提供者记录
+//  4. FindProvidersAsync: 通过标准 DHT Provider 协议查找（以 Chunk 哈希构造 CID）
+//
+// 注意事项:
+//   - Key 统一使用 Chunk 哈希的 hex 编码，与 Announce 保持一致
+//   - 返回结果不包含本节点
+//   - 下载失败时应调用 InvalidateProviders 使缓存失效，以便重新查询
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multihash"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultProviderCacheTTL 默认提供者缓存有效期
+	DefaultProviderCacheTTL = 60 * time.Second
+
+	// maxProvidersPerChunk FindProvidersAsync 查询的最大提供者数量
+	maxProvidersPerChunk = 20
+)
+
+// providerCacheEntry 缓存的提供者列表
+type providerCacheEntry struct {
+	providers []peer.AddrInfo
+	expiresAt time.Time
+}
+
+// providerCache 带 TTL 的 Chunk 提供者缓存
+type providerCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]providerCacheEntry
+	nextSweep time.Time // 下一次清理过期条目的时间
+}
+
+func newProviderCache(ttl time.Duration) *providerCache {
+	if ttl <= 0 {
+		ttl = DefaultProviderCacheTTL
+	}
+	return &providerCache{
+		ttl:     ttl,
+		entries: make(map[string]providerCacheEntry),
+	}
+}
+
+func (c *providerCache) get(key string) ([]peer.AddrInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.providers, true
+}
+
+func (c *providerCache) put(key string, providers []peer.AddrInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 每个 TTL 周期最多清理一次过期条目，避免长时间运行后缓存无限增长，
+	// 同时不让一批连续的 put 每次都遍历整个缓存
+	now := time.Now()
+	if now.After(c.nextSweep) {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+	c.entries[key] = providerCacheEntry{
+		providers: providers,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+func (c *providerCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// chunkHashToCid 将 Chunk 的 SHA256 哈希（hex）转换为 DHT Provider 协议使用的 CID
+func chunkHashToCid(chunkHash string) (cid.Cid, error) {
+	digest, err := hex.DecodeString(chunkHash)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid chunk hash format: %w", err)
+	}
+	mh, err := multihash.Encode(digest, multihash.SHA2_256)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to encode multihash: %w", err)
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
+
+// FindChunkProviders 查找持有指定 Chunk 的节点
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - chunkHash: Chunk 哈希（hex 编码）
+//
+// 返回值:
+//   - []peer.AddrInfo: 提供者列表（不包含本节点）
+//   - error: 错误信息（未找到任何提供者时返回错误）
+func (p *P2PService) FindChunkProviders(ctx context.Context, chunkHash string) ([]peer.AddrInfo, error) {
+	if providers, ok := p.providerCache.get(chunkHash); ok {
+		return providers, nil
+	}
+
+	var (
+		providers []peer.AddrInfo
+		seen      = make(map[peer.ID]int)
+	)
+	addProviders := func(infos []peer.AddrInfo) {
+		for _, info := range infos {
+			if info.ID == "" || info.ID == p.Host.ID() {
+				continue
+			}
+			if i, ok := seen[info.ID]; ok {
+				providers[i].Addrs = append(providers[i].Addrs, info.Addrs...)
+				continue
+			}
+			seen[info.ID] = len(providers)
+			providers = append(providers, info)
+		}
+	}
+
+	// 1. 本地 ProviderStore（本节点接收到的 Announce）
+	if local, err := p.DHT.ProviderStore().GetProviders(ctx, []byte(chunkHash)); err == nil {
+		addProviders(local)
+	} else {
+		logrus.Debugf("Local provider store lookup failed for chunk %s: %v", chunkHash, err)
+	}
+
+	// 2. Lookup 协议（查询最近节点上 Announce 写入的记录）
+	if len(providers) == 0 {
+		remote, err := p.Lookup(ctx, chunkHash)
+		if err != nil {
+			logrus.Debugf("Lookup failed for chunk %s: %v", chunkHash, err)
+		}
+		addProviders(remote)
+	}
+
+	// 3. 标准 DHT Provider 协议
+	if len(providers) == 0 {
+		if c, err := chunkHashToCid(chunkHash); err == nil {
+			for info := range p.DHT.FindProvidersAsync(ctx, c, maxProvidersPerChunk) {
+				addProviders([]peer.AddrInfo{info})
+			}
+		} else {
+			logrus.Debugf("Skip DHT provider lookup for chunk %s: %v", chunkHash, err)
+		}
+	}
+
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no providers found for chunk %s", chunkHash)
+	}
+
+	// 记录提供者地址，便于后续建立流
+	for _, info := range providers {
+		if len(info.Addrs) > 0 {
+			p.Host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+		}
+	}
+
+	p.providerCache.put(chunkHash, providers)
+	return providers, nil
+}
+
+// InvalidateProviders 使指定 Chunk 的提供者缓存失效
+func (p *P2PService) InvalidateProviders(chunkHash string) {
+	p.providerCache.invalidate(chunkHash)
+}
@@ -0,0 +1,272 @@
+// Package p2p 提供 Chunk 提供者记录的周期性重新公告功能
+//
+// Reprovider 功能:
+//   - 遍历存储: 通过 ChunkStore.Iterate 列出所有本地 Chunk
+//   - 周期公告: 按配置的间隔（附加随机抖动）重新 Announce 所有本地 Chunk
+//   - 文件公告: 所有 Chunk 都在本地的文件同时重新公告文件 CID
+//   - 批量公告: 每 MaxAnnounceBatchSize 个 Chunk 一批，通过 AnnounceBatch 公告
+//   - 并发控制: 同时进行的批次数量受 ReprovideConcurrency 限制
+//   - 手动触发: 支持启动时触发和通过 Trigger 随时触发
+//   - 状态查询: Status 返回当前进度和上一轮结果
+//
+// 注意事项:
+//   - Provider 记录会过期，节点重启后也不会自动公告，因此需要周期性重新公告
+//   - ReprovideInterval 为 0 时不进行周期公告，但仍可通过 Trigger 手动触发
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chunkStore"
+)
+
+const (
+	// DefaultReprovideConcurrency 默认的并发批次数量
+	DefaultReprovideConcurrency = 8
+
+	// reprovideStartupDelay 启动后首次公告前的等待时间，等待引导节点连接完成
+	reprovideStartupDelay = 10 * time.Second
+)
+
+// ReproviderStatus 重新公告的进度和状态
+type ReproviderStatus struct {
+	Running    bool      `json:"running"`              // 是否正在进行一轮公告
+	Rounds     int       `json:"rounds"`               // 已完成的轮数
+	Total      int       `json:"total"`                // 本轮（或上一轮）公告总数（Chunk + 文件 CID）
+	Announced  int       `json:"announced"`            // 本轮（或上一轮）公告成功数
+	Failed     int       `json:"failed"`               // 本轮（或上一轮）公告失败数
+	LastStart  time.Time `json:"lastStart,omitempty"`  // 最近一轮开始时间
+	LastFinish time.Time `json:"lastFinish,omitempty"` // 最近一轮结束时间
+	NextRun    time.Time `json:"nextRun,omitempty"`    // 下一轮计划时间（未启用周期公告时为零值）
+	LastError  string    `json:"lastError,omitempty"`  // 最近一次错误
+}
+
+// Reprovider 周期性重新公告本地存储的所有 Chunk
+type Reprovider struct {
+	service     *P2PService
+	interval    time.Duration
+	jitter      time.Duration
+	concurrency int
+
+	trigger chan struct{}
+
+	mu     sync.RWMutex
+	status ReproviderStatus
+}
+
+// NewReprovider 创建 Reprovider
+// 参数:
+//   - service: P2P 服务
+//   - interval: 公告间隔，0 表示只支持手动触发
+//   - jitter: 每轮附加的最大随机抖动，避免大量节点同时公告
+//   - concurrency: 最大并发批次数量
+func NewReprovider(service *P2PService, interval, jitter time.Duration, concurrency int) *Reprovider {
+	if concurrency <= 0 {
+		concurrency = DefaultReprovideConcurrency
+	}
+	return &Reprovider{
+		service:     service,
+		interval:    interval,
+		jitter:      jitter,
+		concurrency: concurrency,
+		trigger:     make(chan struct{}, 1),
+	}
+}
+
+// Start 启动后台循环，ctx 取消时退出
+// runOnStart 为 true 时启动后（短暂等待）立即进行一轮公告
+func (r *Reprovider) Start(ctx context.Context, runOnStart bool) {
+	go r.loop(ctx, runOnStart)
+}
+
+// Trigger 请求立即进行一轮公告
+// 返回 false 表示已有待执行的请求
+func (r *Reprovider) Trigger() bool {
+	select {
+	case r.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status 返回当前状态的副本
+func (r *Reprovider) Status() ReproviderStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status
+}
+
+func (r *Reprovider) loop(ctx context.Context, runOnStart bool) {
+	var (
+		timer  *time.Timer
+		timerC <-chan time.Time
+	)
+	schedule := func(delay time.Duration) {
+		if timer == nil {
+			timer = time.NewTimer(delay)
+		} else {
+			timer.Reset(delay)
+		}
+		timerC = timer.C
+		r.setNextRun(time.Now().Add(delay))
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	if runOnStart {
+		schedule(reprovideStartupDelay)
+	} else if r.interval > 0 {
+		schedule(r.nextDelay())
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timerC:
+		case <-r.trigger:
+		}
+		r.RunOnce(ctx)
+
+		// 未启用周期公告时只响应手动触发
+		if r.interval > 0 {
+			schedule(r.nextDelay())
+		} else {
+			if timer != nil {
+				timer.Stop()
+			}
+			timerC = nil
+			r.setNextRun(time.Time{})
+		}
+	}
+}
+
+// nextDelay 返回下一轮的等待时间（间隔 + [0, jitter) 随机抖动）
+func (r *Reprovider) nextDelay() time.Duration {
+	delay := r.interval
+	if r.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(r.jitter)))
+	}
+	return delay
+}
+
+func (r *Reprovider) setNextRun(t time.Time) {
+	r.mu.Lock()
+	r.status.NextRun = t
+	r.mu.Unlock()
+}
+
+// RunOnce 同步执行一轮公告
+// 参数:
+//   - ctx: 上下文，取消时停止本轮剩余的公告
+//
+// 返回值:
+//   - error: 扫描存储目录失败或 ctx 被取消时返回错误；批次公告失败只计入 Failed
+func (r *Reprovider) RunOnce(ctx context.Context) error {
+	r.mu.Lock()
+	if r.status.Running {
+		r.mu.Unlock()
+		return fmt.Errorf("reprovide already running")
+	}
+	r.status.Running = true
+	r.status.LastStart = time.Now()
+	r.status.Total = 0
+	r.status.Announced = 0
+	r.status.Failed = 0
+	r.status.LastError = ""
+	r.mu.Unlock()
+
+	err := r.run(ctx)
+
+	r.mu.Lock()
+	r.status.Running = false
+	r.status.Rounds++
+	r.status.LastFinish = time.Now()
+	if err != nil {
+		r.status.LastError = err.Error()
+	}
+	status := r.status
+	r.mu.Unlock()
+
+	logrus.Infof("Reprovide finished: %d/%d chunks announced, %d failed (took %v)",
+		status.Announced, status.Total, status.Failed, status.LastFinish.Sub(status.LastStart))
+	return err
+}
+
+func (r *Reprovider) run(ctx context.Context) error {
+	hashes, err := listLocalChunks(r.service.ChunkStore)
+	if err != nil {
+		return err
+	}
+
+	// 所有 Chunk 都在本地的文件同时公告文件 CID（做种者记录）
+	cids, err := listLocalFiles(r.service.Config.MetadataStoragePath, r.service.ChunkStore)
+	if err != nil {
+		logrus.Warnf("Reprovide: failed to list local files: %v", err)
+	}
+	hashes = append(hashes, cids...)
+
+	r.mu.Lock()
+	r.status.Total = len(hashes)
+	r.mu.Unlock()
+	logrus.Infof("Reprovide started: %d local chunks, %d complete files", len(hashes)-len(cids), len(cids))
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, r.concurrency)
+	)
+	for start := 0; start < len(hashes); start += MaxAnnounceBatchSize {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+
+		batch := hashes[start:min(start+MaxAnnounceBatchSize, len(hashes))]
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := r.service.AnnounceBatch(ctx, batch)
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if err != nil {
+				r.status.Failed += len(batch)
+				r.status.LastError = fmt.Sprintf("batch of %d chunks: %v", len(batch), err)
+				logrus.Debugf("Reprovide batch of %d chunks failed: %v", len(batch), err)
+				return
+			}
+			r.status.Announced += len(batch)
+		}(batch)
+	}
+	wg.Wait()
+	return nil
+}
+
+// listLocalChunks 遍历本地 Chunk 存储，返回所有 Chunk 哈希（hex）
+func listLocalChunks(store chunkStore.ChunkStore) ([]string, error) {
+	var hashes []string
+	err := store.Iterate(func(hash string, _ int64) error {
+		// 只接受 SHA256 哈希（64 个 hex 字符）
+		if len(hash) == 64 {
+			hashes = append(hashes, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
@@ -0,0 +1,305 @@
+// Package p2p 提供可断点续传的文件下载功能
+//
+// Resumable 功能:
+//   - 临时文件: 下载写入 <target>.part，完成后原子重命名为 <target>
+//   - 进度日志: <target>.part.journal 记录已完成并通过 SHA256 校验的叶子索引
+//   - 续传校验: 重新开始时按日志重新校验已完成的数据范围，只下载缺失的 Chunk
+//
+// 日志格式:
+//   - 第一行: JSON 头 {"cid": "...", "fileSize": N, "leafCount": N}
+//   - 之后每行: "<叶子索引> <chunkHash(hex)>"
+//   - 头与当前元数据不一致时（如文件已更新）丢弃日志重新下载
+//
+// 注意事项:
+//   - 日志只追加写入，最后一行不完整（进程中途退出）时被忽略
+//   - 日志中的记录在续传时都会重新校验，因此写入日志前不需要 fsync 数据
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/file"
+)
+
+const (
+	// PartFileSuffix 下载中的临时文件后缀
+	PartFileSuffix = ".part"
+
+	// JournalFileSuffix 下载进度日志后缀
+	JournalFileSuffix = ".part.journal"
+)
+
+// downloadJournalHeader 进度日志头，用于判断日志是否属于当前文件
+type downloadJournalHeader struct {
+	CID       string `json:"cid"`
+	FileSize  uint64 `json:"fileSize"`
+	LeafCount int    `json:"leafCount"`
+}
+
+// downloadJournal 只追加的下载进度日志
+type downloadJournal struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openDownloadJournal 打开进度日志，返回日志中记录的 叶子索引 -> chunkHash
+// 日志不存在或头不匹配时创建新日志
+func openDownloadJournal(path string, header downloadJournalHeader) (*downloadJournal, map[int]string, error) {
+	entries, err := readDownloadJournal(path, header)
+	if err != nil {
+		logrus.Infof("Discarding download journal %s: %v", path, err)
+		entries = nil
+	}
+
+	flags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	if entries == nil {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open download journal: %w", err)
+	}
+
+	if entries == nil {
+		data, err := json.Marshal(header)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to marshal journal header: %w", err)
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to write journal header: %w", err)
+		}
+		entries = make(map[int]string)
+	}
+	return &downloadJournal{f: f}, entries, nil
+}
+
+// readDownloadJournal 读取已有日志，日志不存在时返回 nil, nil
+func readDownloadJournal(path string, header downloadJournalHeader) (map[int]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	rdr := bufio.NewReader(f)
+	line, err := rdr.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("missing journal header")
+	}
+	var existing downloadJournalHeader
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &existing); err != nil {
+		return nil, fmt.Errorf("invalid journal header: %w", err)
+	}
+	if existing != header {
+		return nil, fmt.Errorf("journal belongs to a different file version")
+	}
+
+	entries := make(map[int]string)
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil {
+			// 最后一行不完整时忽略
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil || index < 0 || index >= header.LeafCount {
+			continue
+		}
+		entries[index] = fields[1]
+	}
+	return entries, nil
+}
+
+// record 追加一条完成记录
+func (j *downloadJournal) record(index int, chunkHash string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := fmt.Fprintf(j.f, "%d %s\n", index, chunkHash)
+	return err
+}
+
+func (j *downloadJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// DownloadFileResumable 可断点续传地下载文件到 targetPath
+// 下载中断后使用相同参数再次调用即可续传，只下载缺失的 Chunk
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - fileHash: 文件 CID（hex 编码）
+//   - targetPath: 目标文件路径（下载期间使用 <targetPath>.part 和 <targetPath>.part.journal）
+//   - progressCB: 进度回调（可为 nil），已完成的部分计入初始进度
+//
+// 返回值:
+//   - error: 错误信息（出错时保留临时文件和日志以便续传）
+func (p *P2PService) DownloadFileResumable(ctx context.Context, fileHash, targetPath string, progressCB ProgressCallback) error {
+	metaData, err := p.loadMetaData(ctx, fileHash)
+	if err != nil {
+		return err
+	}
+	return p.downloadResumable(ctx, fileHash, metaData, targetPath, progressCB)
+}
+
+// downloadResumable 使用已加载的元数据执行可续传下载（见 DownloadFileResumable）
+func (p *P2PService) downloadResumable(ctx context.Context, fileHash string, metaData *file.MetaData, targetPath string, progressCB ProgressCallback) error {
+	partPath := targetPath + PartFileSuffix
+	journalPath := targetPath + JournalFileSuffix
+
+	part, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open part file: %w", err)
+	}
+	defer func() {
+		if part != nil {
+			part.Close()
+		}
+	}()
+
+	journal, entries, err := openDownloadJournal(journalPath, downloadJournalHeader{
+		CID:       strings.ToLower(fileHash),
+		FileSize:  metaData.FileSize,
+		LeafCount: len(metaData.Leaves),
+	})
+	if err != nil {
+		return err
+	}
+	defer journal.close()
+
+	if err := part.Truncate(int64(metaData.FileSize)); err != nil {
+		return fmt.Errorf("failed to resize part file: %w", err)
+	}
+
+	// 重新校验日志中记录的已完成范围
+	done, doneBytes := verifyCompletedLeaves(part, metaData, entries)
+	if len(entries) > 0 {
+		logrus.Infof("Resuming download of %s: %d/%d chunks verified, %d failed verification",
+			metaData.FileName, done.Count(), len(metaData.Leaves), len(entries)-done.Count())
+	}
+
+	var progress *downloadProgress
+	if progressCB != nil {
+		progress = &downloadProgress{
+			downloaded:      doneBytes,
+			completedChunks: done.Count(),
+			total:           int64(metaData.FileSize),
+			totalChunks:     len(metaData.Leaves),
+			callback:        progressCB,
+		}
+	}
+
+	if done.Count() < len(metaData.Leaves) {
+		err = p.downloadChunksConcurrently(ctx, fileHash, metaData, done, 0, func(i int, chunk file.ChunkData, offset int64, data []byte) error {
+			if _, err := part.WriteAt(data, offset); err != nil {
+				return fmt.Errorf("write chunk %d at offset %d failed: %w", i, offset, err)
+			}
+			// 记录数据的哈希（轻量元数据没有叶子哈希，续传时用它校验已下载的数据）
+			sum := sha256.Sum256(data)
+			if err := journal.record(i, hex.EncodeToString(sum[:])); err != nil {
+				return fmt.Errorf("record chunk %d in journal failed: %w", i, err)
+			}
+			return nil
+		}, progress)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 全部完成: 刷盘后原子重命名
+	if err := part.Sync(); err != nil {
+		return fmt.Errorf("failed to sync part file: %w", err)
+	}
+	if err := part.Close(); err != nil {
+		return fmt.Errorf("failed to close part file: %w", err)
+	}
+	part = nil
+	if err := os.Rename(partPath, targetPath); err != nil {
+		return fmt.Errorf("failed to rename part file: %w", err)
+	}
+	journal.close()
+	if err := os.Remove(journalPath); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Failed to remove download journal %s: %v", journalPath, err)
+	}
+
+	logrus.Infof("File %s downloaded successfully (resumable)", metaData.FileName)
+	return nil
+}
+
+// verifyCompletedLeaves 读取日志中记录的叶子数据并校验 SHA256，返回通过校验的位图和字节数
+func verifyCompletedLeaves(part io.ReaderAt, metaData *file.MetaData, entries map[int]string) (Bitfield, int64) {
+	done := NewBitfield(len(metaData.Leaves))
+	if len(entries) == 0 {
+		return done, 0
+	}
+
+	var (
+		offset    int64
+		doneBytes int64
+		buf       []byte
+	)
+	for i, leaf := range metaData.Leaves {
+		leafOffset := offset
+		offset += int64(leaf.ChunkSize)
+
+		chunkHash, ok := entries[i]
+		if !ok {
+			continue
+		}
+		// 轻量元数据没有叶子哈希，使用下载时（已通过 Merkle 证明校验）记录的哈希
+		expected := leaf.ChunkHash
+		if len(expected) == 0 {
+			expected, _ = hex.DecodeString(chunkHash)
+		}
+		if len(expected) == 0 || chunkHash != hex.EncodeToString(expected) {
+			continue
+		}
+
+		// 最后一个叶子可能小于 ChunkSize
+		size := min(int64(leaf.ChunkSize), int64(metaData.FileSize)-leafOffset)
+		if size <= 0 {
+			continue
+		}
+		if int64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		data := buf[:size]
+		if _, err := part.ReadAt(data, leafOffset); err != nil {
+			continue
+		}
+		hash := sha256.Sum256(data)
+		if !bytesEqual(hash[:], expected) {
+			logrus.Warnf("Chunk %d failed verification on resume, will download again", i)
+			continue
+		}
+
+		done.Set(i)
+		doneBytes += int64(leaf.ChunkSize)
+	}
+	return done, doneBytes
+}
@@ -0,0 +1,194 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"p2pFileTransfer/pkg/file"
+)
+
+// testResumeMetaData 按 chunkSize 切分 content，返回元数据
+// lightLeaf 指定的叶子不带哈希（模拟轻量元数据）
+func testResumeMetaData(content []byte, chunkSize int, lightLeaf int) *file.MetaData {
+	metaData := &file.MetaData{FileName: "test.bin", FileSize: uint64(len(content))}
+	for i, offset := 0, 0; offset < len(content); i, offset = i+1, offset+chunkSize {
+		leaf := file.ChunkData{Index: i, ChunkSize: chunkSize}
+		if i != lightLeaf {
+			sum := sha256.Sum256(content[offset:min(offset+chunkSize, len(content))])
+			leaf.ChunkHash = sum[:]
+		}
+		metaData.Leaves = append(metaData.Leaves, leaf)
+	}
+	return metaData
+}
+
+func chunkHashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadJournalReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f"+JournalFileSuffix)
+	header := downloadJournalHeader{CID: "abcd", FileSize: 10, LeafCount: 3}
+
+	journal, entries, err := openDownloadJournal(path, header)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("new journal has %d entries", len(entries))
+	}
+	if err := journal.record(0, "aa"); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := journal.record(2, "cc"); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	journal.close()
+
+	// 越界索引和不完整的最后一行被忽略
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("7 ff\n1 bb")
+	f.Close()
+
+	journal, entries, err = openDownloadJournal(path, header)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer journal.close()
+	if len(entries) != 2 || entries[0] != "aa" || entries[2] != "cc" {
+		t.Fatalf("entries = %v, want {0: aa, 2: cc}", entries)
+	}
+}
+
+func TestDownloadJournalHeaderMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f"+JournalFileSuffix)
+	journal, _, err := openDownloadJournal(path, downloadJournalHeader{CID: "abcd", FileSize: 10, LeafCount: 3})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	journal.record(0, "aa")
+	journal.close()
+
+	// 文件已更新（大小不同）: 丢弃旧日志
+	journal, entries, err := openDownloadJournal(path, downloadJournalHeader{CID: "abcd", FileSize: 12, LeafCount: 3})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	journal.close()
+	if len(entries) != 0 {
+		t.Fatalf("entries = %v, want none after header mismatch", entries)
+	}
+	entries, err = readDownloadJournal(path, downloadJournalHeader{CID: "abcd", FileSize: 12, LeafCount: 3})
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("rewritten journal: entries=%v err=%v", entries, err)
+	}
+}
+
+func TestVerifyCompletedLeaves(t *testing.T) {
+	content := []byte("0123456789")
+	metaData := testResumeMetaData(content, 4, 1) // 叶子 [0123] [4567] [89]，叶子 1 没有哈希
+
+	part := bytes.NewReader(append([]byte(nil), content...))
+	entries := map[int]string{
+		0: chunkHashHex(content[0:4]),
+		1: chunkHashHex(content[4:8]), // 轻量叶子使用日志中的哈希
+		2: chunkHashHex([]byte("xx")), // 与元数据中的哈希不一致
+	}
+	done, doneBytes := verifyCompletedLeaves(part, metaData, entries)
+	if !done.Has(0) || !done.Has(1) || done.Has(2) {
+		t.Fatalf("done = %08b, want leaves 0 and 1", done)
+	}
+	if doneBytes != 8 {
+		t.Fatalf("doneBytes = %d, want 8", doneBytes)
+	}
+
+	// 数据被破坏的叶子需要重新下载
+	corrupted := append([]byte(nil), content...)
+	corrupted[9] = 'x'
+	entries[2] = chunkHashHex(content[8:10])
+	done, _ = verifyCompletedLeaves(bytes.NewReader(corrupted), metaData, entries)
+	if done.Has(2) {
+		t.Fatal("corrupted last leaf passed verification")
+	}
+	done, _ = verifyCompletedLeaves(bytes.NewReader(content), metaData, entries)
+	if done.Count() != 3 {
+		t.Fatalf("done count = %d, want 3", done.Count())
+	}
+}
+
+// writeResumeState 写入 .part 文件和记录了 leaves 的日志
+func writeResumeState(t *testing.T, target string, content []byte, metaData *file.MetaData, cid string, leaves ...int) {
+	t.Helper()
+	if err := os.WriteFile(target+PartFileSuffix, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	journal, _, err := openDownloadJournal(target+JournalFileSuffix, downloadJournalHeader{
+		CID: cid, FileSize: metaData.FileSize, LeafCount: len(metaData.Leaves),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.close()
+	for _, i := range leaves {
+		end := min((i+1)*4, len(content))
+		journal.record(i, chunkHashHex(content[i*4:end]))
+	}
+}
+
+func TestDownloadResumableCompletesFromJournal(t *testing.T) {
+	service := newTestService(t)
+	content := []byte("0123456789")
+	metaData := testResumeMetaData(content, 4, -1)
+	target := filepath.Join(t.TempDir(), "out.bin")
+	writeResumeState(t, target, content, metaData, "abcd", 0, 1, 2)
+
+	// 所有叶子都已完成，不需要访问网络
+	if err := service.downloadResumable(context.Background(), "abcd", metaData, target, nil); err != nil {
+		t.Fatalf("downloadResumable: %v", err)
+	}
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("target = %q, %v", got, err)
+	}
+	for _, path := range []string{target + PartFileSuffix, target + JournalFileSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s still exists after download", path)
+		}
+	}
+}
+
+func TestDownloadResumableKeepsStateOnFailure(t *testing.T) {
+	service := newTestService(t)
+	content := []byte("0123456789")
+	metaData := testResumeMetaData(content, 4, -1)
+	target := filepath.Join(t.TempDir(), "out.bin")
+	// 叶子 2 未完成，网络中没有提供者
+	writeResumeState(t, target, content, metaData, "", 0, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := service.downloadResumable(ctx, "", metaData, target, nil); err == nil {
+		t.Fatal("downloadResumable succeeded without providers")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatal("target created by failed download")
+	}
+
+	// 已完成的叶子保留在日志中，续传时不再下载
+	entries, err := readDownloadJournal(target+JournalFileSuffix, downloadJournalHeader{
+		FileSize: metaData.FileSize, LeafCount: len(metaData.Leaves),
+	})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("journal after failure: entries=%v err=%v", entries, err)
+	}
+}
@@ -0,0 +1,271 @@
+// Package p2p 提供 Chunk 下载调度策略
+//
+// Scheduler 功能:
+//   - 定义调度策略接口: 决定 Chunk 的下载顺序和 endgame 时的并行请求数
+//   - 顺序调度: SequentialScheduler，按叶子索引顺序下载
+//   - 最稀缺优先: RarestFirstScheduler，优先下载已知提供者最少的 Chunk
+//   - Endgame: EndgameScheduler，最稀缺优先，剩余少量 Chunk 时同时向多个节点请求，先完成者获胜
+//
+// 调度策略（P2PConfig.Scheduler / performance.scheduler）:
+//   - "sequential": 顺序调度（默认，与原有行为一致）
+//   - "rarest-first": 最稀缺优先
+//   - "endgame": 最稀缺优先 + endgame
+//
+// 注意事项:
+//   - 稀缺度来自位图可用性表和提供者缓存，不额外发起 DHT 查询
+//   - endgame 中落后的请求会被取消，其失败不计入下载错误
+//   - endgame 的重复请求优先发往该 Chunk 没有进行中请求的节点，只有一个候选节点时才发往同一节点
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	SchedulerSequential  = "sequential"
+	SchedulerRarestFirst = "rarest-first"
+	SchedulerEndgame     = "endgame"
+
+	// DefaultEndgameThreshold 剩余未完成 Chunk 数量不超过此值时进入 endgame
+	DefaultEndgameThreshold = 4
+
+	// DefaultEndgameFanout endgame 中每个 Chunk 最多同时请求的次数
+	DefaultEndgameFanout = 3
+)
+
+// Scheduler 下载调度策略
+type Scheduler interface {
+	// Order 返回叶子索引的下载顺序
+	// rarity(i) 返回已知持有第 i 个叶子的节点数量
+	Order(leafCount int, rarity func(i int) int) []int
+
+	// Fanout 返回剩余 remaining 个未完成 Chunk 时，每个 Chunk 最多同时进行的请求数（1 表示不重复请求）
+	Fanout(remaining int) int
+}
+
+// NewScheduler 根据名称创建调度策略
+// 参数:
+//   - name: 策略名称（sequential、rarest-first、endgame），为空时使用 sequential
+//
+// 返回值:
+//   - Scheduler: 调度策略
+//   - error: 名称无效时返回错误
+func NewScheduler(name string) (Scheduler, error) {
+	switch name {
+	case "", SchedulerSequential:
+		return &SequentialScheduler{}, nil
+	case SchedulerRarestFirst:
+		return &RarestFirstScheduler{}, nil
+	case SchedulerEndgame:
+		return &EndgameScheduler{Threshold: DefaultEndgameThreshold, MaxRequests: DefaultEndgameFanout}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler: %s (must be %s, %s or %s)",
+			name, SchedulerSequential, SchedulerRarestFirst, SchedulerEndgame)
+	}
+}
+
+// SequentialScheduler 按叶子索引顺序下载
+type SequentialScheduler struct{}
+
+func (s *SequentialScheduler) Order(leafCount int, _ func(i int) int) []int {
+	order := make([]int, leafCount)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+func (s *SequentialScheduler) Fanout(int) int { return 1 }
+
+// RarestFirstScheduler 优先下载已知提供者最少的 Chunk，提供者数量相同时按索引顺序
+type RarestFirstScheduler struct{}
+
+func (s *RarestFirstScheduler) Order(leafCount int, rarity func(i int) int) []int {
+	counts := make([]int, leafCount)
+	order := make([]int, leafCount)
+	for i := range order {
+		order[i] = i
+		counts[i] = rarity(i)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return counts[order[a]] < counts[order[b]]
+	})
+	return order
+}
+
+func (s *RarestFirstScheduler) Fanout(int) int { return 1 }
+
+// EndgameScheduler 最稀缺优先，剩余 Chunk 不超过 Threshold 时每个 Chunk 同时请求 MaxRequests 次
+type EndgameScheduler struct {
+	RarestFirstScheduler
+	Threshold   int // 进入 endgame 的剩余 Chunk 数量
+	MaxRequests int // endgame 中每个 Chunk 的最大并行请求数
+}
+
+func (s *EndgameScheduler) Fanout(remaining int) int {
+	if remaining <= s.Threshold && s.MaxRequests > 1 {
+		return s.MaxRequests
+	}
+	return 1
+}
+
+// -----------------------------
+// 下载队列
+// -----------------------------
+
+// chunkAttempt 一个 Chunk 正在进行的请求
+type chunkAttempt struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers int             // 正在请求该 Chunk 的 worker 数量
+	done    bool            // 是否已有请求成功
+	peers   map[peer.ID]int // 正在请求该 Chunk 的节点 -> 请求数
+}
+
+// downloadQueue 按调度顺序分发 Chunk，endgame 时将未完成的 Chunk 重复分发给空闲 worker
+type downloadQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond // Chunk 结束或 ctx 取消时唤醒等待 endgame 的 worker
+	ctx       context.Context
+	stop      func() bool // 注销 ctx 取消回调
+	scheduler Scheduler
+	pending   []int                 // 按调度顺序排列、尚未分发的索引
+	inflight  map[int]*chunkAttempt // 已分发、尚未结束的索引
+	order     []int                 // 已分发索引的顺序，endgame 按此顺序选择重复请求的 Chunk
+	remaining int                   // 尚未结束（成功或最终失败）的 Chunk 数量
+}
+
+// newDownloadQueue 创建下载队列，调用方在所有 worker 退出后需调用 close
+func newDownloadQueue(ctx context.Context, scheduler Scheduler, order []int) *downloadQueue {
+	q := &downloadQueue{
+		ctx:       ctx,
+		scheduler: scheduler,
+		pending:   order,
+		inflight:  make(map[int]*chunkAttempt),
+		remaining: len(order),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	q.stop = context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.cond.Broadcast()
+	})
+	return q
+}
+
+// close 释放队列持有的 ctx 回调
+func (q *downloadQueue) close() {
+	q.stop()
+}
+
+// next 返回下一个要请求的索引及其上下文（Chunk 完成时被取消）
+// 没有可分发的 Chunk 但仍有进行中的 Chunk 时阻塞等待（之后可能进入 endgame）；
+// 所有 Chunk 都已结束或 ctx 被取消时返回 false，worker 应退出
+func (q *downloadQueue) next() (int, context.Context, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if len(q.pending) > 0 {
+			index := q.pending[0]
+			q.pending = q.pending[1:]
+			ctx, cancel := context.WithCancel(q.ctx)
+			q.inflight[index] = &chunkAttempt{ctx: ctx, cancel: cancel, workers: 1, peers: make(map[peer.ID]int)}
+			q.order = append(q.order, index)
+			return index, ctx, true
+		}
+		if q.remaining == 0 || q.ctx.Err() != nil {
+			return 0, nil, false
+		}
+
+		// endgame: 重复请求正在进行中的 Chunk
+		if fanout := q.scheduler.Fanout(q.remaining); fanout > 1 {
+			for _, index := range q.order {
+				attempt, ok := q.inflight[index]
+				if !ok || attempt.done || attempt.workers >= fanout {
+					continue
+				}
+				attempt.workers++
+				return index, attempt.ctx, true
+			}
+		}
+		q.cond.Wait()
+	}
+}
+
+// finish 记录一次请求的结果
+// 返回值:
+//   - first: 成功且是该 Chunk 的第一个成功请求（调用方负责处理数据）
+//   - failed: 失败且该 Chunk 没有其他进行中的请求（调用方负责报告错误）
+func (q *downloadQueue) finish(index int, success bool) (first, failed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	attempt, ok := q.inflight[index]
+	if !ok {
+		return false, false
+	}
+	attempt.workers--
+
+	if success && !attempt.done {
+		attempt.done = true
+		attempt.cancel() // 取消落后的请求
+		q.remaining--
+		first = true
+	}
+	if attempt.workers == 0 {
+		if !attempt.done {
+			// 最后一个请求也失败，该 Chunk 最终失败
+			failed = !success
+			q.remaining--
+		}
+		attempt.cancel()
+		delete(q.inflight, index)
+	}
+	q.cond.Broadcast()
+	return first, failed
+}
+
+// selectPeer 为第 index 个 Chunk 的一次请求选择节点，并记录为进行中
+// 已有请求的节点被排除，使 endgame 的重复请求发往不同节点；所有候选节点都已有请求时不排除
+// 返回的函数在对该节点的请求结束后调用（可重复调用）
+func (q *downloadQueue) selectPeer(index int, peers []peer.ID, selector PeerSelector) (peer.ID, func(), error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	attempt, ok := q.inflight[index]
+	if !ok {
+		selected, err := selector.SelectPeer(peers)
+		return selected, func() {}, err
+	}
+	candidates := make([]peer.ID, 0, len(peers))
+	for _, id := range peers {
+		if attempt.peers[id] == 0 {
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = peers
+	}
+	selected, err := selector.SelectPeer(candidates)
+	if err != nil {
+		return "", func() {}, err
+	}
+	attempt.peers[selected]++
+
+	var once sync.Once
+	return selected, func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if attempt.peers[selected]--; attempt.peers[selected] <= 0 {
+				delete(attempt.peers, selected)
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,154 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// nextAsync 在后台调用 next，返回接收结果的通道
+func nextAsync(q *downloadQueue) <-chan int {
+	ch := make(chan int, 1)
+	go func() {
+		index, _, ok := q.next()
+		if !ok {
+			index = -1
+		}
+		ch <- index
+	}()
+	return ch
+}
+
+func expectIndex(t *testing.T, ch <-chan int, want int) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Fatalf("next: got %d, want %d", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("next: still blocked, want %d", want)
+	}
+}
+
+func expectBlocked(t *testing.T, ch <-chan int) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		t.Fatalf("next returned %d, want blocked", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDownloadQueueIdleWorkerJoinsEndgame(t *testing.T) {
+	q := newDownloadQueue(context.Background(), &EndgameScheduler{Threshold: 1, MaxRequests: 2}, []int{0, 1})
+	defer q.close()
+
+	expectIndex(t, nextAsync(q), 0)
+	expectIndex(t, nextAsync(q), 1)
+
+	// 剩余 2 个 Chunk，未进入 endgame: 空闲 worker 等待而不是退出
+	idle := nextAsync(q)
+	expectBlocked(t, idle)
+
+	// Chunk 0 完成后只剩 1 个，进入 endgame，空闲 worker 重复请求 Chunk 1
+	if first, failed := q.finish(0, true); !first || failed {
+		t.Fatalf("finish(0): first=%v failed=%v", first, failed)
+	}
+	expectIndex(t, idle, 1)
+
+	if first, _ := q.finish(1, true); !first {
+		t.Fatal("finish(1): want first success")
+	}
+	if first, failed := q.finish(1, false); first || failed {
+		t.Fatalf("lagging request: first=%v failed=%v", first, failed)
+	}
+	expectIndex(t, nextAsync(q), -1)
+}
+
+func TestDownloadQueueTerminalFailure(t *testing.T) {
+	q := newDownloadQueue(context.Background(), &EndgameScheduler{Threshold: 1, MaxRequests: 2}, []int{0, 1})
+	defer q.close()
+
+	expectIndex(t, nextAsync(q), 0)
+	expectIndex(t, nextAsync(q), 1)
+	idle := nextAsync(q)
+	expectBlocked(t, idle)
+
+	// 最终失败的 Chunk 同样计入已结束，剩余 1 个时进入 endgame
+	if first, failed := q.finish(0, false); first || !failed {
+		t.Fatalf("finish(0): first=%v failed=%v", first, failed)
+	}
+	expectIndex(t, idle, 1)
+
+	if _, failed := q.finish(1, false); failed {
+		t.Fatal("finish(1): other request still in flight, want not failed")
+	}
+	if _, failed := q.finish(1, false); !failed {
+		t.Fatal("finish(1): last request failed, want failed")
+	}
+	if q.remaining != 0 {
+		t.Fatalf("remaining = %d, want 0", q.remaining)
+	}
+	expectIndex(t, nextAsync(q), -1)
+}
+
+func TestDownloadQueueContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := newDownloadQueue(ctx, &SequentialScheduler{}, []int{0})
+	defer q.close()
+
+	expectIndex(t, nextAsync(q), 0)
+	idle := nextAsync(q)
+	expectBlocked(t, idle)
+
+	cancel()
+	expectIndex(t, idle, -1)
+}
+
+// firstPeerSelector 总是选择第一个候选节点（与固定延迟下的 LatencyBasedSelector 一样确定）
+type firstPeerSelector struct{}
+
+func (firstPeerSelector) SelectPeer(peers []peer.ID) (peer.ID, error) {
+	if len(peers) == 0 {
+		return "", errors.New("no peers available")
+	}
+	return peers[0], nil
+}
+
+func TestDownloadQueueEndgameDistinctPeers(t *testing.T) {
+	q := newDownloadQueue(context.Background(), &EndgameScheduler{Threshold: 1, MaxRequests: 3}, []int{0})
+	defer q.close()
+	peers := []peer.ID{"peer-a", "peer-b", "peer-c"}
+
+	// 同一 Chunk 的 3 个并行请求分别发往不同节点
+	seen := make(map[peer.ID]bool)
+	var releases []func()
+	for i := 0; i < 3; i++ {
+		expectIndex(t, nextAsync(q), 0)
+		selected, release, err := q.selectPeer(0, peers, firstPeerSelector{})
+		if err != nil {
+			t.Fatalf("selectPeer: %v", err)
+		}
+		if seen[selected] {
+			t.Fatalf("request %d sent to %s again", i, selected)
+		}
+		seen[selected] = true
+		releases = append(releases, release)
+	}
+
+	// 所有节点都有请求时不再排除；请求结束后节点可再次被选择
+	if selected, release, _ := q.selectPeer(0, peers, firstPeerSelector{}); selected != "peer-a" {
+		t.Fatalf("all peers busy: selected %s, want peer-a", selected)
+	} else {
+		release()
+	}
+	releases[1]()
+	releases[1]()
+	if selected, _, _ := q.selectPeer(0, peers, firstPeerSelector{}); selected != "peer-b" {
+		t.Fatalf("after release: selected %s, want peer-b", selected)
+	}
+}
@@ -0,0 +1,408 @@
+// Package p2p 提供本地 Chunk 的完整性巡检功能
+//
+// Scrubber 功能:
+//   - 巡检: 遍历 ChunkStore，重新计算每个 Chunk 的 SHA256 并与其哈希比较
+//   - 限速: 按 ScrubRate（字节/秒）读取，避免与上传、下载争抢磁盘
+//   - 隔离: 损坏的 Chunk 写入隔离目录后从存储删除，不再对外提供，也不再被重新公告
+//   - 自愈: 从其他节点重新获取正确的副本，校验通过后写回存储并重新公告
+//   - 定期巡检: ScrubInterval 大于 0 时按间隔执行，也可以通过 Trigger 随时触发
+//   - 状态查询: Status 返回当前进度、上一轮结果和最近的隔离记录
+//
+// 注意事项:
+//   - 只校验名称为 SHA256 哈希（64 个 hex 字符）的 Chunk
+//   - 超过 MaxChunkSize 的 Chunk 不会被提供，同样视为损坏
+//   - 巡检读取不更新存储配额的 LRU 顺序
+//   - 隔离目录中的文件不会被自动删除，由运维人员检查后清理
+//   - 找不到其他提供者时 Chunk 保持缺失，之后下载该文件时会从网络重新获取
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/file"
+)
+
+const (
+	// DefaultScrubRate 默认的巡检读取速率（字节/秒）
+	DefaultScrubRate = 8 * 1024 * 1024
+
+	// QuarantineDirName 默认隔离目录名，位于 ChunkStoragePath 下（FSStore 遍历时忽略）
+	QuarantineDirName = ".quarantine"
+
+	// maxQuarantineRecords 状态中保留的最近隔离记录数
+	maxQuarantineRecords = 100
+)
+
+// ErrScrubRunning 已有一轮巡检正在进行
+var ErrScrubRunning = errors.New("scrub already running")
+
+// QuarantinedChunk 一个被隔离的 Chunk
+type QuarantinedChunk struct {
+	Hash        string    `json:"hash"`                  // Chunk 哈希（存储中的名称）
+	ActualHash  string    `json:"actualHash,omitempty"`  // 实际内容的哈希（超大 Chunk 为空）
+	Size        int64     `json:"size"`                  // 实际大小（字节）
+	Reason      string    `json:"reason"`                // 隔离原因
+	Path        string    `json:"path,omitempty"`        // 隔离文件路径
+	DetectedAt  time.Time `json:"detectedAt"`            // 发现时间
+	Repaired    bool      `json:"repaired"`              // 是否已从其他节点恢复
+	RepairError string    `json:"repairError,omitempty"` // 恢复失败的原因
+}
+
+// ScrubResult 一轮巡检的结果
+type ScrubResult struct {
+	StartedAt  time.Time `json:"startedAt"`  // 开始时间
+	FinishedAt time.Time `json:"finishedAt"` // 结束时间
+	Chunks     int       `json:"chunks"`     // 校验的 Chunk 数
+	Bytes      int64     `json:"bytes"`      // 校验的字节数
+	Skipped    int       `json:"skipped"`    // 跳过的 Chunk 数（名称不是 SHA256 哈希或巡检期间被删除）
+	Corrupt    int       `json:"corrupt"`    // 损坏并被隔离的 Chunk 数
+	Repaired   int       `json:"repaired"`   // 从其他节点恢复的 Chunk 数
+	Failed     int       `json:"failed"`     // 读取或隔离失败的 Chunk 数
+}
+
+// ScrubStatus 巡检的状态
+type ScrubStatus struct {
+	Running     bool               `json:"running"`               // 是否正在巡检
+	Passes      int                `json:"passes"`                // 已完成的轮数
+	Interval    int                `json:"interval"`              // 定期巡检间隔（秒），0 表示只手动触发
+	Rate        int64              `json:"rate"`                  // 读取速率（字节/秒），0 表示不限速
+	Checked     int                `json:"checked"`               // 本轮已校验的 Chunk 数
+	Total       int                `json:"total"`                 // 本轮需要校验的 Chunk 数
+	NextRun     time.Time          `json:"nextRun,omitempty"`     // 下一轮计划时间
+	LastResult  *ScrubResult       `json:"lastResult,omitempty"`  // 最近一轮结果
+	LastError   string             `json:"lastError,omitempty"`   // 最近一次错误
+	Quarantined []QuarantinedChunk `json:"quarantined,omitempty"` // 最近的隔离记录（新的在前）
+}
+
+// Scrubber 周期性校验本地 Chunk 的完整性
+type Scrubber struct {
+	service        *P2PService
+	interval       time.Duration
+	rate           int64
+	quarantinePath string
+
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status ScrubStatus
+}
+
+// NewScrubber 创建巡检器
+// 参数:
+//   - service: P2P 服务
+//   - interval: 巡检间隔，0 表示只支持手动触发
+//   - rate: 读取速率（字节/秒），0 表示不限速
+//   - quarantinePath: 隔离目录
+func NewScrubber(service *P2PService, interval time.Duration, rate int64, quarantinePath string) *Scrubber {
+	return &Scrubber{
+		service:        service,
+		interval:       interval,
+		rate:           rate,
+		quarantinePath: quarantinePath,
+		trigger:        make(chan struct{}, 1),
+		status:         ScrubStatus{Interval: int(interval / time.Second), Rate: rate},
+	}
+}
+
+// Start 启动后台循环，ctx 取消时退出
+// 第一轮定期巡检在启动 interval 之后进行
+func (s *Scrubber) Start(ctx context.Context) {
+	go s.loop(ctx)
+}
+
+// Trigger 请求立即进行一轮巡检
+// 返回 false 表示已有待执行的请求
+func (s *Scrubber) Trigger() bool {
+	select {
+	case s.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status 返回当前状态的副本
+func (s *Scrubber) Status() ScrubStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Quarantined = append([]QuarantinedChunk(nil), s.status.Quarantined...)
+	return status
+}
+
+func (s *Scrubber) loop(ctx context.Context) {
+	var (
+		timer  *time.Timer
+		timerC <-chan time.Time
+	)
+	if s.interval > 0 {
+		timer = time.NewTimer(s.interval)
+		defer timer.Stop()
+		timerC = timer.C
+		s.setNextRun(time.Now().Add(s.interval))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timerC:
+		case <-s.trigger:
+		}
+		if _, err := s.RunOnce(ctx); err != nil && !errors.Is(err, ErrScrubRunning) && ctx.Err() == nil {
+			logrus.Warnf("Scrub failed: %v", err)
+		}
+		if timer != nil {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(s.interval)
+			s.setNextRun(time.Now().Add(s.interval))
+		}
+	}
+}
+
+func (s *Scrubber) setNextRun(t time.Time) {
+	s.mu.Lock()
+	s.status.NextRun = t
+	s.mu.Unlock()
+}
+
+// RunOnce 同步执行一轮巡检
+// 参数:
+//   - ctx: 上下文，取消时停止本轮剩余的校验
+//
+// 返回值:
+//   - *ScrubResult: 本轮结果（ctx 取消时为已完成部分）
+//   - error: 已有巡检在进行时返回 ErrScrubRunning；遍历存储失败或 ctx 被取消时返回错误
+func (s *Scrubber) RunOnce(ctx context.Context) (*ScrubResult, error) {
+	s.mu.Lock()
+	if s.status.Running {
+		s.mu.Unlock()
+		return nil, ErrScrubRunning
+	}
+	s.status.Running = true
+	s.status.Checked = 0
+	s.status.Total = 0
+	s.mu.Unlock()
+
+	result := &ScrubResult{StartedAt: time.Now()}
+	err := s.run(ctx, result)
+	result.FinishedAt = time.Now()
+
+	s.mu.Lock()
+	s.status.Running = false
+	s.status.Passes++
+	s.status.LastResult = result
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	logrus.Infof("Scrub finished: %d chunks (%d bytes) checked, %d corrupt, %d repaired, %d failed (took %v)",
+		result.Chunks, result.Bytes, result.Corrupt, result.Repaired, result.Failed,
+		result.FinishedAt.Sub(result.StartedAt))
+	return result, err
+}
+
+// scrubEntry 待校验的 Chunk
+type scrubEntry struct {
+	hash string
+	size int64
+}
+
+func (s *Scrubber) run(ctx context.Context, result *ScrubResult) error {
+	// 先列出所有 Chunk，校验和隔离时不持有存储的遍历状态
+	var entries []scrubEntry
+	err := s.service.ChunkStore.Iterate(func(hash string, size int64) error {
+		entries = append(entries, scrubEntry{hash: hash, size: size})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.status.Total = len(entries)
+	s.mu.Unlock()
+	logrus.Infof("Scrub started: %d local chunks", len(entries))
+
+	bucket := &tokenBucket{}
+	bucket.setRate(s.rate, time.Now())
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if delay := bucket.take(int(entry.size), time.Now()); delay > 0 {
+			if !sleepContext(ctx, delay) {
+				return ctx.Err()
+			}
+		}
+		s.check(ctx, entry, result)
+
+		s.mu.Lock()
+		s.status.Checked++
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// check 校验一个 Chunk，损坏时隔离并尝试恢复
+func (s *Scrubber) check(ctx context.Context, entry scrubEntry, result *ScrubResult) {
+	if len(entry.hash) != 2*sha256.Size {
+		result.Skipped++
+		return
+	}
+
+	data, reason, err := s.verify(entry.hash)
+	if errors.Is(err, chunkStore.ErrNotFound) {
+		// 巡检期间被垃圾回收或配额淘汰
+		result.Skipped++
+		return
+	}
+	if err != nil {
+		logrus.Warnf("Scrub: failed to read chunk %s: %v", entry.hash, err)
+		result.Failed++
+		return
+	}
+	result.Chunks++
+	result.Bytes += int64(len(data))
+	if reason == "" {
+		return
+	}
+
+	// 重新读取一次，排除与写入同一 Chunk 的上传之间的竞争
+	data, reason, err = s.verify(entry.hash)
+	if err != nil || reason == "" {
+		return
+	}
+
+	record := QuarantinedChunk{
+		Hash:       entry.hash,
+		Size:       int64(len(data)),
+		Reason:     reason,
+		DetectedAt: time.Now(),
+	}
+	if int64(len(data)) <= MaxChunkSize {
+		actual := sha256.Sum256(data)
+		record.ActualHash = hex.EncodeToString(actual[:])
+	}
+	if err := s.quarantine(&record, data); err != nil {
+		logrus.Errorf("Scrub: chunk %s is corrupt (%s) but could not be quarantined: %v", entry.hash, reason, err)
+		result.Failed++
+		return
+	}
+	result.Corrupt++
+	logrus.Warnf("Scrub: chunk %s is corrupt (%s), quarantined to %s", entry.hash, reason, record.Path)
+
+	if err := s.repair(ctx, entry.hash); err != nil {
+		record.RepairError = err.Error()
+		logrus.Warnf("Scrub: failed to repair chunk %s: %v", entry.hash, err)
+	} else {
+		record.Repaired = true
+		result.Repaired++
+		logrus.Infof("Scrub: repaired chunk %s from the network", entry.hash)
+	}
+	s.addRecord(record)
+}
+
+// verify 读取 Chunk 并校验，reason 为空表示数据完好
+func (s *Scrubber) verify(hash string) (data []byte, reason string, err error) {
+	size, err := s.service.ChunkStore.Size(hash)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.service.Quota != nil {
+		data, err = s.service.Quota.Peek(hash)
+	} else {
+		data, err = s.service.ChunkStore.Get(hash)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if size > MaxChunkSize {
+		return data, fmt.Sprintf("size %d exceeds %d", size, MaxChunkSize), nil
+	}
+	actual := sha256.Sum256(data)
+	if hex.EncodeToString(actual[:]) != hash {
+		return data, "hash mismatch", nil
+	}
+	return data, "", nil
+}
+
+// quarantine 将损坏的数据写入隔离目录，并从存储中删除 Chunk
+func (s *Scrubber) quarantine(record *QuarantinedChunk, data []byte) error {
+	if err := os.MkdirAll(s.quarantinePath, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	path := filepath.Join(s.quarantinePath, fmt.Sprintf("%s.%d", record.Hash, record.DetectedAt.UnixNano()))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write quarantined chunk: %w", err)
+	}
+	record.Path = path
+
+	if err := s.service.ChunkStore.Delete(record.Hash); err != nil {
+		return err
+	}
+	// 压缩缓存中可能保存着损坏数据的压缩结果
+	s.service.compressor.invalidate(record.Hash)
+	return nil
+}
+
+// repair 从其他节点获取 Chunk 的正确副本并写回存储
+func (s *Scrubber) repair(ctx context.Context, hash string) error {
+	dhtTimeout := DefaultDHTTimeout
+	if s.service.Config.DHTTimeout > 0 {
+		dhtTimeout = time.Duration(s.service.Config.DHTTimeout) * time.Second
+	}
+	dhtCtx, cancel := context.WithTimeout(ctx, dhtTimeout)
+	providers, err := s.service.FindChunkProviders(dhtCtx, hash)
+	cancel()
+	if err != nil {
+		return err
+	}
+	peers := make([]peer.ID, len(providers))
+	for i, info := range providers {
+		peers[i] = info.ID
+	}
+
+	hashBytes, _ := hex.DecodeString(hash)
+	task := chunkTask{chunk: file.ChunkData{ChunkHash: hashBytes}, chunkHashStr: hash}
+	data, err := s.service.fetchChunkFromPeers(ctx, task, peers, true, max(1, s.service.Config.MaxRetries))
+	if err != nil {
+		s.service.InvalidateProviders(hash)
+		return err
+	}
+	if err := s.service.ChunkStore.Put(hash, data); err != nil {
+		return fmt.Errorf("failed to store repaired chunk: %w", err)
+	}
+	if err := s.service.AnnounceBatch(ctx, []string{hash}); err != nil {
+		logrus.Debugf("Scrub: failed to announce repaired chunk %s: %v", hash, err)
+	}
+	return nil
+}
+
+// addRecord 记录隔离结果，只保留最近 maxQuarantineRecords 条
+func (s *Scrubber) addRecord(record QuarantinedChunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Quarantined = append([]QuarantinedChunk{record}, s.status.Quarantined...)
+	if len(s.status.Quarantined) > maxQuarantineRecords {
+		s.status.Quarantined = s.status.Quarantined[:maxQuarantineRecords]
+	}
+}
@@ -0,0 +1,81 @@
+// Package p2p 提供文件级别的提供者（做种者）发现功能
+//
+// Seeder 功能:
+//   - 文件公告: 上传时文件 CID 与所有 Chunk 哈希在同一次 AnnounceBatch 中公告
+//   - 做种者发现: 下载前按 CID 查找做种者，优先从做种者下载所有 Chunk
+//   - 逐块回退: 做种者无法提供的 Chunk 再按 Chunk 哈希查找提供者
+//   - 重新公告: Reprovider 同时重新公告本地元数据中所有 Chunk 完整的文件
+//
+// 注意事项:
+//   - 文件 CID 与 Chunk 哈希一样使用 hex 编码，共用 Announce/Lookup 的提供者记录
+//   - 做种者记录可能过期（例如文件被更新），下载时仍会用 CheckChunkExists 确认
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/file"
+)
+
+// FindSeeders 查找持有完整文件的节点
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - cid: 文件 CID（hex 编码）
+//
+// 返回值:
+//   - []peer.AddrInfo: 做种者列表（不包含本节点）
+//   - error: 错误信息（未找到任何做种者时返回错误）
+func (p *P2PService) FindSeeders(ctx context.Context, cid string) ([]peer.AddrInfo, error) {
+	return p.FindChunkProviders(ctx, strings.ToLower(cid))
+}
+
+// findSeederIDs 在 DHT 超时内查找做种者，未找到时返回 nil（下载回退到逐块查找）
+func (p *P2PService) findSeederIDs(ctx context.Context, cid string) []peer.ID {
+	dhtTimeout := DefaultDHTTimeout
+	if p.Config.DHTTimeout > 0 {
+		dhtTimeout = time.Duration(p.Config.DHTTimeout) * time.Second
+	}
+	dhtCtx, cancel := context.WithTimeout(ctx, dhtTimeout)
+	defer cancel()
+
+	seeders, err := p.FindSeeders(dhtCtx, cid)
+	if err != nil {
+		logrus.Debugf("No seeders found for file %s, using per-chunk provider lookup: %v", cid, err)
+		return nil
+	}
+
+	ids := make([]peer.ID, len(seeders))
+	for i, info := range seeders {
+		ids[i] = info.ID
+	}
+	logrus.Infof("Found %d seeders for file %s", len(ids), cid)
+	return ids
+}
+
+// listLocalFiles 扫描元数据目录，返回所有 Chunk 都在本地存储中的文件 CID
+// 部分元数据文件无法读取时同时返回其余文件的结果和错误
+func listLocalFiles(metadataPath string, store chunkStore.ChunkStore) ([]string, error) {
+	var cids []string
+	err := forEachLocalMetaData(metadataPath, func(cid string, metaData *file.MetaData) {
+		if len(metaData.Leaves) > 0 && hasAllChunks(store, metaData.Leaves) {
+			cids = append(cids, cid)
+		}
+	})
+	return cids, err
+}
+
+// hasAllChunks 检查文件的所有 Chunk 是否都在本地存储中
+func hasAllChunks(store chunkStore.ChunkStore, leaves []file.ChunkData) bool {
+	for _, leaf := range leaves {
+		if !store.Has(fmt.Sprintf("%x", leaf.ChunkHash)) {
+			return false
+		}
+	}
+	return true
+}
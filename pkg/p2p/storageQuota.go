@@ -0,0 +1,139 @@
+// Package p2p 提供存储配额中被固定 Chunk 的保护
+//
+// pinnedChunkSet 功能:
+//   - 收集被固定文件的元数据引用的 Chunk 哈希，QuotaStore 淘汰时跳过这些 Chunk
+//   - 上传中的 Chunk: 被 GarbageCollector.Hold 标记的 Chunk 同样受保护，上传写入后、固定前不会被淘汰
+//   - 缓存: 固定列表变化或缓存超过 pinnedChunksTTL 后重新扫描元数据目录，Hold 集合变化时只重新合并，生成新的快照
+//
+// 注意事项:
+//   - 被固定文件更新后，新的叶子最晚在 pinnedChunksTTL 后受保护；在此之前它们刚被写入，位于 LRU 队首
+//   - 上传在固定文件之后才释放 Hold；释放时重新扫描元数据，更新已固定文件写入的新叶子在释放后同样受保护
+//   - 扫描不完整（元数据目录或任一元数据文件无法读取）时所有 Chunk 都受保护，直到下一次扫描成功
+package p2p
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/chunkStore"
+	"p2pFileTransfer/pkg/file"
+)
+
+// pinnedChunksTTL 被固定 Chunk 集合的最长缓存时间
+const pinnedChunksTTL = time.Minute
+
+// pinnedChunkSet 被固定文件引用的 Chunk 哈希集合，以及上传中被 Hold 的 Chunk
+type pinnedChunkSet struct {
+	metadataPath string
+	pins         *PinSet
+	gc           *GarbageCollector // 非空时被 Hold 的 Chunk 同样受保护
+
+	mu          sync.Mutex
+	version     uint64
+	builtAt     time.Time
+	pinned      map[string]bool // 最近一次扫描的结果，扫描失败时为 nil
+	held        []string        // 最近一次合并的 Hold 集合
+	heldVersion uint64
+	snapshot    *chunkStore.ProtectedSet
+}
+
+func newPinnedChunkSet(metadataPath string, pins *PinSet) *pinnedChunkSet {
+	return &pinnedChunkSet{metadataPath: metadataPath, pins: pins}
+}
+
+// protectHeld 使被 gc Hold 的 Chunk 受保护
+// QuotaStore 先于 GarbageCollector 创建（回收器删除时经过配额存储），因此在创建回收器后设置
+func (s *pinnedChunkSet) protectHeld(gc *GarbageCollector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gc = gc
+	s.snapshot = nil
+}
+
+// Snapshot 返回受保护 Chunk 的快照，缓存过期时重新扫描，Hold 集合变化时重新合并
+func (s *pinnedChunkSet) Snapshot() *chunkStore.ProtectedSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stale := s.snapshot == nil
+	if stale || s.version != s.pins.Version() || time.Since(s.builtAt) > pinnedChunksTTL {
+		s.rebuildLocked()
+		stale = true
+	}
+	if s.gc != nil {
+		if version, held, changed := s.gc.heldSince(s.heldVersion); changed {
+			// 被释放的 Chunk 此时已被保存的元数据引用，需要按最新的元数据判断是否仍受保护
+			if !stale && released(s.held, held) {
+				s.rebuildLocked()
+			}
+			s.heldVersion, s.held = version, held
+			stale = true
+		}
+	}
+	if stale {
+		s.mergeLocked()
+	}
+	return s.snapshot
+}
+
+func (s *pinnedChunkSet) rebuildLocked() {
+	version := s.pins.Version()
+	hashes := make(map[string]bool)
+	err := forEachLocalMetaData(s.metadataPath, func(cid string, metaData *file.MetaData) {
+		if !s.pins.IsPinned(cid) {
+			return
+		}
+		for _, leaf := range metaData.Leaves {
+			if len(leaf.ChunkHash) > 0 {
+				hashes[hex.EncodeToString(leaf.ChunkHash)] = true
+			}
+		}
+	})
+	s.builtAt = time.Now()
+	s.version = version
+	if err != nil {
+		// 无法读取的元数据可能属于被固定的文件，不能确定哪些 Chunk 可以淘汰
+		logrus.Warnf("Failed to scan pinned files for storage quota, protecting all chunks: %v", err)
+		s.pinned = nil
+		return
+	}
+	s.pinned = hashes
+}
+
+// released 检查 before 中是否有不在 after 中的哈希
+func released(before, after []string) bool {
+	if len(before) == 0 {
+		return false
+	}
+	current := make(map[string]bool, len(after))
+	for _, hash := range after {
+		current[hash] = true
+	}
+	for _, hash := range before {
+		if !current[hash] {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeLocked 合并扫描结果和 Hold 集合，生成新的快照
+func (s *pinnedChunkSet) mergeLocked() {
+	if s.pinned == nil {
+		s.snapshot = &chunkStore.ProtectedSet{All: true}
+		return
+	}
+	if len(s.held) == 0 {
+		s.snapshot = &chunkStore.ProtectedSet{Hashes: s.pinned}
+		return
+	}
+	hashes := make(map[string]bool, len(s.pinned)+len(s.held))
+	for hash := range s.pinned {
+		hashes[hash] = true
+	}
+	for _, hash := range s.held {
+		hashes[hash] = true
+	}
+	s.snapshot = &chunkStore.ProtectedSet{Hashes: hashes}
+}
@@ -0,0 +1,110 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"p2pFileTransfer/pkg/chunkStore"
+)
+
+func TestPinnedChunkSetSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	pins, err := LoadPinSet(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := chunkStore.NewMemoryStore()
+	pinned, unpinned := putGCChunk(t, store, "pinned"), putGCChunk(t, store, "unpinned")
+	writeGCMetaData(t, dir, "aa", pinned)
+	writeGCMetaData(t, dir, "bb", unpinned)
+	if err := pins.Pin("aa"); err != nil {
+		t.Fatal(err)
+	}
+
+	set := newPinnedChunkSet(dir, pins)
+	snapshot := set.Snapshot()
+	if !snapshot.Contains(hex.EncodeToString(pinned)) || snapshot.Contains(hex.EncodeToString(unpinned)) {
+		t.Fatalf("snapshot = %+v", snapshot)
+	}
+	if set.Snapshot() != snapshot {
+		t.Fatal("unchanged pin set produced a new snapshot")
+	}
+
+	// 扫描不完整时所有 Chunk 都受保护
+	if err := os.WriteFile(filepath.Join(dir, "cc.json"), []byte("{truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pins.Pin("bb"); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot := set.Snapshot(); !snapshot.All {
+		t.Fatalf("snapshot after failed scan = %+v, want all protected", snapshot)
+	}
+}
+
+func TestPinnedChunkSetProtectsHeldChunks(t *testing.T) {
+	dir := t.TempDir()
+	pins, err := LoadPinSet(filepath.Join(dir, "pins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := newPinnedChunkSet(dir, pins)
+	quota, err := chunkStore.NewQuotaStore(chunkStore.NewMemoryStore(), 15, set.Snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc := NewGarbageCollector(quota, dir, pins, 0)
+	set.protectHeld(gc)
+
+	// 上传 Hold 后写入的 Chunk 在固定前不被淘汰
+	release := gc.Hold([][]byte{hashOf("uploading")})
+	uploading := putGCChunk(t, quota, "uploading")
+	snapshot := set.Snapshot()
+	if !snapshot.Contains(hex.EncodeToString(uploading)) {
+		t.Fatalf("held chunk not protected: %+v", snapshot)
+	}
+	if set.Snapshot() != snapshot {
+		t.Fatal("unchanged hold set produced a new snapshot")
+	}
+
+	other := putGCChunk(t, quota, "other")
+	putGCChunk(t, quota, "newest")
+	if !quota.Has(hex.EncodeToString(uploading)) {
+		t.Fatal("held chunk evicted")
+	}
+	if quota.Has(hex.EncodeToString(other)) {
+		t.Fatal("unprotected chunk kept while over quota")
+	}
+
+	// 更新已固定的文件: 固定列表不变，释放时重新扫描，新叶子继续受保护
+	writeGCMetaData(t, dir, "aa", hashOf("old leaf"))
+	if err := pins.Pin("aa"); err != nil {
+		t.Fatal(err)
+	}
+	newLeaf := hashOf("new leaf")
+	releaseUpdate := gc.Hold([][]byte{newLeaf})
+	set.Snapshot()
+	writeGCMetaData(t, dir, "aa", newLeaf)
+	releaseUpdate()
+	if !set.Snapshot().Contains(hex.EncodeToString(newLeaf)) {
+		t.Fatal("new leaf of a pinned file unprotected after release")
+	}
+
+	// 未被引用的 Chunk 释放后恢复为普通的可淘汰 Chunk
+	release()
+	if set.Snapshot().Contains(hex.EncodeToString(uploading)) {
+		t.Fatal("released chunk still protected")
+	}
+	putGCChunk(t, quota, "after release")
+	if quota.Has(hex.EncodeToString(uploading)) {
+		t.Fatal("released chunk not evicted")
+	}
+}
+
+func hashOf(data string) []byte {
+	sum := sha256.Sum256([]byte(data))
+	return sum[:]
+}
@@ -0,0 +1,223 @@
+// Package p2p 提供按顺序流式下载文件的功能
+//
+// Stream 功能:
+//   - 并发获取: 多个 worker 并发下载 Chunk（与 downloadChunksConcurrently 使用相同的节点选择和校验）
+//   - 顺序输出: Chunk 按叶子索引顺序写入 io.Writer，第一个 Chunk 到达后立即开始写出
+//   - 有界重排窗口: 最多 StreamWindow 个 Chunk 处于下载中或等待写出，内存占用与文件大小无关
+//   - 范围输出: StreamFileRange 只下载覆盖指定字节范围的 Chunk（按 ChunkData.ChunkSize 映射到叶子索引）
+//
+// 流水线:
+//  1. 分发 goroutine 按索引顺序为每个 Chunk 创建结果槽，槽位放入有界的顺序队列（即重排窗口）
+//  2. worker 下载并校验 Chunk，结果写入对应槽位
+//  3. 调用方 goroutine 按顺序从队列取槽位，等待结果后写入 io.Writer，释放窗口空间
+//
+// 注意事项:
+//   - 顺序输出不使用调度策略（P2PConfig.Scheduler），总是按索引顺序下载
+//   - 任一 Chunk 失败或写入出错时立即取消其余请求；已写出的数据无法撤回，调用方需自行处理不完整的输出
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"p2pFileTransfer/pkg/file"
+)
+
+// DefaultStreamWindow 顺序流式下载的默认重排窗口大小（Chunk 数量）
+const DefaultStreamWindow = 32
+
+// streamResult 一个 Chunk 的下载结果
+type streamResult struct {
+	data []byte
+	err  error
+}
+
+// streamJob 分发给 worker 的下载任务
+type streamJob struct {
+	task   chunkTask
+	result chan streamResult
+}
+
+// StreamFile 下载文件并按顺序流式写入 w
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - fileHash: 文件 CID（hex 编码）
+//   - w: 输出目标（如 HTTP 响应）
+//   - progressCB: 进度回调（可为 nil），每写出一个 Chunk 调用一次
+//
+// 返回值:
+//   - error: 错误信息（返回错误时 w 中可能已写入部分数据）
+func (p *P2PService) StreamFile(ctx context.Context, fileHash string, w io.Writer, progressCB ProgressCallback) error {
+	metaData, err := p.loadMetaData(ctx, fileHash)
+	if err != nil {
+		return err
+	}
+	if err := p.streamRange(ctx, fileHash, metaData, w, 0, int64(metaData.FileSize), progressCB); err != nil {
+		return err
+	}
+	logrus.Infof("File %s streamed successfully (%d chunks)", metaData.FileName, len(metaData.Leaves))
+	return nil
+}
+
+// StreamFileRange 下载文件的字节范围 [offset, offset+length) 并按顺序流式写入 w
+// 只下载与该范围重叠的 Chunk，首尾 Chunk 校验完整哈希后再截取
+// 参数:
+//   - ctx: 上下文，用于控制生命周期
+//   - fileHash: 文件 CID（hex 编码）
+//   - w: 输出目标
+//   - offset: 起始字节
+//   - length: 字节数（范围必须在文件大小内）
+//   - progressCB: 进度回调（可为 nil），total 为 length
+//
+// 返回值:
+//   - error: 错误信息（返回错误时 w 中可能已写入部分数据）
+func (p *P2PService) StreamFileRange(ctx context.Context, fileHash string, w io.Writer, offset, length int64, progressCB ProgressCallback) error {
+	metaData, err := p.loadMetaData(ctx, fileHash)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || length < 0 || offset+length > int64(metaData.FileSize) {
+		return fmt.Errorf("range [%d, +%d) out of file size %d", offset, length, metaData.FileSize)
+	}
+	return p.streamRange(ctx, fileHash, metaData, w, offset, length, progressCB)
+}
+
+// LeafRange 将文件字节范围 [offset, offset+length) 映射到叶子索引
+// 叶子按 ChunkData.ChunkSize 依次排列，最后一个叶子可能小于 ChunkSize
+// 返回值:
+//   - first, last: 与范围重叠的第一个和最后一个叶子索引（length 为 0 或范围超出所有叶子时 first > last）
+//   - skip: offset 在第一个叶子内的偏移
+func LeafRange(metaData *file.MetaData, offset, length int64) (first, last int, skip int64) {
+	first, last = len(metaData.Leaves), -1
+	end := offset + length
+	var leafOffset int64
+	for i, leaf := range metaData.Leaves {
+		leafEnd := leafOffset + int64(leaf.ChunkSize)
+		if length > 0 && leafEnd > offset && leafOffset < end {
+			if i < first {
+				first, skip = i, offset-leafOffset
+			}
+			last = i
+		}
+		leafOffset = leafEnd
+	}
+	return first, last, skip
+}
+
+// streamRange 用有界重排窗口下载覆盖 [offset, offset+length) 的 chunk 并按顺序写入 w
+func (p *P2PService) streamRange(ctx context.Context, fileHash string, metaData *file.MetaData, w io.Writer, offset, length int64, progressCB ProgressCallback) error {
+	first, last, skip := LeafRange(metaData, offset, length)
+	if first > last {
+		return nil
+	}
+	allTasks := p.buildFileChunkTasks(fileHash, metaData)
+	tasks := allTasks[first : last+1]
+
+	var progress *downloadProgress
+	if progressCB != nil {
+		progress = &downloadProgress{
+			total:       length,
+			totalChunks: len(tasks),
+			callback:    progressCB,
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	avail, probeSeeders := p.prepareChunkSources(ctx, fileHash, allTasks)
+	defer p.releaseAvailability(fileHash, avail)
+
+	retryCfg := p.getRetryConfig()
+
+	concurrency := p.Config.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = ConcurrentLimit
+	}
+	window := max(DefaultStreamWindow, concurrency)
+
+	// 顺序队列的容量即重排窗口: 写出落后时分发阻塞，worker 随之空闲
+	ordered := make(chan chan streamResult, window)
+	jobs := make(chan streamJob)
+
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+		for _, task := range tasks {
+			result := make(chan streamResult, 1)
+			select {
+			case ordered <- result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- streamJob{task: task, result: result}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				data, err := p.fetchChunk(ctx, job.task, avail, probeSeeders, retryCfg)
+				if err == nil {
+					p.seedDownloadedChunk(fileHash, len(allTasks), job.task, data)
+				}
+				job.result <- streamResult{data: data, err: err}
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer cancel() // 先取消再等待 worker 退出
+
+	written := 0
+	remaining := length
+	for result := range ordered {
+		var r streamResult
+		select {
+		case r = <-result:
+		case <-ctx.Done():
+			return fmt.Errorf("stream canceled after %d/%d chunks: %w", written, len(tasks), ctx.Err())
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		// 截取范围内的部分
+		task := tasks[written]
+		data := r.data
+		if written == 0 {
+			if skip > int64(len(data)) {
+				return fmt.Errorf("chunk %d is shorter than range offset %d", task.index, skip)
+			}
+			data = data[skip:]
+		}
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("write chunk %d failed: %w", task.index, err)
+		}
+		remaining -= int64(len(data))
+		if progress != nil {
+			progress.completeChunk(int64(len(data)))
+		}
+		written++
+	}
+
+	if written != len(tasks) {
+		return fmt.Errorf("stream canceled after %d/%d chunks: %w", written, len(tasks), ctx.Err())
+	}
+	if remaining > 0 {
+		return fmt.Errorf("file data ended %d bytes before range end", remaining)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+// Package p2p 提供 DHT 记录的签名与校验功能
+//
+// MetaDataValidator 功能:
+//   - 命名空间: 元数据记录存储在独立的 /meta/<CID> 命名空间中
+//   - 内容校验: regular 树校验 Merkle 根等于 CID，chameleon 树校验变色龙哈希
+//   - 所有者签名: 记录必须带有所有者签名，否则拒绝写入
+//   - 版本选择: Select 优先选择 Seq 最大的记录
+//
+// 签名规则:
+//   - chameleon: 使用变色龙私钥（P256 ECDSA）签名，签名者必须是元数据中的 PublicKey
+//   - regular: 使用发布节点的 libp2p 私钥签名；指针记录的 Publisher 必须是签名者
+//   - 签名内容: sha256(DHT 键 || Seq（大端 8 字节）|| Payload)
+//
+// SignedValueValidator 功能:
+//   - 通用命名空间（NameSpace，默认 "v"）的默认 Validator，Put/Get 接口写入的值都经过签名
+//   - 签名: 使用发布节点的 libp2p 私钥，签名内容与元数据记录相同
+//   - 版本选择: Select 优先选择 Seq 最大的记录
+//
+// 注意事项:
+//   - 通用值只保证完整性和版本顺序，不绑定所有者：任何节点都可以用更大的 Seq 发布同一键
+//   - regular 文件内容由 CID 唯一确定，任何持有完整元数据的节点都可以发布
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"p2pFileTransfer/pkg/chameleonMerkleTree"
+	"p2pFileTransfer/pkg/file"
+)
+
+const (
+	// MetaDataNameSpace 元数据记录所在的 DHT 命名空间
+	MetaDataNameSpace = "meta"
+
+	// 签名密钥类型
+	SignerKeyChameleon = "chameleon" // 变色龙密钥（P256 ECDSA）
+	SignerKeyLibp2p    = "libp2p"    // libp2p 节点密钥
+)
+
+// SignedMetaDataRecord DHT 中存储的带签名的元数据记录
+// Payload 为 MetaDataRecord 的原始 JSON，签名直接作用于该字节序列
+type SignedMetaDataRecord struct {
+	Seq       uint64          `json:"seq"`       // 版本号，越大越新
+	Payload   json.RawMessage `json:"payload"`   // MetaDataRecord JSON
+	KeyType   string          `json:"keyType"`   // "chameleon" | "libp2p"
+	Signer    string          `json:"signer"`    // 签名者公钥（hex）
+	Signature string          `json:"signature"` // 签名（hex）
+}
+
+// MetaDataValidator 元数据命名空间的 record.Validator 实现
+type MetaDataValidator struct{}
+
+var _ record.Validator = MetaDataValidator{}
+
+// metaDataKey 返回 CID 对应的 DHT 键: /meta/<cid>
+func metaDataKey(cid string) string {
+	return "/" + MetaDataNameSpace + "/" + strings.ToLower(cid)
+}
+
+// recordSigningDigest 计算签名摘要，绑定 DHT 键与版本号，防止记录被挪用或回滚
+func recordSigningDigest(key string, seq uint64, payload []byte) []byte {
+	var seqBytes [8]byte
+	binary.BigEndian.PutUint64(seqBytes[:], seq)
+
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write(seqBytes[:])
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// Validate 校验元数据记录的内容与签名
+func (MetaDataValidator) Validate(key string, value []byte) error {
+	ns, cid, err := record.SplitKey(key)
+	if err != nil {
+		return err
+	}
+	if ns != MetaDataNameSpace {
+		return fmt.Errorf("unexpected namespace: %s", ns)
+	}
+	if cid != strings.ToLower(cid) {
+		return errors.New("metadata key must be lower-case hex")
+	}
+	if _, err := hex.DecodeString(cid); err != nil {
+		return fmt.Errorf("invalid cid format: %w", err)
+	}
+
+	signed, rec, err := parseSignedMetaDataRecord(value)
+	if err != nil {
+		return err
+	}
+
+	// 1. 内容校验，同时确定签名者应有的身份
+	var ownerKey []byte
+	switch rec.Type {
+	case MetaDataRecordInline:
+		if rec.MetaData == nil {
+			return errors.New("inline record without metadata")
+		}
+		if err := VerifyMetaData(rec.MetaData, cid); err != nil {
+			return fmt.Errorf("invalid metadata: %w", err)
+		}
+		if rec.MetaData.TreeType == TreeTypeChameleon {
+			ownerKey = rec.MetaData.PublicKey
+		}
+	case MetaDataRecordPointer:
+		if rec.Pointer == nil {
+			return errors.New("pointer record without pointer")
+		}
+		if ownerKey, err = verifyMetaDataPointer(rec.Pointer, cid); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown metadata record type: %q", rec.Type)
+	}
+
+	// 2. 签名校验
+	signer, err := hex.DecodeString(signed.Signer)
+	if err != nil {
+		return fmt.Errorf("invalid signer format: %w", err)
+	}
+	sig, err := hex.DecodeString(signed.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature format: %w", err)
+	}
+	digest := recordSigningDigest(key, signed.Seq, signed.Payload)
+
+	switch signed.KeyType {
+	case SignerKeyChameleon:
+		// chameleon 文件只有持有私钥的所有者才能发布新版本
+		if len(ownerKey) == 0 || !bytes.Equal(signer, ownerKey) {
+			return errors.New("signer is not the owner of the chameleon file")
+		}
+		ok, err := chameleonMerkleTree.VerifyChameleonKeySignature(signer, digest, sig)
+		if err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+		if !ok {
+			return errors.New("invalid signature")
+		}
+	case SignerKeyLibp2p:
+		if len(ownerKey) != 0 {
+			return errors.New("chameleon metadata must be signed with the chameleon key")
+		}
+		pubKey, err := crypto.UnmarshalPublicKey(signer)
+		if err != nil {
+			return fmt.Errorf("invalid signer public key: %w", err)
+		}
+		ok, err := pubKey.Verify(digest, sig)
+		if err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+		if !ok {
+			return errors.New("invalid signature")
+		}
+		// 指针记录会引导下载者连接 Publisher，必须由 Publisher 本人签名
+		if rec.Type == MetaDataRecordPointer {
+			signerID, err := peer.IDFromPublicKey(pubKey)
+			if err != nil {
+				return err
+			}
+			if signerID != rec.Pointer.Publisher.ID {
+				return fmt.Errorf("pointer publisher %s is not the signer %s", rec.Pointer.Publisher.ID, signerID)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown signer key type: %q", signed.KeyType)
+	}
+
+	return nil
+}
+
+// Select 选择 Seq 最大的记录
+func (MetaDataValidator) Select(_ string, values [][]byte) (int, error) {
+	return selectHighestSeq(values)
+}
+
+// selectHighestSeq 选择 Seq 最大的记录，Seq 相同时选择字节序较大的记录，保证各节点选择一致
+func selectHighestSeq(values [][]byte) (int, error) {
+	best := -1
+	var bestSeq uint64
+	for i, value := range values {
+		var signed struct {
+			Seq uint64 `json:"seq"`
+		}
+		if err := json.Unmarshal(value, &signed); err != nil {
+			continue
+		}
+		if best == -1 || signed.Seq > bestSeq ||
+			(signed.Seq == bestSeq && bytes.Compare(value, values[best]) > 0) {
+			best = i
+			bestSeq = signed.Seq
+		}
+	}
+	if best == -1 {
+		return 0, errors.New("no valid signed record")
+	}
+	return best, nil
+}
+
+// SignedValue 通用命名空间中存储的带签名的值
+type SignedValue struct {
+	Seq       uint64 `json:"seq"`       // 版本号，越大越新
+	Value     []byte `json:"value"`     // 原始值
+	Signer    string `json:"signer"`    // 发布节点的 libp2p 公钥（hex）
+	Signature string `json:"signature"` // 签名（hex）
+}
+
+// SignedValueValidator 通用命名空间的 record.Validator 实现
+type SignedValueValidator struct{}
+
+var _ record.Validator = SignedValueValidator{}
+
+// Validate 校验值的签名
+func (SignedValueValidator) Validate(key string, value []byte) error {
+	if _, _, err := record.SplitKey(key); err != nil {
+		return err
+	}
+	var signed SignedValue
+	if err := json.Unmarshal(value, &signed); err != nil {
+		return fmt.Errorf("failed to parse signed value: %w", err)
+	}
+
+	signer, err := hex.DecodeString(signed.Signer)
+	if err != nil {
+		return fmt.Errorf("invalid signer format: %w", err)
+	}
+	sig, err := hex.DecodeString(signed.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature format: %w", err)
+	}
+	pubKey, err := crypto.UnmarshalPublicKey(signer)
+	if err != nil {
+		return fmt.Errorf("invalid signer public key: %w", err)
+	}
+	ok, err := pubKey.Verify(recordSigningDigest(key, signed.Seq, signed.Value), sig)
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Select 选择 Seq 最大的记录
+func (SignedValueValidator) Select(_ string, values [][]byte) (int, error) {
+	return selectHighestSeq(values)
+}
+
+// parseSignedMetaDataRecord 解析签名记录及其 Payload
+func parseSignedMetaDataRecord(value []byte) (*SignedMetaDataRecord, *MetaDataRecord, error) {
+	var signed SignedMetaDataRecord
+	if err := json.Unmarshal(value, &signed); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse signed metadata record: %w", err)
+	}
+	var rec MetaDataRecord
+	if err := json.Unmarshal(signed.Payload, &rec); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse metadata record: %w", err)
+	}
+	return &signed, &rec, nil
+}
+
+// verifyMetaDataPointer 校验指针记录中可以独立验证的部分，返回 chameleon 文件的所有者公钥
+// regular 指针无法在没有叶子的情况下验证 Merkle 根，完整元数据在解析指针后再校验
+func verifyMetaDataPointer(pointer *MetaDataPointer, cid string) ([]byte, error) {
+	if !strings.EqualFold(pointer.RootHash, cid) {
+		return nil, fmt.Errorf("pointer root hash %s does not match cid %s", pointer.RootHash, cid)
+	}
+	if pointer.LeafCount <= 0 {
+		return nil, errors.New("pointer has no leaves")
+	}
+
+	switch pointer.TreeType {
+	case TreeTypeRegular:
+		return nil, nil
+	case TreeTypeChameleon:
+		regularRootHash, err := hex.DecodeString(pointer.RegularRootHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular root hash: %w", err)
+		}
+		randomNum, err := hex.DecodeString(pointer.RandomNum)
+		if err != nil {
+			return nil, fmt.Errorf("invalid random number: %w", err)
+		}
+		publicKey, err := hex.DecodeString(pointer.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		rootHash, _ := hex.DecodeString(cid)
+		ok, err := chameleonMerkleTree.VerifySerializedChameleonHash(regularRootHash, rootHash, randomNum, publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("chameleon hash verification failed: %w", err)
+		}
+		if !ok {
+			return nil, errors.New("chameleon hash does not match public key and random number")
+		}
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("unknown tree type: %q", pointer.TreeType)
+	}
+}
+
+// signMetaDataRecord 对元数据记录签名
+// chameleon 文件使用变色龙私钥签名，regular 文件使用本节点的 libp2p 私钥签名
+func (p *P2PService) signMetaDataRecord(cid string, rec *MetaDataRecord, metaData *file.MetaData, chameleonPrivKey []byte) (*SignedMetaDataRecord, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata record: %w", err)
+	}
+
+	// 使用纳秒时间戳作为版本号，同一所有者后发布的版本总是更大
+	signed := &SignedMetaDataRecord{
+		Seq:     uint64(time.Now().UnixNano()),
+		Payload: payload,
+	}
+	digest := recordSigningDigest(metaDataKey(cid), signed.Seq, payload)
+
+	var sig []byte
+	switch metaData.TreeType {
+	case TreeTypeChameleon:
+		if len(chameleonPrivKey) == 0 {
+			return nil, errors.New("chameleon private key is required to sign chameleon metadata")
+		}
+		sig, err = chameleonMerkleTree.SignWithChameleonKey(chameleonPrivKey, digest)
+		if err != nil {
+			return nil, err
+		}
+		signed.KeyType = SignerKeyChameleon
+		signed.Signer = hex.EncodeToString(metaData.PublicKey)
+	default:
+		privKey := p.Host.Peerstore().PrivKey(p.Host.ID())
+		if privKey == nil {
+			return nil, errors.New("host private key not available")
+		}
+		sig, err = privKey.Sign(digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign metadata record: %w", err)
+		}
+		pubKeyBytes, err := crypto.MarshalPublicKey(privKey.GetPublic())
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal host public key: %w", err)
+		}
+		signed.KeyType = SignerKeyLibp2p
+		signed.Signer = hex.EncodeToString(pubKeyBytes)
+	}
+	signed.Signature = hex.EncodeToString(sig)
+
+	return signed, nil
+}
+
+// signValue 使用本节点的 libp2p 私钥对通用命名空间的值签名
+// 参数:
+//   - key: 完整的 DHT 键（/<NameSpace>/<key>）
+//   - value: 原始值
+//
+// 返回值:
+//   - []byte: SignedValue JSON
+//   - error: 私钥不可用或签名失败时返回错误
+func (p *P2PService) signValue(key string, value []byte) ([]byte, error) {
+	privKey := p.Host.Peerstore().PrivKey(p.Host.ID())
+	if privKey == nil {
+		return nil, errors.New("host private key not available")
+	}
+	signed := &SignedValue{
+		Seq:   uint64(time.Now().UnixNano()),
+		Value: value,
+	}
+	sig, err := privKey.Sign(recordSigningDigest(key, signed.Seq, value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign value: %w", err)
+	}
+	pubKeyBytes, err := crypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal host public key: %w", err)
+	}
+	signed.Signer = hex.EncodeToString(pubKeyBytes)
+	signed.Signature = hex.EncodeToString(sig)
+	return json.Marshal(signed)
+}